@@ -17,6 +17,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -47,6 +48,10 @@ type AddrManager struct {
 	lamtx          sync.Mutex
 	localAddresses map[string]*localAddress
 	version        int
+
+	// cjdnsReachable indicates whether IP addresses in the fc00::/8 range
+	// are CJDNS addresses rather than IPv6 ones.
+	cjdnsReachable bool
 }
 
 type serializedKnownAddress struct {
@@ -74,6 +79,16 @@ type localAddress struct {
 	score AddressPriority
 }
 
+// LocalAddress describes a local address which is advertised to peers.
+type LocalAddress struct {
+	// NetAddress is the advertised address.
+	NetAddress *wire.NetAddressV2
+
+	// Score is the priority the address was added with, which is
+	// increased when it is added again with a higher priority.
+	Score AddressPriority
+}
+
 // AddressPriority type is used to describe the hierarchy of local address
 // discovery methods.
 type AddressPriority int
@@ -720,8 +735,10 @@ func (a *AddrManager) reset() {
 }
 
 // HostToNetAddress returns a netaddress given a host address.  If the address
-// is a Tor .onion address this will be taken care of.  Else if the host is
-// not an IP address it will be resolved (via Tor if required).
+// is a Tor .onion or an I2P .b32.i2p address this will be taken care of.  Else
+// if the host is not an IP address it will be resolved (via Tor if required).
+// IP addresses in the fc00::/8 range are treated as CJDNS addresses when the
+// CJDNS network is reachable.
 func (a *AddrManager) HostToNetAddress(host string, port uint16,
 	services wire.ServiceFlag) (*wire.NetAddressV2, error) {
 
@@ -759,6 +776,18 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16,
 		na = wire.NetAddressV2FromBytes(
 			time.Now(), services, data[:wire.TorV3Size], port,
 		)
+	} else if len(host) == wire.I2PEncodedSize && host[wire.I2PEncodedSize-8:] == ".b32.i2p" {
+		// I2P addresses are 52 base32 characters without padding with
+		// the 8 byte b32.i2p suffix.  They do not use ports.
+		encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+		data, err := encoding.DecodeString(
+			strings.ToUpper(host[:wire.I2PEncodedSize-8]),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		na = wire.NetAddressV2FromI2P(time.Now(), services, data)
 	} else if ip = net.ParseIP(host); ip == nil {
 		ips, err := a.lookupFunc(host)
 		if err != nil {
@@ -769,18 +798,32 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16,
 		}
 		ip = ips[0]
 
-		na = wire.NetAddressV2FromBytes(time.Now(), services, ip, port)
+		na = a.ipToNetAddress(ip, port, services)
 	} else {
 		// This is an non-nil IP address that was parsed in the else if
 		// above.
-		na = wire.NetAddressV2FromBytes(time.Now(), services, ip, port)
+		na = a.ipToNetAddress(ip, port, services)
 	}
 
 	return na, nil
 }
 
+// ipToNetAddress returns a netaddress for the given IP address, which is a
+// CJDNS address when it is in the fc00::/8 range and the CJDNS network is
+// reachable.
+func (a *AddrManager) ipToNetAddress(ip net.IP, port uint16,
+	services wire.ServiceFlag) *wire.NetAddressV2 {
+
+	if a.cjdnsReachable && cjdnsNet.Contains(ip) {
+		return wire.NetAddressV2FromCJDNS(time.Now(), services, ip, port)
+	}
+
+	return wire.NetAddressV2FromBytes(time.Now(), services, ip, port)
+}
+
 // NetAddressKey returns a string key in the form of ip:port for IPv4 addresses
-// or [ip]:port for IPv6 addresses. It also handles onion v2 and v3 addresses.
+// or [ip]:port for IPv6 and CJDNS addresses. It also handles onion v2 and v3
+// and I2P addresses.
 func NetAddressKey(na *wire.NetAddressV2) string {
 	port := strconv.FormatUint(uint64(na.Port), 10)
 
@@ -1019,6 +1062,27 @@ func (a *AddrManager) SetServices(addr *wire.NetAddressV2, services wire.Service
 	}
 }
 
+// SetCJDNSReachable sets whether the host is connected to the CJDNS network, in
+// which case IP addresses in the fc00::/8 range are treated as CJDNS addresses.
+// Otherwise, they are treated as unroutable IPv6 addresses.  It must be called
+// before the address manager is started.
+func (a *AddrManager) SetCJDNSReachable(reachable bool) {
+	a.cjdnsReachable = reachable
+}
+
+// Services returns the services the given address is known to advertise, or
+// zero when the address is unknown.
+func (a *AddrManager) Services(addr *wire.NetAddressV2) wire.ServiceFlag {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		return 0
+	}
+	return ka.NetAddress().Services
+}
+
 // AddLocalAddress adds na to the list of known local addresses to advertise
 // with the given priority.
 func (a *AddrManager) AddLocalAddress(na *wire.NetAddressV2, priority AddressPriority) error {
@@ -1046,6 +1110,29 @@ func (a *AddrManager) AddLocalAddress(na *wire.NetAddressV2, priority AddressPri
 	return nil
 }
 
+// LocalAddresses returns the local addresses which are advertised to peers
+// sorted by their address.
+func (a *AddrManager) LocalAddresses() []LocalAddress {
+	a.lamtx.Lock()
+	defer a.lamtx.Unlock()
+
+	keys := make([]string, 0, len(a.localAddresses))
+	for key := range a.localAddresses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	addrs := make([]LocalAddress, 0, len(keys))
+	for _, key := range keys {
+		la := a.localAddresses[key]
+		addrs = append(addrs, LocalAddress{
+			NetAddress: la.na,
+			Score:      la.score,
+		})
+	}
+	return addrs
+}
+
 // getReachabilityFrom returns the relative reachability of the provided local
 // address to the provided remote address.
 func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddressV2) int {
@@ -1063,12 +1150,33 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddressV2) int {
 		return Unreachable
 	}
 
+	if remoteAddr.IsI2P() {
+		if localAddr.IsI2P() {
+			return Private
+		}
+
+		return Default
+	}
+
+	if remoteAddr.IsCJDNS() {
+		if localAddr.IsCJDNS() {
+			return Private
+		}
+
+		return Default
+	}
+
 	if remoteAddr.IsTorV3() {
 		if localAddr.IsTorV3() {
 			return Private
 		}
 
+		// i2p and cjdns addresses have no legacy encoding.
 		lna := localAddr.ToLegacy()
+		if lna == nil {
+			return Default
+		}
+
 		if IsOnionCatTor(lna) {
 			// Modern v3 clients should not be able to connect to
 			// deprecated v2 hidden services.
@@ -1084,7 +1192,7 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddressV2) int {
 
 	// We can't be sure if the remote party can actually connect to this
 	// address or not.
-	if localAddr.IsTorV3() {
+	if localAddr.IsTorV3() || localAddr.IsI2P() || localAddr.IsCJDNS() {
 		return Default
 	}
 
@@ -1183,8 +1291,10 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddressV2) *wire.N
 
 		// Send something unroutable if nothing suitable.
 		var ip net.IP
-		if remoteAddr.IsTorV3() {
+		if remoteAddr.IsTorV3() || remoteAddr.IsI2P() {
 			ip = net.IPv4zero
+		} else if remoteAddr.IsCJDNS() {
+			ip = net.IPv6zero
 		} else {
 			remoteLna := remoteAddr.ToLegacy()
 			if !IsIPv4(remoteLna) && !IsOnionCatTor(remoteLna) {
@@ -264,6 +264,29 @@ func TestConnected(t *testing.T) {
 	}
 }
 
+func TestServices(t *testing.T) {
+	n := addrmgr.New("testservices", lookupFunc)
+
+	// Add a new address and get it
+	err := n.AddAddressByIP(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("Adding address failed: %v", err)
+	}
+	na := n.GetAddress().NetAddress()
+
+	n.SetServices(na, wire.SFNodeNetwork|wire.SFNodeP2PV2)
+	if services := n.Services(na); services != wire.SFNodeNetwork|wire.SFNodeP2PV2 {
+		t.Errorf("Unexpected services %v", services)
+	}
+
+	unknown := wire.NetAddressV2FromBytes(time.Now(), 0,
+		net.ParseIP("173.194.115.67").To4(), 8333)
+	if services := n.Services(unknown); services != 0 {
+		t.Errorf("Unknown address should not have services, but has %v",
+			services)
+	}
+}
+
 func TestNeedMoreAddresses(t *testing.T) {
 	n := addrmgr.New("testneedmoreaddresses", lookupFunc)
 	addrsToAdd := 1500
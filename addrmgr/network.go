@@ -84,6 +84,10 @@ var (
 	// { magic 6 bytes, 10 bytes base32 decode of key hash }
 	onionCatNet = ipNet("fd87:d87e:eb43::", 48, 128)
 
+	// cjdnsNet defines the IPv6 address block used by CJDNS (FC00::/8).
+	// It is part of the RFC4193 unique local IPv6 range.
+	cjdnsNet = ipNet("FC00::", 8, 128)
+
 	// zero4Net defines the IPv4 address block for address staring with 0
 	// (0.0.0.0/8).
 	zero4Net = ipNet("0.0.0.0", 8, 32)
@@ -223,13 +227,12 @@ func IsValid(na *wire.NetAddress) bool {
 // the public internet.  This is true as long as the address is valid and is not
 // in any reserved ranges.
 func IsRoutable(na *wire.NetAddressV2) bool {
-	if na.IsTorV3() {
-		// na is a torv3 address, return true.
+	if na.IsTorV3() || na.IsI2P() || na.IsCJDNS() {
+		// na is a torv3, i2p, or cjdns address, return true.
 		return true
 	}
 
-	// Else na can be represented as a legacy NetAddress since i2p and
-	// cjdns are unsupported.
+	// Else na can be represented as a legacy NetAddress.
 	lna := na.ToLegacy()
 	return IsValid(lna) && !(IsRFC1918(lna) || IsRFC2544(lna) ||
 		IsRFC3927(lna) || IsRFC4862(lna) || IsRFC3849(lna) ||
@@ -238,17 +241,57 @@ func IsRoutable(na *wire.NetAddressV2) bool {
 		!IsOnionCatTor(lna)))
 }
 
+// The names of the networks an address can belong to as returned by
+// NetworkName.
+const (
+	NetIPv4  = "ipv4"
+	NetIPv6  = "ipv6"
+	NetOnion = "onion"
+	NetI2P   = "i2p"
+	NetCJDNS = "cjdns"
+)
+
+// NetworkName returns the name of the network the passed address belongs to.
+// Tor addresses, including OnionCat encoded ones, are part of the onion
+// network.
+func NetworkName(na *wire.NetAddressV2) string {
+	switch {
+	case na.IsTorV3():
+		return NetOnion
+	case na.IsI2P():
+		return NetI2P
+	case na.IsCJDNS():
+		return NetCJDNS
+	}
+
+	lna := na.ToLegacy()
+	switch {
+	case IsOnionCatTor(lna):
+		return NetOnion
+	case IsIPv4(lna):
+		return NetIPv4
+	}
+	return NetIPv6
+}
+
 // GroupKey returns a string representing the network group an address is part
 // of.  This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address, the string "tor:key" where key is the /4 of the
-// onion address for Tor address, and the string "unroutable" for an unroutable
-// address.
+// onion address for Tor address, the strings "i2p:key" and "cjdns:key" where
+// key is the /4 of the random part of the address for I2P and CJDNS addresses,
+// and the string "unroutable" for an unroutable address.
 func GroupKey(na *wire.NetAddressV2) string {
 	if na.IsTorV3() {
 		// na is a torv3 address. Use the same network group keying as
 		// for torv2.
 		return fmt.Sprintf("tor:%d", na.TorV3Key()&((1<<4)-1))
 	}
+	if na.IsI2P() {
+		return fmt.Sprintf("i2p:%d", na.I2PKey()&((1<<4)-1))
+	}
+	if na.IsCJDNS() {
+		return fmt.Sprintf("cjdns:%d", na.CJDNSKey()&((1<<4)-1))
+	}
 
 	lna := na.ToLegacy()
 
@@ -206,3 +206,104 @@ func TestGroupKey(t *testing.T) {
 		}
 	}
 }
+
+// TestI2PAndCJDNSNetworks ensures i2p and cjdns addresses are routable and are
+// assigned the expected network names and network groups.
+func TestI2PAndCJDNSNetworks(t *testing.T) {
+	n := addrmgr.New("testi2pcjdns", nil)
+	n.SetCJDNSReachable(true)
+
+	tests := []struct {
+		name    string
+		host    string
+		network string
+		group   string
+	}{
+		{
+			name:    "ipv4",
+			host:    "12.1.2.3",
+			network: addrmgr.NetIPv4,
+			group:   "12.1.0.0",
+		},
+		{
+			name:    "ipv6",
+			host:    "2602:100::1",
+			network: addrmgr.NetIPv6,
+			group:   "2602:100::",
+		},
+		{
+			name:    "torv2",
+			host:    "777myonionurl777.onion",
+			network: addrmgr.NetOnion,
+			group:   "tor:15",
+		},
+		{
+			name:    "torv3",
+			host:    "zljnhsg4ttcng4btgdcshlyc5xcj36ggwbhhi3j3kfl2ofp6ta26jlid.onion",
+			network: addrmgr.NetOnion,
+			group:   "tor:10",
+		},
+		{
+			name:    "i2p",
+			host:    "zljnhsg4ttcng4btgdcshlyc5xcj36ggwbhhi3j3kfl2ofp6ta2q.b32.i2p",
+			network: addrmgr.NetI2P,
+			group:   "i2p:10",
+		},
+		{
+			name:    "cjdns",
+			host:    "fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa",
+			network: addrmgr.NetCJDNS,
+			group:   "cjdns:2",
+		},
+	}
+
+	for i, test := range tests {
+		na, err := n.HostToNetAddress(test.host, 8333, wire.SFNodeNetwork)
+		if err != nil {
+			t.Errorf("TestI2PAndCJDNSNetworks #%d (%s): unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if na.Addr.String() != test.host {
+			t.Errorf("TestI2PAndCJDNSNetworks #%d (%s): unexpected "+
+				"address - got '%s', want '%s'", i, test.name,
+				na.Addr.String(), test.host)
+		}
+		if !addrmgr.IsRoutable(na) {
+			t.Errorf("TestI2PAndCJDNSNetworks #%d (%s): address is "+
+				"not routable", i, test.name)
+		}
+		if name := addrmgr.NetworkName(na); name != test.network {
+			t.Errorf("TestI2PAndCJDNSNetworks #%d (%s): unexpected "+
+				"network - got '%s', want '%s'", i, test.name,
+				name, test.network)
+		}
+		if key := addrmgr.GroupKey(na); key != test.group {
+			t.Errorf("TestI2PAndCJDNSNetworks #%d (%s): unexpected "+
+				"group key - got '%s', want '%s'", i, test.name,
+				key, test.group)
+		}
+	}
+}
+
+// TestCJDNSUnreachable ensures addresses in the fc00::/8 range are treated as
+// unroutable IPv6 addresses when the CJDNS network is not reachable.
+func TestCJDNSUnreachable(t *testing.T) {
+	n := addrmgr.New("testcjdnsunreachable", nil)
+
+	na, err := n.HostToNetAddress("fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa",
+		8333, wire.SFNodeNetwork)
+	if err != nil {
+		t.Fatalf("HostToNetAddress: unexpected error: %v", err)
+	}
+	if na.IsCJDNS() {
+		t.Fatal("address is a CJDNS address")
+	}
+	if name := addrmgr.NetworkName(na); name != addrmgr.NetIPv6 {
+		t.Fatalf("unexpected network - got '%s', want '%s'", name,
+			addrmgr.NetIPv6)
+	}
+	if addrmgr.IsRoutable(na) {
+		t.Fatal("address is routable")
+	}
+}
@@ -64,7 +64,8 @@ func (b *BlockChain) maybeAcceptBlock(block *bteutil.Block, flags BehaviorFlags)
 	// if the block ultimately gets connected to the main chain, it starts out
 	// on a side chain.
 	blockHeader := &block.MsgBlock().Header
-	newNode := newBlockNode(blockHeader, prevNode)
+	newNode := new(blockNode)
+	initBlockNode(newNode, blockHeader, block.Hash(), prevNode)
 	newNode.status = statusDataStored
 
 	b.index.AddNode(newNode)
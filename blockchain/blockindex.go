@@ -74,7 +74,8 @@ type blockNode struct {
 	// parent is the parent block for this node.
 	parent *blockNode
 
-	// hash is the double sha 256 of the block.
+	// hash is the yespower hash of the block header, which serves as the
+	// block identifier.
 	hash chainhash.Hash
 
 	// workSum is the total amount of work in the chain up to and including
@@ -101,13 +102,15 @@ type blockNode struct {
 	status blockStatus
 }
 
-// initBlockNode initializes a block node from the given header and parent node,
-// calculating the height and workSum from the respective fields on the parent.
+// initBlockNode initializes a block node from the given header, its already
+// known block hash and parent node, calculating the height and workSum from the
+// respective fields on the parent.  Accepting the hash avoids recomputing the
+// expensive yespower hash when the caller already has it.
 // This function is NOT safe for concurrent access.  It must only be called when
 // initially creating a node.
-func initBlockNode(node *blockNode, blockHeader *wire.BlockHeader, parent *blockNode) {
+func initBlockNode(node *blockNode, blockHeader *wire.BlockHeader, blockHash *chainhash.Hash, parent *blockNode) {
 	*node = blockNode{
-		hash:       blockHeader.BlockHash(),
+		hash:       *blockHash,
 		workSum:    CalcWork(blockHeader.Bits),
 		version:    blockHeader.Version,
 		bits:       blockHeader.Bits,
@@ -127,7 +130,8 @@ func initBlockNode(node *blockNode, blockHeader *wire.BlockHeader, parent *block
 // parent. This function is NOT safe for concurrent access.
 func newBlockNode(blockHeader *wire.BlockHeader, parent *blockNode) *blockNode {
 	var node blockNode
-	initBlockNode(&node, blockHeader, parent)
+	blockHash := blockHeader.BlockHash()
+	initBlockNode(&node, blockHeader, &blockHash, parent)
 	return &node
 }
 
@@ -286,6 +290,84 @@ func (bi *blockIndex) addNode(node *blockNode) {
 	bi.index[node.hash] = node
 }
 
+// Tips returns all block nodes in the index which do not have any children,
+// along with their status.  Every tip is the last block of a branch of the
+// block tree, and the tip of the main chain is always one of them.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) Tips() ([]*blockNode, []blockStatus) {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	hasChildren := make(map[*blockNode]struct{}, len(bi.index))
+	for _, node := range bi.index {
+		if node.parent != nil {
+			hasChildren[node.parent] = struct{}{}
+		}
+	}
+
+	var tips []*blockNode
+	var statuses []blockStatus
+	for _, node := range bi.index {
+		if _, ok := hasChildren[node]; ok {
+			continue
+		}
+		tips = append(tips, node)
+		statuses = append(statuses, node.status)
+	}
+	return tips, statuses
+}
+
+// Descendants returns all block nodes in the index which descend from the
+// passed node, ordered such that every node comes after its parent.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) Descendants(node *blockNode) []*blockNode {
+	bi.RLock()
+	children := make(map[*blockNode][]*blockNode)
+	for _, n := range bi.index {
+		if n.parent != nil && n.height > node.height {
+			children[n.parent] = append(children[n.parent], n)
+		}
+	}
+	bi.RUnlock()
+
+	var descendants []*blockNode
+	queue := children[node]
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		descendants = append(descendants, n)
+		queue = append(queue, children[n]...)
+	}
+	return descendants
+}
+
+// MostWorkCandidate returns the node in the index with the most cumulative
+// work that has its block data available and is not known to be invalid.  The
+// passed node, which is typically the current best chain tip, is preferred
+// over any other node with the same amount of work.  It may be nil.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) MostWorkCandidate(preferred *blockNode) *blockNode {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	best := preferred
+	if best != nil && (!best.status.HaveData() || best.status.KnownInvalid()) {
+		best = nil
+	}
+	for _, node := range bi.index {
+		if !node.status.HaveData() || node.status.KnownInvalid() {
+			continue
+		}
+		if best == nil || node.workSum.Cmp(best.workSum) > 0 {
+			best = node
+		}
+	}
+	return best
+}
+
 // NodeStatus provides concurrent-safe access to the status field of a node.
 //
 // This function is safe for concurrent access.
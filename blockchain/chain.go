@@ -8,6 +8,7 @@ package blockchain
 import (
 	"container/list"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -101,6 +102,8 @@ type BlockChain struct {
 	sigCache            *txscript.SigCache
 	indexManager        IndexManager
 	hashCache           *txscript.HashCache
+	pruneTarget         uint64
+	interrupt           <-chan struct{}
 
 	// The following fields are calculated based upon the provided chain
 	// parameters.  They are also set when the instance is created and
@@ -126,6 +129,38 @@ type BlockChain struct {
 	index     *blockIndex
 	bestChain *chainView
 
+	// utxoCache holds the changes made to the utxo set by connecting blocks
+	// to the main chain until they are written to the database.  It is
+	// protected by the chain lock.
+	utxoCache *utxoCache
+
+	// pruneHeight is the height of the first block in the main chain which
+	// is available in the database.  It is protected by the chain lock.
+	pruneHeight int32
+
+	// pruneRetryLimit and pruneRetrySnapshotHeight are the prune limit and
+	// the height of the last block connected by the validation of the
+	// blocks leading up to the utxo snapshot which must be reached before
+	// the oldest block file that was kept by the last attempt to prune can
+	// be deleted.  They avoid scanning the block index for every new block
+	// while nothing can be pruned.  Both are protected by the chain lock.
+	pruneRetryLimit          int32
+	pruneRetrySnapshotHeight int32
+
+	// snapshotBase is the block the utxo set was bootstrapped from while
+	// the chain leading up to it has not been validated yet and
+	// snapshotHeight is the height of the last block of that chain which
+	// has been connected to the separate utxo set used to validate it.
+	// Both are protected by the chain lock.  snapshotBlockStored is
+	// signalled when a block of that chain is stored.  snapshotQuit and
+	// snapshotWg are used to stop the goroutine validating the chain and
+	// to wait for it to exit.
+	snapshotBase        *blockNode
+	snapshotHeight      int32
+	snapshotBlockStored chan struct{}
+	snapshotQuit        chan struct{}
+	snapshotWg          sync.WaitGroup
+
 	// These fields are related to handling of orphan blocks.  They are
 	// protected by a combination of the chain lock and the orphan lock.
 	orphanLock   sync.RWMutex
@@ -611,14 +646,6 @@ func (b *BlockChain) connectBlock(node *blockNode, block *bteutil.Block,
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails removing all of the utxos spent and adding the new
-		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
-		if err != nil {
-			return err
-		}
-
 		// Update the transaction spend journal by adding a record for
 		// the block that contains all txos spent by it.
 		err = dbPutSpendJournalEntry(dbTx, block.Hash(), stxos)
@@ -642,8 +669,12 @@ func (b *BlockChain) connectBlock(node *blockNode, block *bteutil.Block,
 		return err
 	}
 
-	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// Update the utxo cache using the state of the utxo view.  This
+	// entails removing all of the utxos spent and adding the new ones
+	// created by the block.  Then prune fully spent entries and mark all
+	// entries in the view unmodified now that the modifications have been
+	// committed to the cache.
+	b.utxoCache.commit(view)
 	view.commit()
 
 	// This node is now the end of the best chain.
@@ -658,6 +689,19 @@ func (b *BlockChain) connectBlock(node *blockNode, block *bteutil.Block,
 	b.stateSnapshot = state
 	b.stateLock.Unlock()
 
+	// Write the cached utxo set changes to the database when the cache has
+	// grown too large or has not been flushed in a while.
+	err = b.utxoCache.maybeFlush(&node.hash)
+	if err != nil {
+		return err
+	}
+
+	// Remove the oldest blocks from the database when pruning is enabled
+	// and the stored blocks exceed the target size.
+	if err := b.maybePrune(); err != nil {
+		return err
+	}
+
 	// Notify the caller that the block was connected to the main chain.
 	// The caller would typically want to react with actions such as
 	// updating wallets.
@@ -726,10 +770,18 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *bteutil.Block, view
 		// Update the utxo set using the state of the utxo view.  This
 		// entails restoring all of the utxos spent and removing the new
 		// ones created by the block.
+		//
+		// NOTE: The utxo cache is flushed before any blocks are
+		// disconnected, so the view is written to the database
+		// directly.
 		err = dbPutUtxoView(dbTx, view)
 		if err != nil {
 			return err
 		}
+		err = dbPutUtxoStateConsistency(dbTx, &prevNode.hash)
+		if err != nil {
+			return err
+		}
 
 		// Before we delete the spend journal entry for this back,
 		// we'll fetch it as is so the indexers can utilize if needed.
@@ -837,6 +889,15 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		}
 	}
 
+	// Write all cached utxo set changes to the database before any blocks
+	// are disconnected since disconnecting blocks updates the utxo set in
+	// the database directly.
+	if detachNodes.Len() != 0 {
+		if err := b.utxoCache.flush(&tip.hash); err != nil {
+			return err
+		}
+	}
+
 	// Track the old and new best chains heads.
 	oldBest := tip
 	newBest := tip
@@ -875,7 +936,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err = view.fetchInputUtxos(b.db, block)
+		err = view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
@@ -942,7 +1003,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// checkConnectBlock gets skipped, we still need to update the UTXO
 		// view.
 		if b.index.NodeStatus(n).KnownValid() {
-			err = view.fetchInputUtxos(b.db, block)
+			err = view.fetchInputUtxos(b.utxoCache, block)
 			if err != nil {
 				return err
 			}
@@ -994,7 +1055,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
@@ -1021,7 +1082,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
@@ -1120,7 +1181,7 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *bteutil.Block, fla
 		// utxos, spend them, and add the new utxos being created by
 		// this block.
 		if fastAdd {
-			err := view.fetchInputUtxos(b.db, block)
+			err := view.fetchInputUtxos(b.utxoCache, block)
 			if err != nil {
 				return false, err
 			}
@@ -1204,6 +1265,168 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *bteutil.Block, fla
 	return err == nil, err
 }
 
+// activateBestChain reorganizes the chain to the block with the most cumulative
+// work that is not known to be invalid, preferring the current best chain tip
+// over other blocks with the same amount of work.  Blocks which turn out to be
+// invalid while reorganizing are marked as such and the next best candidate is
+// tried instead.
+//
+// This function may modify node statuses in the block index without flushing.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) activateBestChain() error {
+	for {
+		tip := b.bestChain.Tip()
+		bestNode := b.index.MostWorkCandidate(tip)
+		if bestNode == nil || bestNode == tip {
+			return nil
+		}
+
+		// Nothing is returned to attach when the candidate has an invalid
+		// ancestor.  The affected nodes are marked invalid in that case,
+		// so simply move on to the next candidate.
+		detachNodes, attachNodes := b.getReorganizeNodes(bestNode)
+		if attachNodes.Len() == 0 {
+			continue
+		}
+
+		// A rule violation while reorganizing marks the offending block
+		// invalid before any changes are made to the chain, so move on
+		// to the next candidate in that case as well.
+		err := b.reorganizeChain(detachNodes, attachNodes)
+		if err != nil {
+			if _, ok := err.(RuleError); ok {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// InvalidateBlock marks the block identified by the passed hash as invalid
+// along with all of its descendants.  When the block is part of the main chain,
+// the chain is disconnected back to its parent and then reorganized to the
+// valid chain with the most cumulative work.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) InvalidateBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %s is not known", hash)
+	}
+	if node.parent == nil {
+		return fmt.Errorf("block %s is the genesis block and can't be "+
+			"invalidated", hash)
+	}
+
+	b.index.SetStatusFlags(node, statusValidateFailed)
+	for _, descendant := range b.index.Descendants(node) {
+		b.index.SetStatusFlags(descendant, statusInvalidAncestor)
+	}
+
+	// Disconnect the blocks one at a time when the invalidated block is
+	// part of the main chain in order to avoid loading all of them into
+	// memory at once.
+	for b.bestChain.Contains(node) {
+		detachNodes := list.New()
+		detachNodes.PushBack(b.bestChain.Tip())
+		err := b.reorganizeChain(detachNodes, list.New())
+		if err != nil {
+			if writeErr := b.index.flushToDB(); writeErr != nil {
+				log.Warnf("Error flushing block index changes "+
+					"to disk: %v", writeErr)
+			}
+			return err
+		}
+	}
+
+	err := b.activateBestChain()
+	if writeErr := b.index.flushToDB(); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
+
+// ReconsiderBlock removes the invalidity status from the block identified by
+// the passed hash along with all of its descendants and ancestors, which
+// undoes the effects of InvalidateBlock.  The chain is then reorganized to the
+// valid chain with the most cumulative work, which may result in the blocks
+// being marked invalid again if they really are invalid.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReconsiderBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %s is not known", hash)
+	}
+
+	const invalidFlags = statusValidateFailed | statusInvalidAncestor
+	b.index.UnsetStatusFlags(node, invalidFlags)
+	for _, descendant := range b.index.Descendants(node) {
+		b.index.UnsetStatusFlags(descendant, invalidFlags)
+	}
+	for n := node.parent; n != nil; n = n.parent {
+		if b.index.NodeStatus(n).KnownInvalid() {
+			b.index.UnsetStatusFlags(n, invalidFlags)
+		}
+	}
+
+	err := b.activateBestChain()
+	if writeErr := b.index.flushToDB(); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
+
+// PreciousBlock treats the block identified by the passed hash as if it were
+// received before any other block with the same amount of cumulative work.
+// The chain is reorganized so the block becomes the new best chain tip when
+// it has at least as much cumulative work as the current tip.  Otherwise, or
+// when the block is already part of the main chain, it has no effect.
+//
+// A MissingBlockDataError is returned when only the header of the block is
+// known.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PreciousBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %s is not known", hash)
+	}
+
+	if b.bestChain.Contains(node) {
+		return nil
+	}
+	if !b.index.NodeStatus(node).HaveData() {
+		return MissingBlockDataError(hash.String())
+	}
+	if node.workSum.Cmp(b.bestChain.Tip().workSum) < 0 {
+		return nil
+	}
+	if b.index.NodeStatus(node).KnownInvalid() {
+		return fmt.Errorf("block %s is known to be invalid", hash)
+	}
+
+	detachNodes, attachNodes := b.getReorganizeNodes(node)
+	err := b.reorganizeChain(detachNodes, attachNodes)
+	if writeErr := b.index.flushToDB(); writeErr != nil {
+		log.Warnf("Error flushing block index changes to disk: %v",
+			writeErr)
+	}
+	return err
+}
+
 // isCurrent returns whether or not the chain believes it is current.  Several
 // factors are used to guess, but the key factors that allow the chain to
 // believe it is current are:
@@ -1276,6 +1499,25 @@ func (b *BlockChain) MainChainHasBlock(hash *chainhash.Hash) bool {
 	return node != nil && b.bestChain.Contains(node)
 }
 
+// IsKnownValid returns whether or not the block with the given hash is in the
+// block index and has been fully validated.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsKnownValid(hash *chainhash.Hash) bool {
+	node := b.index.LookupNode(hash)
+	return node != nil && b.index.NodeStatus(node).KnownValid()
+}
+
+// IsKnownInvalid returns whether or not the block with the given hash is in the
+// block index and is known to be invalid, either because it failed validation
+// itself or because one of its ancestors did.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsKnownInvalid(hash *chainhash.Hash) bool {
+	node := b.index.LookupNode(hash)
+	return node != nil && b.index.NodeStatus(node).KnownInvalid()
+}
+
 // BlockLocatorFromHash returns a block locator for the passed block hash.
 // See BlockLocator for details on the algorithm used to create a block locator.
 //
@@ -1614,6 +1856,114 @@ func (b *BlockChain) LocateHeaders(locator BlockLocator, hashStop *chainhash.Has
 	return headers
 }
 
+// TipStatus describes the validation state of the branch of the block tree
+// which ends at a chain tip.
+type TipStatus byte
+
+const (
+	// StatusActive indicates the tip is the tip of the main chain.
+	StatusActive TipStatus = iota
+
+	// StatusValidFork indicates the tip is on a side chain and every block
+	// in the branch has been fully validated at some point.
+	StatusValidFork
+
+	// StatusValidHeaders indicates the tip is on a side chain and all of
+	// the blocks in the branch are available, but they have not been fully
+	// validated.
+	StatusValidHeaders
+
+	// StatusHeadersOnly indicates the tip is on a side chain and only the
+	// header of the tip is known.
+	StatusHeadersOnly
+
+	// StatusInvalid indicates the tip or one of its ancestors is known to
+	// be invalid.
+	StatusInvalid
+)
+
+// tipStatusStrings is a map of tip statuses back to their constant names for
+// pretty printing.
+var tipStatusStrings = map[TipStatus]string{
+	StatusActive:       "active",
+	StatusValidFork:    "valid-fork",
+	StatusValidHeaders: "valid-headers",
+	StatusHeadersOnly:  "headers-only",
+	StatusInvalid:      "invalid",
+}
+
+// String returns the TipStatus as a human-readable name.
+func (s TipStatus) String() string {
+	if str, ok := tipStatusStrings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("Unknown TipStatus (%d)", int(s))
+}
+
+// ChainTip describes the last block of a branch in the block tree.
+type ChainTip struct {
+	// Height is the height of the tip.
+	Height int32
+
+	// Hash is the hash of the tip.
+	Hash chainhash.Hash
+
+	// BranchLen is the number of blocks from the tip back to the block at
+	// which the branch forks from the main chain.  It is zero for the tip
+	// of the main chain.
+	BranchLen int32
+
+	// Status is the validation state of the branch.
+	Status TipStatus
+}
+
+// ChainTips returns information about all known tips in the block tree, which
+// includes the tip of the main chain and the tips of all side chains, ordered
+// by descending height.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ChainTips() []ChainTip {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	tips, statuses := b.index.Tips()
+	chainTips := make([]ChainTip, 0, len(tips))
+	for i, tip := range tips {
+		forkNode := b.bestChain.FindFork(tip)
+		branchLen := tip.height
+		if forkNode != nil {
+			branchLen = tip.height - forkNode.height
+		}
+
+		status := statuses[i]
+		var tipStatus TipStatus
+		switch {
+		case branchLen == 0:
+			tipStatus = StatusActive
+		case status.KnownInvalid():
+			tipStatus = StatusInvalid
+		case status.KnownValid():
+			tipStatus = StatusValidFork
+		case status.HaveData():
+			tipStatus = StatusValidHeaders
+		default:
+			tipStatus = StatusHeadersOnly
+		}
+
+		chainTips = append(chainTips, ChainTip{
+			Height:    tip.height,
+			Hash:      tip.hash,
+			BranchLen: branchLen,
+			Status:    tipStatus,
+		})
+	}
+
+	sort.Slice(chainTips, func(i, j int) bool {
+		return chainTips[i].Height > chainTips[j].Height
+	})
+	return chainTips
+}
+
 // IndexManager provides a generic interface that the is called when blocks are
 // connected and disconnected to and from the tip of the main chain for the
 // purpose of supporting optional indexes.
@@ -1700,6 +2050,25 @@ type Config struct {
 	// This field can be nil if the caller is not interested in using a
 	// signature cache.
 	HashCache *txscript.HashCache
+
+	// UtxoCacheMaxSize defines the approximate maximum number of bytes of
+	// changes to the utxo set that are held in memory before they are
+	// written to the database.  Larger values reduce the number of
+	// database writes considerably, especially during initial block
+	// download.  The caller is expected to call FlushUtxoCache prior to
+	// shutting down.
+	//
+	// This field can be zero to write the changes after every block.
+	UtxoCacheMaxSize uint64
+
+	// Prune defines the approximate maximum number of bytes of block data
+	// to keep in the database.  The oldest blocks are removed once the
+	// target is exceeded, however, the most recent MinBlocksToKeep blocks
+	// of the main chain are always kept.  A database which has been pruned
+	// can't be used with pruning disabled.
+	//
+	// This field can be zero to keep all blocks.
+	Prune uint64
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -1750,7 +2119,12 @@ func New(config *Config) (*BlockChain, error) {
 		blocksPerRetarget:   int32(targetTimespan / targetTimePerBlock),
 		index:               newBlockIndex(config.DB, params),
 		hashCache:           config.HashCache,
+		pruneTarget:         config.Prune,
+		snapshotBlockStored: make(chan struct{}, 1),
+		snapshotQuit:        make(chan struct{}),
+		interrupt:           config.Interrupt,
 		bestChain:           newChainView(nil),
+		utxoCache:           newUtxoCache(config.DB, config.UtxoCacheMaxSize),
 		orphans:             make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:         make(map[chainhash.Hash][]*orphanBlock),
 		warningCaches:       newThresholdCaches(vbNumBits),
@@ -1783,6 +2157,24 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Recover any changes to the utxo set that were lost due to an unclean
+	// shutdown.
+	if err := b.initUtxoState(config.Interrupt); err != nil {
+		return nil, err
+	}
+
+	// Determine which blocks have been pruned from the database.
+	if err := b.initPruneState(); err != nil {
+		return nil, err
+	}
+
+	// Determine whether the chain leading up to the utxo snapshot the
+	// chain was bootstrapped from still needs to be validated and refuse to
+	// run on a chain they were found to be invalid for.
+	if err := b.initUtxoSnapshotState(); err != nil {
+		return nil, err
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
@@ -964,3 +964,343 @@ func TestIntervalBlockHashes(t *testing.T) {
 		}
 	}
 }
+
+// TestChainTips ensures the chain tips reported for the block index have the
+// expected heights, branch lengths and statuses.
+func TestChainTips(t *testing.T) {
+	// Construct a synthetic block chain with a block index consisting of
+	// the following structure.
+	// 	genesis -> 1 -> 2 -> ... -> 15 -> 16  -> 17  -> 18
+	// 	                    |    |     \-> 16a -> 17a (validated)
+	// 	                    |    \-> 11b -> 12b (unvalidated)
+	// 	                    \-> 6c (invalid)
+	// 	               \-> 4d (header only)
+	tip := tstTip
+	chain := newFakeChain(&chaincfg.MainNetParams)
+	branch0Nodes := chainedNodes(chain.bestChain.Genesis(), 18)
+	branch1Nodes := chainedNodes(branch0Nodes[14], 2)
+	branch2Nodes := chainedNodes(branch0Nodes[9], 2)
+	branch3Nodes := chainedNodes(branch0Nodes[4], 1)
+	branch4Nodes := chainedNodes(branch0Nodes[2], 1)
+	for _, node := range branch0Nodes {
+		chain.index.SetStatusFlags(node, statusDataStored|statusValid)
+		chain.index.AddNode(node)
+	}
+	for _, node := range branch1Nodes {
+		chain.index.SetStatusFlags(node, statusDataStored|statusValid)
+		chain.index.AddNode(node)
+	}
+	for _, node := range branch2Nodes {
+		chain.index.SetStatusFlags(node, statusDataStored)
+		chain.index.AddNode(node)
+	}
+	for _, node := range branch3Nodes {
+		chain.index.SetStatusFlags(node, statusDataStored|
+			statusValidateFailed)
+		chain.index.AddNode(node)
+	}
+	for _, node := range branch4Nodes {
+		chain.index.AddNode(node)
+	}
+	chain.bestChain.SetTip(tip(branch0Nodes))
+
+	want := []ChainTip{{
+		Height:    18,
+		Hash:      tip(branch0Nodes).hash,
+		BranchLen: 0,
+		Status:    StatusActive,
+	}, {
+		Height:    17,
+		Hash:      tip(branch1Nodes).hash,
+		BranchLen: 2,
+		Status:    StatusValidFork,
+	}, {
+		Height:    12,
+		Hash:      tip(branch2Nodes).hash,
+		BranchLen: 2,
+		Status:    StatusValidHeaders,
+	}, {
+		Height:    6,
+		Hash:      tip(branch3Nodes).hash,
+		BranchLen: 1,
+		Status:    StatusInvalid,
+	}, {
+		Height:    4,
+		Hash:      tip(branch4Nodes).hash,
+		BranchLen: 1,
+		Status:    StatusHeadersOnly,
+	}}
+	got := chain.ChainTips()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ChainTips: unexpected tips -- got %v, want %v", got,
+			want)
+	}
+}
+
+// TestMostWorkCandidate ensures the block index selects the expected best
+// chain candidates as blocks are marked invalid and reconsidered.
+func TestMostWorkCandidate(t *testing.T) {
+	// Construct a synthetic block chain with a block index consisting of
+	// the following structure.
+	// 	genesis -> 1 -> 2 -> 3 -> 4 -> 5
+	// 	               \-> 3a -> 4a
+	// 	               \-> 3b -> 4b -> 5b -> 6b (header only)
+	//
+	// Nodes created via chainedNodes do not carry any work, so make use of
+	// fake nodes with the proof of work limit as their difficulty instead.
+	// The branch length doubles as the block version so that sibling nodes
+	// end up with distinct hashes.
+	tip := tstTip
+	params := chaincfg.RegressionNetParams
+	chain := newFakeChain(&params)
+	workNodes := func(parent *blockNode, numNodes int) []*blockNode {
+		nodes := make([]*blockNode, numNodes)
+		for i := 0; i < numNodes; i++ {
+			nodes[i] = newFakeNode(parent, int32(numNodes), params.PowLimitBits,
+				time.Unix(parent.timestamp+1, 0))
+			parent = nodes[i]
+		}
+		return nodes
+	}
+	branch0Nodes := workNodes(chain.bestChain.Genesis(), 5)
+	branch1Nodes := workNodes(branch0Nodes[1], 2)
+	branch2Nodes := workNodes(branch0Nodes[1], 4)
+	for _, nodes := range [][]*blockNode{branch0Nodes, branch1Nodes,
+		branch2Nodes[:3]} {
+
+		for _, node := range nodes {
+			chain.index.SetStatusFlags(node, statusDataStored)
+			chain.index.AddNode(node)
+		}
+	}
+	chain.index.AddNode(tip(branch2Nodes))
+	chain.bestChain.SetTip(tip(branch0Nodes))
+
+	// The current tip must be preferred over other blocks with the same
+	// amount of work and headers without data must never be selected.
+	if got := chain.index.MostWorkCandidate(tip(branch0Nodes)); got != tip(branch0Nodes) {
+		t.Fatalf("MostWorkCandidate: unexpected candidate -- got %v, "+
+			"want %v", got.hash, tip(branch0Nodes).hash)
+	}
+	if got := chain.index.MostWorkCandidate(branch2Nodes[2]); got != branch2Nodes[2] {
+		t.Fatalf("MostWorkCandidate: unexpected candidate -- got %v, "+
+			"want %v", got.hash, branch2Nodes[2].hash)
+	}
+
+	// The descendants of a block must all follow their parents.
+	descendants := chain.index.Descendants(branch0Nodes[1])
+	if len(descendants) != 9 {
+		t.Fatalf("Descendants: unexpected number of descendants -- got "+
+			"%d, want %d", len(descendants), 9)
+	}
+	seen := map[*blockNode]bool{branch0Nodes[1]: true}
+	for _, node := range descendants {
+		if !seen[node.parent] {
+			t.Fatalf("Descendants: block %v precedes its parent",
+				node.hash)
+		}
+		seen[node] = true
+	}
+
+	// Invalidating the first block of a branch must exclude the entire
+	// branch from consideration.
+	chain.index.SetStatusFlags(branch0Nodes[2], statusValidateFailed)
+	for _, node := range chain.index.Descendants(branch0Nodes[2]) {
+		chain.index.SetStatusFlags(node, statusInvalidAncestor)
+	}
+	chain.index.SetStatusFlags(branch2Nodes[0], statusValidateFailed)
+	for _, node := range chain.index.Descendants(branch2Nodes[0]) {
+		chain.index.SetStatusFlags(node, statusInvalidAncestor)
+	}
+	if got := chain.index.MostWorkCandidate(tip(branch0Nodes)); got != tip(branch1Nodes) {
+		t.Fatalf("MostWorkCandidate: unexpected candidate -- got %v, "+
+			"want %v", got.hash, tip(branch1Nodes).hash)
+	}
+
+	// Invalidating every branch must leave the common ancestor as the only
+	// candidate.
+	chain.index.SetStatusFlags(branch1Nodes[0], statusValidateFailed)
+	chain.index.SetStatusFlags(branch1Nodes[1], statusInvalidAncestor)
+	if got := chain.index.MostWorkCandidate(nil); got != branch0Nodes[1] {
+		t.Fatalf("MostWorkCandidate: unexpected candidate -- got %v, "+
+			"want %v", got.hash, branch0Nodes[1].hash)
+	}
+}
+
+// addTestBlock creates a block which only contains a coinbase transaction on
+// top of the passed parent, processes it, and returns its node.  The passed tag
+// is included in the coinbase so blocks on different branches at the same
+// height have distinct hashes.
+func addTestBlock(t *testing.T, chain *BlockChain, parent *blockNode, tag byte) *blockNode {
+	t.Helper()
+
+	height := parent.height + 1
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{byte(height), tag},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(&wire.TxOut{
+		Value:    CalcBlockSubsidy(height, chain.chainParams),
+		PkScript: []byte{0x51}, // OP_TRUE
+	})
+	merkles := BuildMerkleTreeStore([]*bteutil.Tx{bteutil.NewTx(coinbase)},
+		false)
+
+	timestamp := parent.Header().Timestamp.Add(time.Minute)
+	bits, err := chain.calcNextRequiredDifficulty(parent, timestamp)
+	if err != nil {
+		t.Fatalf("calcNextRequiredDifficulty: unexpected error: %v", err)
+	}
+	block := wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    4,
+			PrevBlock:  parent.hash,
+			MerkleRoot: *merkles[len(merkles)-1],
+			Timestamp:  timestamp,
+			Bits:       bits,
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+
+	// Solve the block, which is quick with the regression test network
+	// difficulty.
+	target := CompactToBig(bits)
+	for {
+		hash := block.Header.UncachedPowHash()
+		if HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		block.Header.Nonce++
+	}
+
+	blk := bteutil.NewBlock(&block)
+	_, isOrphan, err := chain.ProcessBlock(blk, BFNone)
+	if err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	if isOrphan {
+		t.Fatalf("ProcessBlock: block at height %d is an orphan", height)
+	}
+	return chain.index.LookupNode(blk.Hash())
+}
+
+// TestInvalidateReconsiderPreciousBlock ensures invalidating, reconsidering,
+// and preferring blocks reorganizes the chain to the expected tip and updates
+// the status flags of the affected blocks.
+func TestInvalidateReconsiderPreciousBlock(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("invalidateblock",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Construct a chain with the following structure where the main chain
+	// has the most work.
+	// 	genesis -> 1 -> 2 -> 3 -> 4
+	// 	               \-> 2a -> 3a
+	genesis := chain.bestChain.Genesis()
+	n1 := addTestBlock(t, chain, genesis, 0)
+	n2 := addTestBlock(t, chain, n1, 0)
+	n3 := addTestBlock(t, chain, n2, 0)
+	n4 := addTestBlock(t, chain, n3, 0)
+	n2a := addTestBlock(t, chain, n1, 1)
+	n3a := addTestBlock(t, chain, n2a, 1)
+
+	assertTip := func(want *blockNode) {
+		t.Helper()
+		if tip := chain.bestChain.Tip(); tip != want {
+			t.Fatalf("unexpected tip -- got height %d (%v), want "+
+				"height %d (%v)", tip.height, tip.hash,
+				want.height, want.hash)
+		}
+		if snapshot := chain.BestSnapshot(); snapshot.Hash != want.hash {
+			t.Fatalf("unexpected best snapshot hash -- got %v, "+
+				"want %v", snapshot.Hash, want.hash)
+		}
+	}
+	assertStatus := func(node *blockNode, want blockStatus) {
+		t.Helper()
+		const flags = statusValidateFailed | statusInvalidAncestor
+		if got := chain.index.NodeStatus(node) & flags; got != want {
+			t.Fatalf("unexpected invalid status of block at "+
+				"height %d -- got %v, want %v", node.height,
+				got, want)
+		}
+	}
+	assertTip(n4)
+
+	// Invalidating a main chain block must reorganize to the side chain
+	// and mark the block and its descendants invalid.
+	if err := chain.InvalidateBlock(&n2.hash); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	assertTip(n3a)
+	assertStatus(n2, statusValidateFailed)
+	assertStatus(n3, statusInvalidAncestor)
+	assertStatus(n4, statusInvalidAncestor)
+	assertStatus(n2a, 0)
+
+	// Invalidating the side chain as well must leave the common ancestor
+	// as the tip.
+	if err := chain.InvalidateBlock(&n2a.hash); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	assertTip(n1)
+	assertStatus(n3a, statusInvalidAncestor)
+
+	// Reconsidering a descendant of an invalidated block must also clear
+	// its ancestors and reorganize back to the chain with the most work.
+	if err := chain.ReconsiderBlock(&n4.hash); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	assertTip(n4)
+	for _, node := range []*blockNode{n2, n3, n4} {
+		assertStatus(node, 0)
+	}
+	assertStatus(n2a, statusValidateFailed)
+	if err := chain.ReconsiderBlock(&n2a.hash); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	assertTip(n4)
+	assertStatus(n3a, 0)
+
+	// Extend the side chain so it has the same work as the main chain.
+	// The first block seen with the most work must remain the tip.
+	n4a := addTestBlock(t, chain, n3a, 1)
+	assertTip(n4)
+
+	// Marking blocks as precious must break ties in their favor.
+	if err := chain.PreciousBlock(&n4a.hash); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	assertTip(n4a)
+	if err := chain.PreciousBlock(&n4.hash); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	assertTip(n4)
+
+	// Blocks with less work and blocks on the main chain have no effect.
+	if err := chain.PreciousBlock(&n3a.hash); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	assertTip(n4)
+	if err := chain.PreciousBlock(&n3.hash); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	assertTip(n4)
+
+	// Blocks which are only known by their header can't be preferred.
+	header := newFakeNode(n4a, 4, n4a.bits,
+		n4a.Header().Timestamp.Add(time.Minute))
+	chain.index.AddNode(header)
+	err = chain.PreciousBlock(&header.hash)
+	if _, ok := err.(MissingBlockDataError); !ok {
+		t.Fatalf("PreciousBlock: unexpected error -- got %v, want %T",
+			err, MissingBlockDataError(""))
+	}
+	assertTip(n4)
+}
@@ -67,6 +67,33 @@ var (
 	// unspent transaction output set.
 	utxoSetBucketName = []byte("utxosetv2")
 
+	// utxoStateConsistencyKeyName is the name of the db key used to store
+	// the hash of the block the utxo set in the database is consistent
+	// with.  It trails the best chain state while changes to the utxo set
+	// are being held in the utxo cache.
+	utxoStateConsistencyKeyName = []byte("utxostateconsistency")
+
+	// utxoSnapshotBaseKeyName is the name of the db key used to store the
+	// hash of the block the utxo set was bootstrapped from when it was
+	// loaded from a utxo snapshot.  It is removed once the blocks of the
+	// chain leading up to the block have been validated.
+	utxoSnapshotBaseKeyName = []byte("utxosnapshotbase")
+
+	// utxoSnapshotInvalidKeyName is the name of the db key used to store
+	// the reason the chain leading up to the block the utxo set was
+	// bootstrapped from failed validation.
+	utxoSnapshotInvalidKeyName = []byte("utxosnapshotinvalid")
+
+	// snapshotUtxoSetBucketName is the name of the db bucket used to house
+	// the unspent transaction output set which results from connecting the
+	// blocks leading up to the block the utxo set was bootstrapped from.
+	// It is compared against the snapshot once the block is reached.
+	snapshotUtxoSetBucketName = []byte("snapshotutxoset")
+
+	// snapshotUtxoStateKeyName is the name of the db key used to store the
+	// hash of the last block connected to the snapshot utxo set.
+	snapshotUtxoStateKeyName = []byte("snapshotutxostate")
+
 	// byteOrder is the preferred byte order used for serializing numeric
 	// fields for storage in the database.
 	byteOrder = binary.LittleEndian
@@ -730,10 +757,19 @@ func dbFetchUtxoEntryByHash(dbTx database.Tx, hash *chainhash.Hash) (*UtxoEntry,
 // When there is no entry for the provided output, nil will be returned for both
 // the entry and the error.
 func dbFetchUtxoEntry(dbTx database.Tx, outpoint wire.OutPoint) (*UtxoEntry, error) {
+	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+	return dbFetchUtxoEntryFromBucket(utxoBucket, outpoint)
+}
+
+// dbFetchUtxoEntryFromBucket fetches the specified transaction output from the
+// passed bucket which houses a utxo set.
+//
+// When there is no entry for the provided output, nil will be returned for both
+// the entry and the error.
+func dbFetchUtxoEntryFromBucket(utxoBucket database.Bucket, outpoint wire.OutPoint) (*UtxoEntry, error) {
 	// Fetch the unspent transaction output information for the passed
 	// transaction output.  Return now when there is no entry.
 	key := outpointKey(outpoint)
-	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
 	serializedUtxo := utxoBucket.Get(*key)
 	recycleOutpointKey(key)
 	if serializedUtxo == nil {
@@ -772,6 +808,13 @@ func dbFetchUtxoEntry(dbTx database.Tx, outpoint wire.OutPoint) (*UtxoEntry, err
 // to the database.
 func dbPutUtxoView(dbTx database.Tx, view *UtxoViewpoint) error {
 	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+	return dbPutUtxoViewToBucket(utxoBucket, view)
+}
+
+// dbPutUtxoViewToBucket updates the utxo set housed by the passed bucket based
+// on the provided utxo view contents and state in the same way as
+// dbPutUtxoView.
+func dbPutUtxoViewToBucket(utxoBucket database.Bucket, view *UtxoViewpoint) error {
 	for outpoint, entry := range view.entries {
 		// No need to update the database if the entry was not modified.
 		if entry == nil || !entry.isModified() {
@@ -984,6 +1027,101 @@ func deserializeBestChainState(serializedData []byte) (bestChainState, error) {
 	return state, nil
 }
 
+// dbFetchUtxoStateConsistency uses an existing database transaction to fetch
+// the hash of the block the utxo set in the database is consistent with.  It
+// returns nil when the hash has not been stored yet.
+func dbFetchUtxoStateConsistency(dbTx database.Tx) *chainhash.Hash {
+	serialized := dbTx.Metadata().Get(utxoStateConsistencyKeyName)
+	if len(serialized) != chainhash.HashSize {
+		return nil
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], serialized)
+	return &hash
+}
+
+// dbPutUtxoStateConsistency uses an existing database transaction to update
+// the hash of the block the utxo set in the database is consistent with.
+func dbPutUtxoStateConsistency(dbTx database.Tx, hash *chainhash.Hash) error {
+	return dbTx.Metadata().Put(utxoStateConsistencyKeyName, hash[:])
+}
+
+// dbFetchUtxoSnapshotBase uses an existing database transaction to fetch the
+// hash of the block the utxo set was bootstrapped from.  It returns nil when
+// the utxo set was not loaded from a snapshot or the chain leading up to the
+// block has already been validated.
+func dbFetchUtxoSnapshotBase(dbTx database.Tx) *chainhash.Hash {
+	serialized := dbTx.Metadata().Get(utxoSnapshotBaseKeyName)
+	if len(serialized) != chainhash.HashSize {
+		return nil
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], serialized)
+	return &hash
+}
+
+// dbPutUtxoSnapshotBase uses an existing database transaction to store the hash
+// of the block the utxo set was bootstrapped from.
+func dbPutUtxoSnapshotBase(dbTx database.Tx, hash *chainhash.Hash) error {
+	return dbTx.Metadata().Put(utxoSnapshotBaseKeyName, hash[:])
+}
+
+// dbRemoveUtxoSnapshotBase uses an existing database transaction to remove the
+// hash of the block the utxo set was bootstrapped from.
+func dbRemoveUtxoSnapshotBase(dbTx database.Tx) error {
+	return dbTx.Metadata().Delete(utxoSnapshotBaseKeyName)
+}
+
+// dbFetchUtxoSnapshotInvalid uses an existing database transaction to fetch the
+// reason the chain leading up to the block the utxo set was bootstrapped from
+// is invalid.  An empty string is returned when it has not been found invalid.
+func dbFetchUtxoSnapshotInvalid(dbTx database.Tx) string {
+	return string(dbTx.Metadata().Get(utxoSnapshotInvalidKeyName))
+}
+
+// dbPutUtxoSnapshotInvalid uses an existing database transaction to store the
+// reason the chain leading up to the block the utxo set was bootstrapped from
+// is invalid.
+func dbPutUtxoSnapshotInvalid(dbTx database.Tx, reason string) error {
+	return dbTx.Metadata().Put(utxoSnapshotInvalidKeyName, []byte(reason))
+}
+
+// dbFetchSnapshotUtxoState uses an existing database transaction to fetch the
+// hash of the last block connected to the snapshot utxo set.  It returns nil
+// when no blocks have been connected to it yet.
+func dbFetchSnapshotUtxoState(dbTx database.Tx) *chainhash.Hash {
+	serialized := dbTx.Metadata().Get(snapshotUtxoStateKeyName)
+	if len(serialized) != chainhash.HashSize {
+		return nil
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], serialized)
+	return &hash
+}
+
+// dbPutSnapshotUtxoState uses an existing database transaction to store the
+// hash of the last block connected to the snapshot utxo set.
+func dbPutSnapshotUtxoState(dbTx database.Tx, hash *chainhash.Hash) error {
+	return dbTx.Metadata().Put(snapshotUtxoStateKeyName, hash[:])
+}
+
+// dbRemoveSnapshotUtxoSet uses an existing database transaction to remove the
+// snapshot utxo set along with the hash of the last block connected to it.
+func dbRemoveSnapshotUtxoSet(dbTx database.Tx) error {
+	meta := dbTx.Metadata()
+	if err := meta.Delete(snapshotUtxoStateKeyName); err != nil {
+		return err
+	}
+	err := meta.DeleteBucket(snapshotUtxoSetBucketName)
+	if err != nil && !isDbBucketNotFoundErr(err) {
+		return err
+	}
+	return nil
+}
+
 // dbPutBestState uses an existing database transaction to update the best chain
 // state with the given parameters.
 func dbPutBestState(dbTx database.Tx, snapshot *BestState, workSum *big.Int) error {
@@ -1158,12 +1296,17 @@ func (b *BlockChain) initChainState() error {
 				return err
 			}
 
+			// The block hash is part of the index key, so use it rather
+			// than recomputing the expensive yespower hash of every
+			// header in the index.
+			var blockHash chainhash.Hash
+			copy(blockHash[:], cursor.Key()[4:4+chainhash.HashSize])
+
 			// Determine the parent block node. Since we iterate block headers
 			// in order of height, if the blocks are mostly linear there is a
 			// very good chance the previous header processed is the parent.
 			var parent *blockNode
 			if lastNode == nil {
-				blockHash := header.BlockHash()
 				if !blockHash.IsEqual(b.chainParams.GenesisHash) {
 					return AssertError(fmt.Sprintf("initChainState: Expected "+
 						"first entry in block index to be genesis block, "+
@@ -1178,14 +1321,14 @@ func (b *BlockChain) initChainState() error {
 				parent = b.index.LookupNode(&header.PrevBlock)
 				if parent == nil {
 					return AssertError(fmt.Sprintf("initChainState: Could "+
-						"not find parent for block %s", header.BlockHash()))
+						"not find parent for block %s", blockHash))
 				}
 			}
 
 			// Initialize the block node for the block, connect it,
 			// and add it to the block index.
 			node := new(blockNode)
-			initBlockNode(node, header, parent)
+			initBlockNode(node, header, &blockHash, parent)
 			node.status = status
 			b.index.addNode(node)
 
@@ -1201,17 +1344,6 @@ func (b *BlockChain) initChainState() error {
 		}
 		b.bestChain.SetTip(tip)
 
-		// Load the raw block bytes for the best block.
-		blockBytes, err := dbTx.FetchBlock(&state.hash)
-		if err != nil {
-			return err
-		}
-		var block wire.MsgBlock
-		err = block.Deserialize(bytes.NewReader(blockBytes))
-		if err != nil {
-			return err
-		}
-
 		// As a final consistency check, we'll run through all the
 		// nodes which are ancestors of the current chain tip, and mark
 		// them as valid if they aren't already marked as such.  This
@@ -1231,10 +1363,26 @@ func (b *BlockChain) initChainState() error {
 			}
 		}
 
-		// Initialize the state related to the best block.
-		blockSize := uint64(len(blockBytes))
-		blockWeight := uint64(GetBlockWeight(bteutil.NewBlock(&block)))
-		numTxns := uint64(len(block.Transactions))
+		// Initialize the state related to the best block.  The block
+		// data is not available when the chain was bootstrapped from a
+		// utxo snapshot and no blocks have been connected since.
+		var blockSize, blockWeight, numTxns uint64
+		if tip.status.HaveData() {
+			// Load the raw block bytes for the best block.
+			blockBytes, err := dbTx.FetchBlock(&state.hash)
+			if err != nil {
+				return err
+			}
+			var block wire.MsgBlock
+			err = block.Deserialize(bytes.NewReader(blockBytes))
+			if err != nil {
+				return err
+			}
+
+			blockSize = uint64(len(blockBytes))
+			blockWeight = uint64(GetBlockWeight(bteutil.NewBlock(&block)))
+			numTxns = uint64(len(block.Transactions))
+		}
 		b.stateSnapshot = newBestState(tip, blockSize, blockWeight,
 			numTxns, state.totalTxns, tip.CalcPastMedianTime())
 
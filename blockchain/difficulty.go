@@ -5,7 +5,6 @@
 package blockchain
 
 import (
-	"math"
 	"math/big"
 	"time"
 
@@ -220,8 +219,10 @@ func (b *BlockChain) findPrevTestNetDifficulty(startNode *blockNode) uint32 {
 // the exported version uses the current best chain as the previous block node
 // while this function accepts any block node.
 func (b *BlockChain) calcNextRequiredDifficulty(lastNode *blockNode, newBlockTime time.Time) (uint32, error) {
-	// Genesis block.
-	if lastNode.height > 90 {
+	// Use the LWMA difficulty algorithm once it is active.
+	if b.chainParams.LWMAWindow > 0 &&
+		lastNode.height+1 >= b.chainParams.LWMAActivationHeight {
+
 		return b.lwmaCalculateNextWorkRequired(lastNode, newBlockTime)
 	}
 
@@ -288,40 +289,53 @@ func (b *BlockChain) calcNextRequiredDifficulty(lastNode *blockNode, newBlockTim
 	return newTargetBits, nil
 }
 
-
+// lwmaCalculateNextWorkRequired calculates the required difficulty for the
+// block after the passed previous block node using the linearly weighted moving
+// average (LWMA) difficulty algorithm.  The window size, target spacing and
+// solve time limit are taken from the chain parameters.
+//
+// Blocks which do not have a full window of previous blocks available require
+// the proof of work limit.
 func (b *BlockChain) lwmaCalculateNextWorkRequired(lastNode *blockNode, newBlockTime time.Time) (uint32, error) {
-	const T int64 = 60
-	const N int64 = 90
-	const k int64 = N * (N + 1) * T / 2
+	T := int64(b.chainParams.LWMATargetSpacing / time.Second)
+	N := int64(b.chainParams.LWMAWindow)
+	k := N * (N + 1) * T / 2
 	height := int64(lastNode.height)
 	if height <= N {
-		log.Debugf("lwmaCalculateNextWorkRequired: block height %d must be greater than %d", height, N)
-		return 0, nil
+		log.Debugf("lwmaCalculateNextWorkRequired: block height %d "+
+			"does not have a full window of %d blocks -- using the "+
+			"proof of work limit", height+1, N)
+		return b.chainParams.PowLimitBits, nil
 	}
 
+	// Clamp the solve time of each block to the configured limit in order
+	// to reduce the impact of manipulated timestamps.
+	maxSolvetime := b.chainParams.LWMASolvetimeLimit * T
+	minSolvetime := -maxSolvetime
+
+	// Walk backwards through the window so the most recent block receives
+	// the highest weight of N and the oldest one a weight of 1.
 	sumTarget := big.NewInt(0)
+	divisor := big.NewInt(k * N)
 	t := int64(0)
-	j := int64(0)
-	solvetime := int64(0)
-
-	for i := height - N + 1; i <= height; i++ {
-		blockNode := lastNode.Ancestor(int32(i))
-		blockNodePrev := blockNode.Ancestor(int32(i - 1))
-
-		solvetime = blockNode.timestamp - blockNodePrev.timestamp
-		solvetime = int64(math.Max(float64(-6*T), math.Min(float64(solvetime), float64(6*T))))
-		log.Debugf("lwmaCalculateNextWorkRequired: block height=%d, solvetime=%d", blockNode.height, solvetime)
-		j++
+	blockNode := lastNode
+	for j := N; j > 0; j-- {
+		solvetime := blockNode.timestamp - blockNode.parent.timestamp
+		if solvetime > maxSolvetime {
+			solvetime = maxSolvetime
+		} else if solvetime < minSolvetime {
+			solvetime = minSolvetime
+		}
 		t += solvetime * j
-		target := CompactToBig(blockNode.bits)
-		sumTarget.Add(sumTarget, target.Div(target, big.NewInt(int64(k*N))))
-		log.Debugf("lwmaCalculateNextWorkRequired: block height=%d, target=%d, sumTarget=%d", blockNode.height, target, sumTarget)
 
+		target := CompactToBig(blockNode.bits)
+		sumTarget.Add(sumTarget, target.Div(target, divisor))
+		blockNode = blockNode.parent
 	}
 
 	// Calculate the next target using the LWMA formula
 	if t < k/10 {
-		t = k/10
+		t = k / 10
 	}
 	nextTarget := new(big.Int).Mul(big.NewInt(t), sumTarget)
 	log.Debugf("lwmaCalculateNextWorkRequired: t=%d, sumTarget=%d, nextTarget=%d", t, sumTarget, nextTarget)
@@ -335,7 +349,6 @@ func (b *BlockChain) lwmaCalculateNextWorkRequired(lastNode *blockNode, newBlock
 	return BigToCompact(nextTarget), nil
 }
 
-
 // CalcNextRequiredDifficulty calculates the required difficulty for the block
 // after the end of the current best chain based on the difficulty retarget
 // rules.
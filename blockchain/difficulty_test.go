@@ -7,6 +7,9 @@ package blockchain
 import (
 	"math/big"
 	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/chaincfg"
 )
 
 // TestBigToCompact ensures BigToCompact converts big integers to the expected
@@ -73,3 +76,109 @@ func TestCalcWork(t *testing.T) {
 		}
 	}
 }
+
+// TestLWMACalcNextRequiredDifficulty ensures the LWMA difficulty algorithm
+// honors the parameters configured in the chain parameters.
+func TestLWMACalcNextRequiredDifficulty(t *testing.T) {
+	params := chaincfg.RegressionNetParams
+	params.LWMAActivationHeight = 3
+	params.LWMAWindow = 5
+	params.LWMATargetSpacing = time.Minute
+	params.LWMASolvetimeLimit = 6
+
+	// extendChain extends the passed node by the requested number of nodes
+	// where every block takes the solve time returned by the passed
+	// function.
+	const bits = 0x1e00ffff
+	extendChain := func(node *blockNode, numNodes int, solvetime func(int) time.Duration) *blockNode {
+		timestamp := time.Unix(node.timestamp, 0)
+		for i := 0; i < numNodes; i++ {
+			timestamp = timestamp.Add(solvetime(i))
+			node = newFakeNode(node, 1, bits, timestamp)
+		}
+		return node
+	}
+	steady := func(int) time.Duration { return params.LWMATargetSpacing }
+	nextBits := func(b *BlockChain, node *blockNode) uint32 {
+		t.Helper()
+		nextBits, err := b.calcNextRequiredDifficulty(node, time.Time{})
+		if err != nil {
+			t.Fatalf("calcNextRequiredDifficulty: unexpected error: %v",
+				err)
+		}
+		return nextBits
+	}
+
+	// Blocks without a full window of previous blocks must require the
+	// proof of work limit.
+	b := newFakeChain(&params)
+	node := extendChain(b.bestChain.Tip(), int(params.LWMAWindow), steady)
+	if got := nextBits(b, node); got != params.PowLimitBits {
+		t.Fatalf("unexpected bits below the window - got %08x, want %08x",
+			got, params.PowLimitBits)
+	}
+
+	// Blocks found at the target spacing must keep the difficulty at
+	// roughly the same level.
+	node = extendChain(node, int(params.LWMAWindow), steady)
+	steadyTarget := CompactToBig(nextBits(b, node))
+	want := CompactToBig(bits)
+	diff := new(big.Int).Sub(want, steadyTarget)
+	if diff.Sign() < 0 || diff.Cmp(new(big.Int).Rsh(want, 8)) > 0 {
+		t.Fatalf("unexpected target for steady blocks - got %064x, want "+
+			"%064x", steadyTarget, want)
+	}
+
+	// Slower blocks must lower the difficulty and faster blocks must raise
+	// it.
+	b = newFakeChain(&params)
+	node = extendChain(b.bestChain.Tip(), int(params.LWMAWindow)*2, func(int) time.Duration {
+		return params.LWMATargetSpacing * 2
+	})
+	if got := CompactToBig(nextBits(b, node)); got.Cmp(steadyTarget) <= 0 {
+		t.Fatalf("slow blocks did not lower the difficulty - got %064x",
+			got)
+	}
+	b = newFakeChain(&params)
+	node = extendChain(b.bestChain.Tip(), int(params.LWMAWindow)*2, func(int) time.Duration {
+		return params.LWMATargetSpacing / 2
+	})
+	if got := CompactToBig(nextBits(b, node)); got.Cmp(steadyTarget) >= 0 {
+		t.Fatalf("fast blocks did not raise the difficulty - got %064x",
+			got)
+	}
+
+	// Solve times beyond the configured limit must be clamped.
+	limit := params.LWMATargetSpacing *
+		time.Duration(params.LWMASolvetimeLimit)
+	numNodes := int(params.LWMAWindow) * 2
+	b = newFakeChain(&params)
+	node = extendChain(b.bestChain.Tip(), numNodes, func(i int) time.Duration {
+		if i == numNodes-1 {
+			return limit
+		}
+		return params.LWMATargetSpacing
+	})
+	clampedBits := nextBits(b, node)
+	b = newFakeChain(&params)
+	node = extendChain(b.bestChain.Tip(), numNodes, func(i int) time.Duration {
+		if i == numNodes-1 {
+			return limit * 100
+		}
+		return params.LWMATargetSpacing
+	})
+	if got := nextBits(b, node); got != clampedBits {
+		t.Fatalf("solve time was not clamped - got %08x, want %08x",
+			got, clampedBits)
+	}
+
+	// Disabling LWMA must fall back to the legacy retarget rules which
+	// keep the difficulty of the previous block between retargets.
+	params.LWMAWindow = 0
+	b = newFakeChain(&params)
+	node = extendChain(b.bestChain.Tip(), 10, steady)
+	if got := nextBits(b, node); got != bits {
+		t.Fatalf("unexpected bits with LWMA disabled - got %08x, want "+
+			"%08x", got, bits)
+	}
+}
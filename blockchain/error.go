@@ -18,6 +18,16 @@ func (e DeploymentError) Error() string {
 	return fmt.Sprintf("deployment ID %d does not exist", uint32(e))
 }
 
+// MissingBlockDataError identifies an error that indicates an operation
+// requires the data of a block which is only known by its header.
+type MissingBlockDataError string
+
+// Error returns the missing block data error as a human-readable string and
+// satisfies the error interface.
+func (e MissingBlockDataError) Error() string {
+	return fmt.Sprintf("block %s is only known by its header", string(e))
+}
+
 // AssertError identifies an error that indicates an internal code consistency
 // issue and should be treated as a critical and unrecoverable error.
 type AssertError string
@@ -220,6 +230,12 @@ const (
 	// current chain tip. This is not a block validation rule, but is required
 	// for block proposals submitted via getblocktemplate RPC.
 	ErrPrevBlockNotBest
+
+	// ErrInvalidUtxoSnapshot indicates that a utxo snapshot can't be loaded
+	// because it is malformed, is not one of the known snapshots for the
+	// network, or the chain is not in a state which allows loading it.
+	// This is not a block validation rule.
+	ErrInvalidUtxoSnapshot
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -267,6 +283,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrPreviousBlockUnknown:      "ErrPreviousBlockUnknown",
 	ErrInvalidAncestorBlock:      "ErrInvalidAncestorBlock",
 	ErrPrevBlockNotBest:          "ErrPrevBlockNotBest",
+	ErrInvalidUtxoSnapshot:       "ErrInvalidUtxoSnapshot",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -29,14 +29,20 @@ const (
 	// NTBlockDisconnected indicates the associated block was disconnected
 	// from the main chain.
 	NTBlockDisconnected
+
+	// NTUtxoSnapshotInvalid indicates the chain leading up to the block the
+	// utxo set was bootstrapped from failed validation, so the utxo set can
+	// no longer be trusted.
+	NTUtxoSnapshotInvalid
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
 // names for pretty printing.
 var notificationTypeStrings = map[NotificationType]string{
-	NTBlockAccepted:     "NTBlockAccepted",
-	NTBlockConnected:    "NTBlockConnected",
-	NTBlockDisconnected: "NTBlockDisconnected",
+	NTBlockAccepted:       "NTBlockAccepted",
+	NTBlockConnected:      "NTBlockConnected",
+	NTBlockDisconnected:   "NTBlockDisconnected",
+	NTUtxoSnapshotInvalid: "NTUtxoSnapshotInvalid",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -50,9 +56,10 @@ func (n NotificationType) String() string {
 // Notification defines notification that is sent to the caller via the callback
 // function provided during the call to New and consists of a notification type
 // as well as associated data that depends on the type as follows:
-// 	- NTBlockAccepted:     *bteutil.Block
-// 	- NTBlockConnected:    *bteutil.Block
-// 	- NTBlockDisconnected: *bteutil.Block
+// 	- NTBlockAccepted:       *bteutil.Block
+// 	- NTBlockConnected:      *bteutil.Block
+// 	- NTBlockDisconnected:   *bteutil.Block
+// 	- NTUtxoSnapshotInvalid: error
 type Notification struct {
 	Type NotificationType
 	Data interface{}
@@ -0,0 +1,183 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+)
+
+// MinBlocksToKeep is the minimum number of blocks at the end of the main chain
+// that are never pruned from the database.  This ensures reorganizations of up
+// to this depth are always possible and that recent blocks can be served to
+// peers as required by BIP0159.
+const MinBlocksToKeep = 288
+
+// initPruneState determines the height of the first block in the main chain
+// that has not been pruned from the database or skipped by bootstrapping the
+// chain from a utxo snapshot.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initPruneState() error {
+	var beenPruned bool
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		beenPruned, err = dbTx.BeenPruned()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Blocks can't be downloaded again once they have been pruned, so the
+	// chain must keep running in pruned mode.
+	if beenPruned && b.pruneTarget == 0 {
+		return AssertError("the database has been pruned, so pruning " +
+			"can't be disabled without recreating it")
+	}
+
+	// Walk down from the tip since the blocks of the main chain that are
+	// available are always the most recent ones.  Notice the genesis block
+	// does not mark the lowest available block when the chain was
+	// bootstrapped from a utxo snapshot because its data is stored even
+	// though the blocks after it are not.
+	tip := b.bestChain.Tip()
+	b.pruneHeight = tip.height + 1
+	for node := tip; node != nil; node = node.parent {
+		if !b.index.NodeStatus(node).HaveData() {
+			break
+		}
+		b.pruneHeight = node.height
+	}
+
+	return nil
+}
+
+// maybePrune removes the oldest blocks from the database when the blocks it
+// stores exceed the prune target.  The most recent MinBlocksToKeep blocks of
+// the main chain are always kept along with any blocks that are needed to
+// recover changes in the utxo cache which have not been flushed yet.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) maybePrune() error {
+	if b.pruneTarget == 0 {
+		return nil
+	}
+
+	pruneLimit := b.bestChain.Tip().height - MinBlocksToKeep
+	flushedNode := b.index.LookupNode(&b.utxoCache.lastFlushHash)
+	if flushedNode != nil && flushedNode.height < pruneLimit {
+		pruneLimit = flushedNode.height
+	}
+	if pruneLimit < 0 {
+		return nil
+	}
+
+	// Nothing can be pruned until every block in the oldest block file
+	// that was kept by the last attempt is no longer needed.
+	if pruneLimit < b.pruneRetryLimit {
+		return nil
+	}
+	if b.snapshotBase != nil && b.snapshotHeight < b.pruneRetrySnapshotHeight {
+		return nil
+	}
+
+	// Blocks which are not in the block index are always kept since it's
+	// impossible to tell whether or not they are still needed.  The blocks
+	// leading up to the block the utxo set was bootstrapped from are also
+	// kept until they have been connected by the background validation.
+	//
+	// The limits which must be reached before the kept blocks may be
+	// pruned are tracked so the next attempt is skipped until then.  Since
+	// the keep function is called for every block of the file which stops
+	// pruning, they cover all of its blocks.
+	var retryLimit, retrySnapshotHeight int32
+	keepBlock := func(hash *chainhash.Hash) bool {
+		node := b.index.LookupNode(hash)
+		if node == nil {
+			if retryLimit <= pruneLimit {
+				retryLimit = pruneLimit + 1
+			}
+			return true
+		}
+		if node.height > pruneLimit {
+			if node.height > retryLimit {
+				retryLimit = node.height
+			}
+			return true
+		}
+		if b.snapshotBase != nil && node.height > b.snapshotHeight &&
+			node.height <= b.snapshotBase.height {
+
+			if node.height > retrySnapshotHeight {
+				retrySnapshotHeight = node.height
+			}
+			return true
+		}
+		return false
+	}
+
+	// Remove the blocks and mark their nodes as no longer having data in
+	// the same transaction so the block index never refers to data that
+	// does not exist.
+	var prunedHashes []chainhash.Hash
+	pruneHeight := b.pruneHeight
+	err := b.db.Update(func(dbTx database.Tx) error {
+		var err error
+		prunedHashes, err = dbTx.PruneBlocks(b.pruneTarget, keepBlock)
+		if err != nil {
+			return err
+		}
+
+		for i := range prunedHashes {
+			node := b.index.LookupNode(&prunedHashes[i])
+			if node == nil {
+				continue
+			}
+			b.index.UnsetStatusFlags(node, statusDataStored)
+			if err := dbStoreBlockNode(dbTx, node); err != nil {
+				return err
+			}
+
+			if b.bestChain.Contains(node) && node.height >= pruneHeight {
+				pruneHeight = node.height + 1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.pruneRetryLimit = retryLimit
+	b.pruneRetrySnapshotHeight = retrySnapshotHeight
+
+	if len(prunedHashes) > 0 {
+		log.Infof("Pruned %d blocks (prune height %d)", len(prunedHashes),
+			pruneHeight)
+		b.pruneHeight = pruneHeight
+	}
+	return nil
+}
+
+// IsPruned returns whether or not the chain instance is configured to prune
+// old blocks from the database.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsPruned() bool {
+	return b.pruneTarget != 0
+}
+
+// PruneHeight returns the height of the first block in the main chain which
+// is available in the database.  Blocks below it have either been pruned or
+// were never downloaded because the chain was bootstrapped from a utxo
+// snapshot.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PruneHeight() int32 {
+	b.chainLock.RLock()
+	pruneHeight := b.pruneHeight
+	b.chainLock.RUnlock()
+	return pruneHeight
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	"github.com/mraksoll4/bted/wire"
+)
+
+const (
+	// utxoFlushPeriodicInterval is the maximum amount of time the utxo
+	// cache is allowed to hold changes before they are written to the
+	// database regardless of how much memory it uses.  This limits the
+	// amount of work needed to recover the utxo set after an unclean
+	// shutdown.
+	utxoFlushPeriodicInterval = 5 * time.Minute
+
+	// cachedEntryOverhead is the approximate number of bytes used by each
+	// entry in the utxo cache excluding its public key script.  It
+	// accounts for the outpoint key and entry pointer in the map along
+	// with the entry itself.
+	cachedEntryOverhead = uint64(unsafe.Sizeof(wire.OutPoint{})) +
+		uint64(unsafe.Sizeof(uintptr(0))) +
+		uint64(unsafe.Sizeof(UtxoEntry{}))
+)
+
+// memoryUsage returns the approximate number of bytes the passed entry uses
+// when it is held in the utxo cache.
+func (entry *UtxoEntry) memoryUsage() uint64 {
+	return cachedEntryOverhead + uint64(len(entry.pkScript))
+}
+
+// utxoCache is a write-back cache which sits in front of the utxo set in the
+// database.  Changes to the utxo set made by connecting blocks to the main
+// chain are accumulated in the cache and written to the database in batches
+// which spans many blocks, which greatly reduces the number of database writes
+// during initial block download since most outputs are spent shortly after
+// they are created and therefore never need to be written at all.
+//
+// Only outputs which have been modified since the last flush are held in the
+// cache.  Spent outputs which still exist in the database are kept as spent
+// entries so they can be removed from the database when the cache is flushed.
+//
+// The hash of the block the utxo set in the database is consistent with is
+// stored along with each flush so that any changes lost due to an unclean
+// shutdown can be recovered by connecting the blocks after it again.
+//
+// The cache is NOT safe for concurrent access.  It is protected by the chain
+// lock.
+type utxoCache struct {
+	db database.DB
+
+	// maxTotalMemoryUsage is the approximate maximum number of bytes the
+	// cached entries are allowed to use before they are flushed to the
+	// database.  A limit of zero results in a flush after every block.
+	maxTotalMemoryUsage uint64
+
+	// cachedEntries houses all outputs modified since the last flush and
+	// totalMemoryUsage tracks the approximate number of bytes they use.
+	cachedEntries    map[wire.OutPoint]*UtxoEntry
+	totalMemoryUsage uint64
+
+	// lastFlushHash is the hash of the block the utxo set in the database
+	// is consistent with and lastFlushTime is the time it was written.
+	lastFlushHash chainhash.Hash
+	lastFlushTime time.Time
+}
+
+// newUtxoCache returns a new utxo cache for the utxo set in the passed
+// database which holds at most roughly maxTotalMemoryUsage bytes of changes
+// before they are flushed.
+func newUtxoCache(db database.DB, maxTotalMemoryUsage uint64) *utxoCache {
+	return &utxoCache{
+		db:                  db,
+		maxTotalMemoryUsage: maxTotalMemoryUsage,
+		cachedEntries:       make(map[wire.OutPoint]*UtxoEntry),
+		lastFlushTime:       time.Now(),
+	}
+}
+
+// fetchEntry returns the unspent output for the passed outpoint from the point
+// of view of the end of the main chain.  The cache is consulted first and the
+// database is only accessed when the output has not been modified since the
+// last flush.
+//
+// The returned entry is a copy, so callers are free to modify it.  It is nil
+// when the output is spent or does not exist.
+func (c *utxoCache) fetchEntry(dbTx database.Tx, outpoint wire.OutPoint) (*UtxoEntry, error) {
+	if entry, ok := c.cachedEntries[outpoint]; ok {
+		if entry.IsSpent() {
+			return nil, nil
+		}
+
+		// The flags only describe the state of the cached entry, so
+		// don't leak them into the view the caller is building.
+		entry = entry.Clone()
+		entry.packedFlags &^= tfModified | tfFresh
+		return entry, nil
+	}
+
+	return dbFetchUtxoEntry(dbTx, outpoint)
+}
+
+// commit merges all of the modified entries in the passed view into the cache.
+// Fresh outputs which are also spent in the view are dropped altogether since
+// they never made it to the database.
+func (c *utxoCache) commit(view *UtxoViewpoint) {
+	for outpoint, entry := range view.entries {
+		// No need to update the cache if the entry was not modified.
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+
+		// An output that is already in the cache only stays fresh when
+		// the cached entry is fresh, because the view might not know it
+		// has been written to the database when it was created earlier.
+		fresh := entry.isFresh()
+		if cached, ok := c.cachedEntries[outpoint]; ok {
+			fresh = cached.isFresh()
+			c.totalMemoryUsage -= cached.memoryUsage()
+			delete(c.cachedEntries, outpoint)
+		}
+
+		if entry.IsSpent() {
+			if fresh {
+				continue
+			}
+
+			// Only the fact the output is spent is needed in order
+			// to remove it from the database.
+			c.cachedEntries[outpoint] = &UtxoEntry{
+				packedFlags: tfSpent | tfModified,
+			}
+			c.totalMemoryUsage += cachedEntryOverhead
+			continue
+		}
+
+		// Copy the public key script so the cache does not keep the
+		// scripts of entire transactions alive.
+		cachedEntry := &UtxoEntry{
+			amount:      entry.amount,
+			pkScript:    append([]byte(nil), entry.pkScript...),
+			blockHeight: entry.blockHeight,
+			packedFlags: entry.packedFlags&tfCoinBase | tfModified,
+		}
+		if fresh {
+			cachedEntry.packedFlags |= tfFresh
+		}
+		c.cachedEntries[outpoint] = cachedEntry
+		c.totalMemoryUsage += cachedEntry.memoryUsage()
+	}
+}
+
+// flush writes all of the cached changes to the database along with the passed
+// hash of the block the resulting utxo set is consistent with and then empties
+// the cache.
+func (c *utxoCache) flush(bestHash *chainhash.Hash) error {
+	// Nothing to do when the database is already up to date.
+	if len(c.cachedEntries) == 0 && c.lastFlushHash == *bestHash {
+		return nil
+	}
+
+	err := c.db.Update(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+		for outpoint, entry := range c.cachedEntries {
+			// Remove the utxo entry if it is spent.
+			if entry.IsSpent() {
+				key := outpointKey(outpoint)
+				err := utxoBucket.Delete(*key)
+				recycleOutpointKey(key)
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			// Serialize and store the utxo entry.
+			serialized, err := serializeUtxoEntry(entry)
+			if err != nil {
+				return err
+			}
+			key := outpointKey(outpoint)
+			err = utxoBucket.Put(*key, serialized)
+			// NOTE: The key is intentionally not recycled here since
+			// the database interface contract prohibits
+			// modifications.  It will be garbage collected normally
+			// when the database is done with it.
+			if err != nil {
+				return err
+			}
+		}
+
+		return dbPutUtxoStateConsistency(dbTx, bestHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Flushed %d utxo cache entries (%d bytes) to the database "+
+		"at block %v", len(c.cachedEntries), c.totalMemoryUsage, bestHash)
+
+	c.cachedEntries = make(map[wire.OutPoint]*UtxoEntry)
+	c.totalMemoryUsage = 0
+	c.lastFlushHash = *bestHash
+	c.lastFlushTime = time.Now()
+	return nil
+}
+
+// maybeFlush flushes the cache when it exceeds its memory budget or when it
+// has held changes for longer than the periodic flush interval.
+func (c *utxoCache) maybeFlush(bestHash *chainhash.Hash) error {
+	if c.totalMemoryUsage <= c.maxTotalMemoryUsage &&
+		time.Since(c.lastFlushTime) < utxoFlushPeriodicInterval {
+
+		return nil
+	}
+
+	return c.flush(bestHash)
+}
+
+// initUtxoState ensures the utxo set in the database is consistent with the
+// current best chain tip.  Any blocks connected after the last utxo cache flush
+// prior to an unclean shutdown are connected to the utxo set again.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initUtxoState(interrupt <-chan struct{}) error {
+	tip := b.bestChain.Tip()
+	var consistentHash *chainhash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		consistentHash = dbFetchUtxoStateConsistency(dbTx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Databases without the consistency hash were always written with the
+	// utxo set updated along with the best chain state, so they are
+	// consistent with the tip.
+	if consistentHash == nil {
+		return b.utxoCache.flush(&tip.hash)
+	}
+	b.utxoCache.lastFlushHash = *consistentHash
+	if *consistentHash == tip.hash {
+		return nil
+	}
+
+	// The utxo cache is always flushed before blocks are disconnected, so
+	// the utxo set must be consistent with a block in the main chain.
+	node := b.index.LookupNode(consistentHash)
+	if node == nil || !b.bestChain.Contains(node) {
+		return AssertError(fmt.Sprintf("utxo set is consistent with "+
+			"block %v which is not in the main chain", consistentHash))
+	}
+
+	log.Infof("Recovering utxo set from height %d to %d", node.height+1,
+		tip.height)
+
+	for n := b.bestChain.Next(node); n != nil; n = b.bestChain.Next(n) {
+		var block *bteutil.Block
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		// The blocks have already been fully validated when they were
+		// originally connected, so simply update the utxo set.
+		view := NewUtxoViewpoint()
+		err = view.fetchInputUtxos(b.utxoCache, block)
+		if err != nil {
+			return err
+		}
+		err = view.connectTransactions(block, nil)
+		if err != nil {
+			return err
+		}
+		b.utxoCache.commit(view)
+
+		if interruptRequested(interrupt) {
+			if err := b.utxoCache.flush(&n.hash); err != nil {
+				return err
+			}
+			return errInterruptRequested
+		}
+		if err := b.utxoCache.maybeFlush(&n.hash); err != nil {
+			return err
+		}
+	}
+
+	return b.utxoCache.flush(&tip.hash)
+}
+
+// FlushUtxoCache writes all changes to the utxo set held in memory to the
+// database.  It should be called prior to shutting down in order to avoid
+// having to recover the changes on the next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushUtxoCache() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.utxoCache.flush(&b.bestChain.Tip().hash)
+}
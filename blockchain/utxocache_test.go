@@ -0,0 +1,133 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// TestUtxoCacheFlush ensures the utxo cache only writes the expected changes
+// to the database when it is flushed and serves lookups from the cached state
+// in the mean time.
+func TestUtxoCacheFlush(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxocacheflush",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	cache := chain.utxoCache
+	outpointA := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 0}
+	outpointB := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 1}
+	txOut := &wire.TxOut{Value: 5000, PkScript: []byte{0x51}}
+
+	// fetchEntries returns the entries for both outputs from the point of
+	// view of the cache as well as the database alone.
+	fetchEntries := func() (cached, stored [2]*UtxoEntry) {
+		t.Helper()
+		err := chain.db.View(func(dbTx database.Tx) error {
+			for i, outpoint := range []wire.OutPoint{outpointA, outpointB} {
+				var err error
+				cached[i], err = cache.fetchEntry(dbTx, outpoint)
+				if err != nil {
+					return err
+				}
+				stored[i], err = dbFetchUtxoEntry(dbTx, outpoint)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error fetching entries: %v", err)
+		}
+		return cached, stored
+	}
+
+	// Adding new outputs must only place them in the cache.
+	view := NewUtxoViewpoint()
+	view.addTxOut(outpointA, txOut, false, 1)
+	view.addTxOut(outpointB, txOut, false, 1)
+	cache.commit(view)
+	view.commit()
+	cached, stored := fetchEntries()
+	if cached[0] == nil || cached[1] == nil {
+		t.Fatalf("new outputs are not available from the cache")
+	}
+	if cached[0].isModified() || cached[0].isFresh() {
+		t.Fatalf("fetched entry exposes cache flags %x",
+			cached[0].packedFlags)
+	}
+	if stored[0] != nil || stored[1] != nil {
+		t.Fatalf("new outputs were written before the cache was flushed")
+	}
+
+	// Spending a fresh output must remove it from the cache entirely since
+	// it never made it to the database.
+	view.LookupEntry(outpointA).Spend()
+	cache.commit(view)
+	view.commit()
+	if _, ok := cache.cachedEntries[outpointA]; ok {
+		t.Fatalf("spent fresh output is still in the cache")
+	}
+
+	// Flushing must write the remaining output along with the hash the
+	// utxo set is consistent with and empty the cache.
+	flushHash := chainhash.Hash{0x02}
+	if err := cache.flush(&flushHash); err != nil {
+		t.Fatalf("unexpected error flushing cache: %v", err)
+	}
+	if len(cache.cachedEntries) != 0 || cache.totalMemoryUsage != 0 {
+		t.Fatalf("cache is not empty after flushing -- %d entries, "+
+			"%d bytes", len(cache.cachedEntries),
+			cache.totalMemoryUsage)
+	}
+	cached, stored = fetchEntries()
+	if cached[0] != nil || stored[0] != nil {
+		t.Fatalf("spent output was written to the database")
+	}
+	if cached[1] == nil || stored[1] == nil {
+		t.Fatalf("unspent output was not written to the database")
+	}
+	var consistentHash *chainhash.Hash
+	chain.db.View(func(dbTx database.Tx) error {
+		consistentHash = dbFetchUtxoStateConsistency(dbTx)
+		return nil
+	})
+	if consistentHash == nil || *consistentHash != flushHash {
+		t.Fatalf("unexpected utxo state consistency hash -- got %v, "+
+			"want %v", consistentHash, flushHash)
+	}
+
+	// Spending an output that exists in the database must keep it in the
+	// cache as spent until the next flush removes it from the database.
+	view = NewUtxoViewpoint()
+	err = view.fetchUtxos(cache, map[wire.OutPoint]struct{}{
+		outpointB: {},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error fetching utxos: %v", err)
+	}
+	view.LookupEntry(outpointB).Spend()
+	cache.commit(view)
+	view.commit()
+	cached, stored = fetchEntries()
+	if cached[1] != nil || stored[1] == nil {
+		t.Fatalf("spent output is not pending removal from the database")
+	}
+	if err := cache.flush(&flushHash); err != nil {
+		t.Fatalf("unexpected error flushing cache: %v", err)
+	}
+	if _, stored = fetchEntries(); stored[1] != nil {
+		t.Fatalf("spent output was not removed from the database")
+	}
+}
@@ -0,0 +1,982 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	"github.com/mraksoll4/bted/wire"
+)
+
+const (
+	// utxoSnapshotVersion is the current version of the utxo snapshot file
+	// format.
+	utxoSnapshotVersion = 1
+
+	// utxoSnapshotHeaderLen is the length of the utxo snapshot header.
+	utxoSnapshotHeaderLen = 55
+
+	// utxoSnapshotBatchSize is the number of unspent outputs written to the
+	// database per transaction while loading a utxo snapshot.
+	utxoSnapshotBatchSize = 100000
+
+	// snapshotValidationLogInterval is the number of blocks between
+	// progress messages while validating the chain leading up to the block
+	// a utxo snapshot was loaded from.
+	snapshotValidationLogInterval = 10000
+
+	// snapshotBlockWindow is the maximum number of blocks after the last
+	// block connected to the snapshot utxo set that are reported as needed
+	// by MissingSnapshotBlocks.  It limits how far blocks are downloaded
+	// ahead of the background validation.
+	snapshotBlockWindow = 1024
+)
+
+// utxoSnapshotMagic identifies a file as a utxo snapshot.
+var utxoSnapshotMagic = [5]byte{'u', 't', 'x', 'o', 0xff}
+
+// The utxo snapshot file format is as follows:
+//
+//	<header><block headers><unspent outputs>
+//
+//	Field           Type      Size
+//	magic           [5]byte   5
+//	version         uint16    2
+//	network         uint32    4
+//	base hash       [32]byte  32
+//	base height     uint32    4
+//	num outputs     uint64    8
+//
+// The header is followed by the block headers of the chain leading up to and
+// including the base block, excluding the genesis block, in order of height.
+// Each block header is the 80 byte serialized header followed by its 32 byte
+// block hash.  Including the hashes allows the chain to be loaded without
+// computing the expensive yespower hash of every header.  The chain is instead
+// validated in the background afterwards.
+//
+// The block headers are followed by the unspent outputs in the order of their
+// outpoints, which is the order of the utxo set bucket.  Each output is:
+//
+//	<tx hash><output index><serialized len><serialized utxo>
+//
+//	Field           Type      Size
+//	tx hash         [32]byte  32
+//	output index    varint    variable
+//	serialized len  varint    variable
+//	serialized utxo []byte    variable
+//
+// The serialized utxo uses the same format as the utxo set bucket which is
+// described in chainio.go.  All integers in the header are little endian.
+//
+// The snapshot hash which is pinned by chaincfg.AssumeUtxo is the double sha256
+// of the entire file.
+
+// UtxoSnapshotInfo describes a utxo set snapshot.
+type UtxoSnapshotInfo struct {
+	// Height and Hash identify the block the snapshot was taken at.
+	Height int32
+	Hash   chainhash.Hash
+
+	// Outputs is the number of unspent outputs in the snapshot.
+	Outputs uint64
+
+	// SnapshotHash is the double sha256 of the entire snapshot.
+	SnapshotHash chainhash.Hash
+}
+
+// DumpUtxoSnapshot writes a snapshot of the entire unspent transaction output
+// set along with the block headers of the chain leading up to it to the passed
+// writer.
+//
+// Any changes held in the utxo cache are written to the database first.  The
+// set is then read from a database snapshot, so blocks can continue to be
+// processed while the snapshot is written.  The snapshot is taken at the block
+// the database snapshot is consistent with, which is described by the returned
+// info.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DumpUtxoSnapshot(w io.Writer) (*UtxoSnapshotInfo, error) {
+	if err := b.FlushUtxoCache(); err != nil {
+		return nil, err
+	}
+
+	var info UtxoSnapshotInfo
+	err := b.db.View(func(dbTx database.Tx) error {
+		consistentHash := dbFetchUtxoStateConsistency(dbTx)
+		if consistentHash == nil {
+			return AssertError("utxo set consistency hash is missing")
+		}
+		node := b.index.LookupNode(consistentHash)
+		if node == nil {
+			return AssertError(fmt.Sprintf("utxo set is consistent "+
+				"with unknown block %v", consistentHash))
+		}
+		info.Height = node.height
+		info.Hash = node.hash
+
+		var err error
+		utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+		info.Outputs, info.SnapshotHash, err = b.writeUtxoSnapshot(w,
+			utxoBucket, node)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// writeUtxoSnapshot writes a snapshot of the utxo set housed by the passed
+// bucket, which must be consistent with the passed block, to the passed writer.
+// It returns the number of unspent outputs and the hash of the snapshot.
+func (b *BlockChain) writeUtxoSnapshot(w io.Writer, utxoBucket database.Bucket,
+	node *blockNode) (uint64, chainhash.Hash, error) {
+
+	// The number of outputs is part of the header, so count them prior to
+	// writing anything.
+	var numOutputs uint64
+	err := utxoBucket.ForEach(func(k, v []byte) error {
+		numOutputs++
+		return nil
+	})
+	if err != nil {
+		return 0, chainhash.Hash{}, err
+	}
+
+	hasher := sha256.New()
+	bw := bufio.NewWriter(w)
+	mw := io.MultiWriter(bw, hasher)
+	var hdr [utxoSnapshotHeaderLen]byte
+	copy(hdr[:], utxoSnapshotMagic[:])
+	binary.LittleEndian.PutUint16(hdr[5:], utxoSnapshotVersion)
+	binary.LittleEndian.PutUint32(hdr[7:], uint32(b.chainParams.Net))
+	copy(hdr[11:], node.hash[:])
+	binary.LittleEndian.PutUint32(hdr[43:], uint32(node.height))
+	binary.LittleEndian.PutUint64(hdr[47:], numOutputs)
+	if _, err := mw.Write(hdr[:]); err != nil {
+		return 0, chainhash.Hash{}, err
+	}
+
+	// Collect the nodes leading up to the block in a single pass since
+	// looking up each ancestor separately is quadratic.
+	nodes := make([]*blockNode, node.height)
+	for n := node; n.height > 0; n = n.parent {
+		nodes[n.height-1] = n
+	}
+	for _, n := range nodes {
+		header := n.Header()
+		if err := header.Serialize(mw); err != nil {
+			return 0, chainhash.Hash{}, err
+		}
+		if _, err := mw.Write(n.hash[:]); err != nil {
+			return 0, chainhash.Hash{}, err
+		}
+	}
+
+	err = utxoBucket.ForEach(func(k, v []byte) error {
+		if len(k) <= chainhash.HashSize {
+			return AssertError(fmt.Sprintf("utxo set key %x is "+
+				"malformed", k))
+		}
+		index, _ := deserializeVLQ(k[chainhash.HashSize:])
+		if _, err := mw.Write(k[:chainhash.HashSize]); err != nil {
+			return err
+		}
+		if err := wire.WriteVarInt(mw, 0, index); err != nil {
+			return err
+		}
+		return wire.WriteVarBytes(mw, 0, v)
+	})
+	if err != nil {
+		return 0, chainhash.Hash{}, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, chainhash.Hash{}, err
+	}
+
+	return numOutputs, chainhash.HashH(hasher.Sum(nil)), nil
+}
+
+// snapshotReader reads the fields of a utxo snapshot while hashing everything
+// it reads.
+type snapshotReader struct {
+	r      io.Reader
+	hasher hash.Hash
+}
+
+// newSnapshotReader returns a snapshot reader for the passed reader.
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	hasher := sha256.New()
+	return &snapshotReader{
+		r:      io.TeeReader(bufio.NewReader(r), hasher),
+		hasher: hasher,
+	}
+}
+
+// Read reads from the underlying reader and hashes the data.  It is part of
+// the io.Reader interface.
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	return sr.r.Read(p)
+}
+
+// snapshotHash returns the double sha256 of everything read so far.
+func (sr *snapshotReader) snapshotHash() chainhash.Hash {
+	return chainhash.HashH(sr.hasher.Sum(nil))
+}
+
+// findAssumeUtxo returns the known utxo snapshot for the block with the passed
+// hash and height or nil when there isn't one.
+func findAssumeUtxo(params *chaincfg.Params, blockHash *chainhash.Hash, height int32) *chaincfg.AssumeUtxo {
+	for i := range params.AssumeUtxos {
+		assumeUtxo := &params.AssumeUtxos[i]
+		if assumeUtxo.Height == height && assumeUtxo.BlockHash.IsEqual(blockHash) {
+			return assumeUtxo
+		}
+	}
+	return nil
+}
+
+// readSnapshotHeaders reads the block headers of a utxo snapshot and ensures
+// they form a chain from the genesis block to the passed base block.
+func (b *BlockChain) readSnapshotHeaders(r io.Reader, baseHash *chainhash.Hash,
+	baseHeight int32) ([]wire.BlockHeader, []chainhash.Hash, error) {
+
+	headers := make([]wire.BlockHeader, baseHeight)
+	hashes := make([]chainhash.Hash, baseHeight)
+	prevHash := &b.bestChain.Genesis().hash
+	for i := range headers {
+		if err := headers[i].Deserialize(r); err != nil {
+			return nil, nil, err
+		}
+		if _, err := io.ReadFull(r, hashes[i][:]); err != nil {
+			return nil, nil, err
+		}
+		if headers[i].PrevBlock != *prevHash {
+			str := fmt.Sprintf("utxo snapshot header at height %d "+
+				"does not connect to the previous header", i+1)
+			return nil, nil, ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+		prevHash = &hashes[i]
+	}
+	if *prevHash != *baseHash {
+		str := fmt.Sprintf("utxo snapshot headers end at block %v "+
+			"instead of base block %v", prevHash, baseHash)
+		return nil, nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+
+	return headers, hashes, nil
+}
+
+// loadSnapshotOutputs reads the unspent outputs of a utxo snapshot and writes
+// them to the utxo set in the database in batches.  The outputs must be in the
+// order of their outpoints without duplicates.
+func (b *BlockChain) loadSnapshotOutputs(r io.Reader, numOutputs uint64) error {
+	var prevKey []byte
+	batch := make([][2][]byte, 0, utxoSnapshotBatchSize)
+	writeBatch := func() error {
+		err := b.db.Update(func(dbTx database.Tx) error {
+			utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+			for _, kv := range batch {
+				if err := utxoBucket.Put(kv[0], kv[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	for n := uint64(0); n < numOutputs; n++ {
+		var outpoint wire.OutPoint
+		if _, err := io.ReadFull(r, outpoint.Hash[:]); err != nil {
+			return err
+		}
+		index, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return err
+		}
+		if index > uint64(^uint32(0)) {
+			str := fmt.Sprintf("utxo snapshot output index %d is out "+
+				"of range", index)
+			return ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+		outpoint.Index = uint32(index)
+		serialized, err := wire.ReadVarBytes(r, 0, wire.MaxBlockPayload,
+			"serialized utxo")
+		if err != nil {
+			return err
+		}
+
+		// Ensure the entry is valid and the outputs are in order.
+		if _, err := deserializeUtxoEntry(serialized); err != nil {
+			str := fmt.Sprintf("utxo snapshot output %v is "+
+				"malformed: %v", outpoint, err)
+			return ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+		key := *outpointKey(outpoint)
+		if prevKey != nil && bytes.Compare(key, prevKey) <= 0 {
+			str := fmt.Sprintf("utxo snapshot output %v is out of "+
+				"order", outpoint)
+			return ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+		prevKey = key
+
+		batch = append(batch, [2][]byte{key, serialized})
+		if len(batch) == utxoSnapshotBatchSize {
+			if err := writeBatch(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeBatch()
+}
+
+// clearUtxoSet removes all unspent outputs from the utxo set in the database.
+func (b *BlockChain) clearUtxoSet() error {
+	return b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if err := meta.DeleteBucket(utxoSetBucketName); err != nil {
+			return err
+		}
+		_, err := meta.CreateBucket(utxoSetBucketName)
+		return err
+	})
+}
+
+// LoadUtxoSnapshot bootstraps a new chain from the utxo snapshot read from the
+// passed reader.  The snapshot must be for one of the AssumeUtxos of the chain
+// parameters and its hash must match.  Once loaded, the base block of the
+// snapshot is the end of the main chain and new blocks are connected to it as
+// usual, however, the blocks leading up to it are not available.
+//
+// The chain leading up to the base block is validated in the background once
+// Start is called.  Its blocks are downloaded as reported by
+// MissingSnapshotBlocks and passed to ProcessSnapshotBlock, connected to a
+// separate utxo set, and the resulting utxo set must match the snapshot once
+// the base block is reached.
+//
+// This function may only be called when the main chain consists of the genesis
+// block alone and no optional indexes are in use.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) LoadUtxoSnapshot(r io.Reader) (*UtxoSnapshotInfo, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	if b.bestChain.Tip().height != 0 || len(b.utxoCache.cachedEntries) != 0 {
+		str := "utxo snapshots can only be loaded into a new chain"
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+	if b.indexManager != nil {
+		str := "utxo snapshots can't be loaded with optional indexes " +
+			"enabled"
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+
+	// Read and check the snapshot header.
+	sr := newSnapshotReader(r)
+	var hdr [utxoSnapshotHeaderLen]byte
+	if _, err := io.ReadFull(sr, hdr[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:5], utxoSnapshotMagic[:]) {
+		str := "file is not a utxo snapshot"
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+	if version := binary.LittleEndian.Uint16(hdr[5:]); version != utxoSnapshotVersion {
+		str := fmt.Sprintf("unsupported utxo snapshot version %d",
+			version)
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+	net := wire.BitcoinNet(binary.LittleEndian.Uint32(hdr[7:]))
+	if net != b.chainParams.Net {
+		str := fmt.Sprintf("utxo snapshot is for network %v instead "+
+			"of %v", net, b.chainParams.Net)
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+	var info UtxoSnapshotInfo
+	copy(info.Hash[:], hdr[11:43])
+	info.Height = int32(binary.LittleEndian.Uint32(hdr[43:]))
+	info.Outputs = binary.LittleEndian.Uint64(hdr[47:])
+	assumeUtxo := findAssumeUtxo(b.chainParams, &info.Hash, info.Height)
+	if assumeUtxo == nil {
+		str := fmt.Sprintf("utxo snapshot for block %v (height %d) is "+
+			"not a known snapshot", info.Hash, info.Height)
+		return nil, ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+
+	log.Infof("Loading utxo snapshot at block %v (height %d) with %d "+
+		"outputs", info.Hash, info.Height, info.Outputs)
+
+	headers, hashes, err := b.readSnapshotHeaders(sr, &info.Hash,
+		info.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	// Any outputs written to the database must be removed again if the
+	// snapshot turns out to be invalid.
+	err = b.loadSnapshotOutputs(sr, info.Outputs)
+	if err == nil {
+		var extra [1]byte
+		if n, _ := sr.Read(extra[:]); n != 0 {
+			str := "utxo snapshot contains trailing data"
+			err = ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+	}
+	if err == nil {
+		info.SnapshotHash = sr.snapshotHash()
+		if info.SnapshotHash != *assumeUtxo.SnapshotHash {
+			str := fmt.Sprintf("utxo snapshot hash %v does not "+
+				"match the expected hash %v", info.SnapshotHash,
+				assumeUtxo.SnapshotHash)
+			err = ruleError(ErrInvalidUtxoSnapshot, str)
+		}
+	}
+	if err != nil {
+		if clearErr := b.clearUtxoSet(); clearErr != nil {
+			log.Errorf("Unable to remove partially loaded utxo "+
+				"snapshot: %v", clearErr)
+		}
+		return nil, err
+	}
+
+	// Create block nodes for the headers.  The blocks themselves are not
+	// available, but they are assumed to be valid since the snapshot is
+	// until they are validated in the background.
+	nodes := make([]blockNode, len(headers))
+	parent := b.bestChain.Genesis()
+	for i := range nodes {
+		node := &nodes[i]
+		initBlockNode(node, &headers[i], &hashes[i], parent)
+		node.status = statusValid
+		parent = node
+	}
+	baseNode := parent
+	state := newBestState(baseNode, 0, 0, 0, 0,
+		baseNode.CalcPastMedianTime())
+
+	err = b.db.Update(func(dbTx database.Tx) error {
+		for i := range nodes {
+			node := &nodes[i]
+			if err := dbStoreBlockNode(dbTx, node); err != nil {
+				return err
+			}
+			err := dbPutBlockIndex(dbTx, &node.hash, node.height)
+			if err != nil {
+				return err
+			}
+		}
+		err := dbPutBestState(dbTx, state, baseNode.workSum)
+		if err != nil {
+			return err
+		}
+		err = dbPutUtxoStateConsistency(dbTx, &baseNode.hash)
+		if err != nil {
+			return err
+		}
+		_, err = dbTx.Metadata().CreateBucketIfNotExists(
+			snapshotUtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		return dbPutUtxoSnapshotBase(dbTx, &baseNode.hash)
+	})
+	if err != nil {
+		if clearErr := b.clearUtxoSet(); clearErr != nil {
+			log.Errorf("Unable to remove partially loaded utxo "+
+				"snapshot: %v", clearErr)
+		}
+		return nil, err
+	}
+
+	b.index.Lock()
+	for i := range nodes {
+		b.index.addNode(&nodes[i])
+	}
+	b.index.Unlock()
+	b.bestChain.SetTip(baseNode)
+	b.utxoCache.lastFlushHash = baseNode.hash
+	b.pruneHeight = baseNode.height + 1
+	b.snapshotBase = baseNode
+	b.snapshotHeight = 0
+
+	b.stateLock.Lock()
+	b.stateSnapshot = state
+	b.stateLock.Unlock()
+
+	log.Infof("Loaded utxo snapshot at block %v (height %d)", info.Hash,
+		info.Height)
+
+	return &info, nil
+}
+
+// snapshotBlockNode returns the node of the block with the passed hash when it
+// is one of the blocks leading up to the block the utxo set was bootstrapped
+// from which are still needed by the background validation and have not been
+// stored yet.  Otherwise, nil is returned.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) snapshotBlockNode(hash *chainhash.Hash) *blockNode {
+	if b.snapshotBase == nil {
+		return nil
+	}
+	node := b.index.LookupNode(hash)
+	if node == nil || node.height <= b.snapshotHeight ||
+		node.height > b.snapshotBase.height ||
+		!b.bestChain.Contains(node) ||
+		b.index.NodeStatus(node).HaveData() {
+
+		return nil
+	}
+	return node
+}
+
+// NeedsSnapshotBlock returns whether or not the block with the passed hash is
+// one of the blocks leading up to the block the utxo set was bootstrapped from
+// which must be passed to ProcessSnapshotBlock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) NeedsSnapshotBlock(hash *chainhash.Hash) bool {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	return b.snapshotBlockNode(hash) != nil
+}
+
+// MissingSnapshotBlocks returns the hashes of up to the passed number of blocks
+// leading up to the block the utxo set was bootstrapped from which are needed
+// by the background validation next, in order of height.  Nothing is returned
+// when the chain was not bootstrapped from a utxo snapshot or the chain leading
+// up to it has already been validated.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) MissingSnapshotBlocks(maxBlocks int) []chainhash.Hash {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	if b.snapshotBase == nil {
+		return nil
+	}
+	endHeight := b.snapshotHeight + snapshotBlockWindow
+	if endHeight > b.snapshotBase.height {
+		endHeight = b.snapshotBase.height
+	}
+
+	// Walk down from the end of the window since the blocks with the
+	// lowest heights are needed first.
+	var missing []chainhash.Hash
+	for node := b.bestChain.NodeByHeight(endHeight); node != nil &&
+		node.height > b.snapshotHeight; node = node.parent {
+
+		if !b.index.NodeStatus(node).HaveData() {
+			missing = append(missing, node.hash)
+		}
+	}
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	if len(missing) > maxBlocks {
+		missing = missing[:maxBlocks]
+	}
+	return missing
+}
+
+// ProcessSnapshotBlock stores the passed block, which must be one of the blocks
+// reported by MissingSnapshotBlocks, so it can be connected by the background
+// validation of the chain leading up to the block the utxo set was bootstrapped
+// from.  Only the checks which don't depend on the chain are performed here, so
+// an error means the block was malformed by whoever provided it rather than the
+// snapshot being invalid.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessSnapshotBlock(block *bteutil.Block) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	blockHash := block.Hash()
+	node := b.snapshotBlockNode(blockHash)
+	if node == nil {
+		str := fmt.Sprintf("block %v is not needed to validate the "+
+			"utxo snapshot", blockHash)
+		return ruleError(ErrDuplicateBlock, str)
+	}
+
+	// The block hash commits to everything except the witness data, so
+	// also ensure it matches the witness commitment to detect blocks
+	// whose witness data was changed.
+	err := checkBlockSanity(block, b.chainParams.PowLimit, b.timeSource,
+		BFNone)
+	if err != nil {
+		return err
+	}
+	if err := ValidateWitnessCommitment(block); err != nil {
+		return err
+	}
+
+	err = b.db.Update(func(dbTx database.Tx) error {
+		return dbStoreBlock(dbTx, block)
+	})
+	if err != nil {
+		return err
+	}
+	b.index.SetStatusFlags(node, statusDataStored)
+	if err := b.index.flushToDB(); err != nil {
+		return err
+	}
+
+	// Wake up the background validation in case it is waiting for the
+	// block.
+	select {
+	case b.snapshotBlockStored <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start begins validating the chain leading up to the block the utxo set was
+// bootstrapped from in the background when the chain was loaded from a utxo
+// snapshot and it has not been validated yet.  The chain is marked as invalid
+// and an NTUtxoSnapshotInvalid notification is sent when it turns out to be
+// invalid.
+func (b *BlockChain) Start() {
+	b.chainLock.RLock()
+	baseNode := b.snapshotBase
+	b.chainLock.RUnlock()
+	if baseNode == nil {
+		return
+	}
+
+	b.snapshotWg.Add(1)
+	go b.validateSnapshotChain(baseNode)
+}
+
+// Stop stops the background validation started by Start and waits for it to
+// finish, so the database may be closed afterwards.  It must only be called
+// once.
+func (b *BlockChain) Stop() {
+	close(b.snapshotQuit)
+	b.snapshotWg.Wait()
+}
+
+// validateSnapshotChain connects the blocks of the chain leading up to the
+// passed block the utxo set was bootstrapped from to a separate utxo set as they
+// become available and ensures the resulting utxo set matches the snapshot.
+// The snapshot base and the separate utxo set are removed from the database
+// once the entire chain has been validated.  Validation resumes on the next
+// start when it is interrupted.
+//
+// This function MUST be run as a goroutine.
+func (b *BlockChain) validateSnapshotChain(baseNode *blockNode) {
+	defer b.snapshotWg.Done()
+
+	b.chainLock.RLock()
+	startHeight := b.snapshotHeight
+	b.chainLock.RUnlock()
+	log.Infof("Validating the %d blocks leading up to the utxo snapshot "+
+		"in the background", baseNode.height-startHeight)
+
+	for {
+		b.chainLock.RLock()
+		height := b.snapshotHeight + 1
+		node := b.bestChain.NodeByHeight(height)
+		inMainChain := b.bestChain.Contains(baseNode)
+		b.chainLock.RUnlock()
+		if height > baseNode.height {
+			break
+		}
+		if !inMainChain {
+			log.Errorf("Utxo snapshot base block %v is no longer in "+
+				"the main chain", baseNode.hash)
+			return
+		}
+
+		// Wait for the block to be stored by ProcessSnapshotBlock.
+		if !b.index.NodeStatus(node).HaveData() {
+			select {
+			case <-b.snapshotBlockStored:
+				continue
+			case <-b.snapshotQuit:
+				return
+			case <-b.interrupt:
+				return
+			}
+		}
+		if interruptRequested(b.snapshotQuit) ||
+			interruptRequested(b.interrupt) {
+
+			return
+		}
+
+		if err := b.connectSnapshotBlock(node); err != nil {
+			if _, ok := err.(RuleError); ok {
+				b.invalidateUtxoSnapshot(fmt.Sprintf("the chain "+
+					"leading up to the utxo snapshot is "+
+					"invalid at height %d: %v", height, err))
+				return
+			}
+			log.Errorf("Unable to validate block %v leading up to "+
+				"the utxo snapshot: %v", node.hash, err)
+			return
+		}
+
+		if height%snapshotValidationLogInterval == 0 {
+			log.Infof("Validated %d of %d blocks leading up to the "+
+				"utxo snapshot", height, baseNode.height)
+		}
+	}
+
+	// The utxo set resulting from the chain must be the same as the
+	// snapshot, which is determined by hashing it in the same way.
+	var snapshotHash chainhash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(snapshotUtxoSetBucketName)
+		var err error
+		_, snapshotHash, err = b.writeUtxoSnapshot(ioutil.Discard,
+			utxoBucket, baseNode)
+		return err
+	})
+	if err != nil {
+		log.Errorf("Unable to hash the utxo set leading up to the utxo "+
+			"snapshot: %v", err)
+		return
+	}
+	assumeUtxo := findAssumeUtxo(b.chainParams, &baseNode.hash,
+		baseNode.height)
+	if assumeUtxo == nil {
+		b.invalidateUtxoSnapshot(fmt.Sprintf("the utxo snapshot at "+
+			"block %v is no longer a known snapshot", baseNode.hash))
+		return
+	}
+	if snapshotHash != *assumeUtxo.SnapshotHash {
+		b.invalidateUtxoSnapshot(fmt.Sprintf("the utxo set resulting "+
+			"from the chain leading up to the utxo snapshot has "+
+			"hash %v instead of %v", snapshotHash,
+			assumeUtxo.SnapshotHash))
+		return
+	}
+
+	err = b.db.Update(func(dbTx database.Tx) error {
+		if err := dbRemoveSnapshotUtxoSet(dbTx); err != nil {
+			return err
+		}
+		return dbRemoveUtxoSnapshotBase(dbTx)
+	})
+	if err != nil {
+		log.Errorf("Unable to record utxo snapshot validation: %v", err)
+		return
+	}
+	b.chainLock.Lock()
+	b.snapshotBase = nil
+	b.chainLock.Unlock()
+
+	log.Infof("Validated the chain leading up to the utxo snapshot at "+
+		"block %v (height %d)", baseNode.hash, baseNode.height)
+}
+
+// checkSnapshotHeader ensures the header of the passed block leading up to the
+// block the utxo set was bootstrapped from has valid proof of work, difficulty,
+// and timestamp.  The headers are only checked here since they were taken from
+// the snapshot without computing their proof of work hashes.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkSnapshotHeader(node *blockNode) error {
+	header := node.Header()
+	err := checkProofOfWork(&header, b.chainParams.PowLimit, BFNone)
+	if err != nil {
+		return err
+	}
+	if header.PowHash() != node.hash {
+		str := fmt.Sprintf("block hash %v does not match the proof of "+
+			"work hash", node.hash)
+		return ruleError(ErrHighHash, str)
+	}
+
+	bits, err := b.calcNextRequiredDifficulty(node.parent, header.Timestamp)
+	if err != nil {
+		return err
+	}
+	if bits != header.Bits {
+		str := fmt.Sprintf("block difficulty of %08x is not the "+
+			"expected value of %08x", header.Bits, bits)
+		return ruleError(ErrUnexpectedDifficulty, str)
+	}
+
+	medianTime := node.parent.CalcPastMedianTime()
+	if !header.Timestamp.After(medianTime) {
+		str := fmt.Sprintf("block timestamp of %v is not after "+
+			"expected %v", header.Timestamp, medianTime)
+		return ruleError(ErrTimeTooOld, str)
+	}
+	return nil
+}
+
+// fetchSnapshotUtxos loads the outputs spent by the transactions of the passed
+// block along with the outputs they create from the passed bucket which houses
+// the snapshot utxo set into the view.  Missing outputs result in nil entries,
+// so connecting the block to the view never consults the utxo cache of the main
+// chain.
+func fetchSnapshotUtxos(utxoBucket database.Bucket, view *UtxoViewpoint,
+	block *bteutil.Block) error {
+
+	fetch := func(outpoint wire.OutPoint) error {
+		if _, ok := view.entries[outpoint]; ok {
+			return nil
+		}
+		entry, err := dbFetchUtxoEntryFromBucket(utxoBucket, outpoint)
+		if err != nil {
+			return err
+		}
+		view.entries[outpoint] = entry
+		return nil
+	}
+
+	for i, tx := range block.Transactions() {
+		outpoint := wire.OutPoint{Hash: *tx.Hash()}
+		for txOutIdx := range tx.MsgTx().TxOut {
+			outpoint.Index = uint32(txOutIdx)
+			if err := fetch(outpoint); err != nil {
+				return err
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			if err := fetch(txIn.PreviousOutPoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// connectSnapshotBlock fully validates the passed block leading up to the block
+// the utxo set was bootstrapped from and connects it to the snapshot utxo set.
+// The block must be the next one to connect and its data must be stored.
+//
+// The chain state lock is only held while the block is connected, so new blocks
+// can be processed in between.
+func (b *BlockChain) connectSnapshotBlock(node *blockNode) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	if err := b.checkSnapshotHeader(node); err != nil {
+		return err
+	}
+
+	var block *bteutil.Block
+	view := NewUtxoViewpoint()
+	view.SetBestHash(&node.parent.hash)
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		block, err = dbFetchBlockByNode(dbTx, node)
+		if err != nil {
+			return err
+		}
+
+		utxoBucket := dbTx.Metadata().Bucket(snapshotUtxoSetBucketName)
+		return fetchSnapshotUtxos(utxoBucket, view, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := b.checkBlockTransactionsContext(block, node.parent); err != nil {
+		return err
+	}
+	if err := b.checkConnectBlock(node, block, view, nil); err != nil {
+		return err
+	}
+
+	err = b.db.Update(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(snapshotUtxoSetBucketName)
+		if err := dbPutUtxoViewToBucket(utxoBucket, view); err != nil {
+			return err
+		}
+		return dbPutSnapshotUtxoState(dbTx, &node.hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.snapshotHeight = node.height
+	return nil
+}
+
+// invalidateUtxoSnapshot records that the chain leading up to the block the
+// utxo set was bootstrapped from is invalid for the passed reason, so the chain
+// refuses to load on the next start, and sends an NTUtxoSnapshotInvalid
+// notification so the caller can stop using it.
+func (b *BlockChain) invalidateUtxoSnapshot(reason string) {
+	log.Criticalf("Utxo snapshot is invalid: %s -- the chain must be "+
+		"resynchronized without the snapshot", reason)
+
+	err := b.db.Update(func(dbTx database.Tx) error {
+		return dbPutUtxoSnapshotInvalid(dbTx, reason)
+	})
+	if err != nil {
+		log.Errorf("Unable to record invalid utxo snapshot: %v", err)
+	}
+
+	b.sendNotification(NTUtxoSnapshotInvalid,
+		ruleError(ErrInvalidUtxoSnapshot, reason))
+}
+
+// initUtxoSnapshotState determines whether the background validation of the
+// chain leading up to the block the utxo set was bootstrapped from still needs
+// to be run by Start.  An error is returned when the chain was previously found
+// to be invalid since the utxo set can't be trusted in that case.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initUtxoSnapshotState() error {
+	var baseHash, stateHash *chainhash.Hash
+	var invalidReason string
+	err := b.db.View(func(dbTx database.Tx) error {
+		baseHash = dbFetchUtxoSnapshotBase(dbTx)
+		stateHash = dbFetchSnapshotUtxoState(dbTx)
+		invalidReason = dbFetchUtxoSnapshotInvalid(dbTx)
+		return nil
+	})
+	if err != nil || baseHash == nil {
+		return err
+	}
+	if invalidReason != "" {
+		str := fmt.Sprintf("%s -- the chain must be resynchronized "+
+			"without the snapshot", invalidReason)
+		return ruleError(ErrInvalidUtxoSnapshot, str)
+	}
+
+	baseNode := b.index.LookupNode(baseHash)
+	if baseNode == nil {
+		return AssertError(fmt.Sprintf("utxo snapshot base block %v is "+
+			"not in the block index", baseHash))
+	}
+	b.snapshotBase = baseNode
+	b.snapshotHeight = 0
+	if stateHash != nil {
+		node := b.index.LookupNode(stateHash)
+		if node == nil {
+			return AssertError(fmt.Sprintf("snapshot utxo set is "+
+				"consistent with unknown block %v", stateHash))
+		}
+		b.snapshotHeight = node.height
+	}
+
+	return nil
+}
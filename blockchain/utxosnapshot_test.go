@@ -0,0 +1,277 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+)
+
+// TestUtxoSnapshot ensures a utxo snapshot dumped from one chain can be used to
+// bootstrap another chain and that snapshots which don't match the expected
+// hash are rejected without leaving anything behind.  It also ensures the chain
+// leading up to the snapshot is validated in the background and that snapshots
+// whose utxo set or headers don't match the chain are detected.
+func TestUtxoSnapshot(t *testing.T) {
+	params := chaincfg.RegressionNetParams
+	source, teardownSource, err := chainSetup("utxosnapshotsrc", &params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+
+	// Extend the main chain of the source with a few blocks whose coinbase
+	// outputs make up the utxo set.
+	tip := source.bestChain.Tip()
+	blocks := make(map[chainhash.Hash]*bteutil.Block)
+	for i := 0; i < 5; i++ {
+		tip = addTestBlock(t, source, tip, 0)
+		block, err := source.BlockByHash(&tip.hash)
+		if err != nil {
+			t.Fatalf("BlockByHash: unexpected error: %v", err)
+		}
+		blocks[tip.hash] = block
+	}
+
+	var snapshot bytes.Buffer
+	info, err := source.DumpUtxoSnapshot(&snapshot)
+	if err != nil {
+		t.Fatalf("DumpUtxoSnapshot: unexpected error: %v", err)
+	}
+	if info.Height != tip.height || info.Hash != tip.hash ||
+		info.Outputs != 5 {
+
+		t.Fatalf("unexpected snapshot info %+v", info)
+	}
+	wantStats, err := source.UtxoStats()
+	if err != nil {
+		t.Fatalf("UtxoStats: unexpected error: %v", err)
+	}
+
+	// Forge a snapshot whose utxo set lacks the output of the first block
+	// although its headers are the same.
+	var forgedSnapshot bytes.Buffer
+	err = source.db.Update(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		if !cursor.First() {
+			return errors.New("utxo set is empty")
+		}
+		return cursor.Delete()
+	})
+	if err != nil {
+		t.Fatalf("unable to remove output: %v", err)
+	}
+	forgedInfo, err := source.DumpUtxoSnapshot(&forgedSnapshot)
+	if err != nil {
+		t.Fatalf("DumpUtxoSnapshot: unexpected error: %v", err)
+	}
+
+	// The test databases share a root directory which is removed on
+	// teardown, so only one chain instance may exist at a time.
+	teardownSource()
+
+	// loadSnapshot attempts to load the snapshot into a new chain which
+	// expects the passed snapshot hash.
+	loadSnapshot := func(name string, snapshot *bytes.Buffer, snapshotHash chainhash.Hash) (*BlockChain, func(), error) {
+		params := chaincfg.RegressionNetParams
+		params.AssumeUtxos = []chaincfg.AssumeUtxo{{
+			Height:       info.Height,
+			BlockHash:    &info.Hash,
+			SnapshotHash: &snapshotHash,
+		}}
+		chain, teardown, err := chainSetup(name, &params)
+		if err != nil {
+			t.Fatalf("Failed to setup chain instance: %v", err)
+		}
+
+		_, err = chain.LoadUtxoSnapshot(bytes.NewReader(snapshot.Bytes()))
+		return chain, teardown, err
+	}
+
+	// validate runs the background validation of the chain leading up to
+	// the snapshot while providing the blocks it reports as missing.  It
+	// returns the error of the invalid snapshot notification or nil once
+	// the chain has been validated.
+	validate := func(chain *BlockChain) error {
+		invalid := make(chan error, 1)
+		chain.Subscribe(func(n *Notification) {
+			if n.Type == NTUtxoSnapshotInvalid {
+				invalid <- n.Data.(error)
+			}
+		})
+		chain.Start()
+		defer chain.Stop()
+
+		missing := chain.MissingSnapshotBlocks(len(blocks) + 1)
+		if len(missing) != len(blocks) {
+			t.Fatalf("MissingSnapshotBlocks: unexpected number of "+
+				"blocks -- got %d, want %d", len(missing),
+				len(blocks))
+		}
+		for i := len(missing) - 1; i >= 0; i-- {
+			if !chain.NeedsSnapshotBlock(&missing[i]) {
+				t.Fatalf("NeedsSnapshotBlock: block %v is not "+
+					"needed", missing[i])
+			}
+			err := chain.ProcessSnapshotBlock(blocks[missing[i]])
+			if err != nil {
+				t.Fatalf("ProcessSnapshotBlock: unexpected "+
+					"error: %v", err)
+			}
+		}
+
+		timeout := time.After(time.Second * 10)
+		for {
+			select {
+			case err := <-invalid:
+				return err
+			case <-timeout:
+				t.Fatalf("background validation did not finish")
+			case <-time.After(time.Millisecond * 10):
+			}
+
+			chain.chainLock.RLock()
+			done := chain.snapshotBase == nil
+			chain.chainLock.RUnlock()
+			if done {
+				return nil
+			}
+		}
+	}
+
+	// A snapshot that doesn't match the expected hash must be rejected
+	// without leaving any outputs behind.
+	chain, teardown, err := loadSnapshot("utxosnapshotbad", &snapshot,
+		chainhash.Hash{})
+	var rerr RuleError
+	if !errors.As(err, &rerr) || rerr.ErrorCode != ErrInvalidUtxoSnapshot {
+		teardown()
+		t.Fatalf("unexpected error loading mismatched snapshot: %v", err)
+	}
+	var leftOutputs bool
+	chain.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		leftOutputs = cursor.First()
+		return nil
+	})
+	tipHeight := chain.bestChain.Tip().height
+	teardown()
+	if tipHeight != 0 {
+		t.Fatalf("mismatched snapshot changed the main chain")
+	}
+	if leftOutputs {
+		t.Fatalf("mismatched snapshot left outputs behind")
+	}
+
+	// The matching snapshot must result in the same chain tip and utxo set.
+	chain, teardown, err = loadSnapshot("utxosnapshot", &snapshot,
+		info.SnapshotHash)
+	func() {
+		defer teardown()
+		if err != nil {
+			t.Fatalf("LoadUtxoSnapshot: unexpected error: %v", err)
+		}
+		if chain.bestChain.Tip().hash != tip.hash {
+			t.Fatalf("unexpected tip after loading snapshot -- "+
+				"got %v, want %v", chain.bestChain.Tip().hash,
+				tip.hash)
+		}
+		gotStats, err := chain.UtxoStats()
+		if err != nil {
+			t.Fatalf("UtxoStats: unexpected error: %v", err)
+		}
+		if *gotStats != *wantStats {
+			t.Fatalf("unexpected utxo stats after loading "+
+				"snapshot -- got %+v, want %+v", gotStats,
+				wantStats)
+		}
+
+		// Loading a snapshot again must be rejected.
+		_, err = chain.LoadUtxoSnapshot(bytes.NewReader(
+			snapshot.Bytes()))
+		if !errors.As(err, &rerr) ||
+			rerr.ErrorCode != ErrInvalidUtxoSnapshot {
+
+			t.Fatalf("unexpected error loading snapshot twice: %v",
+				err)
+		}
+
+		// The blocks leading up to the snapshot must remain
+		// unavailable after the prune state is determined again on
+		// restart.
+		chain.pruneHeight = 0
+		if err := chain.initPruneState(); err != nil {
+			t.Fatalf("initPruneState: unexpected error: %v", err)
+		}
+		if chain.PruneHeight() != tip.height+1 {
+			t.Fatalf("unexpected prune height -- got %d, want %d",
+				chain.PruneHeight(), tip.height+1)
+		}
+
+		// The chain leading up to the snapshot must validate and the
+		// state of the background validation must be removed
+		// afterwards.
+		if err := validate(chain); err != nil {
+			t.Fatalf("unexpected background validation error: %v",
+				err)
+		}
+		if missing := chain.MissingSnapshotBlocks(1); len(missing) != 0 {
+			t.Fatalf("MissingSnapshotBlocks: unexpected blocks %v "+
+				"after validation", missing)
+		}
+		var haveBase, haveState bool
+		chain.db.View(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			haveBase = dbFetchUtxoSnapshotBase(dbTx) != nil
+			haveState = dbFetchSnapshotUtxoState(dbTx) != nil ||
+				meta.Bucket(snapshotUtxoSetBucketName) != nil
+			return nil
+		})
+		if haveBase || haveState {
+			t.Fatalf("validated snapshot left state behind (base "+
+				"%v, utxo set %v)", haveBase, haveState)
+		}
+	}()
+
+	// A snapshot whose utxo set doesn't match the chain must be detected
+	// once the chain leading up to it has been connected and prevent the
+	// chain from loading again.
+	chain, teardown, err = loadSnapshot("utxosnapshotforged",
+		&forgedSnapshot, forgedInfo.SnapshotHash)
+	if err != nil {
+		teardown()
+		t.Fatalf("LoadUtxoSnapshot: unexpected error: %v", err)
+	}
+	err = validate(chain)
+	if !errors.As(err, &rerr) || rerr.ErrorCode != ErrInvalidUtxoSnapshot {
+		teardown()
+		t.Fatalf("unexpected error validating forged snapshot: %v", err)
+	}
+	err = chain.initUtxoSnapshotState()
+	teardown()
+	if !errors.As(err, &rerr) || rerr.ErrorCode != ErrInvalidUtxoSnapshot {
+		t.Fatalf("unexpected error initializing invalid snapshot "+
+			"state: %v", err)
+	}
+
+	// Headers with an unexpected difficulty must be detected as well.
+	chain, teardown, err = loadSnapshot("utxosnapshotbits", &snapshot,
+		info.SnapshotHash)
+	defer teardown()
+	if err != nil {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error: %v", err)
+	}
+	chain.bestChain.Tip().Ancestor(2).bits = 0x1d00ffff
+	err = validate(chain)
+	if !errors.As(err, &rerr) || rerr.ErrorCode != ErrInvalidUtxoSnapshot {
+		t.Fatalf("unexpected error validating invalid headers: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// UtxoStats houses statistics about the unspent transaction output set at a
+// specific block in the main chain.
+type UtxoStats struct {
+	// Height and Hash identify the block the statistics are for.
+	Height int32
+	Hash   chainhash.Hash
+
+	// Transactions is the number of transactions with unspent outputs and
+	// Outputs is the number of unspent outputs.
+	Transactions int64
+	Outputs      int64
+
+	// BogoSize is a database independent metric for the size of the
+	// unspent outputs and DiskSize is the number of bytes they use in the
+	// database excluding any backend overhead.
+	BogoSize int64
+	DiskSize int64
+
+	// SerializedHash commits to the entire unspent transaction output set.
+	// It only depends on the contents of the set, so it is identical
+	// across nodes that have the same set.  See writeUtxoForHash for
+	// the details.
+	SerializedHash chainhash.Hash
+
+	// TotalAmount is the sum of the amounts of all unspent outputs.
+	TotalAmount int64
+}
+
+// writeUtxoForHash writes the unspent output to the passed writer in the form
+// which is committed to by the utxo set hash.  The set hash is the double
+// sha256 of all unspent outputs serialized as follows in the order of their
+// outpoints, which is the order the utxo set bucket iterates in since the
+// output index in the keys uses an MSB encoding:
+//
+//	<tx hash><output index><header code><amount><script len><script>
+//
+//	Field           Type      Size
+//	tx hash         [32]byte  32
+//	output index    uint32    4
+//	header code     uint32    4
+//	amount          int64     8
+//	script len      varint    variable
+//	script          []byte    variable
+//
+// The integers are little endian and the header code is the block height
+// shifted left one bit with the lowest bit set for coinbase outputs.
+func writeUtxoForHash(w io.Writer, outpoint wire.OutPoint, entry *UtxoEntry) error {
+	headerCode := uint32(entry.BlockHeight()) << 1
+	if entry.IsCoinBase() {
+		headerCode |= 0x01
+	}
+
+	var buf [chainhash.HashSize + 16]byte
+	copy(buf[:], outpoint.Hash[:])
+	binary.LittleEndian.PutUint32(buf[32:], outpoint.Index)
+	binary.LittleEndian.PutUint32(buf[36:], headerCode)
+	binary.LittleEndian.PutUint64(buf[40:], uint64(entry.Amount()))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	pkScript := entry.PkScript()
+	err := wire.WriteVarInt(w, 0, uint64(len(pkScript)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(pkScript)
+	return err
+}
+
+// UtxoStats returns statistics about the entire unspent transaction output set
+// at the end of the main chain along with a hash which commits to it.
+//
+// Any changes held in the utxo cache are written to the database first.  The
+// set is then read from a database snapshot, so blocks can continue to be
+// processed while the potentially lengthy scan takes place.  The returned
+// statistics are for the block the snapshot is consistent with.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) UtxoStats() (*UtxoStats, error) {
+	if err := b.FlushUtxoCache(); err != nil {
+		return nil, err
+	}
+
+	var stats UtxoStats
+	hasher := sha256.New()
+	err := b.db.View(func(dbTx database.Tx) error {
+		consistentHash := dbFetchUtxoStateConsistency(dbTx)
+		if consistentHash == nil {
+			return AssertError("utxo set consistency hash is missing")
+		}
+		node := b.index.LookupNode(consistentHash)
+		if node == nil {
+			return AssertError(fmt.Sprintf("utxo set is consistent "+
+				"with unknown block %v", consistentHash))
+		}
+		stats.Height = node.height
+		stats.Hash = node.hash
+
+		var prevHash chainhash.Hash
+		utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+		return utxoBucket.ForEach(func(k, v []byte) error {
+			if len(k) <= chainhash.HashSize {
+				return AssertError(fmt.Sprintf("utxo set key %x "+
+					"is malformed", k))
+			}
+			var outpoint wire.OutPoint
+			copy(outpoint.Hash[:], k[:chainhash.HashSize])
+			index, _ := deserializeVLQ(k[chainhash.HashSize:])
+			outpoint.Index = uint32(index)
+
+			entry, err := deserializeUtxoEntry(v)
+			if err != nil {
+				return err
+			}
+
+			// Outputs of the same transaction are adjacent.
+			if stats.Outputs == 0 || outpoint.Hash != prevHash {
+				stats.Transactions++
+				prevHash = outpoint.Hash
+			}
+			stats.Outputs++
+			stats.BogoSize += 50 + int64(len(entry.PkScript()))
+			stats.DiskSize += int64(len(k) + len(v))
+			stats.TotalAmount += entry.Amount()
+
+			return writeUtxoForHash(hasher, outpoint, entry)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.SerializedHash = chainhash.HashH(hasher.Sum(nil))
+	return &stats, nil
+}
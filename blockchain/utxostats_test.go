@@ -0,0 +1,95 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// TestUtxoStats ensures the utxo set statistics account for changes which are
+// still held in the utxo cache and that the set hash only depends on the
+// contents of the set.
+func TestUtxoStats(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxostats",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Use a cache large enough to hold all of the changes so the stats
+	// must flush them.
+	chain.utxoCache.maxTotalMemoryUsage = 1 << 20
+
+	outpoints := []wire.OutPoint{
+		{Hash: chainhash.Hash{0x01}, Index: 0},
+		{Hash: chainhash.Hash{0x01}, Index: 300},
+		{Hash: chainhash.Hash{0x02}, Index: 1},
+	}
+	view := NewUtxoViewpoint()
+	for i, outpoint := range outpoints {
+		txOut := &wire.TxOut{Value: int64(i+1) * 1000, PkScript: []byte{0x51}}
+		view.addTxOut(outpoint, txOut, i == 0, int32(i))
+	}
+	chain.utxoCache.commit(view)
+	view.commit()
+
+	stats, err := chain.UtxoStats()
+	if err != nil {
+		t.Fatalf("UtxoStats: unexpected error: %v", err)
+	}
+	tip := chain.BestSnapshot()
+	if stats.Height != tip.Height || stats.Hash != tip.Hash {
+		t.Fatalf("unexpected block -- got %d (%v), want %d (%v)",
+			stats.Height, stats.Hash, tip.Height, tip.Hash)
+	}
+	if stats.Transactions != 2 || stats.Outputs != 3 {
+		t.Fatalf("unexpected counts -- got %d transactions and %d "+
+			"outputs, want 2 and 3", stats.Transactions,
+			stats.Outputs)
+	}
+	if stats.TotalAmount != 6000 {
+		t.Fatalf("unexpected total amount -- got %d, want 6000",
+			stats.TotalAmount)
+	}
+	if stats.BogoSize != 3*51 {
+		t.Fatalf("unexpected bogo size -- got %d, want %d",
+			stats.BogoSize, 3*51)
+	}
+	if len(chain.utxoCache.cachedEntries) != 0 {
+		t.Fatalf("utxo cache was not flushed")
+	}
+
+	// The hash must change when the set changes and be restored when the
+	// same set is recreated.
+	spent := view.LookupEntry(outpoints[1]).Clone()
+	view.LookupEntry(outpoints[1]).Spend()
+	chain.utxoCache.commit(view)
+	view.commit()
+	spentStats, err := chain.UtxoStats()
+	if err != nil {
+		t.Fatalf("UtxoStats: unexpected error: %v", err)
+	}
+	if spentStats.SerializedHash == stats.SerializedHash {
+		t.Fatalf("utxo set hash did not change after spending output")
+	}
+
+	txOut := &wire.TxOut{Value: spent.Amount(), PkScript: spent.PkScript()}
+	view.addTxOut(outpoints[1], txOut, false, spent.BlockHeight())
+	chain.utxoCache.commit(view)
+	view.commit()
+	restoredStats, err := chain.UtxoStats()
+	if err != nil {
+		t.Fatalf("UtxoStats: unexpected error: %v", err)
+	}
+	if restoredStats.SerializedHash != stats.SerializedHash {
+		t.Fatalf("unexpected utxo set hash -- got %v, want %v",
+			restoredStats.SerializedHash, stats.SerializedHash)
+	}
+}
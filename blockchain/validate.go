@@ -343,8 +343,11 @@ func checkProofOfWork(header *wire.BlockHeader, powLimit *big.Int, flags Behavio
 	// The block hash must be less than the claimed target unless the flag
 	// to avoid proof of work checks is set.
 	if flags&BFNoPoWCheck != BFNoPoWCheck {
-		// The block hash must be less than the claimed target.
-		hash := header.BlockHash()
+		// The yespower hash must be less than the claimed target.
+		// This is the only check which fundamentally requires the
+		// proof-of-work hash, so all other code paths identify blocks
+		// by the hash already stored alongside them.
+		hash := header.PowHash()
 		hashNum := HashToBig(&hash)
 		if hashNum.Cmp(target) > 0 {
 			str := fmt.Sprintf("block hash of %064x is higher than "+
@@ -363,6 +366,16 @@ func CheckProofOfWork(block *bteutil.Block, powLimit *big.Int) error {
 	return checkProofOfWork(&block.MsgBlock().Header, powLimit, BFNone)
 }
 
+// CheckHeaderProofOfWork ensures the passed block header bits which indicate
+// the target difficulty is in min/max range and that the yespower hash of the
+// header is less than the target difficulty as claimed.
+//
+// This function is safe for concurrent access, which allows callers to verify
+// the expensive proof of work of several headers in parallel.
+func CheckHeaderProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	return checkProofOfWork(header, powLimit, BFNone)
+}
+
 // CountSigOps returns the number of signature operations for all transaction
 // input and output scripts in the provided transaction.  This uses the
 // quicker, but imprecise, signature operation counting mechanism from
@@ -760,85 +773,98 @@ func (b *BlockChain) checkBlockContext(block *bteutil.Block, prevNode *blockNode
 
 	fastAdd := flags&BFFastAdd == BFFastAdd
 	if !fastAdd {
-		// Obtain the latest state of the deployed CSV soft-fork in
-		// order to properly guard the new validation behavior based on
-		// the current BIP 9 version bits state.
-		csvState, err := b.deploymentState(prevNode, chaincfg.DeploymentCSV)
-		if err != nil {
-			return err
-		}
+		return b.checkBlockTransactionsContext(block, prevNode)
+	}
 
-		// Once the CSV soft-fork is fully active, we'll switch to
-		// using the current median time past of the past block's
-		// timestamps for all lock-time based checks.
-		blockTime := header.Timestamp
-		if csvState == ThresholdActive {
-			blockTime = prevNode.CalcPastMedianTime()
-		}
+	return nil
+}
 
-		// The height of this block is one more than the referenced
-		// previous block.
-		blockHeight := prevNode.height + 1
+// checkBlockTransactionsContext performs the validation checks on the
+// transactions of the block which depend on its position within the block
+// chain, but not on the outputs they spend.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkBlockTransactionsContext(block *bteutil.Block, prevNode *blockNode) error {
+	header := &block.MsgBlock().Header
 
-		// Ensure all transactions in the block are finalized.
-		for _, tx := range block.Transactions() {
-			if !IsFinalizedTransaction(tx, blockHeight,
-				blockTime) {
+	// Obtain the latest state of the deployed CSV soft-fork in
+	// order to properly guard the new validation behavior based on
+	// the current BIP 9 version bits state.
+	csvState, err := b.deploymentState(prevNode, chaincfg.DeploymentCSV)
+	if err != nil {
+		return err
+	}
 
-				str := fmt.Sprintf("block contains unfinalized "+
-					"transaction %v", tx.Hash())
-				return ruleError(ErrUnfinalizedTx, str)
-			}
-		}
+	// Once the CSV soft-fork is fully active, we'll switch to
+	// using the current median time past of the past block's
+	// timestamps for all lock-time based checks.
+	blockTime := header.Timestamp
+	if csvState == ThresholdActive {
+		blockTime = prevNode.CalcPastMedianTime()
+	}
 
-		// Ensure coinbase starts with serialized block heights for
-		// blocks whose version is the serializedHeightVersion or newer
-		// once a majority of the network has upgraded.  This is part of
-		// BIP0034.
-		if ShouldHaveSerializedBlockHeight(header) &&
-			blockHeight >= b.chainParams.BIP0034Height {
+	// The height of this block is one more than the referenced
+	// previous block.
+	blockHeight := prevNode.height + 1
 
-			coinbaseTx := block.Transactions()[0]
-			err := checkSerializedHeight(coinbaseTx, blockHeight)
-			if err != nil {
-				return err
-			}
+	// Ensure all transactions in the block are finalized.
+	for _, tx := range block.Transactions() {
+		if !IsFinalizedTransaction(tx, blockHeight,
+			blockTime) {
+
+			str := fmt.Sprintf("block contains unfinalized "+
+				"transaction %v", tx.Hash())
+			return ruleError(ErrUnfinalizedTx, str)
 		}
+	}
 
-		// Query for the Version Bits state for the segwit soft-fork
-		// deployment. If segwit is active, we'll switch over to
-		// enforcing all the new rules.
-		segwitState, err := b.deploymentState(prevNode,
-			chaincfg.DeploymentSegwit)
+	// Ensure coinbase starts with serialized block heights for
+	// blocks whose version is the serializedHeightVersion or newer
+	// once a majority of the network has upgraded.  This is part of
+	// BIP0034.
+	if ShouldHaveSerializedBlockHeight(header) &&
+		blockHeight >= b.chainParams.BIP0034Height {
+
+		coinbaseTx := block.Transactions()[0]
+		err := checkSerializedHeight(coinbaseTx, blockHeight)
 		if err != nil {
 			return err
 		}
+	}
 
-		// If segwit is active, then we'll need to fully validate the
-		// new witness commitment for adherence to the rules.
-		if segwitState == ThresholdActive {
-			// Validate the witness commitment (if any) within the
-			// block.  This involves asserting that if the coinbase
-			// contains the special commitment output, then this
-			// merkle root matches a computed merkle root of all
-			// the wtxid's of the transactions within the block. In
-			// addition, various other checks against the
-			// coinbase's witness stack.
-			if err := ValidateWitnessCommitment(block); err != nil {
-				return err
-			}
+	// Query for the Version Bits state for the segwit soft-fork
+	// deployment. If segwit is active, we'll switch over to
+	// enforcing all the new rules.
+	segwitState, err := b.deploymentState(prevNode,
+		chaincfg.DeploymentSegwit)
+	if err != nil {
+		return err
+	}
 
-			// Once the witness commitment, witness nonce, and sig
-			// op cost have been validated, we can finally assert
-			// that the block's weight doesn't exceed the current
-			// consensus parameter.
-			blockWeight := GetBlockWeight(block)
-			if blockWeight > MaxBlockWeight {
-				str := fmt.Sprintf("block's weight metric is "+
-					"too high - got %v, max %v",
-					blockWeight, MaxBlockWeight)
-				return ruleError(ErrBlockWeightTooHigh, str)
-			}
+	// If segwit is active, then we'll need to fully validate the
+	// new witness commitment for adherence to the rules.
+	if segwitState == ThresholdActive {
+		// Validate the witness commitment (if any) within the
+		// block.  This involves asserting that if the coinbase
+		// contains the special commitment output, then this
+		// merkle root matches a computed merkle root of all
+		// the wtxid's of the transactions within the block. In
+		// addition, various other checks against the
+		// coinbase's witness stack.
+		if err := ValidateWitnessCommitment(block); err != nil {
+			return err
+		}
+
+		// Once the witness commitment, witness nonce, and sig
+		// op cost have been validated, we can finally assert
+		// that the block's weight doesn't exceed the current
+		// consensus parameter.
+		blockWeight := GetBlockWeight(block)
+		if blockWeight > MaxBlockWeight {
+			str := fmt.Sprintf("block's weight metric is "+
+				"too high - got %v, max %v",
+				blockWeight, MaxBlockWeight)
+			return ruleError(ErrBlockWeightTooHigh, str)
 		}
 	}
 
@@ -867,7 +893,7 @@ func (b *BlockChain) checkBIP0030(node *blockNode, block *bteutil.Block, view *U
 			fetchSet[prevOut] = struct{}{}
 		}
 	}
-	err := view.fetchUtxos(b.db, fetchSet)
+	err := view.fetchUtxos(b.utxoCache, fetchSet)
 	if err != nil {
 		return err
 	}
@@ -1062,7 +1088,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *bteutil.Block, vi
 	//
 	// These utxo entries are needed for verification of things such as
 	// transaction inputs, counting pay-to-script-hashes, and scripts.
-	err := view.fetchInputUtxos(b.db, block)
+	err := view.fetchInputUtxos(b.utxoCache, block)
 	if err != nil {
 		return err
 	}
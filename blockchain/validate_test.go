@@ -6,6 +6,7 @@ package blockchain
 
 import (
 	"math"
+	"math/big"
 	"reflect"
 	"testing"
 	"time"
@@ -169,6 +170,40 @@ func TestCheckBlockSanity(t *testing.T) {
 	}
 }
 
+// TestCheckHeaderProofOfWork ensures the header proof of work check accepts
+// a valid header and rejects headers with an invalid hash or target.
+func TestCheckHeaderProofOfWork(t *testing.T) {
+	powLimit := chaincfg.MainNetParams.PowLimit
+	header := chaincfg.MainNetParams.GenesisBlock.Header
+	if err := CheckHeaderProofOfWork(&header, powLimit); err != nil {
+		t.Fatalf("CheckHeaderProofOfWork: unexpected error for genesis "+
+			"header: %v", err)
+	}
+
+	// Find a nonce which no longer satisfies the claimed target.
+	target := CompactToBig(header.Bits)
+	for {
+		header.Nonce++
+		hash := header.PowHash()
+		if HashToBig(&hash).Cmp(target) > 0 {
+			break
+		}
+	}
+	err := CheckHeaderProofOfWork(&header, powLimit)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrHighHash {
+		t.Fatalf("CheckHeaderProofOfWork: unexpected error - got %v, "+
+			"want %v", err, ErrHighHash)
+	}
+
+	// A target higher than the proof of work limit must be rejected.
+	header.Bits = BigToCompact(new(big.Int).Lsh(powLimit, 1))
+	err = CheckHeaderProofOfWork(&header, powLimit)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrUnexpectedDifficulty {
+		t.Fatalf("CheckHeaderProofOfWork: unexpected error - got %v, "+
+			"want %v", err, ErrUnexpectedDifficulty)
+	}
+}
+
 // TestCheckSerializedHeight tests the checkSerializedHeight function with
 // various serialized heights and also does negative tests to ensure errors
 // and handled properly.
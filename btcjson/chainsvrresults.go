@@ -117,6 +117,14 @@ type GetBlockVerboseTxResult struct {
 	NextHash      string        `json:"nextblockhash,omitempty"`
 }
 
+// GetChainTipsResult models the data from the getchaintips command.
+type GetChainTipsResult struct {
+	Height    int32  `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen int32  `json:"branchlen"`
+	Status    string `json:"status"`
+}
+
 // GetChainTxStatsResult models the data from the getchaintxstats command.
 type GetChainTxStatsResult struct {
 	Time                   int64   `json:"time"`
@@ -145,6 +153,15 @@ type DecodeScriptResult struct {
 	P2sh      string   `json:"p2sh,omitempty"`
 }
 
+// DumpTxOutSetResult models the data returned from the dumptxoutset command.
+type DumpTxOutSetResult struct {
+	CoinsWritten uint64 `json:"coins_written"`
+	BaseHash     string `json:"base_hash"`
+	BaseHeight   int32  `json:"base_height"`
+	Path         string `json:"path"`
+	SnapshotHash string `json:"snapshot_hash"`
+}
+
 // GetAddedNodeInfoResultAddr models the data of the addresses portion of the
 // getaddednodeinfo command.
 type GetAddedNodeInfoResultAddr struct {
@@ -297,6 +314,11 @@ type GetBlockTemplateResult struct {
 	// Block proposal from BIP 0023.
 	Capabilities  []string `json:"capabilities,omitempty"`
 	RejectReasion string   `json:"reject-reason,omitempty"`
+
+	// Version bits deployments from BIP 0009.
+	Rules       []string       `json:"rules"`
+	VbAvailable map[string]int `json:"vbavailable"`
+	VbRequired  int            `json:"vbrequired"`
 }
 
 // GetMempoolEntryResult models the data returned from the getmempoolentry's
@@ -312,29 +334,79 @@ type MempoolFees struct {
 // GetMempoolEntryResult models the data returned from the getmempoolentry
 // command.
 type GetMempoolEntryResult struct {
-	VSize           int32       `json:"vsize"`
-	Size            int32       `json:"size"`
-	Weight          int64       `json:"weight"`
-	Fee             float64     `json:"fee"`
-	ModifiedFee     float64     `json:"modifiedfee"`
-	Time            int64       `json:"time"`
-	Height          int64       `json:"height"`
-	DescendantCount int64       `json:"descendantcount"`
-	DescendantSize  int64       `json:"descendantsize"`
-	DescendantFees  float64     `json:"descendantfees"`
-	AncestorCount   int64       `json:"ancestorcount"`
-	AncestorSize    int64       `json:"ancestorsize"`
-	AncestorFees    float64     `json:"ancestorfees"`
-	WTxId           string      `json:"wtxid"`
-	Fees            MempoolFees `json:"fees"`
-	Depends         []string    `json:"depends"`
+	VSize             int32       `json:"vsize"`
+	Size              int32       `json:"size"`
+	Weight            int64       `json:"weight"`
+	Fee               float64     `json:"fee"`
+	ModifiedFee       float64     `json:"modifiedfee"`
+	Time              int64       `json:"time"`
+	Height            int64       `json:"height"`
+	DescendantCount   int64       `json:"descendantcount"`
+	DescendantSize    int64       `json:"descendantsize"`
+	DescendantFees    float64     `json:"descendantfees"`
+	AncestorCount     int64       `json:"ancestorcount"`
+	AncestorSize      int64       `json:"ancestorsize"`
+	AncestorFees      float64     `json:"ancestorfees"`
+	WTxId             string      `json:"wtxid"`
+	Fees              MempoolFees `json:"fees"`
+	Depends           []string    `json:"depends"`
+	SpentBy           []string    `json:"spentby"`
+	BIP125Replaceable bool        `json:"bip125-replaceable"`
 }
 
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size          int64   `json:"size"`
+	Bytes         int64   `json:"bytes"`
+	MaxMempool    int64   `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+}
+
+// SaveMempoolResult models the data returned from the savemempool command.
+type SaveMempoolResult struct {
+	Filename string `json:"filename"`
+}
+
+// SubmitPackageFees models the fees of a transaction returned from the
+// submitpackage command.
+type SubmitPackageFees struct {
+	Base              float64  `json:"base"`
+	EffectiveFeeRate  float64  `json:"effective-feerate"`
+	EffectiveIncludes []string `json:"effective-includes"`
+}
+
+// SubmitPackageTxResult models the data returned from the submitpackage
+// command for each of the transactions.
+type SubmitPackageTxResult struct {
+	Txid  string            `json:"txid"`
+	Vsize int64             `json:"vsize"`
+	Fees  SubmitPackageFees `json:"fees"`
+}
+
+// SubmitPackageResult models the data returned from the submitpackage command.
+type SubmitPackageResult struct {
+	PackageMsg string                           `json:"package_msg"`
+	TxResults  map[string]SubmitPackageTxResult `json:"tx-results"`
+}
+
+// TestMempoolAcceptFees models the fees of a transaction returned from the
+// testmempoolaccept command.
+type TestMempoolAcceptFees struct {
+	Base             float64 `json:"base"`
+	EffectiveFeeRate float64 `json:"effective-feerate"`
+}
+
+// TestMempoolAcceptResult models the data returned from the testmempoolaccept
+// command for each of the transactions.
+type TestMempoolAcceptResult struct {
+	Txid         string                 `json:"txid"`
+	Wtxid        string                 `json:"wtxid"`
+	Allowed      bool                   `json:"allowed"`
+	Vsize        int64                  `json:"vsize,omitempty"`
+	Fees         *TestMempoolAcceptFees `json:"fees,omitempty"`
+	RejectReason string                 `json:"reject-reason,omitempty"`
 }
 
 // NetworksResult models the networks data from the getnetworkinfo command.
@@ -417,6 +489,7 @@ type GetRawMempoolVerboseResult struct {
 	Vsize            int32    `json:"vsize"`
 	Weight           int32    `json:"weight"`
 	Fee              float64  `json:"fee"`
+	ModifiedFee      float64  `json:"modifiedfee"`
 	Time             int64    `json:"time"`
 	Height           int64    `json:"height"`
 	StartingPriority float64  `json:"startingpriority"`
@@ -455,6 +528,23 @@ type GetTxOutSetInfoResult struct {
 	TotalAmount    bteutil.Amount `json:"total_amount"`
 }
 
+// MarshalJSON marshals the result of the gettxoutsetinfo JSON-RPC call such
+// that the hashes are encoded as strings and the total amount is in BTE.
+func (g *GetTxOutSetInfoResult) MarshalJSON() ([]byte, error) {
+	type Alias GetTxOutSetInfoResult
+	return json.Marshal(&struct {
+		BestBlock      string  `json:"bestblock"`
+		HashSerialized string  `json:"hash_serialized_2"`
+		TotalAmount    float64 `json:"total_amount"`
+		*Alias
+	}{
+		BestBlock:      g.BestBlock.String(),
+		HashSerialized: g.HashSerialized.String(),
+		TotalAmount:    g.TotalAmount.ToBTE(),
+		Alias:          (*Alias)(g),
+	})
+}
+
 // UnmarshalJSON unmarshals the result of the gettxoutsetinfo JSON-RPC call
 func (g *GetTxOutSetInfoResult) UnmarshalJSON(data []byte) error {
 	// Step 1: Create type aliases of the original struct.
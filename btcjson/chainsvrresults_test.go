@@ -107,8 +107,8 @@ func TestChainSvrCustomResults(t *testing.T) {
 	}
 }
 
-// TestGetTxOutSetInfoResult ensures that custom unmarshalling of
-// GetTxOutSetInfoResult works as intended.
+// TestGetTxOutSetInfoResult ensures that custom marshalling and unmarshalling
+// of GetTxOutSetInfoResult works as intended.
 func TestGetTxOutSetInfoResult(t *testing.T) {
 	t.Parallel()
 
@@ -170,6 +170,27 @@ func TestGetTxOutSetInfoResult(t *testing.T) {
 				spew.Sdump(test.want))
 			continue
 		}
+
+		// Ensure the result survives a round trip.
+		marshalled, err := json.Marshal(&out)
+		if err != nil {
+			t.Errorf("Test #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		var roundTripped btcjson.GetTxOutSetInfoResult
+		err = json.Unmarshal(marshalled, &roundTripped)
+		if err != nil {
+			t.Errorf("Test #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(roundTripped, test.want) {
+			t.Errorf("Test #%d (%s) unexpected round tripped data "+
+				"- got %v, want %v", i, test.name,
+				spew.Sdump(roundTripped), spew.Sdump(test.want))
+			continue
+		}
 	}
 }
 
@@ -32,13 +32,14 @@ func (e OutOfRangeError) Error() string {
 // transactions on their first access so subsequent accesses don't have to
 // repeat the relatively expensive hashing operations.
 type Block struct {
-	msgBlock                 *wire.MsgBlock  // Underlying MsgBlock
-	serializedBlock          []byte          // Serialized bytes for the block
-	serializedBlockNoWitness []byte          // Serialized bytes for block w/o witness data
-	blockHash                *chainhash.Hash // Cached block hash
-	blockHeight              int32           // Height in the main block chain
-	transactions             []*Tx           // Transactions
-	txnsGenerated            bool            // ALL wrapped transactions generated
+	msgBlock                 *wire.MsgBlock   // Underlying MsgBlock
+	serializedBlock          []byte           // Serialized bytes for the block
+	serializedBlockNoWitness []byte           // Serialized bytes for block w/o witness data
+	blockHash                *chainhash.Hash  // Cached block hash
+	hashedHeader             wire.BlockHeader // Header the cached hash was computed from
+	blockHeight              int32            // Height in the main block chain
+	transactions             []*Tx            // Transactions
+	txnsGenerated            bool             // ALL wrapped transactions generated
 }
 
 // MsgBlock returns the underlying wire.MsgBlock for the Block.
@@ -92,19 +93,31 @@ func (b *Block) BytesNoWitness() ([]byte, error) {
 
 // Hash returns the block identifier hash for the Block.  This is equivalent to
 // calling BlockHash on the underlying wire.MsgBlock, however it caches the
-// result so subsequent calls are more efficient.
+// result so subsequent calls are more efficient.  The cached hash is
+// discarded when the header of the underlying wire.MsgBlock is modified.
 func (b *Block) Hash() *chainhash.Hash {
-	// Return the cached block hash if it has already been generated.
-	if b.blockHash != nil {
+	// Return the cached block hash if it has already been generated and
+	// the header has not been modified since.
+	if b.blockHash != nil && sameHeader(&b.hashedHeader, &b.msgBlock.Header) {
 		return b.blockHash
 	}
 
 	// Cache the block hash and return it.
 	hash := b.msgBlock.BlockHash()
 	b.blockHash = &hash
+	b.hashedHeader = b.msgBlock.Header
 	return &hash
 }
 
+// sameHeader returns whether or not the two block headers serialize to the
+// same bytes and therefore hash to the same value.
+func sameHeader(a, b *wire.BlockHeader) bool {
+	return a.Version == b.Version && a.PrevBlock == b.PrevBlock &&
+		a.MerkleRoot == b.MerkleRoot &&
+		a.Timestamp.Unix() == b.Timestamp.Unix() &&
+		a.Bits == b.Bits && a.Nonce == b.Nonce
+}
+
 // Tx returns a wrapped transaction (bteutil.Tx) for the transaction at the
 // specified index in the Block.  The supplied index is 0 based.  That is to
 // say, the first transaction in the block is txNum 0.  This is nearly
@@ -51,6 +51,19 @@ func TestBlock(t *testing.T) {
 		}
 	}
 
+	// Ensure the cached hash is discarded when the header is modified.
+	origHash := *b.Hash()
+	b.MsgBlock().Header.Nonce++
+	if hash := b.Hash(); hash.IsEqual(&origHash) {
+		t.Errorf("Hash: stale hash %v returned for modified header",
+			hash)
+	}
+	b.MsgBlock().Header.Nonce--
+	if hash := b.Hash(); !hash.IsEqual(&origHash) {
+		t.Errorf("Hash: mismatched hash after restoring header - got "+
+			"%v, want %v", hash, origHash)
+	}
+
 	// Hashes for the transactions in Block100000.
 	wantTxHashes := []string{
 		"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
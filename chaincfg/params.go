@@ -77,6 +77,17 @@ type Checkpoint struct {
 	Hash   *chainhash.Hash
 }
 
+// AssumeUtxo identifies a known good snapshot of the unspent transaction
+// output set which can be used to bootstrap a new node before downloading and
+// validating the blocks which lead up to it.  The snapshot hash commits to the
+// entire contents of the snapshot file, including the block headers of the
+// chain up to the block it was taken at.
+type AssumeUtxo struct {
+	Height       int32
+	BlockHash    *chainhash.Hash
+	SnapshotHash *chainhash.Hash
+}
+
 // DNSSeed identifies a DNS seed.
 type DNSSeed struct {
 	// Host defines the hostname of the seed.
@@ -224,12 +235,37 @@ type Params struct {
 	// NOTE: This only applies if ReduceMinDifficulty is true.
 	MinDiffReductionTime time.Duration
 
+	// LWMAActivationHeight is the height of the first block whose required
+	// difficulty is calculated with the linearly weighted moving average
+	// (LWMA) difficulty algorithm.  The difficulty of blocks prior to it is
+	// calculated with the legacy retarget rules.
+	LWMAActivationHeight int32
+
+	// LWMAWindow is the number of most recent blocks averaged by the LWMA
+	// difficulty algorithm.  Blocks which do not have a full window of
+	// previous blocks yet require the proof of work limit.  Setting it to
+	// zero disables LWMA so the legacy retarget rules apply to all blocks.
+	LWMAWindow int32
+
+	// LWMATargetSpacing is the desired amount of time between blocks that
+	// the LWMA difficulty algorithm targets.
+	LWMATargetSpacing time.Duration
+
+	// LWMASolvetimeLimit limits the solve time of each block in the LWMA
+	// window to the range [-limit * spacing, limit * spacing] in order to
+	// reduce the impact of manipulated timestamps.
+	LWMASolvetimeLimit int64
+
 	// GenerateSupported specifies whether or not CPU mining is allowed.
 	GenerateSupported bool
 
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// AssumeUtxos are the utxo set snapshots new nodes may be bootstrapped
+	// from.
+	AssumeUtxos []AssumeUtxo
+
 	// These fields are related to voting on consensus rule changes as
 	// defined by BIP0009.
 	//
@@ -299,6 +335,10 @@ var MainNetParams = Params{
 	RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
 	ReduceMinDifficulty:      false,
 	MinDiffReductionTime:     0,
+	LWMAActivationHeight:     92, // First block with a full window
+	LWMAWindow:               90,
+	LWMATargetSpacing:        time.Minute * 1,
+	LWMASolvetimeLimit:       6,
 	GenerateSupported:        false,
 
 	// Checkpoints ordered from oldest to newest.
@@ -320,6 +360,13 @@ var MainNetParams = Params{
 //        {939796, newHashFromStr("0000040a3e7b559aea417d3f886c0e8fe69f1f0530800424b9ea739b1f2c8498")},
 //	},
 	Checkpoints: nil,
+
+	// UTXO set snapshots new nodes may be bootstrapped from.  None are
+	// pinned yet, like the checkpoints, since a snapshot must be dumped
+	// from a node which validated the entire chain and reviewed before it
+	// is trusted.
+	AssumeUtxos: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -425,11 +472,15 @@ var RegressionNetParams = Params{
 	RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
 	ReduceMinDifficulty:      true,
 	MinDiffReductionTime:     time.Minute * 20, // TargetTimePerBlock * 2
+	LWMAWindow:               0,                // Not active - Legacy retarget rules
 	GenerateSupported:        true,
 
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// UTXO set snapshots new nodes may be bootstrapped from.
+	AssumeUtxos: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -534,11 +585,18 @@ var TestNet3Params = Params{
 	RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
 	ReduceMinDifficulty:      true,
 	MinDiffReductionTime:     time.Minute * 20, // TargetTimePerBlock * 2
+	LWMAActivationHeight:     92,               // First block with a full window
+	LWMAWindow:               90,
+	LWMATargetSpacing:        time.Minute * 1,
+	LWMASolvetimeLimit:       6,
 	GenerateSupported:        false,
 
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// UTXO set snapshots new nodes may be bootstrapped from.
+	AssumeUtxos: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -647,11 +705,15 @@ var SimNetParams = Params{
 	RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
 	ReduceMinDifficulty:      true,
 	MinDiffReductionTime:     time.Minute * 20, // TargetTimePerBlock * 2
+	LWMAWindow:               0,                // Not active - Legacy retarget rules
 	GenerateSupported:        true,
 
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// UTXO set snapshots new nodes may be bootstrapped from.
+	AssumeUtxos: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -775,6 +837,10 @@ func CustomSignetParams(challenge []byte, dnsSeeds []DNSSeed) Params {
 		RetargetAdjustmentFactor: 4,                   // 25% less, 400% more
 		ReduceMinDifficulty:      false,
 		MinDiffReductionTime:     time.Minute * 20, // TargetTimePerBlock * 2
+		LWMAActivationHeight:     92,               // First block with a full window
+		LWMAWindow:               90,
+		LWMATargetSpacing:        time.Minute * 1,
+		LWMASolvetimeLimit:       6,
 		GenerateSupported:        false,
 
 		// Checkpoints ordered from oldest to newest.
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mraksoll4/bted/addrmgr"
 	"github.com/mraksoll4/bted/blockchain"
 	"github.com/mraksoll4/bted/chaincfg"
 	"github.com/mraksoll4/bted/chaincfg/chainhash"
@@ -45,6 +46,9 @@ const (
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultConnectTimeout        = time.Second * 30
+	defaultI2PConnectTimeout     = time.Minute * 3
+	defaultI2PKeyFilename        = "i2p_private_key"
+	defaultOnionKeyFilename      = "onion_v3_private_key"
 	defaultMaxRPCClients         = 10
 	defaultMaxRPCWebsockets      = 25
 	defaultMaxRPCConcurrentReqs  = 20
@@ -60,9 +64,15 @@ const (
 	blockMaxWeightMin            = 4000
 	blockMaxWeightMax            = blockchain.MaxBlockWeight - 4000
 	defaultGenerate              = false
+	defaultStratumPort           = "3333"
+	defaultStratumDifficulty     = 1.0
+	defaultStratumMaxClients     = 50
 	defaultMaxOrphanTransactions = 100
 	defaultMaxOrphanTxSize       = 100000
 	defaultSigCacheMaxSize       = 100000
+	defaultUtxoCacheMaxSizeMiB   = 250
+	minPruneTargetMiB            = 550
+	minMaxMempoolMB              = 5
 	sampleConfigFilename         = "sample-bted.conf"
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
@@ -76,6 +86,8 @@ var (
 	defaultRPCKeyFile  = filepath.Join(defaultHomeDir, "rpc.key")
 	defaultRPCCertFile = filepath.Join(defaultHomeDir, "rpc.cert")
 	defaultLogDir      = filepath.Join(defaultHomeDir, defaultLogDirname)
+	supportedNetworks  = []string{addrmgr.NetIPv4, addrmgr.NetIPv6,
+		addrmgr.NetOnion, addrmgr.NetI2P, addrmgr.NetCJDNS}
 )
 
 // runServiceCommand is only set to a real function on Windows.  It is used
@@ -108,6 +120,7 @@ type config struct {
 	BlockMinWeight       uint32        `long:"blockminweight" description:"Mininum block weight to be used when creating a block"`
 	BlockPrioritySize    uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	CJDNSReachable       bool          `long:"cjdnsreachable" description:"Connect to CJDNS addresses (fc00::/8) directly -- NOTE: Only use this when the host is connected to the CJDNS network"`
 	ConfigFile           string        `short:"C" long:"configfile" description:"Path to configuration file"`
 	ConnectPeers         []string      `long:"connect" description:"Connect only to the specified peers at startup"`
 	CPUProfile           string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
@@ -119,21 +132,26 @@ type config struct {
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	ExternalIPs          []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
 	Generate             bool          `long:"generate" description:"Generate (mine) bitcoins using the CPU"`
+	I2PSAM               string        `long:"i2psam" description:"Connect to I2P destinations and accept incoming connections over I2P via the SAM v3.1 bridge of an I2P router (eg. 127.0.0.1:7656)"`
 	FreeTxRelayLimit     float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
 	LogDir               string        `long:"logdir" description:"Directory to log output."`
+	MaxMempoolMB         int64         `long:"maxmempool" description:"Keep the transaction memory pool below the given size in megabytes by evicting the transactions with the lowest fee rates (minimum 5)"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	MempoolExpiry        time.Duration `long:"mempoolexpiry" description:"Remove transactions from the memory pool when they have not been mined within the given time.  Valid time units are {s, m, h}.  Minimum 1 hour"`
 	MiningAddrs          []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
 	MinRelayTxFee        float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTE/kB to be considered a non-zero fee."`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
 	DisableCheckpoints   bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
 	DisableDNSSeed       bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
+	NoI2PListen          bool          `long:"noi2plisten" description:"Do not accept incoming connections over I2P when --i2psam is specified -- NOTE: Incoming I2P connections are not affected by --nolisten"`
 	DisableListen        bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
 	NoOnion              bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
 	NoRelayPriority      bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
+	NoV2Transport        bool          `long:"nov2transport" description:"Disable the v2 encrypted transport protocol (BIP0324)"`
 	NoWinService         bool          `long:"nowinservice" description:"Do not start as a background service on Windows -- NOTE: This flag only works on the command line, not in the config file"`
 	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
 	DisableStallHandler  bool          `long:"nostalldetect" description:"Disables the stall handler system for each peer, useful in simnet/regtest integration tests frameworks"`
@@ -141,10 +159,12 @@ type config struct {
 	OnionProxy           string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	OnionProxyPass       string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	OnionProxyUser       string        `long:"onionuser" description:"Username for onion proxy server"`
+	OnlyNets             []string      `long:"onlynet" description:"Only connect to peers found on the specified network {ipv4, ipv6, onion, i2p, cjdns} automatically -- Can be specified multiple times"`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	Proxy                string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	ProxyPass            string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
 	ProxyUser            string        `long:"proxyuser" description:"Username for proxy server"`
+	PruneMiB             uint64        `long:"prune" description:"Prune old blocks from the database to keep the block data below the target size in MiB (0 to disable, minimum 550)"`
 	RegressionTest       bool          `long:"regtest" description:"Use the regression test network"`
 	RejectNonStd         bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network."`
 	RejectReplacement    bool          `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
@@ -165,16 +185,26 @@ type config struct {
 	SigNet               bool          `long:"signet" description:"Use the signet test network"`
 	SigNetChallenge      string        `long:"signetchallenge" description:"Connect to a custom signet network defined by this challenge instead of using the global default signet test network -- Can be specified multiple times"`
 	SigNetSeedNode       []string      `long:"signetseednode" description:"Specify a seed node for the signet network instead of using the global default signet network seed nodes"`
+	StratumDifficulty    float64       `long:"stratumdiff" description:"Share difficulty Stratum miners start with before it is adjusted to the rate at which they submit shares"`
+	StratumListeners     []string      `long:"stratumlisten" description:"Add an interface/port to listen for Stratum mining connections -- The Stratum server is only enabled when at least one is specified (default port: 3333)"`
+	StratumMaxClients    int           `long:"stratummaxclients" description:"Max number of Stratum miners which may be connected at the same time"`
 	TestNet3             bool          `long:"testnet" description:"Use the test network"`
+	TorControl           string        `long:"torcontrol" description:"Accept incoming connections over Tor by creating an onion service through the control port of the Tor daemon and advertise its address to peers (eg. 127.0.0.1:9051)"`
 	TorIsolation         bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
+	TorPassword          string        `long:"torpassword" default-mask:"-" description:"Password for the Tor control port -- NOTE: Cookie authentication is used when not specified"`
 	TrickleInterval      time.Duration `long:"trickleinterval" description:"Minimum time between attempts to send new inventory to a connected peer"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
 	Upnp                 bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	UtxoCacheMaxSizeMiB  uint          `long:"utxocachemaxsize" description:"The maximum size in MiB of the UTXO cache"`
+	UtxoSnapshot         string        `long:"utxosnapshot" description:"Bootstrap a new chain from the UTXO set snapshot in the given file -- the snapshot must be known to the network parameters and requires --nocfilters"`
 	ShowVersion          bool          `short:"V" long:"version" description:"Display version information and exit"`
 	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
 	lookup               func(string) ([]net.IP, error)
 	oniondial            func(string, string, time.Duration) (net.Conn, error)
+	i2pdial              func(string, string, time.Duration) (net.Conn, error)
+	i2pSession           *connmgr.I2PSession
+	reachableNets        map[string]bool
 	dial                 func(string, string, time.Duration) (net.Conn, error)
 	addCheckpoints       []chaincfg.Checkpoint
 	miningAddrs          []bteutil.Address
@@ -434,9 +464,14 @@ func loadConfig() (*config, []string, error) {
 		BlockMinWeight:       defaultBlockMinWeight,
 		BlockMaxWeight:       defaultBlockMaxWeight,
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
+		MaxMempoolMB:         mempool.DefaultMaxPoolSize / 1000000,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
+		MempoolExpiry:        mempool.DefaultTxExpiry,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		UtxoCacheMaxSizeMiB:  defaultUtxoCacheMaxSizeMiB,
 		Generate:             defaultGenerate,
+		StratumDifficulty:    defaultStratumDifficulty,
+		StratumMaxClients:    defaultStratumMaxClients,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
 	}
@@ -858,6 +893,28 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The mempool must be able to hold at least a few packages of the
+	// largest standard size.
+	if cfg.MaxMempoolMB < minMaxMempoolMB {
+		str := "%s: The maxmempool option may not be less than %d " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, minMaxMempoolMB,
+			cfg.MaxMempoolMB)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Don't allow mempool expiry durations that are too short.
+	if cfg.MempoolExpiry < time.Hour {
+		str := "%s: The mempoolexpiry option may not be less than 1h " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MempoolExpiry)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
 	cfg.BlockMinSize = minUint32(cfg.BlockMinSize, cfg.BlockMaxSize)
@@ -924,6 +981,47 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Pruning requires a minimum target in order to keep enough recent
+	// blocks to handle reorganizations and serve peers.
+	if cfg.PruneMiB != 0 && cfg.PruneMiB < minPruneTargetMiB {
+		str := "%s: the --prune option must be at least %d MiB -- " +
+			"parsed [%d]"
+		err := fmt.Errorf(str, funcName, minPruneTargetMiB,
+			cfg.PruneMiB)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune does not mix with the transaction and address indexes since
+	// they rely on having all blocks available.
+	if cfg.PruneMiB != 0 && (cfg.TxIndex || cfg.AddrIndex) {
+		err := fmt.Errorf("%s: the --prune option may not be "+
+			"activated at the same time as the --txindex or "+
+			"--addrindex options", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --utxosnapshot does not mix with the optional indexes since the blocks
+	// leading up to the snapshot are only downloaded for the background
+	// validation and never connected to the main chain.
+	if cfg.UtxoSnapshot != "" && (cfg.TxIndex || cfg.AddrIndex ||
+		!cfg.NoCFilters) {
+
+		err := fmt.Errorf("%s: the --utxosnapshot option may not be "+
+			"activated at the same time as the --txindex or "+
+			"--addrindex options and requires the --nocfilters "+
+			"option", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.UtxoSnapshot != "" {
+		cfg.UtxoSnapshot = cleanAndExpandPath(cfg.UtxoSnapshot)
+	}
+
 	// Check mining addresses are valid and saved parsed versions.
 	cfg.miningAddrs = make([]bteutil.Address, 0, len(cfg.MiningAddrs))
 	for _, strAddr := range cfg.MiningAddrs {
@@ -956,6 +1054,25 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address and a valid share
+	// difficulty when the Stratum server is enabled.
+	if len(cfg.StratumListeners) > 0 && len(cfg.MiningAddrs) == 0 {
+		str := "%s: the stratumlisten option is set, but there are no " +
+			"mining addresses specified "
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.StratumDifficulty <= 0 {
+		str := "%s: the stratumdiff option must be greater than 0 " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.StratumDifficulty)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Add default port to all listener addresses if needed and remove
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners,
@@ -966,6 +1083,11 @@ func loadConfig() (*config, []string, error) {
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners,
 		activeNetParams.rpcPort)
 
+	// Add default port to all Stratum listener addresses if needed and
+	// remove duplicate addresses.
+	cfg.StratumListeners = normalizeAddresses(cfg.StratumListeners,
+		defaultStratumPort)
+
 	// Only allow TLS to be disabled if the RPC is bound to localhost
 	// addresses.
 	if !cfg.DisableRPC && cfg.DisableTLS {
@@ -1136,6 +1258,96 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Setup the I2P session used to dial I2P addresses and to accept
+	// incoming I2P connections when the SAM bridge of an I2P router is
+	// specified.  The private key of the session is stored in the data
+	// directory so the I2P address of the node stays the same.
+	if cfg.I2PSAM != "" {
+		_, _, err := net.SplitHostPort(cfg.I2PSAM)
+		if err != nil {
+			str := "%s: I2P SAM bridge address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.I2PSAM, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+
+		cfg.i2pSession = connmgr.NewI2PSession(&connmgr.I2PConfig{
+			SAMAddr: cfg.I2PSAM,
+			KeyFile: filepath.Join(cfg.DataDir, defaultI2PKeyFilename),
+		})
+		cfg.i2pdial = cfg.i2pSession.Dial
+	} else {
+		cfg.i2pdial = func(a, b string, t time.Duration) (net.Conn, error) {
+			return nil, errors.New("i2p has not been enabled")
+		}
+	}
+
+	// Validate the address of the Tor control port used to create the
+	// onion service.
+	if cfg.TorControl != "" {
+		_, _, err := net.SplitHostPort(cfg.TorControl)
+		if err != nil {
+			str := "%s: Tor control port address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.TorControl, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	} else if cfg.TorPassword != "" {
+		str := "%s: the --torpassword option requires --torcontrol"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Determine the networks peers are automatically connected on.  Tor
+	// requires a proxy, I2P requires the SAM bridge of a router and CJDNS
+	// must be enabled explicitly.  The --onlynet option restricts them
+	// further.
+	cfg.reachableNets = map[string]bool{
+		addrmgr.NetIPv4:  true,
+		addrmgr.NetIPv6:  true,
+		addrmgr.NetOnion: !cfg.NoOnion && (cfg.Proxy != "" || cfg.OnionProxy != ""),
+		addrmgr.NetI2P:   cfg.I2PSAM != "",
+		addrmgr.NetCJDNS: cfg.CJDNSReachable,
+	}
+	if len(cfg.OnlyNets) > 0 {
+		onlyNets := make(map[string]bool)
+		for _, name := range cfg.OnlyNets {
+			reachable, ok := cfg.reachableNets[name]
+			if !ok {
+				str := "%s: The specified network '%s' is " +
+					"unknown -- supported networks: %s"
+				err := fmt.Errorf(str, funcName, name,
+					strings.Join(supportedNetworks, ", "))
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+			if !reachable {
+				str := "%s: The specified network '%s' is " +
+					"not reachable -- onion requires --proxy " +
+					"or --onion, i2p requires --i2psam, and " +
+					"cjdns requires --cjdnsreachable"
+				err := fmt.Errorf(str, funcName, name)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+			onlyNets[name] = true
+		}
+		for name := range cfg.reachableNets {
+			cfg.reachableNets[name] = onlyNets[name]
+		}
+
+		// The DNS seeds only return IPv4 and IPv6 addresses.
+		if !onlyNets[addrmgr.NetIPv4] && !onlyNets[addrmgr.NetIPv6] {
+			cfg.DisableDNSSeed = true
+		}
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -1217,26 +1429,58 @@ func createDefaultConfigFile(destinationPath string) error {
 // dial function depending on the address and configuration options.  For
 // example, .onion addresses will be dialed using the onion specific proxy if
 // one was specified, but will otherwise use the normal dial function (which
-// could itself use a proxy or not).
+// could itself use a proxy or not).  CJDNS addresses are always dialed directly
+// since they are only reachable through the local CJDNS interface.
 func btedDial(addr net.Addr) (net.Conn, error) {
 	if strings.Contains(addr.String(), ".onion:") {
 		return cfg.oniondial(addr.Network(), addr.String(),
 			defaultConnectTimeout)
 	}
+	if strings.Contains(addr.String(), ".b32.i2p:") {
+		return cfg.i2pdial(addr.Network(), addr.String(),
+			defaultI2PConnectTimeout)
+	}
+	if cfg.CJDNSReachable && isCJDNSAddr(addr) {
+		return net.DialTimeout(addr.Network(), addr.String(),
+			defaultConnectTimeout)
+	}
 	return cfg.dial(addr.Network(), addr.String(), defaultConnectTimeout)
 }
 
+// isCJDNSAddr returns whether the given address is in the fc00::/8 range used
+// by CJDNS.
+func isCJDNSAddr(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil && ip[0] == 0xfc
+}
+
+// btedReachable returns whether peers at the given address may be connected to
+// automatically depending on the configuration options.  For example, I2P
+// addresses are only reachable when the SAM bridge of an I2P router was
+// specified.
+func btedReachable(na *wire.NetAddressV2) bool {
+	return cfg.reachableNets[addrmgr.NetworkName(na)]
+}
+
 // btedLookup resolves the IP of the given host using the correct DNS lookup
 // function depending on the configuration options.  For example, addresses will
 // be resolved using tor when the --proxy flag was specified unless --noonion
 // was also specified in which case the normal system DNS resolver will be used.
 //
-// Any attempt to resolve a tor address (.onion) will return an error since they
-// are not intended to be resolved outside of the tor proxy.
+// Any attempt to resolve a tor address (.onion) or an I2P address (.i2p) will
+// return an error since they are not intended to be resolved outside of the tor
+// proxy or the I2P router.
 func btedLookup(host string) ([]net.IP, error) {
 	if strings.HasSuffix(host, ".onion") {
 		return nil, fmt.Errorf("attempt to resolve tor address %s", host)
 	}
+	if strings.HasSuffix(host, ".i2p") {
+		return nil, fmt.Errorf("attempt to resolve i2p address %s", host)
+	}
 
 	return cfg.lookup(host)
 }
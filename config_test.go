@@ -2,6 +2,7 @@ package main
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -70,3 +71,26 @@ func TestCreateDefaultConfigFile(t *testing.T) {
 		t.Error("Could not find rpcpass in generated default config file.")
 	}
 }
+
+func TestIsCJDNSAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"[fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa]:8333", true},
+		{"[fd00::1]:8333", false},
+		{"[2602:100::1]:8333", false},
+		{"12.1.2.3:8333", false},
+	}
+
+	for _, test := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", test.addr)
+		if err != nil {
+			t.Fatalf("Failed resolving %s: %v", test.addr, err)
+		}
+		if got := isCJDNSAddr(addr); got != test.want {
+			t.Errorf("isCJDNSAddr(%s) = %v, want %v", test.addr, got,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// samVersion is the version of the SAM protocol used to talk to the
+	// I2P router.
+	samVersion = "3.1"
+
+	// samMaxLineLen is the maximum length of a line read from the SAM
+	// bridge.
+	samMaxLineLen = 65536
+
+	// i2pSessionTimeout is the maximum duration of time the creation of a
+	// session, which includes building its tunnels, may take.
+	i2pSessionTimeout = 3 * time.Minute
+
+	// i2pAcceptRetryInterval is the duration of time to wait before
+	// accepting incoming connections is attempted again after the I2P
+	// router failed to do so.
+	i2pAcceptRetryInterval = 30 * time.Second
+)
+
+var (
+	// ErrI2PListenerClosed is returned by the Accept method of an I2P
+	// listener after it has been closed.
+	ErrI2PListenerClosed = errors.New("i2p listener closed")
+
+	// i2pBase64 is the base64 encoding used by I2P which replaces the '+'
+	// and '/' characters of the standard encoding by '-' and '~'.
+	i2pBase64 = base64.NewEncoding(
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~",
+	)
+
+	// i2pBase32 is the base32 encoding used by .b32.i2p addresses.
+	i2pBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// samError describes a request to the SAM bridge which was refused.
+type samError struct {
+	request string
+	result  string
+	message string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *samError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("SAM %s failed: %s", e.request, e.result)
+	}
+	return fmt.Sprintf("SAM %s failed: %s (%s)", e.request, e.result,
+		e.message)
+}
+
+// readSAMLine reads a line terminated by a newline from the SAM bridge.  The
+// line is read one byte at a time since the connection turns into the stream
+// with the remote destination after some of the replies.
+func readSAMLine(conn net.Conn) (string, error) {
+	var line []byte
+	var b [1]byte
+	for len(line) < samMaxLineLen {
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line from SAM bridge is too long")
+}
+
+// parseSAMReply splits a reply of the SAM bridge into its kind, which consists
+// of its first two words, and its KEY=VALUE parameters.  Values may be quoted
+// in order to contain spaces.
+func parseSAMReply(line string) (string, map[string]string) {
+	var words []string
+	var word []rune
+	quoted := false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = word[:0]
+			}
+		default:
+			word = append(word, c)
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	kindLen := len(words)
+	if kindLen > 2 {
+		kindLen = 2
+	}
+	params := make(map[string]string)
+	for _, w := range words[kindLen:] {
+		if i := strings.IndexByte(w, '='); i != -1 {
+			params[w[:i]] = w[i+1:]
+		} else {
+			params[w] = ""
+		}
+	}
+	return strings.Join(words[:kindLen], " "), params
+}
+
+// samRequest sends the passed request to the SAM bridge and returns the
+// parameters of its reply, which must be of the expected kind and successful.
+func samRequest(conn net.Conn, request, expected string) (map[string]string, error) {
+	// Only the command of the request is used in errors since the rest of
+	// it may contain the private key of the destination.
+	command, _ := parseSAMReply(request)
+
+	if _, err := conn.Write([]byte(request + "\n")); err != nil {
+		return nil, err
+	}
+	line, err := readSAMLine(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, params := parseSAMReply(line)
+	if kind != expected {
+		return nil, fmt.Errorf("unexpected reply to SAM %s: %q",
+			command, kind)
+	}
+	if result, ok := params["RESULT"]; ok && result != "OK" {
+		return nil, &samError{
+			request: command,
+			result:  result,
+			message: params["MESSAGE"],
+		}
+	}
+	return params, nil
+}
+
+// i2pDestinationAddr returns the .b32.i2p address of the passed public I2P
+// destination, which is the base32 encoding of its SHA256 hash.
+func i2pDestinationAddr(dest []byte) string {
+	hash := sha256.Sum256(dest)
+	return strings.ToLower(i2pBase32.EncodeToString(hash[:])) + ".b32.i2p"
+}
+
+// i2pPublicDestination returns the public destination contained at the start
+// of the passed base64 encoded private key of an I2P destination.
+func i2pPublicDestination(privKey string) ([]byte, error) {
+	key, err := i2pBase64.DecodeString(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The public destination consists of a 256 byte public key, a 128 byte
+	// signing key, and a certificate made of a 1 byte type and a 2 byte
+	// length followed by its data.
+	const certLenOffset = 256 + 128 + 1
+	if len(key) < certLenOffset+2 {
+		return nil, errors.New("i2p private key is too short")
+	}
+	destLen := certLenOffset + 2 +
+		int(binary.BigEndian.Uint16(key[certLenOffset:]))
+	if len(key) < destLen {
+		return nil, errors.New("i2p private key is too short")
+	}
+	return key[:destLen], nil
+}
+
+// i2pAddr implements the net.Addr interface and represents an I2P address.
+type i2pAddr struct {
+	addr string
+}
+
+// String returns the I2P address in the form of host:port.
+//
+// This is part of the net.Addr interface.
+func (a *i2pAddr) String() string {
+	return a.addr
+}
+
+// Network returns "i2p".
+//
+// This is part of the net.Addr interface.
+func (a *i2pAddr) Network() string {
+	return "i2p"
+}
+
+// Ensure i2pAddr implements the net.Addr interface.
+var _ net.Addr = (*i2pAddr)(nil)
+
+// i2pConn is a stream with an I2P destination.  It reports the I2P addresses
+// of both ends instead of the addresses of the connection to the SAM bridge.
+type i2pConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+// LocalAddr returns the I2P address of the session.
+//
+// This is part of the net.Conn interface.
+func (c *i2pConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RemoteAddr returns the I2P address of the remote destination.
+//
+// This is part of the net.Conn interface.
+func (c *i2pConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// I2PConfig houses the configuration of a session with an I2P router.
+type I2PConfig struct {
+	// SAMAddr is the address of the SAM v3.1 bridge of the I2P router in
+	// the form of host:port.
+	SAMAddr string
+
+	// KeyFile is the path of the file the private key of the destination
+	// of the session is stored in, which keeps the I2P address the same
+	// across sessions.  A new private key is generated by the router and
+	// written to the file when it doesn't exist.  The private key is not
+	// stored at all when it is empty.
+	KeyFile string
+}
+
+// I2PSession is a session with an I2P router through its SAM v3.1 bridge.  It
+// is used to connect to I2P destinations and to accept incoming connections to
+// the destination of the session.
+//
+// The session is created with the router when it is first needed and it is
+// created again when the router ends it.
+type I2PSession struct {
+	cfg I2PConfig
+
+	mtx       sync.Mutex
+	privKey   string
+	control   net.Conn
+	id        string
+	localAddr string
+}
+
+// NewI2PSession returns a new session with the I2P router described by the
+// passed configuration.
+func NewI2PSession(cfg *I2PConfig) *I2PSession {
+	s := &I2PSession{cfg: *cfg}
+
+	// The address of the session is already known when the private key
+	// was stored previously.
+	if cfg.KeyFile != "" {
+		data, err := ioutil.ReadFile(cfg.KeyFile)
+		if err == nil {
+			s.privKey = strings.TrimSpace(string(data))
+			dest, err := i2pPublicDestination(s.privKey)
+			if err == nil {
+				s.localAddr = i2pDestinationAddr(dest)
+			}
+		}
+	}
+
+	return s
+}
+
+// samConnect connects to the SAM bridge and negotiates the protocol version.
+// The deadline of the returned connection is set to the passed deadline.
+func (s *I2PSession) samConnect(deadline time.Time) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", s.cfg.SAMAddr, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+
+	hello := fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s", samVersion,
+		samVersion)
+	if _, err := samRequest(conn, hello, "HELLO REPLY"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// privateKey returns the private key of the destination of the session, which
+// is either the stored one or a new one generated by the router through the
+// passed connection to the SAM bridge.
+//
+// This function MUST be called with the session mutex held (for writes).
+func (s *I2PSession) privateKey(conn net.Conn) (string, error) {
+	if s.privKey != "" {
+		return s.privKey, nil
+	}
+
+	// Use EdDSA-SHA512-Ed25519 signatures for new destinations.
+	reply, err := samRequest(conn, "DEST GENERATE SIGNATURE_TYPE=7",
+		"DEST REPLY")
+	if err != nil {
+		return "", err
+	}
+	privKey := reply["PRIV"]
+	if privKey == "" {
+		return "", errors.New("SAM DEST GENERATE returned no private key")
+	}
+
+	if s.cfg.KeyFile != "" {
+		err := ioutil.WriteFile(s.cfg.KeyFile, []byte(privKey+"\n"), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+	s.privKey = privKey
+	return privKey, nil
+}
+
+// session returns the id and the I2P address of the session, creating the
+// session with the router first when there is none.
+func (s *I2PSession) session() (string, string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.control != nil {
+		return s.id, s.localAddr, nil
+	}
+
+	conn, err := s.samConnect(time.Now().Add(i2pSessionTimeout))
+	if err != nil {
+		return "", "", err
+	}
+	privKey, err := s.privateKey(conn)
+	if err != nil {
+		conn.Close()
+		return "", "", err
+	}
+	dest, err := i2pPublicDestination(privKey)
+	if err != nil {
+		conn.Close()
+		return "", "", err
+	}
+
+	var id [5]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return "", "", err
+	}
+	sessionID := hex.EncodeToString(id[:])
+	request := fmt.Sprintf("SESSION CREATE STYLE=STREAM ID=%s "+
+		"DESTINATION=%s", sessionID, privKey)
+	if _, err := samRequest(conn, request, "SESSION STATUS"); err != nil {
+		conn.Close()
+		return "", "", err
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.control = conn
+	s.id = sessionID
+	s.localAddr = i2pDestinationAddr(dest)
+	log.Infof("Created I2P session %s for %s", s.id, s.localAddr)
+
+	go s.monitorSession(conn)
+	return s.id, s.localAddr, nil
+}
+
+// monitorSession waits for the passed control connection of a session to be
+// closed, which ends the session, so the next use of the session creates a new
+// one.  It must be run as a goroutine.
+func (s *I2PSession) monitorSession(conn net.Conn) {
+	var b [1]byte
+	for {
+		if _, err := conn.Read(b[:]); err != nil {
+			break
+		}
+	}
+	conn.Close()
+
+	s.mtx.Lock()
+	if s.control == conn {
+		log.Infof("I2P session %s ended", s.id)
+		s.control = nil
+		s.id = ""
+	}
+	s.mtx.Unlock()
+}
+
+// checkSession ends the session with the passed id when the passed error of a
+// request to the SAM bridge indicates the router does not know it anymore.
+func (s *I2PSession) checkSession(id string, err error) {
+	if e, ok := err.(*samError); !ok || e.result != "INVALID_ID" {
+		return
+	}
+
+	s.mtx.Lock()
+	if s.control != nil && s.id == id {
+		s.control.Close()
+	}
+	s.mtx.Unlock()
+}
+
+// LocalAddr returns the .b32.i2p address of the session, creating the session
+// with the router first when there is none.
+func (s *I2PSession) LocalAddr() (string, error) {
+	_, localAddr, err := s.session()
+	return localAddr, err
+}
+
+// Dial connects to the I2P destination with the passed address in the form of
+// host:port, where host is a .b32.i2p address.  The network and port are
+// ignored since SAM v3.1 streams don't use ports.  The signature is the same
+// as the one of the dial functions of the proxies.
+func (s *I2PSession) Dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(host, ".b32.i2p") {
+		return nil, fmt.Errorf("%s is not an i2p address", host)
+	}
+
+	id, localAddr, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.samConnect(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams are connected to the full destination which the router
+	// looks up from the hash contained in the address.
+	reply, err := samRequest(conn, "NAMING LOOKUP NAME="+host,
+		"NAMING REPLY")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	request := fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s "+
+		"SILENT=false", id, reply["VALUE"])
+	if _, err := samRequest(conn, request, "STREAM STATUS"); err != nil {
+		conn.Close()
+		s.checkSession(id, err)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &i2pConn{
+		Conn:       conn,
+		localAddr:  &i2pAddr{addr: net.JoinHostPort(localAddr, "0")},
+		remoteAddr: &i2pAddr{addr: addr},
+	}, nil
+}
+
+// Close ends the session with the router.
+func (s *I2PSession) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.control == nil {
+		return nil
+	}
+	err := s.control.Close()
+	s.control = nil
+	s.id = ""
+	return err
+}
+
+// Listener returns a listener which accepts the incoming connections to the
+// destination of the session.
+func (s *I2PSession) Listener() net.Listener {
+	return &i2pListener{
+		session: s,
+		quit:    make(chan struct{}),
+	}
+}
+
+// i2pListener implements the net.Listener interface and accepts the incoming
+// connections to the destination of an I2P session.
+type i2pListener struct {
+	session *I2PSession
+
+	mtx     sync.Mutex
+	pending net.Conn
+	closed  bool
+	quit    chan struct{}
+}
+
+// setPending records the passed connection to the SAM bridge which is waiting
+// for an incoming connection so it is closed when the listener is.  It returns
+// false when the listener has been closed already.
+func (l *i2pListener) setPending(conn net.Conn) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return false
+	}
+	l.pending = conn
+	return true
+}
+
+// accept waits for the next incoming connection.  The returned error is nil
+// together with a nil connection when the remote destination was invalid.
+func (l *i2pListener) accept() (net.Conn, error) {
+	id, localAddr, err := l.session.session()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := l.session.samConnect(time.Now().Add(i2pSessionTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if !l.setPending(conn) {
+		conn.Close()
+		return nil, ErrI2PListenerClosed
+	}
+	defer l.setPending(nil)
+
+	request := fmt.Sprintf("STREAM ACCEPT ID=%s SILENT=false", id)
+	if _, err := samRequest(conn, request, "STREAM STATUS"); err != nil {
+		conn.Close()
+		l.session.checkSession(id, err)
+		return nil, err
+	}
+
+	// The router sends the public destination of the remote peer once it
+	// connects, after which the connection is the stream with it.
+	conn.SetDeadline(time.Time{})
+	line, err := readSAMLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var dest []byte
+	if fields := strings.Fields(line); len(fields) > 0 {
+		dest, err = i2pBase64.DecodeString(fields[0])
+	}
+	if len(dest) == 0 || err != nil {
+		log.Debugf("Invalid destination of incoming I2P connection: %q",
+			line)
+		conn.Close()
+		return nil, nil
+	}
+
+	remoteAddr := net.JoinHostPort(i2pDestinationAddr(dest), "0")
+	return &i2pConn{
+		Conn:       conn,
+		localAddr:  &i2pAddr{addr: net.JoinHostPort(localAddr, "0")},
+		remoteAddr: &i2pAddr{addr: remoteAddr},
+	}, nil
+}
+
+// Accept waits for and returns the next incoming connection.  Failures of the
+// router are retried until the listener is closed.
+//
+// This is part of the net.Listener interface.
+func (l *i2pListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.accept()
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-l.quit:
+			return nil, ErrI2PListenerClosed
+		default:
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Warnf("Unable to accept I2P connections: %v", err)
+		select {
+		case <-l.quit:
+			return nil, ErrI2PListenerClosed
+		case <-time.After(i2pAcceptRetryInterval):
+		}
+	}
+}
+
+// Close stops the listener.  The session remains usable for outgoing
+// connections.
+//
+// This is part of the net.Listener interface.
+func (l *i2pListener) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.quit)
+	if l.pending != nil {
+		l.pending.Close()
+	}
+	return nil
+}
+
+// Addr returns the I2P address of the session when it is known and the address
+// of the SAM bridge otherwise.
+//
+// This is part of the net.Listener interface.
+func (l *i2pListener) Addr() net.Addr {
+	l.session.mtx.Lock()
+	defer l.session.mtx.Unlock()
+
+	if l.session.localAddr == "" {
+		return &i2pAddr{addr: l.session.cfg.SAMAddr}
+	}
+	return &i2pAddr{addr: net.JoinHostPort(l.session.localAddr, "0")}
+}
+
+// Ensure i2pListener implements the net.Listener interface.
+var _ net.Listener = (*i2pListener)(nil)
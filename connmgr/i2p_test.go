@@ -0,0 +1,242 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeI2PDestination returns a random public I2P destination along with a
+// private key which contains it.
+func fakeI2PDestination(t *testing.T) ([]byte, string) {
+	// 256 byte public key, 128 byte signing key, and a key certificate
+	// with 4 bytes of data.
+	dest := make([]byte, 256+128+3+4)
+	if _, err := rand.Read(dest); err != nil {
+		t.Fatalf("unable to generate destination: %v", err)
+	}
+	copy(dest[256+128:], []byte{5, 0, 4})
+
+	privKey := make([]byte, len(dest)+256+32)
+	copy(privKey, dest)
+	return dest, i2pBase64.EncodeToString(privKey)
+}
+
+// fakeSAMBridge implements enough of a SAM v3.1 bridge to create sessions and
+// streams.  Streams echo everything written to them.
+type fakeSAMBridge struct {
+	listener   net.Listener
+	privKey    string
+	dest       string
+	remoteDest string
+}
+
+// serve accepts connections to the bridge until its listener is closed.
+func (b *fakeSAMBridge) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+// handle answers the requests sent through the passed connection.
+func (b *fakeSAMBridge) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		kind, _ := parseSAMReply(strings.TrimSpace(line))
+		switch kind {
+		case "HELLO VERSION":
+			io.WriteString(conn, "HELLO REPLY RESULT=OK VERSION=3.1\n")
+		case "DEST GENERATE":
+			io.WriteString(conn, "DEST REPLY PUB="+b.dest+" PRIV="+
+				b.privKey+"\n")
+		case "SESSION CREATE":
+			io.WriteString(conn, "SESSION STATUS RESULT=OK "+
+				"DESTINATION="+b.privKey+"\n")
+		case "NAMING LOOKUP":
+			io.WriteString(conn, "NAMING REPLY RESULT=OK VALUE="+
+				b.remoteDest+"\n")
+		case "STREAM CONNECT":
+			io.WriteString(conn, "STREAM STATUS RESULT=OK\n")
+			io.Copy(conn, r)
+			return
+		case "STREAM ACCEPT":
+			io.WriteString(conn, "STREAM STATUS RESULT=OK\n")
+			io.WriteString(conn, b.remoteDest+"\n")
+			io.Copy(conn, r)
+			return
+		default:
+			io.WriteString(conn, kind+" RESULT=I2P_ERROR "+
+				"MESSAGE=\"unknown request\"\n")
+		}
+	}
+}
+
+// TestParseSAMReply ensures replies of the SAM bridge are parsed correctly.
+func TestParseSAMReply(t *testing.T) {
+	tests := []struct {
+		line   string
+		kind   string
+		params map[string]string
+	}{
+		{
+			line:   "HELLO REPLY RESULT=OK VERSION=3.1",
+			kind:   "HELLO REPLY",
+			params: map[string]string{"RESULT": "OK", "VERSION": "3.1"},
+		},
+		{
+			line: "STREAM STATUS RESULT=CANT_REACH_PEER " +
+				"MESSAGE=\"Connection timed out\"",
+			kind: "STREAM STATUS",
+			params: map[string]string{
+				"RESULT":  "CANT_REACH_PEER",
+				"MESSAGE": "Connection timed out",
+			},
+		},
+		{
+			line:   "PING",
+			kind:   "PING",
+			params: map[string]string{},
+		},
+	}
+
+	for i, test := range tests {
+		kind, params := parseSAMReply(test.line)
+		if kind != test.kind {
+			t.Errorf("parseSAMReply #%d: unexpected kind - got %q, "+
+				"want %q", i, kind, test.kind)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("parseSAMReply #%d: unexpected params - got "+
+				"%v, want %v", i, params, test.params)
+		}
+	}
+}
+
+// TestI2PSession ensures outgoing and incoming connections are made through
+// the SAM bridge and the private key of the session is stored.
+func TestI2PSession(t *testing.T) {
+	dest, privKey := fakeI2PDestination(t)
+	remoteDest, _ := fakeI2PDestination(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	bridge := &fakeSAMBridge{
+		listener:   listener,
+		privKey:    privKey,
+		dest:       i2pBase64.EncodeToString(dest),
+		remoteDest: i2pBase64.EncodeToString(remoteDest),
+	}
+	go bridge.serve()
+
+	dir, err := ioutil.TempDir("", "i2psession")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "i2p_private_key")
+
+	session := NewI2PSession(&I2PConfig{
+		SAMAddr: listener.Addr().String(),
+		KeyFile: keyFile,
+	})
+	defer session.Close()
+
+	// The address of the session is the hash of its destination and the
+	// generated private key is stored.
+	localAddr, err := session.LocalAddr()
+	if err != nil {
+		t.Fatalf("LocalAddr: unexpected error: %v", err)
+	}
+	if want := i2pDestinationAddr(dest); localAddr != want {
+		t.Fatalf("LocalAddr: unexpected address - got %s, want %s",
+			localAddr, want)
+	}
+	stored, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("unable to read key file: %v", err)
+	}
+	if strings.TrimSpace(string(stored)) != privKey {
+		t.Fatalf("unexpected stored private key %q", stored)
+	}
+	if got := NewI2PSession(&I2PConfig{KeyFile: keyFile}).localAddr; got != localAddr {
+		t.Fatalf("unexpected address from stored key - got %s, want %s",
+			got, localAddr)
+	}
+
+	remoteAddr := i2pDestinationAddr(remoteDest)
+	checkConn := func(conn net.Conn, remote string) {
+		t.Helper()
+
+		if conn.RemoteAddr().String() != remote {
+			t.Fatalf("unexpected remote address - got %s, want %s",
+				conn.RemoteAddr(), remote)
+		}
+		if conn.LocalAddr().String() != localAddr+":0" {
+			t.Fatalf("unexpected local address %s", conn.LocalAddr())
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		if _, err := io.WriteString(conn, "ping"); err != nil {
+			t.Fatalf("unable to write to stream: %v", err)
+		}
+		var buf [4]byte
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			t.Fatalf("unable to read from stream: %v", err)
+		}
+		if string(buf[:]) != "ping" {
+			t.Fatalf("unexpected data %q read from stream", buf)
+		}
+	}
+
+	// Outgoing connection.
+	conn, err := session.Dial("tcp", remoteAddr+":8333", time.Second*5)
+	if err != nil {
+		t.Fatalf("Dial: unexpected error: %v", err)
+	}
+	checkConn(conn, remoteAddr+":8333")
+	conn.Close()
+
+	if _, err := session.Dial("tcp", "127.0.0.1:8333", time.Second); err == nil {
+		t.Fatal("Dial: expected error for non-i2p address")
+	}
+
+	// Incoming connection.
+	i2pListener := session.Listener()
+	conn, err = i2pListener.Accept()
+	if err != nil {
+		t.Fatalf("Accept: unexpected error: %v", err)
+	}
+	checkConn(conn, remoteAddr+":0")
+	conn.Close()
+
+	// Closing the listener stops it from accepting connections.
+	i2pListener.Close()
+	if _, err := i2pListener.Accept(); err != ErrI2PListenerClosed {
+		t.Fatalf("Accept: unexpected error - got %v, want %v", err,
+			ErrI2PListenerClosed)
+	}
+}
@@ -0,0 +1,492 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// torControlTimeout is the maximum duration of time connecting to the
+	// Tor control port, authenticating, and creating an onion service may
+	// take.
+	torControlTimeout = time.Minute
+
+	// torReplyOK is the status code of successful replies of the Tor
+	// control port.
+	torReplyOK = 250
+
+	// torCookieLen is the length of the authentication cookie of the Tor
+	// control port.
+	torCookieLen = 32
+
+	// torSafeCookieServerKey and torSafeCookieClientKey are the HMAC keys
+	// used to prove the knowledge of the authentication cookie during
+	// SAFECOOKIE authentication by the Tor daemon and the controller
+	// respectively.
+	torSafeCookieServerKey = "Tor safe cookie authentication server-to-controller hash"
+	torSafeCookieClientKey = "Tor safe cookie authentication controller-to-server hash"
+)
+
+// torControlError describes a command sent to the Tor control port which
+// failed.
+type torControlError struct {
+	command string
+	code    int
+	message string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *torControlError) Error() string {
+	return fmt.Sprintf("tor control %s failed: %d %s", e.command, e.code,
+		e.message)
+}
+
+// torReply is a reply of the Tor control port which consists of a status code
+// and the text of each of its lines.
+type torReply struct {
+	code  int
+	lines []string
+}
+
+// readTorReply reads a reply from the Tor control port.  The lines of a reply
+// start with the status code followed by '-' for mid reply lines, '+' for lines
+// followed by data terminated by a single ".", and ' ' for the final line.
+func readTorReply(r *bufio.Reader) (*torReply, error) {
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	reply := &torReply{}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("malformed reply from tor control "+
+				"port: %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return nil, fmt.Errorf("malformed reply from tor control "+
+				"port: %q", line)
+		}
+		reply.code = code
+		reply.lines = append(reply.lines, line[4:])
+
+		switch line[3] {
+		case ' ':
+			return reply, nil
+
+		case '-':
+
+		case '+':
+			for {
+				data, err := readLine()
+				if err != nil {
+					return nil, err
+				}
+				if data == "." {
+					break
+				}
+				reply.lines = append(reply.lines,
+					strings.TrimPrefix(data, "."))
+			}
+
+		default:
+			return nil, fmt.Errorf("malformed reply from tor control "+
+				"port: %q", line)
+		}
+	}
+}
+
+// parseTorParams parses the KEY=VALUE parameters of a line of a reply of the
+// Tor control port.  Values may be quoted strings which contain spaces and
+// backslash escapes.
+func parseTorParams(line string) map[string]string {
+	params := make(map[string]string)
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return params
+		}
+
+		// Parameters without a value.
+		eq := strings.IndexAny(line, "= ")
+		if eq == -1 || line[eq] == ' ' {
+			if eq == -1 {
+				eq = len(line)
+			}
+			params[line[:eq]] = ""
+			line = line[eq:]
+			continue
+		}
+
+		key := line[:eq]
+		line = line[eq+1:]
+		if !strings.HasPrefix(line, "\"") {
+			end := strings.IndexByte(line, ' ')
+			if end == -1 {
+				end = len(line)
+			}
+			params[key] = line[:end]
+			line = line[end:]
+			continue
+		}
+
+		// Unescape the quoted value up to the closing quote.
+		var value []byte
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			value = append(value, line[i])
+		}
+		params[key] = string(value)
+		if i < len(line) {
+			i++
+		}
+		line = line[i:]
+	}
+}
+
+// torQuote returns the passed string as a quoted string of the Tor control
+// protocol.
+func torQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	return "\"" + s + "\""
+}
+
+// torCommand sends the passed command to the Tor control port and returns its
+// reply, which must be successful.
+func torCommand(conn net.Conn, r *bufio.Reader, command string) (*torReply, error) {
+	// Only the keyword of the command is used in errors since the rest of
+	// it may contain the password or the private key of the onion service.
+	keyword := command
+	if i := strings.IndexByte(command, ' '); i != -1 {
+		keyword = command[:i]
+	}
+
+	if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+		return nil, err
+	}
+	reply, err := readTorReply(r)
+	if err != nil {
+		return nil, err
+	}
+	if reply.code != torReplyOK {
+		return nil, &torControlError{
+			command: keyword,
+			code:    reply.code,
+			message: strings.Join(reply.lines, " "),
+		}
+	}
+	return reply, nil
+}
+
+// readTorCookie reads the authentication cookie of the Tor control port from
+// the passed file.
+func readTorCookie(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("tor control port did not report its " +
+			"cookie file")
+	}
+	cookie, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cookie) != torCookieLen {
+		return nil, fmt.Errorf("tor cookie file %s has an invalid "+
+			"length of %d bytes", path, len(cookie))
+	}
+	return cookie, nil
+}
+
+// torSafeCookieHash returns the HMAC-SHA256 of the passed cookie and nonces
+// keyed with the passed key as used by SAFECOOKIE authentication.
+func torSafeCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(cookie)
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// TorControlConfig houses the configuration of the onion service created
+// through the control port of a Tor daemon.
+type TorControlConfig struct {
+	// ControlAddr is the address of the control port of the Tor daemon in
+	// the form of host:port.
+	ControlAddr string
+
+	// Password is the password used to authenticate to the control port.
+	// Cookie authentication is used when it is empty.
+	Password string
+
+	// KeyFile is the path of the file the private key of the onion service
+	// is stored in, which keeps the onion address the same across
+	// restarts.  A new private key is generated by Tor and written to the
+	// file when it doesn't exist.  The private key is not stored at all
+	// when it is empty.
+	KeyFile string
+
+	// VirtualPort is the port of the onion service advertised to peers.
+	VirtualPort uint16
+
+	// TargetAddr is the local address in the form of host:port Tor
+	// forwards the incoming connections of the onion service to.
+	TargetAddr string
+}
+
+// TorController creates an ephemeral v3 onion service through the control port
+// of a Tor daemon.  Tor removes the service again once the connection to the
+// control port is closed.
+type TorController struct {
+	cfg TorControlConfig
+
+	mtx  sync.Mutex
+	conn net.Conn
+}
+
+// NewTorController returns a new controller for the Tor daemon described by
+// the passed configuration.
+func NewTorController(cfg *TorControlConfig) *TorController {
+	return &TorController{cfg: *cfg}
+}
+
+// VirtualPort returns the port of the onion service advertised to peers.
+func (c *TorController) VirtualPort() uint16 {
+	return c.cfg.VirtualPort
+}
+
+// authenticate authenticates to the Tor control port using the password when
+// one is configured and the authentication cookie otherwise.
+func (c *TorController) authenticate(conn net.Conn, r *bufio.Reader) error {
+	reply, err := torCommand(conn, r, "PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	methods := make(map[string]bool)
+	var cookieFile string
+	for _, line := range reply.lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		params := parseTorParams(line[len("AUTH "):])
+		for _, method := range strings.Split(params["METHODS"], ",") {
+			methods[method] = true
+		}
+		cookieFile = params["COOKIEFILE"]
+	}
+
+	switch {
+	case c.cfg.Password != "":
+		if !methods["HASHEDPASSWORD"] {
+			return errors.New("tor control port does not accept " +
+				"password authentication")
+		}
+		_, err = torCommand(conn, r, "AUTHENTICATE "+
+			torQuote(c.cfg.Password))
+		return err
+
+	case methods["NULL"]:
+		_, err = torCommand(conn, r, "AUTHENTICATE")
+		return err
+
+	case methods["SAFECOOKIE"]:
+		return c.safeCookieAuthenticate(conn, r, cookieFile)
+
+	case methods["COOKIE"]:
+		cookie, err := readTorCookie(cookieFile)
+		if err != nil {
+			return err
+		}
+		_, err = torCommand(conn, r, "AUTHENTICATE "+
+			hex.EncodeToString(cookie))
+		return err
+	}
+
+	return errors.New("tor control port requires a password")
+}
+
+// safeCookieAuthenticate authenticates to the Tor control port with the cookie
+// stored in the passed file using SAFECOOKIE authentication, which also makes
+// sure the control port knows the cookie without revealing it.
+func (c *TorController) safeCookieAuthenticate(conn net.Conn, r *bufio.Reader, cookieFile string) error {
+	cookie, err := readTorCookie(cookieFile)
+	if err != nil {
+		return err
+	}
+	clientNonce := make([]byte, 32)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return err
+	}
+
+	reply, err := torCommand(conn, r, "AUTHCHALLENGE SAFECOOKIE "+
+		hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+	params := parseTorParams(strings.TrimPrefix(reply.lines[0],
+		"AUTHCHALLENGE "))
+	serverHash, err := hex.DecodeString(params["SERVERHASH"])
+	if err != nil {
+		return fmt.Errorf("tor control port sent an invalid server "+
+			"hash: %v", err)
+	}
+	serverNonce, err := hex.DecodeString(params["SERVERNONCE"])
+	if err != nil {
+		return fmt.Errorf("tor control port sent an invalid server "+
+			"nonce: %v", err)
+	}
+
+	expected := torSafeCookieHash(torSafeCookieServerKey, cookie,
+		clientNonce, serverNonce)
+	if !hmac.Equal(serverHash, expected) {
+		return errors.New("tor control port does not know the " +
+			"authentication cookie")
+	}
+
+	clientHash := torSafeCookieHash(torSafeCookieClientKey, cookie,
+		clientNonce, serverNonce)
+	_, err = torCommand(conn, r, "AUTHENTICATE "+
+		hex.EncodeToString(clientHash))
+	return err
+}
+
+// addOnion creates the onion service with the stored private key, or a new one
+// generated by Tor which is stored then, and returns its service id.
+func (c *TorController) addOnion(conn net.Conn, r *bufio.Reader) (string, error) {
+	key := "NEW:ED25519-V3"
+	if c.cfg.KeyFile != "" {
+		data, err := ioutil.ReadFile(c.cfg.KeyFile)
+		if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			key = strings.TrimSpace(string(data))
+		}
+	}
+
+	command := fmt.Sprintf("ADD_ONION %s Port=%d,%s", key,
+		c.cfg.VirtualPort, c.cfg.TargetAddr)
+	reply, err := torCommand(conn, r, command)
+	if err != nil {
+		return "", err
+	}
+	var serviceID, privKey string
+	for _, line := range reply.lines {
+		params := parseTorParams(line)
+		if id, ok := params["ServiceID"]; ok {
+			serviceID = id
+		}
+		if pk, ok := params["PrivateKey"]; ok {
+			privKey = pk
+		}
+	}
+	if serviceID == "" {
+		return "", errors.New("tor control ADD_ONION returned no " +
+			"service id")
+	}
+
+	if privKey != "" && c.cfg.KeyFile != "" {
+		err := ioutil.WriteFile(c.cfg.KeyFile, []byte(privKey+"\n"), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+	return serviceID, nil
+}
+
+// CreateOnionService connects to the Tor control port, authenticates, and
+// creates the onion service.  It returns the .onion address of the service
+// along with a channel which is closed once the connection to the control port
+// ends, which removes the service.
+func (c *TorController) CreateOnionService() (string, <-chan struct{}, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.conn != nil {
+		return "", nil, errors.New("onion service already created")
+	}
+
+	conn, err := net.DialTimeout("tcp", c.cfg.ControlAddr,
+		torControlTimeout)
+	if err != nil {
+		return "", nil, err
+	}
+	conn.SetDeadline(time.Now().Add(torControlTimeout))
+	r := bufio.NewReader(conn)
+
+	if err := c.authenticate(conn, r); err != nil {
+		conn.Close()
+		return "", nil, err
+	}
+	serviceID, err := c.addOnion(conn, r)
+	if err != nil {
+		conn.Close()
+		return "", nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.conn = conn
+	onionAddr := serviceID + ".onion"
+	log.Infof("Created onion service %s", onionAddr)
+
+	done := make(chan struct{})
+	go c.monitorConn(conn, r, done)
+	return onionAddr, done, nil
+}
+
+// monitorConn waits for the passed connection to the control port to be
+// closed, which removes the onion service, and closes the passed channel then.
+// Asynchronous events sent by the control port are ignored.  It must be run as
+// a goroutine.
+func (c *TorController) monitorConn(conn net.Conn, r *bufio.Reader, done chan struct{}) {
+	for {
+		if _, err := readTorReply(r); err != nil {
+			break
+		}
+	}
+	conn.Close()
+
+	c.mtx.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mtx.Unlock()
+
+	close(done)
+}
+
+// Close closes the connection to the control port, which removes the onion
+// service.
+func (c *TorController) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTorControl implements enough of the control port of a Tor daemon to
+// authenticate and create onion services.
+type fakeTorControl struct {
+	listener    net.Listener
+	methods     string
+	cookieFile  string
+	cookie      []byte
+	password    string
+	serviceID   string
+	privKey     string
+	serverNonce []byte
+
+	// onions receives the ADD_ONION commands received by the control port.
+	onions chan string
+}
+
+// serve accepts connections to the control port until its listener is closed.
+func (f *fakeTorControl) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle answers the commands sent through the passed connection.
+func (f *fakeTorControl) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var clientNonce []byte
+	authenticated := false
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		keyword, args := line, ""
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			keyword, args = line[:i], line[i+1:]
+		}
+
+		switch keyword {
+		case "PROTOCOLINFO":
+			fmt.Fprintf(conn, "250-PROTOCOLINFO 1\r\n"+
+				"250-AUTH METHODS=%s COOKIEFILE=%s\r\n"+
+				"250-VERSION Tor=\"0.4.8.9\"\r\n250 OK\r\n",
+				f.methods, torQuote(f.cookieFile))
+
+		case "AUTHCHALLENGE":
+			clientNonce, _ = hex.DecodeString(
+				strings.TrimPrefix(args, "SAFECOOKIE "))
+			serverHash := torSafeCookieHash(torSafeCookieServerKey,
+				f.cookie, clientNonce, f.serverNonce)
+			fmt.Fprintf(conn, "250 AUTHCHALLENGE SERVERHASH=%x "+
+				"SERVERNONCE=%x\r\n", serverHash, f.serverNonce)
+
+		case "AUTHENTICATE":
+			want := torQuote(f.password)
+			if f.password == "" {
+				want = hex.EncodeToString(torSafeCookieHash(
+					torSafeCookieClientKey, f.cookie,
+					clientNonce, f.serverNonce))
+			}
+			if args != want {
+				io.WriteString(conn, "515 Authentication failed\r\n")
+				return
+			}
+			authenticated = true
+			io.WriteString(conn, "250 OK\r\n")
+
+		case "ADD_ONION":
+			if !authenticated {
+				io.WriteString(conn, "514 Authentication required\r\n")
+				return
+			}
+			f.onions <- args
+			fmt.Fprintf(conn, "250-ServiceID=%s\r\n", f.serviceID)
+			if strings.HasPrefix(args, "NEW:") {
+				fmt.Fprintf(conn, "250-PrivateKey=%s\r\n", f.privKey)
+			}
+			io.WriteString(conn, "250 OK\r\n")
+
+		default:
+			io.WriteString(conn, "510 Unrecognized command\r\n")
+		}
+	}
+}
+
+// TestParseTorParams ensures the parameters of replies of the Tor control port
+// are parsed correctly.
+func TestParseTorParams(t *testing.T) {
+	tests := []struct {
+		line   string
+		params map[string]string
+	}{
+		{
+			line: "METHODS=COOKIE,SAFECOOKIE " +
+				"COOKIEFILE=\"/var/lib/tor/control auth\\\"cookie\"",
+			params: map[string]string{
+				"METHODS":    "COOKIE,SAFECOOKIE",
+				"COOKIEFILE": "/var/lib/tor/control auth\"cookie",
+			},
+		},
+		{
+			line:   "ServiceID=abcdef",
+			params: map[string]string{"ServiceID": "abcdef"},
+		},
+		{
+			line:   "OK  Flag",
+			params: map[string]string{"OK": "", "Flag": ""},
+		},
+		{
+			line:   "",
+			params: map[string]string{},
+		},
+	}
+
+	for i, test := range tests {
+		params := parseTorParams(test.line)
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("parseTorParams #%d: unexpected params - got %v, "+
+				"want %v", i, params, test.params)
+		}
+	}
+}
+
+// TestReadTorReply ensures multi-line replies of the Tor control port are read
+// correctly.
+func TestReadTorReply(t *testing.T) {
+	reply, err := readTorReply(bufio.NewReader(strings.NewReader(
+		"250-ServiceID=abc\r\n250+Data=\r\nline\r\n..dot\r\n.\r\n" +
+			"250 OK\r\n")))
+	if err != nil {
+		t.Fatalf("readTorReply: unexpected error: %v", err)
+	}
+	want := &torReply{
+		code:  250,
+		lines: []string{"ServiceID=abc", "Data=", "line", ".dot", "OK"},
+	}
+	if !reflect.DeepEqual(reply, want) {
+		t.Fatalf("readTorReply: unexpected reply - got %v, want %v",
+			reply, want)
+	}
+
+	_, err = readTorReply(bufio.NewReader(strings.NewReader("25\r\n")))
+	if err == nil {
+		t.Fatal("readTorReply: expected error for malformed reply")
+	}
+}
+
+// TestTorController ensures onion services are created through the control
+// port with both cookie and password authentication and the private key of the
+// service is stored and reused.
+func TestTorController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torcontrol")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cookie := make([]byte, torCookieLen)
+	for i := range cookie {
+		cookie[i] = byte(i)
+	}
+	cookieFile := filepath.Join(dir, "control_auth_cookie")
+	if err := ioutil.WriteFile(cookieFile, cookie, 0600); err != nil {
+		t.Fatalf("unable to write cookie file: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	const serviceID = "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd"
+	control := &fakeTorControl{
+		listener:    listener,
+		methods:     "COOKIE,SAFECOOKIE,HASHEDPASSWORD",
+		cookieFile:  cookieFile,
+		cookie:      cookie,
+		serviceID:   serviceID,
+		privKey:     "ED25519-V3:c2VjcmV0",
+		serverNonce: []byte{0x01, 0x02, 0x03},
+		onions:      make(chan string, 1),
+	}
+	go control.serve()
+
+	keyFile := filepath.Join(dir, "onion_v3_private_key")
+	cfg := TorControlConfig{
+		ControlAddr: listener.Addr().String(),
+		KeyFile:     keyFile,
+		VirtualPort: 8333,
+		TargetAddr:  "127.0.0.1:18334",
+	}
+
+	// A new service is created using cookie authentication and its private
+	// key is stored.
+	controller := NewTorController(&cfg)
+	onionAddr, done, err := controller.CreateOnionService()
+	if err != nil {
+		t.Fatalf("CreateOnionService: unexpected error: %v", err)
+	}
+	if onionAddr != serviceID+".onion" {
+		t.Fatalf("CreateOnionService: unexpected address %s", onionAddr)
+	}
+	if cmd := <-control.onions; cmd != "NEW:ED25519-V3 Port=8333,127.0.0.1:18334" {
+		t.Fatalf("unexpected ADD_ONION command %q", cmd)
+	}
+	stored, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("unable to read key file: %v", err)
+	}
+	if strings.TrimSpace(string(stored)) != control.privKey {
+		t.Fatalf("unexpected stored private key %q", stored)
+	}
+
+	// Closing the controller removes the service.
+	controller.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("done channel not closed after Close")
+	}
+
+	// The stored private key is used with password authentication.
+	control.password = "pass\"word"
+	cfg.Password = control.password
+	controller = NewTorController(&cfg)
+	if _, _, err := controller.CreateOnionService(); err != nil {
+		t.Fatalf("CreateOnionService: unexpected error: %v", err)
+	}
+	defer controller.Close()
+	if cmd := <-control.onions; cmd != control.privKey+" Port=8333,127.0.0.1:18334" {
+		t.Fatalf("unexpected ADD_ONION command %q", cmd)
+	}
+
+	// A wrong password is rejected.
+	cfg.Password = "wrong"
+	_, _, err = NewTorController(&cfg).CreateOnionService()
+	if _, ok := err.(*torControlError); !ok {
+		t.Fatalf("CreateOnionService: unexpected error %v", err)
+	}
+}
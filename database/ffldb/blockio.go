@@ -99,6 +99,9 @@ type writeCursor struct {
 	// curOffset is the offset in the current write block file where the
 	// next new block will be written.
 	curOffset uint32
+
+	// firstFileNum is the oldest block file which has not been pruned.
+	firstFileNum uint32
 }
 
 // blockStore houses information used to handle reading and writing blocks (and
@@ -712,28 +715,93 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 	}
 }
 
+// pruneFiles closes and deletes the passed block files, which must be the
+// oldest block files in ascending order, and advances the first block file
+// accordingly.  It is used to remove block files once the block index no longer
+// refers to any of the blocks they contain.
+//
+// Any errors are simply logged at a warning level rather than being returned
+// since the files which failed to be deleted are attempted again the next time
+// blocks are pruned.
+func (s *blockStore) pruneFiles(fileNums []uint32) {
+	for _, fileNum := range fileNums {
+		// Close the file under the write lock for the file in case any
+		// readers are currently reading from it so it's not closed out
+		// from under them.
+		s.obfMutex.Lock()
+		if blockFile, ok := s.openBlockFiles[fileNum]; ok {
+			s.lruMutex.Lock()
+			s.openBlocksLRU.Remove(s.fileNumToLRUElem[fileNum])
+			delete(s.fileNumToLRUElem, fileNum)
+			s.lruMutex.Unlock()
+
+			blockFile.Lock()
+			_ = blockFile.file.Close()
+			blockFile.Unlock()
+			delete(s.openBlockFiles, fileNum)
+		}
+		s.obfMutex.Unlock()
+
+		if err := s.deleteFileFunc(fileNum); err != nil {
+			log.Warnf("PRUNE: Failed to delete block file number "+
+				"%d: %v", fileNum, err)
+			return
+		}
+
+		wc := s.writeCursor
+		wc.Lock()
+		wc.firstFileNum = fileNum + 1
+		wc.Unlock()
+	}
+}
+
+// blockFileSize returns the size of the passed flat block file number.
+func (s *blockStore) blockFileSize(fileNum uint32) (uint64, error) {
+	filePath := blockFilePath(s.basePath, fileNum)
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return 0, makeDbErr(database.ErrDriverSpecific, err.Error(), err)
+	}
+
+	return uint64(st.Size()), nil
+}
+
 // scanBlockFiles searches the database directory for all flat block files to
-// find the end of the most recent file.  This position is considered the
-// current write cursor which is also stored in the metadata.  Thus, it is used
-// to detect unexpected shutdowns in the middle of writes so the block files
-// can be reconciled.
-func scanBlockFiles(dbPath string) (int, uint32) {
+// find the oldest file along with the end of the most recent file.  The end of
+// the most recent file is considered the current write cursor which is also
+// stored in the metadata.  Thus, it is used to detect unexpected shutdowns in
+// the middle of writes so the block files can be reconciled.  The oldest file
+// is only something other than the first one when blocks have been pruned.
+func scanBlockFiles(dbPath string) (int, int, uint32) {
+	firstFile := -1
 	lastFile := -1
 	fileLen := uint32(0)
-	for i := 0; ; i++ {
-		filePath := blockFilePath(dbPath, uint32(i))
+	filePaths, _ := filepath.Glob(filepath.Join(dbPath, "*.fdb"))
+	for _, filePath := range filePaths {
+		var fileNum uint32
+		_, err := fmt.Sscanf(filepath.Base(filePath), blockFilenameTemplate,
+			&fileNum)
+		if err != nil {
+			continue
+		}
+		if firstFile == -1 || int(fileNum) < firstFile {
+			firstFile = int(fileNum)
+		}
+		if int(fileNum) <= lastFile {
+			continue
+		}
 		st, err := os.Stat(filePath)
 		if err != nil {
-			break
+			continue
 		}
-		lastFile = i
+		lastFile = int(fileNum)
 
 		fileLen = uint32(st.Size())
 	}
 
-	log.Tracef("Scan found latest block file #%d with length %d", lastFile,
-		fileLen)
-	return lastFile, fileLen
+	log.Tracef("Scan found oldest block file #%d and latest block file #%d "+
+		"with length %d", firstFile, lastFile, fileLen)
+	return firstFile, lastFile, fileLen
 }
 
 // newBlockStore returns a new block store with the current block file number
@@ -742,8 +810,9 @@ func newBlockStore(basePath string, network wire.BitcoinNet) *blockStore {
 	// Look for the end of the latest block to file to determine what the
 	// write cursor position is from the viewpoing of the block files on
 	// disk.
-	fileNum, fileOff := scanBlockFiles(basePath)
+	firstFileNum, fileNum, fileOff := scanBlockFiles(basePath)
 	if fileNum == -1 {
+		firstFileNum = 0
 		fileNum = 0
 		fileOff = 0
 	}
@@ -757,9 +826,10 @@ func newBlockStore(basePath string, network wire.BitcoinNet) *blockStore {
 		fileNumToLRUElem: make(map[uint32]*list.Element),
 
 		writeCursor: &writeCursor{
-			curFile:    &lockableFile{},
-			curFileNum: uint32(fileNum),
-			curOffset:  fileOff,
+			curFile:      &lockableFile{},
+			curFileNum:   uint32(fileNum),
+			curOffset:    fileOff,
+			firstFileNum: uint32(firstFileNum),
 		},
 	}
 	store.openFileFunc = store.openFile
@@ -966,6 +966,9 @@ type transaction struct {
 	pendingBlocks    map[chainhash.Hash]int
 	pendingBlockData []pendingBlock
 
+	// Block files that need to be deleted on commit.
+	pendingPrunedFiles []uint32
+
 	// Keys that need to be stored or deleted on commit.
 	pendingKeys   *treap.Mutable
 	pendingRemove *treap.Mutable
@@ -1583,6 +1586,140 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 	return blockRegions, nil
 }
 
+// PruneBlocks deletes the oldest block files until the total size of all block
+// files is at or below the provided target size.  Files are deleted in order
+// from the oldest to the newest and the process stops at the first file which
+// contains a block for which the provided keep function returns true.  The
+// keep function is called for every block in that file.  The file currently
+// being written to is never deleted.  The hashes of all blocks
+// contained in the deleted files are returned.
+//
+// The block files are not actually deleted until the transaction is committed
+// and the removal of the blocks from the block index has been written to
+// persistent storage.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(targetSize uint64, keepBlock func(hash *chainhash.Hash) bool) ([]chainhash.Hash, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return nil, makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	// Determine the range of block files which are candidates for pruning
+	// while skipping any files already pruned by this transaction.
+	store := tx.db.store
+	wc := store.writeCursor
+	wc.RLock()
+	firstFileNum := wc.firstFileNum
+	curFileNum := wc.curFileNum
+	curOffset := wc.curOffset
+	wc.RUnlock()
+	if numPending := len(tx.pendingPrunedFiles); numPending != 0 {
+		firstFileNum = tx.pendingPrunedFiles[numPending-1] + 1
+	}
+
+	// Nothing to do when the total size of the block files is already
+	// within the target.
+	totalSize := uint64(curOffset)
+	fileSizes := make(map[uint32]uint64, curFileNum-firstFileNum)
+	for fileNum := firstFileNum; fileNum < curFileNum; fileNum++ {
+		fileSize, err := store.blockFileSize(fileNum)
+		if err != nil {
+			return nil, err
+		}
+		fileSizes[fileNum] = fileSize
+		totalSize += fileSize
+	}
+	if totalSize <= targetSize {
+		return nil, nil
+	}
+
+	// Group the blocks in the prunable files by file.
+	blocksByFile := make(map[uint32][]chainhash.Hash)
+	err := tx.blockIdxBucket.ForEach(func(k, v []byte) error {
+		loc := deserializeBlockLoc(v)
+		if loc.blockFileNum < firstFileNum ||
+			loc.blockFileNum >= curFileNum {
+
+			return nil
+		}
+
+		var hash chainhash.Hash
+		copy(hash[:], k)
+		blocksByFile[loc.blockFileNum] = append(
+			blocksByFile[loc.blockFileNum], hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the blocks in the oldest files from the block index until the
+	// target is reached or a file with a block that must be kept is found.
+	var prunedHashes []chainhash.Hash
+	for fileNum := firstFileNum; fileNum < curFileNum; fileNum++ {
+		if totalSize <= targetSize {
+			break
+		}
+
+		// The keep function is called for every block in the file, even
+		// after one of them must be kept, so the caller learns about all
+		// of the blocks which prevent the file from being pruned.
+		hashes := blocksByFile[fileNum]
+		var keep bool
+		for i := range hashes {
+			if keepBlock(&hashes[i]) {
+				keep = true
+			}
+		}
+		if keep {
+			return prunedHashes, nil
+		}
+		for i := range hashes {
+			if err := tx.blockIdxBucket.Delete(hashes[i][:]); err != nil {
+				return nil, err
+			}
+		}
+
+		log.Debugf("Pruning block file %d with %d blocks", fileNum,
+			len(hashes))
+		prunedHashes = append(prunedHashes, hashes...)
+		tx.pendingPrunedFiles = append(tx.pendingPrunedFiles, fileNum)
+		totalSize -= fileSizes[fileNum]
+	}
+
+	return prunedHashes, nil
+}
+
+// BeenPruned returns whether or not any block files have been pruned from the
+// database.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) BeenPruned() (bool, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return false, err
+	}
+
+	wc := tx.db.store.writeCursor
+	wc.RLock()
+	defer wc.RUnlock()
+	return wc.firstFileNum > 0, nil
+}
+
 // close marks the transaction closed then releases any pending data, the
 // underlying snapshot, the transaction read lock, and the write lock when the
 // transaction is writable.
@@ -1592,6 +1729,7 @@ func (tx *transaction) close() {
 	// Clear pending blocks that would have been written on commit.
 	tx.pendingBlocks = nil
 	tx.pendingBlockData = nil
+	tx.pendingPrunedFiles = nil
 
 	// Clear pending keys that would have been written or deleted on commit.
 	tx.pendingKeys = nil
@@ -1666,7 +1804,21 @@ func (tx *transaction) writePendingAndCommit() error {
 
 	// Atomically update the database cache.  The cache automatically
 	// handles flushing to the underlying persistent storage database.
-	return tx.db.cache.commitTx(tx)
+	if err := tx.db.cache.commitTx(tx); err != nil {
+		return err
+	}
+
+	// Delete the block files pruned by the transaction.  The cache is
+	// flushed first to ensure the block index in persistent storage never
+	// refers to blocks in files which no longer exist.
+	if len(tx.pendingPrunedFiles) != 0 {
+		if err := tx.db.cache.flush(); err != nil {
+			return err
+		}
+		tx.db.store.pruneFiles(tx.pendingPrunedFiles)
+	}
+
+	return nil
 }
 
 // Commit commits all changes that have been made to the root metadata bucket
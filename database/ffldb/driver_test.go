@@ -12,8 +12,10 @@ import (
 	"testing"
 
 	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
 	"github.com/mraksoll4/bted/database"
 	"github.com/mraksoll4/bted/database/ffldb"
+	"github.com/mraksoll4/bted/wire"
 	"github.com/mraksoll4/bted/bteutil"
 )
 
@@ -284,3 +286,137 @@ func TestInterface(t *testing.T) {
 		testInterface(t, db)
 	})
 }
+
+// TestPrune ensures pruning removes the oldest block files along with the
+// blocks they contain while keeping the requested blocks and that the pruned
+// state persists across database restarts.
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	// Create a new database to run tests against.
+	dbPath := filepath.Join(os.TempDir(), "ffldb-prunetest")
+	_ = os.RemoveAll(dbPath)
+	db, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	// Store a set of synthetic blocks with a small maximum file size to
+	// force multiple flat files.
+	blocks := make([]*bteutil.Block, 40)
+	for i := range blocks {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxOut(wire.NewTxOut(0, make([]byte, 200)))
+		msgBlock := wire.MsgBlock{Header: wire.BlockHeader{Nonce: uint32(i)}}
+		msgBlock.AddTransaction(msgTx)
+		blocks[i] = bteutil.NewBlock(&msgBlock)
+	}
+	ffldb.TstRunWithMaxBlockFileSize(db, 2048, func() {
+		err = db.Update(func(tx database.Tx) error {
+			for _, block := range blocks {
+				if err := tx.StoreBlock(block); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Errorf("StoreBlock: unexpected error: %v", err)
+		return
+	}
+
+	// Prune as much as possible while keeping the most recent blocks.
+	const numKeep = 10
+	keep := make(map[chainhash.Hash]struct{})
+	for _, block := range blocks[len(blocks)-numKeep:] {
+		keep[*block.Hash()] = struct{}{}
+	}
+	var prunedHashes []chainhash.Hash
+	checked := make(map[chainhash.Hash]struct{})
+	err = db.Update(func(tx database.Tx) error {
+		if pruned, err := tx.BeenPruned(); err != nil || pruned {
+			return fmt.Errorf("BeenPruned: unexpected result %v "+
+				"(err %v) before pruning", pruned, err)
+		}
+
+		var err error
+		prunedHashes, err = tx.PruneBlocks(0, func(hash *chainhash.Hash) bool {
+			checked[*hash] = struct{}{}
+			_, ok := keep[*hash]
+			return ok
+		})
+		return err
+	})
+	if err != nil {
+		t.Errorf("PruneBlocks: unexpected error: %v", err)
+		return
+	}
+	if len(prunedHashes) == 0 {
+		t.Errorf("PruneBlocks: no blocks were pruned")
+		return
+	}
+
+	// Every block of the file which stopped pruning must have been checked,
+	// which includes more than one of the blocks that must be kept.
+	var numKeptChecked int
+	for hash := range checked {
+		if _, ok := keep[hash]; ok {
+			numKeptChecked++
+		}
+	}
+	if numKeptChecked < 2 {
+		t.Errorf("PruneBlocks: only %d kept blocks were checked",
+			numKeptChecked)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dbPath, "000000000.fdb")); !os.IsNotExist(err) {
+		t.Errorf("PruneBlocks: oldest block file was not deleted")
+		return
+	}
+
+	// Ensure the pruned blocks are gone and the kept blocks remain after
+	// restarting the database.
+	db.Close()
+	db, err = database.Open(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Failed to open test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+	err = db.View(func(tx database.Tx) error {
+		if pruned, err := tx.BeenPruned(); err != nil || !pruned {
+			return fmt.Errorf("BeenPruned: unexpected result %v "+
+				"(err %v) after pruning", pruned, err)
+		}
+
+		for i := range prunedHashes {
+			hash := &prunedHashes[i]
+			if _, ok := keep[*hash]; ok {
+				return fmt.Errorf("PruneBlocks: block %v that "+
+					"must be kept was pruned", hash)
+			}
+			_, err := tx.FetchBlock(hash)
+			if !checkDbError(t, "FetchBlock", err,
+				database.ErrBlockNotFound) {
+
+				return errSubTestFail
+			}
+		}
+		for hash := range keep {
+			if _, err := tx.FetchBlock(&hash); err != nil {
+				return fmt.Errorf("FetchBlock: unexpected error "+
+					"for kept block %v: %v", hash, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+		return
+	}
+}
@@ -389,6 +389,29 @@ type Tx interface {
 	// implementations.
 	FetchBlockRegions(regions []BlockRegion) ([][]byte, error)
 
+	// PruneBlocks deletes the oldest blocks from the database until the
+	// total size of the stored blocks is at or below the provided target
+	// size.  Blocks are deleted from the oldest to the newest in units
+	// determined by the backend implementation and the process stops at
+	// the first unit which contains a block for which the provided keep
+	// function returns true.  The keep function is called for every block
+	// in that unit so callers can tell when it may be deleted.  The hashes
+	// of all deleted blocks are returned.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	PruneBlocks(targetSize uint64, keepBlock func(hash *chainhash.Hash) bool) ([]chainhash.Hash, error)
+
+	// BeenPruned returns whether or not any blocks have ever been pruned
+	// from the database.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxClosed if the transaction has already been closed
+	BeenPruned() (bool, error)
+
 	// ******************************************************************
 	// Methods related to both atomic metadata storage and block storage.
 	// ******************************************************************
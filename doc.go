@@ -44,6 +44,9 @@ Application Options:
                               transactions when creating a block (default:
                               50000)
       --blocksonly            Do not accept transactions from remote peers.
+      --cjdnsreachable        Connect to CJDNS addresses (fc00::/8) directly --
+                              NOTE: Only use this when the host is connected to
+                              the CJDNS network
   -C, --configfile=           Path to configuration file
       --connect=              Connect only to the specified peers at startup
       --cpuprofile=           Write CPU profile to the specified file
@@ -67,6 +70,9 @@ Application Options:
       --externalip=           Add an ip to the list of local addresses we claim
                               to listen on to peers
       --generate              Generate (mine) bitcoins using the CPU
+      --i2psam=               Connect to I2P destinations and accept incoming
+                              connections over I2P via the SAM v3.1 bridge of
+                              an I2P router (eg. 127.0.0.1:7656)
       --limitfreerelay=       Limit relay of transactions with no transaction
                               fee to the given amount in thousands of bytes per
                               minute (default: 15)
@@ -74,10 +80,18 @@ Application Options:
                               (default all interfaces port: 8333, testnet:
                               18333, signet: 38333)
       --logdir=               Directory to log output
+      --maxmempool=           Keep the transaction memory pool below the given
+                              size in megabytes by evicting the transactions
+                              with the lowest fee rates (minimum 5) (default:
+                              300)
       --maxorphantx=          Max number of orphan transactions to keep in
                               memory (default: 100)
       --maxpeers=             Max number of inbound and outbound peers
                               (default: 125)
+      --mempoolexpiry=        Remove transactions from the memory pool when
+                              they have not been mined within the given time.
+                              Valid time units are {s, m, h}.  Minimum 1 hour
+                              (default: 336h0m0s)
       --miningaddr=           Add the specified payment address to the list of
                               addresses to use for generated blocks -- At least
                               one address is required if the generate option is
@@ -89,6 +103,9 @@ Application Options:
       --nocheckpoints         Disable built-in checkpoints.  Don't do this
                               unless you know what you're doing.
       --nodnsseed             Disable DNS seeding for peers
+      --noi2plisten           Do not accept incoming connections over I2P when
+                              --i2psam is specified -- NOTE: Incoming I2P
+                              connections are not affected by --nolisten
       --nolisten              Disable listening for incoming connections --
                               NOTE: Listening is automatically disabled if the
                               --connect or --proxy options are used without
@@ -104,15 +121,23 @@ Application Options:
       --notls                 Disable TLS for the RPC server -- NOTE: This is
                               only allowed if the RPC server is bound to
                               localhost
+      --nov2transport         Disable the v2 encrypted transport protocol
+                              (BIP0324)
       --onion=                Connect to tor hidden services via SOCKS5 proxy
                               (eg. 127.0.0.1:9050)
       --onionpass=            Password for onion proxy server
       --onionuser=            Username for onion proxy server
+      --onlynet=              Only connect to peers found on the specified
+                              network {ipv4, ipv6, onion, i2p, cjdns}
+                              automatically -- Can be specified multiple times
       --profile=              Enable HTTP profiling on given port -- NOTE port
                               must be between 1024 and 65536
       --proxy=                Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)
       --proxypass=            Password for proxy server
       --proxyuser=            Username for proxy server
+      --prune=                Prune old blocks from the database to keep the
+                              block data below the target size in MiB (0 to
+                              disable, minimum 550)
       --regtest               Use the regression test network
       --rejectnonstd          Reject non-standard transactions regardless of
                               the default settings for the active network.
@@ -138,9 +163,24 @@ Application Options:
       --sigcachemaxsize=      The maximum number of entries in the signature
                               verification cache (default: 100000)
       --simnet                Use the simulation test network
+      --stratumdiff=          Share difficulty Stratum miners start with before
+                              it is adjusted to the rate at which they submit
+                              shares (default: 1)
+      --stratumlisten=        Add an interface/port to listen for Stratum
+                              mining connections -- The Stratum server is only
+                              enabled when at least one is specified (default
+                              port: 3333)
+      --stratummaxclients=    Max number of Stratum miners which may be
+                              connected at the same time (default: 50)
       --testnet               Use the test network
+      --torcontrol=           Accept incoming connections over Tor by creating
+                              an onion service through the control port of the
+                              Tor daemon and advertise its address to peers
+                              (eg. 127.0.0.1:9051)
       --torisolation          Enable Tor stream isolation by randomizing user
                               credentials for each connection.
+      --torpassword=          Password for the Tor control port -- NOTE: Cookie
+                              authentication is used when not specified
       --trickleinterval=      Minimum time between attempts to send new
                               inventory to a connected peer (default: 10s)
       --txindex               Maintain a full hash-based transaction index
@@ -149,6 +189,12 @@ Application Options:
       --uacomment=            Comment to add to the user agent -- See BIP 14
                               for more information.
       --upnp                  Use UPnP to map our listening port outside of NAT
+      --utxocachemaxsize=     The maximum size in MiB of the UTXO cache
+                              (default: 250)
+      --utxosnapshot=         Bootstrap a new chain from the UTXO set snapshot
+                              in the given file -- the snapshot must be known
+                              to the network parameters and requires
+                              --nocfilters
   -V, --version               Display version information and exit
       --whitelist=            Add an IP network or IP that will not be banned.
                               (eg. 192.168.1.0/24 or ::1)
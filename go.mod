@@ -1,6 +1,7 @@
 module github.com/mraksoll4/bted
 
 require (
+	github.com/aead/siphash v1.0.1
 	github.com/mraksoll4/bted/btcec/v2 v2.1.3
 	github.com/mraksoll4/bted/bteutil v1.1.5
 	github.com/mraksoll4/bted/chaincfg/chainhash v1.0.2
@@ -21,7 +22,6 @@ require (
 )
 
 require (
-	github.com/aead/siphash v1.0.1 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/kkdai/bstream v0.0.0-20161212061736-f391b8402d23 // indirect
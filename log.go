@@ -18,6 +18,7 @@ import (
 	"github.com/mraksoll4/bted/mempool"
 	"github.com/mraksoll4/bted/mining"
 	"github.com/mraksoll4/bted/mining/cpuminer"
+	"github.com/mraksoll4/bted/mining/stratum"
 	"github.com/mraksoll4/bted/netsync"
 	"github.com/mraksoll4/bted/peer"
 	"github.com/mraksoll4/bted/txscript"
@@ -67,6 +68,7 @@ var (
 	rpcsLog = backendLog.Logger("RPCS")
 	scrpLog = backendLog.Logger("SCRP")
 	srvrLog = backendLog.Logger("SRVR")
+	strmLog = backendLog.Logger("STRM")
 	syncLog = backendLog.Logger("SYNC")
 	txmpLog = backendLog.Logger("TXMP")
 )
@@ -80,6 +82,7 @@ func init() {
 	indexers.UseLogger(indxLog)
 	mining.UseLogger(minrLog)
 	cpuminer.UseLogger(minrLog)
+	stratum.UseLogger(strmLog)
 	peer.UseLogger(peerLog)
 	txscript.UseLogger(scrpLog)
 	netsync.UseLogger(syncLog)
@@ -101,6 +104,7 @@ var subsystemLoggers = map[string]btclog.Logger{
 	"RPCS": rpcsLog,
 	"SCRP": scrpLog,
 	"SRVR": srvrLog,
+	"STRM": strmLog,
 	"SYNC": syncLog,
 	"TXMP": txmpLog,
 }
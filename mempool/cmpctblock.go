@@ -0,0 +1,111 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// ReconstructCmpctBlock attempts to reconstruct the block represented by the
+// passed compact block from the prefilled transactions of the message and the
+// transactions in the main and orphan pools which match its short transaction
+// ids (BIP0152).
+//
+// It returns the block along with the indexes of the transactions which could
+// not be found, in ascending order.  The transactions at those indexes are nil
+// and must be filled in with the transactions requested from the peer which
+// sent the compact block before the block can be processed.  Short ids which
+// match more than one transaction in the pools are treated as missing as well.
+//
+// An error is returned when the message does not describe a valid block, such
+// as when it has no transactions or the same short id is used more than once.
+// The full block should be requested instead in that case.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ReconstructCmpctBlock(msg *wire.MsgCmpctBlock) (*wire.MsgBlock, []uint32, error) {
+	numTxns := msg.TxCount()
+	if numTxns == 0 {
+		return nil, nil, fmt.Errorf("compact block %v has no "+
+			"transactions", msg.Header.BlockHash())
+	}
+
+	// Place the prefilled transactions.  Their indexes are distinct and
+	// within the block since they were validated when decoding the
+	// message.
+	txns := make([]*wire.MsgTx, numTxns)
+	for i := range msg.PrefilledTxs {
+		prefilled := &msg.PrefilledTxs[i]
+		if int(prefilled.Index) >= numTxns || txns[prefilled.Index] != nil {
+			return nil, nil, fmt.Errorf("compact block %v has an "+
+				"invalid prefilled transaction index %d",
+				msg.Header.BlockHash(), prefilled.Index)
+		}
+		txns[prefilled.Index] = prefilled.Tx
+	}
+
+	// The transactions identified by the short ids fill the remaining
+	// indexes in order.
+	slots := make(map[uint64]int, len(msg.ShortIDs))
+	index := 0
+	for _, shortID := range msg.ShortIDs {
+		for txns[index] != nil {
+			index++
+		}
+		if _, ok := slots[shortID]; ok {
+			return nil, nil, fmt.Errorf("compact block %v has "+
+				"duplicate short id %x", msg.Header.BlockHash(),
+				shortID)
+		}
+		slots[shortID] = index
+		index++
+	}
+
+	key := msg.ShortIDKey()
+	found := make(map[int]*wire.MsgTx, len(slots))
+	collisions := make(map[int]struct{})
+	match := func(tx *bteutil.Tx) {
+		index, ok := slots[wire.ShortTxID(&key, tx.WitnessHash())]
+		if !ok {
+			return
+		}
+		if _, ok := found[index]; ok {
+			collisions[index] = struct{}{}
+			return
+		}
+		found[index] = tx.MsgTx()
+	}
+
+	mp.mtx.RLock()
+	for _, txD := range mp.pool {
+		match(txD.Tx)
+	}
+	for _, otx := range mp.orphans {
+		match(otx.tx)
+	}
+	mp.mtx.RUnlock()
+
+	for index, tx := range found {
+		if _, ok := collisions[index]; ok {
+			continue
+		}
+		txns[index] = tx
+	}
+
+	var missing []uint32
+	for i, tx := range txns {
+		if tx == nil {
+			missing = append(missing, uint32(i))
+		}
+	}
+
+	block := &wire.MsgBlock{
+		Header:       msg.Header,
+		Transactions: txns,
+	}
+	return block, missing, nil
+}
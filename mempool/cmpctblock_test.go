@@ -0,0 +1,96 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// TestReconstructCmpctBlock ensures blocks are reconstructed from compact
+// blocks using the transactions in the pool and that the indexes of the
+// transactions which are not in the pool are reported as missing.
+func TestReconstructCmpctBlock(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+
+	// Create a block with a coinbase, two transactions in the pool, and
+	// one which isn't in between them.
+	coinbase := ctx.addCoinbaseTx(3)
+	inPool1 := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	notInPool, err := harness.CreateSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 10000,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	inPool2 := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 2)}, 1, 10000,
+		false, false,
+	)
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{Version: 1},
+		Transactions: []*wire.MsgTx{coinbase.MsgTx(), inPool1.MsgTx(),
+			notInPool.MsgTx(), inPool2.MsgTx()},
+	}
+	msg := wire.NewMsgCmpctBlock(msgBlock, 12345)
+
+	block, missing, err := harness.txPool.ReconstructCmpctBlock(msg)
+	if err != nil {
+		t.Fatalf("ReconstructCmpctBlock: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(missing, []uint32{2}) {
+		t.Fatalf("ReconstructCmpctBlock: unexpected missing indexes %v",
+			missing)
+	}
+	want := []*wire.MsgTx{coinbase.MsgTx(), inPool1.MsgTx(), nil,
+		inPool2.MsgTx()}
+	for i, tx := range block.Transactions {
+		if tx != want[i] {
+			t.Fatalf("ReconstructCmpctBlock: unexpected transaction "+
+				"at index %d", i)
+		}
+	}
+
+	// Once the missing transaction is in the pool the block is complete.
+	_, err = harness.txPool.ProcessTransaction(notInPool, false, false, 0)
+	if err != nil {
+		t.Fatalf("unable to process transaction: %v", err)
+	}
+	block, missing, err = harness.txPool.ReconstructCmpctBlock(msg)
+	if err != nil {
+		t.Fatalf("ReconstructCmpctBlock: unexpected error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("ReconstructCmpctBlock: unexpected missing indexes %v",
+			missing)
+	}
+	if block.Transactions[2] != notInPool.MsgTx() {
+		t.Fatalf("ReconstructCmpctBlock: missing transaction not filled")
+	}
+
+	// Compact blocks which use the same short id more than once and those
+	// without any transactions are rejected.
+	msg.ShortIDs[1] = msg.ShortIDs[0]
+	if _, _, err := harness.txPool.ReconstructCmpctBlock(msg); err == nil {
+		t.Fatal("ReconstructCmpctBlock: duplicate short ids accepted")
+	}
+	empty := &wire.MsgCmpctBlock{Header: msg.Header}
+	if _, _, err := harness.txPool.ReconstructCmpctBlock(empty); err == nil {
+		t.Fatal("ReconstructCmpctBlock: empty compact block accepted")
+	}
+}
@@ -178,6 +178,11 @@ type FeeEstimator struct {
 	// Transactions that have been removed from the bins. This allows us to
 	// revert in case of an orphaned block.
 	dropped []*registeredBlock
+
+	// The bucketed statistics used for smart fee estimation.  They are
+	// not reverted when a block is rolled back.  Instead, the blocks which
+	// replace rolled back blocks are not registered with them again.
+	smart *smartFeeStats
 }
 
 // NewFeeEstimator creates a FeeEstimator for which at most maxRollback blocks
@@ -192,6 +197,7 @@ func NewFeeEstimator(maxRollback, minRegisteredBlocks uint32) *FeeEstimator {
 		maxReplacements:     estimateFeeMaxReplacements,
 		observed:            make(map[chainhash.Hash]*observedTransaction),
 		dropped:             make([]*registeredBlock, 0, maxRollback),
+		smart:               newSmartFeeStats(),
 	}
 }
 
@@ -217,6 +223,17 @@ func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 			mined:    mining.UnminedHeight,
 		}
 	}
+
+	// Only transactions which entered the pool at the current height are
+	// tracked for smart fee estimation since the number of blocks it took
+	// to confirm the others is unknown.
+	if t.Height == ef.lastKnownHeight {
+		size := GetTxVirtualSize(t.Tx)
+		if size > 0 {
+			feeRate := float64(t.Fee) * bytePerKb / float64(size)
+			ef.smart.observeTransaction(hash, t.Height, feeRate)
+		}
+	}
 }
 
 // RegisterBlock informs the fee estimator of a new block to take into account.
@@ -237,6 +254,8 @@ func (ef *FeeEstimator) RegisterBlock(block *bteutil.Block) error {
 	ef.lastKnownHeight = height
 	ef.numBlocksRegistered++
 
+	ef.smart.registerBlock(block, height)
+
 	// Randomly order txs in block.
 	transactions := make(map[*bteutil.Tx]struct{})
 	for _, t := range block.Transactions() {
@@ -587,7 +606,7 @@ func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (BtePerKilobyte, error) {
 // we use a version number. If the version number changes, it does not make
 // sense to try to upgrade a previous version to a new version. Instead, just
 // start fee estimation over.
-const estimateFeeSaveVersion = 1
+const estimateFeeSaveVersion = 2
 
 func deserializeRegisteredBlock(r io.Reader, txs map[uint32]*observedTransaction) (*registeredBlock, error) {
 	var lenTransactions uint32
@@ -680,6 +699,9 @@ func (ef *FeeEstimator) Save() FeeEstimatorState {
 		registered.serialize(w, observed)
 	}
 
+	// Smart fee statistics.
+	ef.smart.serialize(w)
+
 	// Commit the tx and return.
 	return FeeEstimatorState(w.Bytes())
 }
@@ -695,7 +717,7 @@ func RestoreFeeEstimator(data FeeEstimatorState) (*FeeEstimator, error) {
 	if err != nil {
 		return nil, err
 	}
-	if version != estimateFeeSaveVersion {
+	if version != 1 && version != estimateFeeSaveVersion {
 		return nil, fmt.Errorf("Incorrect version: expected %d found %d", estimateFeeSaveVersion, version)
 	}
 
@@ -754,5 +776,19 @@ func RestoreFeeEstimator(data FeeEstimatorState) (*FeeEstimator, error) {
 		}
 	}
 
+	// Read smart fee statistics, which are not included before version 2.
+	if version < 2 {
+		ef.smart = newSmartFeeStats()
+		return ef, nil
+	}
+	ef.smart, err = deserializeSmartFeeStats(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The highest block registered with the statistics is not saved, so
+	// the last registered block is assumed to be the highest one.
+	ef.smart.bestSeenHeight = ef.lastKnownHeight
+
 	return ef, nil
 }
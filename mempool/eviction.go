@@ -0,0 +1,74 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"github.com/mraksoll4/bted/bteutil"
+)
+
+// txPackage houses the total modified fee and virtual size of a transaction in
+// the main pool along with all of its descendants, which would become orphans
+// without it.  The totals are maintained as transactions are added to and
+// removed from the pool so the package with the lowest fee rate can be evicted
+// from the full pool without examining every transaction.
+type txPackage struct {
+	tx   *bteutil.Tx
+	fee  int64
+	size int64
+
+	// index is the position of the package in the eviction queue.
+	index int
+}
+
+// feeRate returns the fee rate of the package in satoshi/kB.
+func (p *txPackage) feeRate() float64 {
+	return float64(p.fee) * 1000 / float64(p.size)
+}
+
+// evictionQueue implements a priority queue of the packages of all transactions
+// in the main pool ordered by ascending fee rate.  Packages keep track of their
+// index, so they can be fixed up or removed once their totals change or the
+// transaction leaves the pool.
+type evictionQueue []*txPackage
+
+// Len returns the number of packages in the queue.  It is part of the
+// heap.Interface implementation.
+func (q evictionQueue) Len() int {
+	return len(q)
+}
+
+// Less returns whether the package with index i has a lower fee rate than the
+// package with index j.  It is part of the heap.Interface implementation.
+func (q evictionQueue) Less(i, j int) bool {
+	return q[i].feeRate() < q[j].feeRate()
+}
+
+// Swap swaps the packages at the passed indices in the queue.  It is part of
+// the heap.Interface implementation.
+func (q evictionQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Push pushes the passed package onto the queue.  It is part of the
+// heap.Interface implementation.
+func (q *evictionQueue) Push(x interface{}) {
+	pkg := x.(*txPackage)
+	pkg.index = len(*q)
+	*q = append(*q, pkg)
+}
+
+// Pop removes the package with the lowest fee rate from the queue and returns
+// it.  It is part of the heap.Interface implementation.
+func (q *evictionQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	pkg := old[n-1]
+	old[n-1] = nil
+	pkg.index = -1
+	*q = old[:n-1]
+	return pkg
+}
@@ -5,6 +5,7 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"fmt"
 	"math"
@@ -39,6 +40,14 @@ const (
 	// scans of the orphan pool to evict expired transactions.
 	orphanExpireScanInterval = time.Minute * 5
 
+	// DefaultTxExpiry is the default maximum amount of time a transaction
+	// is allowed to stay in the main pool without being mined.
+	DefaultTxExpiry = time.Hour * 24 * 14
+
+	// txExpireScanInterval is the minimum amount of time in between scans
+	// of the main pool to remove expired transactions.
+	txExpireScanInterval = time.Minute * 10
+
 	// MaxRBFSequence is the maximum sequence number an input can use to
 	// signal that the transaction spending it can be replaced using the
 	// Replace-By-Fee (RBF) policy.
@@ -48,6 +57,16 @@ const (
 	// can be evicted from the mempool when accepting a transaction
 	// replacement.
 	MaxReplacementEvictions = 100
+
+	// DefaultMaxPoolSize is the default maximum total virtual size in
+	// bytes of all transactions in the main pool.
+	DefaultMaxPoolSize = 300 * 1000 * 1000
+
+	// rollingFeeHalfLife is the amount of time it takes the rolling minimum
+	// fee rate to decay to half of its value once a block has been
+	// connected since it was last raised.  The rate decays faster when the
+	// pool is well below its maximum size.
+	rollingFeeHalfLife = time.Hour * 12
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -55,6 +74,62 @@ const (
 // so that orphans can be identified by which peer first relayed them.
 type Tag uint64
 
+// RemovalReason identifies why a transaction was removed from the main pool.
+type RemovalReason uint8
+
+// These constants define the reasons a transaction can be removed from the
+// main pool for.  Transactions which are removed because they spend outputs of
+// a removed transaction share its reason.
+const (
+	// RemovalReasonBlock indicates the transaction was included in a block
+	// connected to the main chain.
+	RemovalReasonBlock RemovalReason = iota
+
+	// RemovalReasonConflict indicates the transaction spends an output
+	// which was spent by a transaction in a block connected to the main
+	// chain.
+	RemovalReasonConflict
+
+	// RemovalReasonReplaced indicates the transaction was replaced by a
+	// transaction paying a higher fee.
+	RemovalReasonReplaced
+
+	// RemovalReasonReorg indicates the transaction became invalid because
+	// blocks were disconnected from the main chain.
+	RemovalReasonReorg
+
+	// RemovalReasonExpiry indicates the transaction stayed in the pool for
+	// longer than allowed by the policy without being mined.
+	RemovalReasonExpiry
+
+	// RemovalReasonSizeLimit indicates the transaction was evicted to make
+	// room for transactions paying higher fee rates.
+	RemovalReasonSizeLimit
+
+	// RemovalReasonManual indicates the transaction was removed on request
+	// for a reason not covered by the others.
+	RemovalReasonManual
+)
+
+// Map of removal reasons back to their constant names for pretty printing.
+var removalReasonStrings = map[RemovalReason]string{
+	RemovalReasonBlock:     "RemovalReasonBlock",
+	RemovalReasonConflict:  "RemovalReasonConflict",
+	RemovalReasonReplaced:  "RemovalReasonReplaced",
+	RemovalReasonReorg:     "RemovalReasonReorg",
+	RemovalReasonExpiry:    "RemovalReasonExpiry",
+	RemovalReasonSizeLimit: "RemovalReasonSizeLimit",
+	RemovalReasonManual:    "RemovalReasonManual",
+}
+
+// String returns the RemovalReason as a human-readable name.
+func (r RemovalReason) String() string {
+	if s := removalReasonStrings[r]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown RemovalReason (%d)", uint8(r))
+}
+
 // Config is a descriptor containing the memory pool configuration.
 type Config struct {
 	// Policy defines the various mempool configuration options related
@@ -103,6 +178,11 @@ type Config struct {
 	// FeeEstimatator provides a feeEstimator. If it is not nil, the mempool
 	// records all new transactions it observes into the feeEstimator.
 	FeeEstimator *FeeEstimator
+
+	// OnTxRemoved is invoked with the reason when a transaction is removed
+	// from the main pool.  It is optional and is called with the mempool
+	// lock held, so it MUST NOT call back into the pool.
+	OnTxRemoved func(tx *bteutil.Tx, reason RemovalReason)
 }
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -148,6 +228,19 @@ type Policy struct {
 	// transactions using the Replace-By-Fee (RBF) signaling policy into
 	// the mempool.
 	RejectReplacement bool
+
+	// TxExpiry is the maximum amount of time a transaction is allowed to
+	// stay in the main pool.  Expired transactions are removed along with
+	// all of their descendants by a periodic scan.  A value of zero
+	// disables expiry.
+	TxExpiry time.Duration
+
+	// MaxPoolSize is the maximum total virtual size in bytes of all
+	// transactions in the main pool.  Once it is exceeded, the packages of
+	// transactions with the lowest descendant fee rates are evicted and
+	// the minimum fee rate required to enter the pool is raised.  A value
+	// of zero disables the limit.
+	MaxPoolSize int64
 }
 
 // TxDesc is a descriptor containing a transaction in the mempool along with
@@ -175,6 +268,7 @@ type orphanTx struct {
 type TxPool struct {
 	// The following variables must only be used atomically.
 	lastUpdated int64 // last time pool was updated
+	loaded      int32 // whether transactions saved by Dump were loaded
 
 	mtx           sync.RWMutex
 	cfg           Config
@@ -182,6 +276,14 @@ type TxPool struct {
 	orphans       map[chainhash.Hash]*orphanTx
 	orphansByPrev map[wire.OutPoint]map[chainhash.Hash]*bteutil.Tx
 	outpoints     map[wire.OutPoint]*bteutil.Tx
+
+	// poolWtxids and orphanWtxids map the witness hashes of transactions
+	// in the main and orphan pools to their transaction hashes so they
+	// can be looked up by the witness hash they are announced with
+	// (BIP0339).
+	poolWtxids   map[chainhash.Hash]chainhash.Hash
+	orphanWtxids map[chainhash.Hash]chainhash.Hash
+
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
 
@@ -190,6 +292,39 @@ type TxPool struct {
 	// the scan will only run when an orphan is added to the pool as opposed
 	// to on an unconditional timer.
 	nextExpireScan time.Time
+
+	// nextTxExpireScan is the time after which the main pool will be
+	// scanned in order to remove expired transactions.  The scan runs
+	// when a transaction is added to the pool and whenever the owner of
+	// the pool calls MaybeExpireTransactions.
+	nextTxExpireScan time.Time
+
+	// poolSize is the total virtual size of all transactions in the main
+	// pool.
+	poolSize int64
+
+	// packages holds the package of every transaction in the main pool,
+	// which is the transaction along with all of its descendants, and
+	// evictQueue orders them by fee rate so the full pool can be trimmed
+	// efficiently.
+	packages   map[chainhash.Hash]*txPackage
+	evictQueue evictionQueue
+
+	// rollingMinFeeRate is the minimum fee rate in satoshi/kB transactions
+	// must pay to enter the pool after it has been full.  It is raised
+	// above the fee rate of every package evicted to make room and decays
+	// once a block has been connected after rollingFeeHeight, which is the
+	// best chain height when it was last raised.  lastRollingFeeUpdate is
+	// the time it last changed.
+	rollingMinFeeRate    float64
+	rollingFeeHeight     int32
+	lastRollingFeeUpdate time.Time
+
+	// feeDeltas holds the amounts in satoshi the fees of transactions are
+	// modified by as set with PrioritiseTransaction.  Transactions do not
+	// need to be in the pool to have an entry, and entries are only
+	// removed once the transaction has been mined.
+	feeDeltas map[chainhash.Hash]int64
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -233,6 +368,7 @@ func (mp *TxPool) removeOrphan(tx *bteutil.Tx, removeRedeemers bool) {
 	}
 
 	// Remove the transaction from the orphan pool.
+	delete(mp.orphanWtxids, *otx.tx.WitnessHash())
 	delete(mp.orphans, *txHash)
 }
 
@@ -335,6 +471,7 @@ func (mp *TxPool) addOrphan(tx *bteutil.Tx, tag Tag) {
 		tag:        tag,
 		expiration: time.Now().Add(orphanTTL),
 	}
+	mp.orphanWtxids[*tx.WitnessHash()] = *tx.Hash()
 	for _, txIn := range tx.MsgTx().TxIn {
 		if _, exists := mp.orphansByPrev[txIn.PreviousOutPoint]; !exists {
 			mp.orphansByPrev[txIn.PreviousOutPoint] =
@@ -462,18 +599,36 @@ func (mp *TxPool) HaveTransaction(hash *chainhash.Hash) bool {
 	return haveTx
 }
 
+// HaveTransactionByWitnessHash returns whether or not a transaction with the
+// passed witness hash already exists in the main pool or in the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) HaveTransactionByWitnessHash(wtxid *chainhash.Hash) bool {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	_, inPool := mp.poolWtxids[*wtxid]
+	_, inOrphans := mp.orphanWtxids[*wtxid]
+	mp.mtx.RUnlock()
+
+	return inPool || inOrphans
+}
+
 // removeTransaction is the internal function which implements the public
-// RemoveTransaction.  See the comment for RemoveTransaction for more details.
+// RemoveTransactionWithReason.  See the comment for RemoveTransactionWithReason
+// for more details.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) removeTransaction(tx *bteutil.Tx, removeRedeemers bool) {
+func (mp *TxPool) removeTransaction(tx *bteutil.Tx, removeRedeemers bool, reason RemovalReason) {
 	txHash := tx.Hash()
+	if reason == RemovalReasonBlock {
+		delete(mp.feeDeltas, *txHash)
+	}
 	if removeRedeemers {
 		// Remove any transactions which rely on this one.
 		for i := uint32(0); i < uint32(len(tx.MsgTx().TxOut)); i++ {
 			prevOut := wire.OutPoint{Hash: *txHash, Index: i}
 			if txRedeemer, exists := mp.outpoints[prevOut]; exists {
-				mp.removeTransaction(txRedeemer, true)
+				mp.removeTransaction(txRedeemer, true, reason)
 			}
 		}
 	}
@@ -486,12 +641,35 @@ func (mp *TxPool) removeTransaction(tx *bteutil.Tx, removeRedeemers bool) {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
 
+		// Remove the transaction from the packages of its ancestors.
+		// Transactions are only removed without their redeemers when
+		// they have been mined, in which case their ancestors have been
+		// mined before them, so the packages of the ancestors never
+		// retain descendants which no longer depend on them.
+		txSize := GetTxVirtualSize(tx)
+		mp.updateAncestorPackages(tx, -(txDesc.Fee + txDesc.FeeDelta),
+			-txSize)
+		heap.Remove(&mp.evictQueue, mp.packages[*txHash].index)
+		delete(mp.packages, *txHash)
+
 		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
+		delete(mp.poolWtxids, *txDesc.Tx.WitnessHash())
 		delete(mp.pool, *txHash)
+		mp.poolSize -= txSize
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+
+		// Transactions which leave the pool without being mined count
+		// as having failed to confirm for fee estimation.
+		if mp.cfg.FeeEstimator != nil && reason != RemovalReasonBlock {
+			mp.cfg.FeeEstimator.RemoveTransaction(txHash)
+		}
+
+		if mp.cfg.OnTxRemoved != nil {
+			mp.cfg.OnTxRemoved(tx, reason)
+		}
 	}
 }
 
@@ -500,11 +678,24 @@ func (mp *TxPool) removeTransaction(tx *bteutil.Tx, removeRedeemers bool) {
 // removed transaction will also be removed recursively from the mempool, as
 // they would otherwise become orphans.
 //
+// The removal is reported with RemovalReasonManual.  Use
+// RemoveTransactionWithReason to report a different reason, such as when the
+// transaction was mined.
+//
 // This function is safe for concurrent access.
 func (mp *TxPool) RemoveTransaction(tx *bteutil.Tx, removeRedeemers bool) {
+	mp.RemoveTransactionWithReason(tx, removeRedeemers, RemovalReasonManual)
+}
+
+// RemoveTransactionWithReason removes the passed transaction from the mempool
+// for the passed reason.  See RemoveTransaction for the meaning of the
+// removeRedeemers flag.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemoveTransactionWithReason(tx *bteutil.Tx, removeRedeemers bool, reason RemovalReason) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	mp.removeTransaction(tx, removeRedeemers)
+	mp.removeTransaction(tx, removeRedeemers, reason)
 	mp.mtx.Unlock()
 }
 
@@ -521,13 +712,71 @@ func (mp *TxPool) RemoveDoubleSpends(tx *bteutil.Tx) {
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txRedeemer, ok := mp.outpoints[txIn.PreviousOutPoint]; ok {
 			if !txRedeemer.Hash().IsEqual(tx.Hash()) {
-				mp.removeTransaction(txRedeemer, true)
+				mp.removeTransaction(txRedeemer, true,
+					RemovalReasonConflict)
 			}
 		}
 	}
 	mp.mtx.Unlock()
 }
 
+// PrioritiseTransaction adds the passed delta in satoshi to the fee of the
+// transaction with the passed hash for the purposes of selecting transactions
+// for new blocks and the minimum fees required to enter the pool.  The actual
+// fee the transaction pays is not affected.  Deltas accumulate, and the
+// transaction does not need to be in the pool yet.  The delta is kept until the
+// transaction has been mined.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PrioritiseTransaction(txHash *chainhash.Hash, delta int64) {
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	feeDelta := mp.feeDeltas[*txHash] + delta
+	if feeDelta == 0 {
+		delete(mp.feeDeltas, *txHash)
+	} else {
+		mp.feeDeltas[*txHash] = feeDelta
+	}
+
+	// The descriptors of transactions in the pool are handed out to
+	// callers without holding the lock, so replace the descriptor rather
+	// than modifying it.
+	if txD, exists := mp.pool[*txHash]; exists {
+		modified := *txD
+		modified.FeeDelta = feeDelta
+		mp.pool[*txHash] = &modified
+
+		// The modified fee is part of the packages of the transaction
+		// and all of its ancestors.
+		change := feeDelta - txD.FeeDelta
+		pkg := mp.packages[*txHash]
+		pkg.fee += change
+		heap.Fix(&mp.evictQueue, pkg.index)
+		mp.updateAncestorPackages(txD.Tx, change, 0)
+		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+	}
+
+	log.Debugf("Prioritised transaction %v by %d (fee delta %d)", txHash,
+		delta, feeDelta)
+}
+
+// FeeDeltas returns the amounts in satoshi the fees of transactions have been
+// modified by with PrioritiseTransaction keyed by transaction hash.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FeeDeltas() map[chainhash.Hash]int64 {
+	mp.mtx.RLock()
+	feeDeltas := make(map[chainhash.Hash]int64, len(mp.feeDeltas))
+	for hash, delta := range mp.feeDeltas {
+		feeDeltas[hash] = delta
+	}
+	mp.mtx.RUnlock()
+
+	return feeDeltas
+}
+
 // addTransaction adds the passed transaction to the memory pool.  It should
 // not be called directly as it doesn't perform any validation.  This is a
 // helper for maybeAcceptTransaction.
@@ -543,16 +792,42 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *bteutil
 			Height:   height,
 			Fee:      fee,
 			FeePerKB: fee * 1000 / GetTxVirtualSize(tx),
+			FeeDelta: mp.feeDeltas[*tx.Hash()],
 		},
 		StartingPriority: mining.CalcPriority(tx.MsgTx(), utxoView, height),
 	}
 
 	mp.pool[*tx.Hash()] = txD
+	mp.poolWtxids[*tx.WitnessHash()] = *tx.Hash()
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.poolSize += GetTxVirtualSize(tx)
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
+	// Create the package of the transaction and add it to the packages of
+	// its ancestors.  Transactions which are added back to the pool after
+	// the block containing them was disconnected may already have
+	// descendants in the pool, which are part of the package as well.
+	descendants := mp.txDescendants(tx, nil)
+	pkg := &txPackage{tx: tx}
+	pkg.fee, pkg.size = mp.packageTotals(txD, descendants)
+	mp.packages[*tx.Hash()] = pkg
+	heap.Push(&mp.evictQueue, pkg)
+	if len(descendants) == 0 {
+		mp.updateAncestorPackages(tx, pkg.fee, pkg.size)
+	} else {
+		// The descendants may already be part of the packages of some
+		// of the ancestors through other inputs, so the packages of the
+		// ancestors are totaled again instead.
+		for hash, ancestor := range mp.txAncestors(tx, nil) {
+			ancestorPkg := mp.packages[hash]
+			ancestorPkg.fee, ancestorPkg.size = mp.packageTotals(
+				mp.pool[hash], mp.txDescendants(ancestor, nil))
+			heap.Fix(&mp.evictQueue, ancestorPkg.index)
+		}
+	}
+
 	// Add unconfirmed address index entries associated with the transaction
 	// if enabled.
 	if mp.cfg.AddrIndex != nil {
@@ -567,6 +842,189 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *bteutil
 	return txD
 }
 
+// minFeeRate returns the minimum fee rate in satoshi/kB a new transaction must
+// pay in order to be accepted into the pool.  It is zero unless the pool has
+// been full recently.
+//
+// The rolling minimum fee rate is decayed as a side effect, which is why this
+// function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minFeeRate() bteutil.Amount {
+	if mp.rollingMinFeeRate == 0 {
+		return 0
+	}
+
+	// The rate is kept until a block has been connected since it was last
+	// raised because only blocks free up room in the pool without
+	// evicting anything.
+	incrementalFee := mp.cfg.Policy.MinRelayTxFee
+	if mp.cfg.BestHeight() > mp.rollingFeeHeight {
+		halfLife := rollingFeeHalfLife
+		maxPoolSize := mp.cfg.Policy.MaxPoolSize
+		if mp.poolSize < maxPoolSize/4 {
+			halfLife /= 4
+		} else if mp.poolSize < maxPoolSize/2 {
+			halfLife /= 2
+		}
+
+		now := time.Now()
+		elapsed := now.Sub(mp.lastRollingFeeUpdate)
+		mp.rollingMinFeeRate /= math.Pow(2, float64(elapsed)/
+			float64(halfLife))
+		mp.lastRollingFeeUpdate = now
+
+		// Stop requiring a higher fee rate once the decayed rate is no
+		// longer meaningful.
+		if mp.rollingMinFeeRate < float64(incrementalFee)/2 {
+			mp.rollingMinFeeRate = 0
+			return 0
+		}
+	}
+
+	if mp.rollingMinFeeRate < float64(incrementalFee) {
+		return incrementalFee
+	}
+	return bteutil.Amount(mp.rollingMinFeeRate)
+}
+
+// MinFeeRate returns the minimum fee rate in satoshi/kB a new transaction must
+// pay in order to be accepted into the pool due to it having been full
+// recently.  It is zero when the pool has not been full recently, in which
+// case only the minimum relay fee applies.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFeeRate() bteutil.Amount {
+	mp.mtx.Lock()
+	minFeeRate := mp.minFeeRate()
+	mp.mtx.Unlock()
+
+	return minFeeRate
+}
+
+// trimToSize evicts the packages of transactions with the lowest fee rates from
+// the pool until its total virtual size no longer exceeds the maximum allowed by
+// the policy.  A package consists of a transaction along with all of its
+// descendants which would become orphans without it, and its fee rate includes
+// any fee deltas the transactions were prioritised by.
+//
+// The rolling minimum fee rate is raised above the fee rate of each evicted
+// package, increased by the minimum relay fee, so that transactions which
+// would be evicted again right away are not accepted in the mean time.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) trimToSize() {
+	maxPoolSize := mp.cfg.Policy.MaxPoolSize
+	if maxPoolSize == 0 {
+		return
+	}
+
+	var numEvicted int
+	for mp.poolSize > maxPoolSize && len(mp.evictQueue) > 0 {
+		// Removing the transaction removes its package from the queue
+		// and updates the packages of its ancestors.
+		pkg := mp.evictQueue[0]
+		pkgFeeRate := pkg.feeRate()
+		feeRate := pkgFeeRate + float64(mp.cfg.Policy.MinRelayTxFee)
+		if feeRate > mp.rollingMinFeeRate {
+			mp.rollingMinFeeRate = feeRate
+			mp.lastRollingFeeUpdate = time.Now()
+		}
+		mp.rollingFeeHeight = mp.cfg.BestHeight()
+
+		log.Debugf("Evicting transaction %v and its descendants "+
+			"(fee_rate=%.0f sat/kb) since the mempool is full",
+			pkg.tx.Hash(), pkgFeeRate)
+		mp.removeTransaction(pkg.tx, true, RemovalReasonSizeLimit)
+		numEvicted++
+	}
+
+	if numEvicted > 0 {
+		log.Debugf("Evicted %d transaction packages from the full "+
+			"mempool (min fee rate %.0f sat/kb)", numEvicted,
+			mp.rollingMinFeeRate)
+	}
+}
+
+// updateAncestorPackages adds the passed fee and size changes to the packages
+// of all ancestors of the passed transaction in the main pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updateAncestorPackages(tx *bteutil.Tx, fee, size int64) {
+	if fee == 0 && size == 0 {
+		return
+	}
+	for hash := range mp.txAncestors(tx, nil) {
+		pkg := mp.packages[hash]
+		pkg.fee += fee
+		pkg.size += size
+		heap.Fix(&mp.evictQueue, pkg.index)
+	}
+}
+
+// packageTotals returns the total modified fee and virtual size of the passed
+// transaction along with the passed descendants of it.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) packageTotals(txD *TxDesc,
+	descendants map[chainhash.Hash]*bteutil.Tx) (int64, int64) {
+
+	fee := txD.Fee + txD.FeeDelta
+	size := GetTxVirtualSize(txD.Tx)
+	for hash, descendant := range descendants {
+		descendantDesc := mp.pool[hash]
+		fee += descendantDesc.Fee + descendantDesc.FeeDelta
+		size += GetTxVirtualSize(descendant)
+	}
+	return fee, size
+}
+
+// maybeExpireTransactions removes all transactions which have been in the pool
+// for longer than the expiry allowed by the policy along with their descendants
+// when it's time to scan for them.  This is done for efficiency so the scan
+// only happens periodically instead of on every transaction added to the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeExpireTransactions() {
+	expiry := mp.cfg.Policy.TxExpiry
+	now := time.Now()
+	if expiry == 0 || now.Before(mp.nextTxExpireScan) {
+		return
+	}
+
+	origNumTxns := len(mp.pool)
+	cutoff := now.Add(-expiry)
+	for _, txD := range mp.pool {
+		// Descendants are removed too since they can't be mined without
+		// their expired ancestor.  Removing entries while ranging over
+		// the map is safe and they are not visited afterwards.
+		if txD.Added.Before(cutoff) {
+			mp.removeTransaction(txD.Tx, true, RemovalReasonExpiry)
+		}
+	}
+
+	// Set next expiration scan to occur after the scan interval.
+	mp.nextTxExpireScan = now.Add(txExpireScanInterval)
+
+	numTxns := len(mp.pool)
+	if numExpired := origNumTxns - numTxns; numExpired > 0 {
+		log.Debugf("Expired %d %s from the mempool (remaining: %d)",
+			numExpired, pickNoun(numExpired, "transaction",
+				"transactions"), numTxns)
+	}
+}
+
+// MaybeExpireTransactions removes all transactions which have been in the pool
+// for longer than the expiry allowed by the policy along with their descendants
+// when it's time to scan for them.  It should be called periodically, such as
+// whenever a block is connected, so expired transactions are also removed when
+// no new transactions are added to the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MaybeExpireTransactions() {
+	mp.mtx.Lock()
+	mp.maybeExpireTransactions()
+	mp.mtx.Unlock()
+}
+
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
 // If it does, we'll check whether each of those transactions are signaling for
@@ -825,6 +1283,28 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*bteutil.Tx, error)
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTransactionByWitnessHash returns the transaction with the passed
+// witness hash from the transaction pool.  Like FetchTransaction, this only
+// fetches from the main transaction pool and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTransactionByWitnessHash(wtxid *chainhash.Hash) (*bteutil.Tx, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	var txDesc *TxDesc
+	txHash, exists := mp.poolWtxids[*wtxid]
+	if exists {
+		txDesc = mp.pool[txHash]
+	}
+	mp.mtx.RUnlock()
+
+	if txDesc != nil {
+		return txDesc.Tx, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
 // validateReplacement determines whether a transaction is deemed as a valid
 // replacement of all of its conflicts according to the RBF policy. If it is
 // valid, no error is returned. Otherwise, an error is returned indicating what
@@ -919,12 +1399,45 @@ func (mp *TxPool) validateReplacement(tx *bteutil.Tx,
 	return conflicts, nil
 }
 
-// maybeAcceptTransaction is the internal function which implements the public
-// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// MempoolAcceptResult houses the result of checking whether or not a
+// transaction would be accepted into the pool.
+type MempoolAcceptResult struct {
+	// TxFee is the fee paid by the transaction.
+	TxFee bteutil.Amount
+
+	// TxSize is the virtual size of the transaction.
+	TxSize int64
+
+	// MissingParents houses the hashes of the transactions which create
+	// the outputs the transaction spends when they are neither in the main
+	// chain nor the pool.  Such a transaction is an orphan and the other
+	// fields are not set.
+	MissingParents []*chainhash.Hash
+
+	// utxoView houses the outputs spent by the transaction, bestHeight is
+	// the height of the main chain it was checked against and conflicts
+	// are the transactions in the pool it replaces.
+	utxoView   *blockchain.UtxoViewpoint
+	bestHeight int32
+	conflicts  map[chainhash.Hash]*bteutil.Tx
+}
+
+// checkMempoolAcceptance performs all of the checks which determine whether or
+// not the passed transaction would be accepted into the pool without modifying
+// the pool.  The only state it updates is the rate limiter for free
+// transactions when the rate limit flag is set.
+//
+// The optional package transactions are treated as if they were in the pool,
+// which allows checking transactions that spend outputs of other transactions
+// which are not in the pool yet.  The checks of the fee and priority of the
+// transaction are skipped when the check fees flag is not set so transactions
+// can be evaluated by the fee rate of the package they are part of instead.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+func (mp *TxPool) checkMempoolAcceptance(tx *bteutil.Tx, isNew, rateLimit,
+	rejectDupOrphans, checkFees bool,
+	pkgTxns map[chainhash.Hash]*bteutil.Tx) (*MempoolAcceptResult, error) {
+
 	txHash := tx.Hash()
 
 	// If a transaction has witness data, and segwit isn't active yet, If
@@ -933,7 +1446,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	if tx.MsgTx().HasWitness() {
 		segwitActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentSegwit)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
 		if !segwitActive {
@@ -945,7 +1458,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 			}
 			str := fmt.Sprintf("transaction %v has witness data, "+
 				"but segwit isn't active yet%s", txHash, simnetHint)
-			return nil, nil, txRuleError(wire.RejectNonstandard, str)
+			return nil, txRuleError(wire.RejectNonstandard, str)
 		}
 	}
 
@@ -957,7 +1470,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		mp.isOrphanInPool(txHash)) {
 
 		str := fmt.Sprintf("already have transaction %v", txHash)
-		return nil, nil, txRuleError(wire.RejectDuplicate, str)
+		return nil, txRuleError(wire.RejectDuplicate, str)
 	}
 
 	// Perform preliminary sanity checks on the transaction.  This makes
@@ -966,16 +1479,16 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	err := blockchain.CheckTransactionSanity(tx)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
 	// A standalone transaction must not be a coinbase transaction.
 	if blockchain.IsCoinBase(tx) {
 		str := fmt.Sprintf("transaction %v is an individual coinbase",
 			txHash)
-		return nil, nil, txRuleError(wire.RejectInvalid, str)
+		return nil, txRuleError(wire.RejectInvalid, str)
 	}
 
 	// Get the current height of the main chain.  A standalone transaction
@@ -1002,7 +1515,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 			}
 			str := fmt.Sprintf("transaction %v is not standard: %v",
 				txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return nil, txRuleError(rejectCode, str)
 		}
 	}
 
@@ -1017,7 +1530,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	// spend data and prevents double spends.
 	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Fetch all of the unspent transaction outputs referenced by the inputs
@@ -1027,9 +1540,23 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	utxoView, err := mp.fetchInputUtxos(tx)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
+		}
+		return nil, err
+	}
+
+	// Attempt to populate any inputs which are still missing from the
+	// package transactions.
+	for _, txIn := range tx.MsgTx().TxIn {
+		prevOut := &txIn.PreviousOutPoint
+		entry := utxoView.LookupEntry(*prevOut)
+		if entry != nil && !entry.IsSpent() {
+			continue
+		}
+		if pkgTx, exists := pkgTxns[prevOut.Hash]; exists {
+			utxoView.AddTxOut(pkgTx, prevOut.Index,
+				mining.UnminedHeight)
 		}
-		return nil, nil, err
 	}
 
 	// Don't allow the transaction if it exists in the main chain and is
@@ -1039,7 +1566,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		prevOut.Index = uint32(txOutIdx)
 		entry := utxoView.LookupEntry(prevOut)
 		if entry != nil && !entry.IsSpent() {
-			return nil, nil, txRuleError(wire.RejectDuplicate,
+			return nil, txRuleError(wire.RejectDuplicate,
 				"transaction already exists")
 		}
 		utxoView.RemoveEntry(prevOut)
@@ -1061,7 +1588,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		}
 	}
 	if len(missingParents) > 0 {
-		return missingParents, nil, nil
+		return &MempoolAcceptResult{MissingParents: missingParents}, nil
 	}
 
 	// Don't allow the transaction into the mempool unless its sequence
@@ -1070,13 +1597,13 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	sequenceLock, err := mp.cfg.CalcSequenceLock(tx, utxoView)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 	if !blockchain.SequenceLockActive(sequenceLock, nextBlockHeight,
 		medianTimePast) {
-		return nil, nil, txRuleError(wire.RejectNonstandard,
+		return nil, txRuleError(wire.RejectNonstandard,
 			"transaction's sequence locks on inputs not met")
 	}
 
@@ -1088,9 +1615,9 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		utxoView, mp.cfg.ChainParams)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Don't allow transactions with non-standard inputs if the network
@@ -1107,7 +1634,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 			}
 			str := fmt.Sprintf("transaction %v has a non-standard "+
 				"input: %v", txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return nil, txRuleError(rejectCode, str)
 		}
 	}
 
@@ -1124,14 +1651,14 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	sigOpCost, err := blockchain.GetSigOpCost(tx, false, utxoView, true, true)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 	if sigOpCost > mp.cfg.Policy.MaxSigOpCostPerTx {
 		str := fmt.Sprintf("transaction %v sigop cost is too high: %d > %d",
 			txHash, sigOpCost, mp.cfg.Policy.MaxSigOpCostPerTx)
-		return nil, nil, txRuleError(wire.RejectNonstandard, str)
+		return nil, txRuleError(wire.RejectNonstandard, str)
 	}
 
 	// Don't allow transactions with fees too low to get into a mined block.
@@ -1145,34 +1672,57 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	// which is more desirable.  Therefore, as long as the size of the
 	// transaction does not exceed 1000 less than the reserved space for
 	// high-priority transactions, don't require a fee for it.
+	//
+	// The fee used for these checks includes any fee delta the transaction
+	// was prioritised by.
 	serializedSize := GetTxVirtualSize(tx)
+	modifiedFee := txFee + mp.feeDeltas[*txHash]
 	minFee := calcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
-	if serializedSize >= (DefaultBlockPrioritySize-1000) && txFee < minFee {
+	if checkFees && serializedSize >= (DefaultBlockPrioritySize-1000) &&
+		modifiedFee < minFee {
+
 		str := fmt.Sprintf("transaction %v has %d fees which is under "+
-			"the required amount of %d", txHash, txFee,
+			"the required amount of %d", txHash, modifiedFee,
 			minFee)
-		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
 	}
 
 	// Require that free transactions have sufficient priority to be mined
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
 	// are exempted.
-	if isNew && !mp.cfg.Policy.DisableRelayPriority && txFee < minFee {
+	if checkFees && isNew && !mp.cfg.Policy.DisableRelayPriority &&
+		modifiedFee < minFee {
+
 		currentPriority := mining.CalcPriority(tx.MsgTx(), utxoView,
 			nextBlockHeight)
 		if currentPriority <= mining.MinHighPriority {
 			str := fmt.Sprintf("transaction %v has insufficient "+
 				"priority (%g <= %g)", txHash,
 				currentPriority, mining.MinHighPriority)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
+		}
+	}
+
+	// Don't allow transactions which don't pay the minimum fee rate that
+	// is required while the pool is full or has been full recently.
+	// Transactions which are being added back to the memory pool from
+	// blocks that have been disconnected during a reorg are exempted.
+	if checkFees && isNew {
+		poolMinFee := calcMinRequiredTxRelayFee(serializedSize,
+			mp.minFeeRate())
+		if modifiedFee < poolMinFee {
+			str := fmt.Sprintf("transaction %v has %d fees which is "+
+				"under the required mempool minimum of %d",
+				txHash, modifiedFee, poolMinFee)
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
 		}
 	}
 
 	// Free-to-relay transactions are rate limited here to prevent
 	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && txFee < minFee {
+	if rateLimit && modifiedFee < minFee {
 		nowUnix := time.Now().Unix()
 		// Decay passed data with an exponentially decaying ~10 minute
 		// window - matches bitcoind handling.
@@ -1184,7 +1734,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		if mp.pennyTotal >= mp.cfg.Policy.FreeTxRelayLimit*10*1000 {
 			str := fmt.Sprintf("transaction %v has been rejected "+
 				"by the rate limiter due to low fees", txHash)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
 		}
 		oldTotal := mp.pennyTotal
 
@@ -1200,7 +1750,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 	if isReplacement {
 		conflicts, err = mp.validateReplacement(tx, txFee)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 
@@ -1211,26 +1761,73 @@ func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejec
 		mp.cfg.HashCache)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
-	// Now that we've deemed the transaction as valid, we can add it to the
-	// mempool. If it ended up replacing any transactions, we'll remove them
-	// first.
-	for _, conflict := range conflicts {
+	return &MempoolAcceptResult{
+		TxFee:      bteutil.Amount(txFee),
+		TxSize:     serializedSize,
+		utxoView:   utxoView,
+		bestHeight: bestHeight,
+		conflicts:  conflicts,
+	}, nil
+}
+
+// addAcceptedTransaction adds the passed transaction which was deemed valid by
+// checkMempoolAcceptance to the pool.  If it ended up replacing any
+// transactions, they are removed first.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addAcceptedTransaction(tx *bteutil.Tx, result *MempoolAcceptResult) *TxDesc {
+	for _, conflict := range result.conflicts {
 		log.Debugf("Replacing transaction %v (fee_rate=%v sat/kb) "+
 			"with %v (fee_rate=%v sat/kb)\n", conflict.Hash(),
 			mp.pool[*conflict.Hash()].FeePerKB, tx.Hash(),
-			txFee*1000/serializedSize)
+			int64(result.TxFee)*1000/result.TxSize)
 
 		// The conflict set should already include the descendants for
 		// each one, so we don't need to remove the redeemers within
 		// this call as they'll be removed eventually.
-		mp.removeTransaction(conflict, false)
+		mp.removeTransaction(conflict, false, RemovalReasonReplaced)
+	}
+
+	return mp.addTransaction(result.utxoView, tx, result.bestHeight,
+		int64(result.TxFee))
+}
+
+// maybeAcceptTransaction is the internal function which implements the public
+// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
+// more details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+	txHash := tx.Hash()
+	result, err := mp.checkMempoolAcceptance(tx, isNew, rateLimit,
+		rejectDupOrphans, true, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.MissingParents) > 0 {
+		return result.MissingParents, nil, nil
+	}
+
+	// Now that we've deemed the transaction as valid, we can add it to the
+	// mempool.
+	txD := mp.addAcceptedTransaction(tx, result)
+
+	// Remove expired transactions and then make room for the transaction
+	// if the pool has grown beyond its maximum size.  The transaction
+	// itself is rejected when it ends up being evicted because it pays a
+	// lower fee rate than everything else.
+	mp.maybeExpireTransactions()
+	mp.trimToSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v was not accepted since the "+
+			"mempool is full", txHash)
+		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
 	}
-	txD := mp.addTransaction(utxoView, tx, bestHeight, txFee)
 
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
@@ -1258,6 +1855,70 @@ func (mp *TxPool) MaybeAcceptTransaction(tx *bteutil.Tx, isNew, rateLimit bool)
 	return hashes, txD, err
 }
 
+// CheckMempoolAcceptance checks whether or not each of the passed transactions
+// would be accepted into the pool without modifying it.  The transactions are
+// checked in order and may spend outputs of the transactions before them, so
+// chains of transactions can be checked as a whole.
+//
+// A result or the reason the transaction would be rejected is returned for
+// each transaction at the same index.  Transactions which spend outputs of a
+// rejected transaction are rejected too since they would be orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckMempoolAcceptance(txns []*bteutil.Tx) ([]*MempoolAcceptResult, []error) {
+	results := make([]*MempoolAcceptResult, len(txns))
+	errs := make([]error, len(txns))
+
+	// The lock is held for writes since checking the minimum fee rate
+	// decays it.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	pkgTxns := make(map[chainhash.Hash]*bteutil.Tx, len(txns))
+	pkgSpends := make(map[wire.OutPoint]*bteutil.Tx)
+	for i, tx := range txns {
+		// The transactions must not spend the same outputs as the
+		// ones before them since they could not all be accepted.
+		for _, txIn := range tx.MsgTx().TxIn {
+			spender, ok := pkgSpends[txIn.PreviousOutPoint]
+			if !ok {
+				continue
+			}
+			str := fmt.Sprintf("output %v already spent by "+
+				"transaction %v in the package",
+				txIn.PreviousOutPoint, spender.Hash())
+			errs[i] = txRuleError(wire.RejectDuplicate, str)
+			break
+		}
+		if errs[i] != nil {
+			continue
+		}
+
+		result, err := mp.checkMempoolAcceptance(tx, true, false, true,
+			true, pkgTxns)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if len(result.MissingParents) > 0 {
+			str := fmt.Sprintf("orphan transaction %v references "+
+				"outputs of unknown or fully-spent "+
+				"transaction %v", tx.Hash(),
+				result.MissingParents[0])
+			errs[i] = txRuleError(wire.RejectDuplicate, str)
+			continue
+		}
+
+		results[i] = result
+		pkgTxns[*tx.Hash()] = tx
+		for _, txIn := range tx.MsgTx().TxIn {
+			pkgSpends[txIn.PreviousOutPoint] = tx
+		}
+	}
+
+	return results, errs
+}
+
 // processOrphans is the internal function which implements the public
 // ProcessOrphans.  See the comment for ProcessOrphans for more details.
 //
@@ -1517,6 +2178,7 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 			Vsize:            int32(GetTxVirtualSize(tx)),
 			Weight:           int32(blockchain.GetTransactionWeight(tx)),
 			Fee:              bteutil.Amount(desc.Fee).ToBTE(),
+			ModifiedFee:      bteutil.Amount(desc.Fee + desc.FeeDelta).ToBTE(),
 			Time:             desc.Added.Unix(),
 			Height:           int64(desc.Height),
 			StartingPriority: desc.StartingPriority,
@@ -1537,6 +2199,154 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*btcjson.GetRawMempoolVerboseRe
 	return result
 }
 
+// mempoolEntry returns the passed transaction in the main pool as a fully
+// populated btcjson result.  The ancestor and descendant statistics include
+// the transaction itself.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) mempoolEntry(desc *TxDesc) *btcjson.GetMempoolEntryResult {
+	tx := desc.Tx
+	vsize := GetTxVirtualSize(tx)
+	fee := bteutil.Amount(desc.Fee)
+	modifiedFee := bteutil.Amount(desc.Fee + desc.FeeDelta)
+
+	// The ancestor and descendant fees include any fee deltas the
+	// transactions were prioritised by.
+	ancestorSize, ancestorFees := vsize, modifiedFee
+	ancestors := mp.txAncestors(tx, nil)
+	for hash := range ancestors {
+		ancestor := mp.pool[hash]
+		ancestorSize += GetTxVirtualSize(ancestor.Tx)
+		ancestorFees += bteutil.Amount(ancestor.Fee + ancestor.FeeDelta)
+	}
+	descendantSize, descendantFees := vsize, modifiedFee
+	descendants := mp.txDescendants(tx, nil)
+	for hash := range descendants {
+		descendant := mp.pool[hash]
+		descendantSize += GetTxVirtualSize(descendant.Tx)
+		descendantFees += bteutil.Amount(descendant.Fee +
+			descendant.FeeDelta)
+	}
+
+	mpe := &btcjson.GetMempoolEntryResult{
+		VSize:           int32(vsize),
+		Size:            int32(tx.MsgTx().SerializeSize()),
+		Weight:          blockchain.GetTransactionWeight(tx),
+		Fee:             fee.ToBTE(),
+		ModifiedFee:     modifiedFee.ToBTE(),
+		Time:            desc.Added.Unix(),
+		Height:          int64(desc.Height),
+		DescendantCount: int64(len(descendants)) + 1,
+		DescendantSize:  descendantSize,
+		DescendantFees:  float64(descendantFees),
+		AncestorCount:   int64(len(ancestors)) + 1,
+		AncestorSize:    ancestorSize,
+		AncestorFees:    float64(ancestorFees),
+		WTxId:           tx.WitnessHash().String(),
+		Fees: btcjson.MempoolFees{
+			Base:       fee.ToBTE(),
+			Modified:   modifiedFee.ToBTE(),
+			Ancestor:   ancestorFees.ToBTE(),
+			Descendant: descendantFees.ToBTE(),
+		},
+		Depends:           make([]string, 0),
+		SpentBy:           make([]string, 0),
+		BIP125Replaceable: mp.signalsReplacement(tx, nil),
+	}
+
+	// Only the direct parents and children are listed, and each of them
+	// only once even if several of its outputs are involved.
+	seen := make(map[chainhash.Hash]struct{})
+	for _, txIn := range tx.MsgTx().TxIn {
+		hash := txIn.PreviousOutPoint.Hash
+		if _, ok := seen[hash]; ok || !mp.haveTransaction(&hash) {
+			continue
+		}
+		seen[hash] = struct{}{}
+		mpe.Depends = append(mpe.Depends, hash.String())
+	}
+	op := wire.OutPoint{Hash: *tx.Hash()}
+	for i := range tx.MsgTx().TxOut {
+		op.Index = uint32(i)
+		spender, ok := mp.outpoints[op]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[*spender.Hash()]; ok {
+			continue
+		}
+		seen[*spender.Hash()] = struct{}{}
+		mpe.SpentBy = append(mpe.SpentBy, spender.Hash().String())
+	}
+
+	return mpe
+}
+
+// MempoolEntry returns the transaction with the passed hash in the main pool as
+// a fully populated btcjson result.  An error is returned if the transaction is
+// not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolEntry(txHash *chainhash.Hash) (*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+
+	return mp.mempoolEntry(desc), nil
+}
+
+// MempoolAncestors returns all of the unconfirmed ancestors of the transaction
+// with the passed hash in the main pool as fully populated btcjson results
+// keyed by their hashes.  An error is returned if the transaction is not in the
+// main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolAncestors(txHash *chainhash.Hash) (map[string]*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+
+	ancestors := mp.txAncestors(desc.Tx, nil)
+	result := make(map[string]*btcjson.GetMempoolEntryResult, len(ancestors))
+	for hash := range ancestors {
+		result[hash.String()] = mp.mempoolEntry(mp.pool[hash])
+	}
+
+	return result, nil
+}
+
+// MempoolDescendants returns all of the unconfirmed descendants of the
+// transaction with the passed hash in the main pool as fully populated btcjson
+// results keyed by their hashes.  An error is returned if the transaction is
+// not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolDescendants(txHash *chainhash.Hash) (map[string]*btcjson.GetMempoolEntryResult, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	desc, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+
+	descendants := mp.txDescendants(desc.Tx, nil)
+	result := make(map[string]*btcjson.GetMempoolEntryResult, len(descendants))
+	for hash := range descendants {
+		result[hash.String()] = mp.mempoolEntry(mp.pool[hash])
+	}
+
+	return result, nil
+}
+
 // LastUpdated returns the last time a transaction was added to or removed from
 // the main pool.  It does not include the orphan pool.
 //
@@ -1549,11 +2359,16 @@ func (mp *TxPool) LastUpdated() time.Time {
 // transactions until they are mined into a block.
 func New(cfg *Config) *TxPool {
 	return &TxPool{
-		cfg:            *cfg,
-		pool:           make(map[chainhash.Hash]*TxDesc),
-		orphans:        make(map[chainhash.Hash]*orphanTx),
-		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*bteutil.Tx),
-		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
-		outpoints:      make(map[wire.OutPoint]*bteutil.Tx),
+		cfg:              *cfg,
+		pool:             make(map[chainhash.Hash]*TxDesc),
+		orphans:          make(map[chainhash.Hash]*orphanTx),
+		orphansByPrev:    make(map[wire.OutPoint]map[chainhash.Hash]*bteutil.Tx),
+		poolWtxids:       make(map[chainhash.Hash]chainhash.Hash),
+		orphanWtxids:     make(map[chainhash.Hash]chainhash.Hash),
+		nextExpireScan:   time.Now().Add(orphanExpireScanInterval),
+		nextTxExpireScan: time.Now().Add(txExpireScanInterval),
+		outpoints:        make(map[wire.OutPoint]*bteutil.Tx),
+		feeDeltas:        make(map[chainhash.Hash]int64),
+		packages:         make(map[chainhash.Hash]*txPackage),
 	}
 }
@@ -5,6 +5,8 @@
 package mempool
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"reflect"
 	"strings"
@@ -1452,6 +1454,339 @@ func TestAncestorsDescendants(t *testing.T) {
 	}
 }
 
+// testPackages ensures the packages maintained by the pool match the totals of
+// the transactions in the pool along with their descendants and that the
+// eviction queue contains every package in the expected order.
+func testPackages(tc *testContext) {
+	tc.t.Helper()
+
+	txPool := tc.harness.txPool
+	if len(txPool.packages) != len(txPool.pool) ||
+		len(txPool.evictQueue) != len(txPool.pool) {
+
+		tc.t.Fatalf("got %d packages and %d queued packages for %d "+
+			"transactions", len(txPool.packages),
+			len(txPool.evictQueue), len(txPool.pool))
+	}
+	for hash, txD := range txPool.pool {
+		fee := txD.Fee + txD.FeeDelta
+		size := GetTxVirtualSize(txD.Tx)
+		for descendantHash := range txPool.txDescendants(txD.Tx, nil) {
+			descendant := txPool.pool[descendantHash]
+			fee += descendant.Fee + descendant.FeeDelta
+			size += GetTxVirtualSize(descendant.Tx)
+		}
+		pkg := txPool.packages[hash]
+		if pkg.fee != fee || pkg.size != size {
+			tc.t.Fatalf("unexpected package for %v -- got fee %d "+
+				"size %d, want fee %d size %d", hash, pkg.fee,
+				pkg.size, fee, size)
+		}
+		if txPool.evictQueue[pkg.index] != pkg {
+			tc.t.Fatalf("package for %v not at its queue index", hash)
+		}
+	}
+	for i := 1; i < len(txPool.evictQueue); i++ {
+		if txPool.evictQueue.Less(i, (i-1)/2) {
+			tc.t.Fatalf("eviction queue is not a heap at index %d", i)
+		}
+	}
+}
+
+// TestTxPackages ensures the packages of transactions used to evict them from
+// the full pool are kept up to date as transactions are added, prioritised and
+// removed.
+func TestTxPackages(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Create the same chain of unconfirmed transactions as in
+	// TestAncestorsDescendants, where E has two paths to A.
+	a := ctx.addSignedTx(outputs[:1], 2, 1000, false, false)
+	testPackages(ctx)
+	b := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(a, 0)}, 1, 2000,
+		false, false,
+	)
+	c := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(a, 1)}, 1, 3000,
+		false, false,
+	)
+	d := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(c, 0)}, 1, 4000,
+		false, false,
+	)
+	e := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(b, 0), txOutToSpendableOut(d, 0),
+	}, 1, 5000, false, false)
+	testPackages(ctx)
+
+	// Fee deltas change the packages of the transaction and all of its
+	// ancestors.
+	txPool.PrioritiseTransaction(d.Hash(), 100000)
+	testPackages(ctx)
+	txPool.PrioritiseTransaction(d.Hash(), -40000)
+	testPackages(ctx)
+
+	// Removing a transaction along with its redeemers removes them from
+	// the packages of their ancestors.
+	txPool.RemoveTransactionWithReason(c, true,
+		RemovalReasonConflict)
+	testPoolMembership(ctx, d, false, false)
+	testPoolMembership(ctx, e, false, false)
+	testPackages(ctx)
+
+	// Removing mined transactions in block order leaves the packages of
+	// their descendants intact.
+	txPool.RemoveTransactionWithReason(a, false, RemovalReasonBlock)
+	testPackages(ctx)
+	txPool.RemoveTransactionWithReason(b, false, RemovalReasonBlock)
+	testPackages(ctx)
+	if len(txPool.evictQueue) != 0 {
+		t.Fatalf("unexpected %d packages in empty pool",
+			len(txPool.evictQueue))
+	}
+}
+
+// TestTxPackagesReorg ensures the package of a transaction which is added back
+// to the pool after the block containing it was disconnected includes its
+// descendants which are still in the pool, so it remains consistent once they
+// are removed and the transaction is evicted.
+func TestTxPackagesReorg(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	parent := ctx.addSignedTx(outputs[:1], 1, 1000, false, false)
+	child := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 1, 2000,
+		false, false,
+	)
+
+	// Mine the parent and disconnect the block again, which adds the
+	// parent back to the pool while its child remains in it.
+	txPool.RemoveTransactionWithReason(parent, false, RemovalReasonBlock)
+	testPackages(ctx)
+	_, _, err = txPool.MaybeAcceptTransaction(parent, false, false)
+	if err != nil {
+		t.Fatalf("MaybeAcceptTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPackages(ctx)
+
+	// Removing the child and evicting the parent must leave the packages
+	// consistent.
+	txPool.RemoveTransactionWithReason(child, true, RemovalReasonConflict)
+	testPackages(ctx)
+	if pkg := txPool.packages[*parent.Hash()]; pkg.size <= 0 {
+		t.Fatalf("unexpected package size %d", pkg.size)
+	}
+	txPool.cfg.Policy.MaxPoolSize = 1
+	txPool.trimToSize()
+	testPoolMembership(ctx, parent, false, false)
+	testPackages(ctx)
+}
+
+// TestMempoolEntry ensures the mempool entries of transactions report the
+// expected statistics about their unconfirmed ancestors and descendants.
+func TestMempoolEntry(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Create a chain of transactions where B spends both outputs of A and
+	// C spends B.  Only A signals replacement, which B and C inherit.
+	a := ctx.addSignedTx(outputs[:1], 2, 1000, true, false)
+	b := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(a, 0), txOutToSpendableOut(a, 1),
+	}, 1, 2000, false, false)
+	c := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(b, 0)}, 1, 3000, false,
+		false,
+	)
+
+	entry, err := txPool.MempoolEntry(b.Hash())
+	if err != nil {
+		t.Fatalf("unable to fetch mempool entry: %v", err)
+	}
+	vsizeA, vsizeB := GetTxVirtualSize(a), GetTxVirtualSize(b)
+	vsizeC := GetTxVirtualSize(c)
+	if entry.AncestorCount != 2 || entry.AncestorSize != vsizeA+vsizeB ||
+		entry.AncestorFees != 3000 {
+
+		t.Fatalf("unexpected ancestor statistics -- got count %d, "+
+			"size %d, fees %v", entry.AncestorCount,
+			entry.AncestorSize, entry.AncestorFees)
+	}
+	if entry.DescendantCount != 2 ||
+		entry.DescendantSize != vsizeB+vsizeC ||
+		entry.DescendantFees != 5000 {
+
+		t.Fatalf("unexpected descendant statistics -- got count %d, "+
+			"size %d, fees %v", entry.DescendantCount,
+			entry.DescendantSize, entry.DescendantFees)
+	}
+	if len(entry.Depends) != 1 || entry.Depends[0] != a.Hash().String() {
+		t.Fatalf("unexpected depends %v", entry.Depends)
+	}
+	if len(entry.SpentBy) != 1 || entry.SpentBy[0] != c.Hash().String() {
+		t.Fatalf("unexpected spent by %v", entry.SpentBy)
+	}
+	if !entry.BIP125Replaceable {
+		t.Fatalf("transaction does not inherit replaceability")
+	}
+
+	// The ancestors of C and the descendants of A are the remaining
+	// transactions of the chain.
+	ancestors, err := txPool.MempoolAncestors(c.Hash())
+	if err != nil {
+		t.Fatalf("unable to fetch mempool ancestors: %v", err)
+	}
+	descendants, err := txPool.MempoolDescendants(a.Hash())
+	if err != nil {
+		t.Fatalf("unable to fetch mempool descendants: %v", err)
+	}
+	if len(ancestors) != 2 || ancestors[a.Hash().String()] == nil ||
+		ancestors[b.Hash().String()] == nil {
+
+		t.Fatalf("unexpected ancestors %v", ancestors)
+	}
+	if len(descendants) != 2 || descendants[b.Hash().String()] == nil ||
+		descendants[c.Hash().String()] == nil {
+
+		t.Fatalf("unexpected descendants %v", descendants)
+	}
+
+	// Transactions which are not in the pool must be rejected.
+	if _, err := txPool.MempoolEntry(&chainhash.Hash{}); err == nil {
+		t.Fatalf("fetched mempool entry for unknown transaction")
+	}
+}
+
+// TestPrioritiseTransaction ensures fee deltas are applied to transactions
+// whether or not they are in the pool yet, are taken into account when checking
+// the minimum fees, are saved along with the pool and are removed once the
+// transaction is mined.
+func TestPrioritiseTransaction(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Require a fee rate of 10 satoshi per byte as if the pool had been
+	// full recently.  The coinbase is added first since doing so connects
+	// a block which would decay the rate.
+	coinbase := ctx.addCoinbaseTx(2)
+	txPool.rollingMinFeeRate = 10000
+	txPool.rollingFeeHeight = harness.chain.BestHeight()
+
+	// A transaction paying a sufficient fee is prioritised once it is in
+	// the pool.
+	a := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	txPool.PrioritiseTransaction(a.Hash(), 5000)
+	txPool.PrioritiseTransaction(a.Hash(), 1000)
+	entry, err := txPool.MempoolEntry(a.Hash())
+	if err != nil {
+		t.Fatalf("unable to fetch mempool entry: %v", err)
+	}
+	if entry.ModifiedFee != bteutil.Amount(16000).ToBTE() ||
+		entry.Fee != bteutil.Amount(10000).ToBTE() {
+
+		t.Fatalf("unexpected fees -- got fee %v, modified fee %v",
+			entry.Fee, entry.ModifiedFee)
+	}
+	verbose := txPool.RawMempoolVerbose()[a.Hash().String()]
+	if verbose.ModifiedFee != bteutil.Amount(16000).ToBTE() {
+		t.Fatalf("unexpected verbose modified fee %v",
+			verbose.ModifiedFee)
+	}
+
+	// A transaction which does not pay the minimum fee must be rejected
+	// unless it was prioritised before being seen.
+	b, err := harness.CreateSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 0,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessTransaction(b, false, false, 0)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("unexpected result for unprioritised transaction: %v",
+			err)
+	}
+	txPool.PrioritiseTransaction(b.Hash(), 10000)
+	if _, err := txPool.ProcessTransaction(b, false, false, 0); err != nil {
+		t.Fatalf("unable to accept prioritised transaction: %v", err)
+	}
+	testPoolMembership(ctx, b, false, true)
+	for _, desc := range txPool.MiningDescs() {
+		want := int64(6000)
+		if desc.Tx.Hash().IsEqual(b.Hash()) {
+			want = 10000
+		}
+		if desc.FeeDelta != want {
+			t.Fatalf("unexpected fee delta for %v -- got %d, "+
+				"want %d", desc.Tx.Hash(), desc.FeeDelta, want)
+		}
+	}
+
+	// The deltas must be loaded along with the transactions.
+	var buf bytes.Buffer
+	if _, err := txPool.Dump(&buf); err != nil {
+		t.Fatalf("unexpected error dumping pool: %v", err)
+	}
+	loaded := New(&txPool.cfg)
+	loaded.rollingMinFeeRate = 10000
+	loaded.rollingFeeHeight = harness.chain.BestHeight()
+	numAccepted, _, err := loaded.Load(&buf, nil)
+	if err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	if numAccepted != 2 {
+		t.Fatalf("unexpected number of loaded transactions -- got %d, "+
+			"want 2", numAccepted)
+	}
+	if !reflect.DeepEqual(loaded.FeeDeltas(), txPool.FeeDeltas()) {
+		t.Fatalf("unexpected loaded fee deltas -- got %v, want %v",
+			loaded.FeeDeltas(), txPool.FeeDeltas())
+	}
+
+	// Deltas are kept when transactions leave the pool for other reasons
+	// than being mined.
+	txPool.RemoveTransaction(b, false)
+	txPool.RemoveTransactionWithReason(a, false, RemovalReasonBlock)
+	feeDeltas := txPool.FeeDeltas()
+	if _, ok := feeDeltas[*a.Hash()]; ok || len(feeDeltas) != 1 ||
+		feeDeltas[*b.Hash()] != 10000 {
+
+		t.Fatalf("unexpected fee deltas after removal: %v", feeDeltas)
+	}
+}
+
 // TestRBF tests the different cases required for a transaction to properly
 // replace its conflicts given that they all signal replacement.
 func TestRBF(t *testing.T) {
@@ -1851,3 +2186,477 @@ func TestRBF(t *testing.T) {
 		}
 	}
 }
+
+// TestMaxPoolSize ensures the transactions with the lowest descendant fee rates
+// are evicted when the pool exceeds its maximum size and that the resulting
+// minimum fee rate is enforced until it decays.
+func TestMaxPoolSize(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Fill the pool with a transaction paying a low fee along with a
+	// parent and child which pay a higher fee rate as a package.  The
+	// pool is then limited to its current size with some room to spare
+	// since signatures can vary in size by a byte.
+	coinbase := ctx.addCoinbaseTx(4)
+	lowFeeTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	parentTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 5000,
+		false, false,
+	)
+	childTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(parentTx, 0)}, 1, 30000,
+		false, false,
+	)
+	if txPool.MinFeeRate() != 0 {
+		t.Fatalf("unexpected min fee rate %v before the pool was full",
+			txPool.MinFeeRate())
+	}
+	txPool.cfg.Policy.MaxPoolSize = txPool.poolSize + 10
+
+	// Adding another transaction must evict the low fee transaction
+	// instead of the parent since its child pays for it.
+	highFeeTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 2)}, 1, 40000,
+		false, false,
+	)
+	testPoolMembership(ctx, lowFeeTx, false, false)
+	testPoolMembership(ctx, parentTx, false, true)
+	testPoolMembership(ctx, childTx, false, true)
+	testPoolMembership(ctx, highFeeTx, false, true)
+	if txPool.poolSize > txPool.cfg.Policy.MaxPoolSize {
+		t.Fatalf("pool size %d exceeds the maximum of %d",
+			txPool.poolSize, txPool.cfg.Policy.MaxPoolSize)
+	}
+
+	// The minimum fee rate must now exceed the fee rate of the evicted
+	// transaction by the minimum relay fee.
+	lowFeeRate := 10000 * 1000 / GetTxVirtualSize(lowFeeTx)
+	wantMinFeeRate := bteutil.Amount(lowFeeRate) +
+		txPool.cfg.Policy.MinRelayTxFee
+	if txPool.MinFeeRate() < wantMinFeeRate {
+		t.Fatalf("unexpected min fee rate -- got %v, want at least %v",
+			txPool.MinFeeRate(), wantMinFeeRate)
+	}
+
+	// A new transaction paying the same fee as the evicted one must be
+	// rejected due to the minimum fee rate.
+	rejectedTx, err := harness.CreateSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 3)}, 1, 10000,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessTransaction(rejectedTx, false, false, 0)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("unexpected error for transaction below the min fee "+
+			"rate: %v", err)
+	}
+	testPoolMembership(ctx, rejectedTx, false, false)
+
+	// The minimum fee rate must not decay until a block is connected and
+	// then vanish once enough time has passed.
+	txPool.lastRollingFeeUpdate = time.Now().Add(-rollingFeeHalfLife * 10)
+	if txPool.MinFeeRate() < wantMinFeeRate {
+		t.Fatalf("min fee rate decayed before a block was connected")
+	}
+	harness.chain.SetHeight(harness.chain.BestHeight() + 1)
+	if txPool.MinFeeRate() != 0 {
+		t.Fatalf("unexpected min fee rate %v after decaying",
+			txPool.MinFeeRate())
+	}
+}
+
+// TestMaxPoolSizeFeeDelta ensures the fee deltas transactions were prioritised
+// by are taken into account when choosing which transactions to evict from the
+// full pool.
+func TestMaxPoolSizeFeeDelta(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Fill the pool with two transactions where the one paying the lower
+	// fee has been prioritised above the other one.
+	coinbase := ctx.addCoinbaseTx(3)
+	prioritisedTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	txPool.PrioritiseTransaction(prioritisedTx.Hash(), 100000)
+	otherTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 20000,
+		false, false,
+	)
+	txPool.cfg.Policy.MaxPoolSize = txPool.poolSize + 10
+
+	// Adding another transaction must evict the transaction which pays
+	// the lower fee once the fee delta is included.
+	highFeeTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 2)}, 1, 40000,
+		false, false,
+	)
+	testPoolMembership(ctx, prioritisedTx, false, true)
+	testPoolMembership(ctx, otherTx, false, false)
+	testPoolMembership(ctx, highFeeTx, false, true)
+}
+
+// TestDumpLoad ensures the transactions in the pool can be saved and loaded
+// into a new pool again along with the time they were added.
+func TestDumpLoad(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+
+	// Create a chain of transactions along with an unrelated one so the
+	// children can only be loaded after their parents.
+	coinbase := ctx.addCoinbaseTx(2)
+	txns := []*bteutil.Tx{ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)}
+	for i := 0; i < 3; i++ {
+		txns = append(txns, ctx.addSignedTx(
+			[]spendableOutput{txOutToSpendableOut(txns[i], 0)}, 1,
+			10000, false, false,
+		))
+	}
+	txns = append(txns, ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 10000,
+		false, false,
+	))
+	added := time.Unix(time.Now().Unix()-3600, 0)
+	for _, txD := range harness.txPool.pool {
+		txD.Added = added
+	}
+
+	var buf bytes.Buffer
+	numDumped, err := harness.txPool.Dump(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error dumping pool: %v", err)
+	}
+	if numDumped != len(txns) {
+		t.Fatalf("unexpected number of dumped transactions -- got %d, "+
+			"want %d", numDumped, len(txns))
+	}
+
+	// Load the transactions into a new pool backed by the same chain.
+	txPool := New(&harness.txPool.cfg)
+	if txPool.IsLoaded() {
+		t.Fatalf("new pool claims to be loaded")
+	}
+	numAccepted, numSkipped, err := txPool.Load(&buf, nil)
+	if err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	if numAccepted != len(txns) || numSkipped != 0 {
+		t.Fatalf("unexpected load result -- got %d accepted and %d "+
+			"skipped, want %d accepted", numAccepted, numSkipped,
+			len(txns))
+	}
+	for _, tx := range txns {
+		txD, ok := txPool.pool[*tx.Hash()]
+		if !ok {
+			t.Fatalf("transaction %v was not loaded", tx.Hash())
+		}
+		if !txD.Added.Equal(added) {
+			t.Fatalf("unexpected time added for %v -- got %v, "+
+				"want %v", tx.Hash(), txD.Added, added)
+		}
+	}
+
+	// Loading the same transactions again must skip all of them.
+	if _, err := harness.txPool.Dump(&buf); err != nil {
+		t.Fatalf("unexpected error dumping pool: %v", err)
+	}
+	numAccepted, numSkipped, err = txPool.Load(&buf, nil)
+	if err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	if numAccepted != 0 || numSkipped != len(txns) {
+		t.Fatalf("unexpected reload result -- got %d accepted and %d "+
+			"skipped, want %d skipped", numAccepted, numSkipped,
+			len(txns))
+	}
+
+	// Transactions which come before their parents must be held as
+	// orphans and accepted once their parents have been loaded.
+	buf.Reset()
+	var scratch [8]byte
+	binary.BigEndian.PutUint32(scratch[:4], dumpVersion)
+	buf.Write(scratch[:4])
+	binary.BigEndian.PutUint64(scratch[:], 0)
+	buf.Write(scratch[:])
+	binary.BigEndian.PutUint64(scratch[:], uint64(len(txns)))
+	buf.Write(scratch[:])
+	for i := len(txns) - 1; i >= 0; i-- {
+		binary.BigEndian.PutUint64(scratch[:], uint64(added.Unix()))
+		buf.Write(scratch[:])
+		if err := txns[i].MsgTx().Serialize(&buf); err != nil {
+			t.Fatalf("unable to serialize transaction: %v", err)
+		}
+	}
+	txPool = New(&harness.txPool.cfg)
+	numAccepted, numSkipped, err = txPool.Load(&buf, nil)
+	if err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	if numAccepted != len(txns) || numSkipped != 0 {
+		t.Fatalf("unexpected load result for reversed transactions "+
+			"-- got %d accepted and %d skipped, want %d accepted",
+			numAccepted, numSkipped, len(txns))
+	}
+	for _, tx := range txns {
+		txD, ok := txPool.pool[*tx.Hash()]
+		if !ok {
+			t.Fatalf("transaction %v was not loaded", tx.Hash())
+		}
+		if !txD.Added.Equal(added) {
+			t.Fatalf("unexpected time added for %v -- got %v, "+
+				"want %v", tx.Hash(), txD.Added, added)
+		}
+	}
+}
+
+// TestTxExpiry ensures transactions which stay in the pool for longer than
+// allowed are removed along with their descendants and that the removals are
+// reported with the expiry reason.
+func TestTxExpiry(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	removed := make(map[chainhash.Hash]RemovalReason)
+	txPool.cfg.OnTxRemoved = func(tx *bteutil.Tx, reason RemovalReason) {
+		removed[*tx.Hash()] = reason
+	}
+	txPool.cfg.Policy.TxExpiry = time.Hour
+
+	coinbase := ctx.addCoinbaseTx(3)
+	parentTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	childTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(parentTx, 0)}, 1, 10000,
+		false, false,
+	)
+	recentTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 10000,
+		false, false,
+	)
+
+	// Only the parent is old enough to expire, but the child must be
+	// removed along with it.  Nothing happens until the next scan is due.
+	txPool.pool[*parentTx.Hash()].Added = time.Now().Add(-2 * time.Hour)
+	newTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 2)}, 1, 10000,
+		false, false,
+	)
+	testPoolMembership(ctx, parentTx, false, true)
+	if len(removed) != 0 {
+		t.Fatalf("transactions were removed before the scan was due")
+	}
+
+	// The periodic scan removes them without adding a transaction.
+	txPool.nextTxExpireScan = time.Now().Add(-time.Second)
+	txPool.MaybeExpireTransactions()
+	testPoolMembership(ctx, parentTx, false, false)
+	testPoolMembership(ctx, childTx, false, false)
+	testPoolMembership(ctx, recentTx, false, true)
+	testPoolMembership(ctx, newTx, false, true)
+	for _, tx := range []*bteutil.Tx{parentTx, childTx} {
+		reason, ok := removed[*tx.Hash()]
+		if !ok || reason != RemovalReasonExpiry {
+			t.Fatalf("unexpected removal reason for %v -- got %v "+
+				"(removed %v), want %v", tx.Hash(), reason, ok,
+				RemovalReasonExpiry)
+		}
+	}
+	if len(removed) != 2 {
+		t.Fatalf("unexpected number of removed transactions -- got "+
+			"%d, want 2", len(removed))
+	}
+	if !txPool.nextTxExpireScan.After(time.Now()) {
+		t.Fatalf("next expiry scan was not scheduled")
+	}
+}
+
+// TestCheckMempoolAcceptance ensures chains of transactions can be checked for
+// acceptance without modifying the pool and that transactions which could not
+// be accepted are rejected.
+func TestCheckMempoolAcceptance(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// createTx creates a transaction spending the passed output with the
+	// passed fee without adding it to the pool.
+	createTx := func(input spendableOutput, fee bteutil.Amount) *bteutil.Tx {
+		t.Helper()
+		tx, err := harness.CreateSignedTx([]spendableOutput{input}, 1,
+			fee, false)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		return tx
+	}
+
+	// Create a chain of transactions where the first one spends the
+	// output of a transaction already in the pool, a transaction which
+	// double spends the second one and an orphan.
+	coinbase := ctx.addCoinbaseTx(2)
+	poolTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 10000,
+		false, false,
+	)
+	tx1 := createTx(txOutToSpendableOut(poolTx, 0), 10000)
+	tx2 := createTx(txOutToSpendableOut(tx1, 0), 20000)
+	tx3 := createTx(txOutToSpendableOut(tx2, 0), 30000)
+	doubleSpendTx := createTx(txOutToSpendableOut(tx1, 0), 40000)
+	orphanTx := createTx(spendableOutput{
+		outPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		amount:   bteutil.Amount(5000000000),
+	}, 10000)
+
+	txns := []*bteutil.Tx{tx1, tx2, tx3, doubleSpendTx, orphanTx}
+	results, errs := txPool.CheckMempoolAcceptance(txns)
+	for i, tx := range txns[:3] {
+		if errs[i] != nil {
+			t.Fatalf("transaction %d was rejected: %v", i, errs[i])
+		}
+		wantFee := bteutil.Amount((i + 1) * 10000)
+		if results[i].TxFee != wantFee {
+			t.Fatalf("unexpected fee for transaction %d -- got %v, "+
+				"want %v", i, results[i].TxFee, wantFee)
+		}
+		if results[i].TxSize != GetTxVirtualSize(tx) {
+			t.Fatalf("unexpected size for transaction %d -- got "+
+				"%d, want %d", i, results[i].TxSize,
+				GetTxVirtualSize(tx))
+		}
+	}
+	for i := 3; i < len(txns); i++ {
+		if results[i] != nil || errs[i] == nil {
+			t.Fatalf("transaction %d was not rejected", i)
+		}
+		if _, ok := errs[i].(RuleError); !ok {
+			t.Fatalf("unexpected error type for transaction %d: "+
+				"%T", i, errs[i])
+		}
+	}
+
+	// None of the transactions may have been added to the pool.
+	if txPool.Count() != 1 {
+		t.Fatalf("unexpected pool size %d after checking transactions",
+			txPool.Count())
+	}
+	for _, tx := range txns {
+		testPoolMembership(ctx, tx, false, false)
+	}
+
+	// The transactions in the pool must be rejected as duplicates.
+	_, errs = txPool.CheckMempoolAcceptance([]*bteutil.Tx{poolTx})
+	if code, _ := extractRejectCode(errs[0]); code != wire.RejectDuplicate {
+		t.Fatalf("unexpected error for transaction in the pool: %v",
+			errs[0])
+	}
+}
+
+// TestWitnessHashLookup ensures transactions in the main and orphan pools can
+// be looked up by their witness hash and that they can no longer be found
+// once they were removed.
+func TestWitnessHashLookup(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	chainedTxns, err := harness.CreateTxChain(spendableOuts[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	parent, child := chainedTxns[0], chainedTxns[1]
+
+	// Orphans are known by their witness hash, but are not fetched.
+	_, err = harness.txPool.ProcessTransaction(child, true, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid orphan "+
+			"%v", err)
+	}
+	if !harness.txPool.HaveTransactionByWitnessHash(child.WitnessHash()) {
+		t.Fatal("HaveTransactionByWitnessHash: orphan is not known")
+	}
+	_, err = harness.txPool.FetchTransactionByWitnessHash(child.WitnessHash())
+	if err == nil {
+		t.Fatal("FetchTransactionByWitnessHash: orphan was fetched")
+	}
+
+	// Both transactions can be fetched once the orphan was accepted.
+	_, err = harness.txPool.ProcessTransaction(parent, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid "+
+			"transaction %v", err)
+	}
+	for _, tx := range chainedTxns {
+		wtxid := tx.WitnessHash()
+		if !harness.txPool.HaveTransactionByWitnessHash(wtxid) {
+			t.Fatalf("HaveTransactionByWitnessHash: transaction "+
+				"%v is not known", tx.Hash())
+		}
+		fetched, err := harness.txPool.FetchTransactionByWitnessHash(wtxid)
+		if err != nil {
+			t.Fatalf("FetchTransactionByWitnessHash: unexpected "+
+				"error %v", err)
+		}
+		if !fetched.Hash().IsEqual(tx.Hash()) {
+			t.Fatalf("FetchTransactionByWitnessHash: got %v, "+
+				"want %v", fetched.Hash(), tx.Hash())
+		}
+	}
+
+	// Neither transaction is found after they were removed.
+	harness.txPool.RemoveTransactionWithReason(parent, true,
+		RemovalReasonBlock)
+	for _, tx := range chainedTxns {
+		wtxid := tx.WitnessHash()
+		if harness.txPool.HaveTransactionByWitnessHash(wtxid) {
+			t.Fatalf("HaveTransactionByWitnessHash: removed "+
+				"transaction %v is known", tx.Hash())
+		}
+		_, err := harness.txPool.FetchTransactionByWitnessHash(wtxid)
+		if err == nil {
+			t.Fatalf("FetchTransactionByWitnessHash: removed "+
+				"transaction %v was fetched", tx.Hash())
+		}
+	}
+}
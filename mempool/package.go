@@ -0,0 +1,330 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/wire"
+)
+
+const (
+	// MaxPackageCount is the maximum number of transactions a package may
+	// contain.
+	MaxPackageCount = 25
+
+	// MaxPackageWeight is the maximum total weight of the transactions a
+	// package may contain.  It is slightly more than the maximum weight of
+	// a standard transaction so a package can always hold one along with
+	// a small child spending it.
+	MaxPackageWeight = 404000
+)
+
+// PackageTxResult houses the outcome of accepting a single transaction of a
+// package into the pool with ProcessPackage.
+type PackageTxResult struct {
+	// TxDesc is the descriptor of the transaction in the pool.
+	TxDesc *TxDesc
+
+	// FeePerKB is the fee rate in satoshi/kB the transaction was accepted
+	// with.  It is the fee rate of all transactions which were evaluated
+	// together when PackageFeeRate is set.
+	FeePerKB int64
+
+	// PackageFeeRate indicates the transaction did not pay a sufficient
+	// fee on its own and was accepted because of the fee rate of the
+	// transactions evaluated together with it instead.
+	PackageFeeRate bool
+
+	// AlreadyInPool indicates the transaction was already in the pool
+	// before the package was processed.
+	AlreadyInPool bool
+}
+
+// PackageAcceptResult houses the outcome of accepting a package of transactions
+// into the pool with ProcessPackage.
+type PackageAcceptResult struct {
+	// TxResults holds the result for each transaction in the same order
+	// as the package.
+	TxResults []PackageTxResult
+
+	// AcceptedTxs holds the descriptors of all transactions which were
+	// newly added to the pool, including orphans which were accepted as a
+	// result.  Parents always come before the transactions which spend
+	// them.
+	AcceptedTxs []*TxDesc
+}
+
+// checkPackageTopology ensures the passed transactions form a child-with-parents
+// package.  That is, the last transaction is the child and every other
+// transaction is a parent it spends directly.  The parents must be sorted such
+// that no transaction spends an output of a transaction after it, and the
+// transactions must not spend the same outputs.
+func checkPackageTopology(txns []*bteutil.Tx) error {
+	if len(txns) == 0 {
+		return txRuleError(wire.RejectInvalid, "package is empty")
+	}
+	if len(txns) > MaxPackageCount {
+		str := fmt.Sprintf("package contains %d transactions which is "+
+			"more than the maximum of %d", len(txns),
+			MaxPackageCount)
+		return txRuleError(wire.RejectInvalid, str)
+	}
+
+	var weight int64
+	pkgIndex := make(map[chainhash.Hash]int, len(txns))
+	for i, tx := range txns {
+		if _, ok := pkgIndex[*tx.Hash()]; ok {
+			str := fmt.Sprintf("package contains transaction %v "+
+				"more than once", tx.Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+		pkgIndex[*tx.Hash()] = i
+		weight += blockchain.GetTransactionWeight(tx)
+	}
+	if weight > MaxPackageWeight {
+		str := fmt.Sprintf("package weight of %d is larger than the "+
+			"maximum of %d", weight, MaxPackageWeight)
+		return txRuleError(wire.RejectInvalid, str)
+	}
+
+	spent := make(map[wire.OutPoint]*bteutil.Tx)
+	for i, tx := range txns {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if spender, ok := spent[prevOut]; ok {
+				str := fmt.Sprintf("transactions %v and %v in "+
+					"the package both spend output %v",
+					spender.Hash(), tx.Hash(), prevOut)
+				return txRuleError(wire.RejectInvalid, str)
+			}
+			spent[prevOut] = tx
+
+			parentIndex, ok := pkgIndex[prevOut.Hash]
+			if ok && parentIndex > i {
+				str := fmt.Sprintf("transaction %v in the "+
+					"package spends transaction %v which "+
+					"comes after it", tx.Hash(),
+					prevOut.Hash)
+				return txRuleError(wire.RejectInvalid, str)
+			}
+		}
+	}
+
+	// Every transaction other than the child must be one of its parents.
+	child := txns[len(txns)-1]
+	parents := make(map[chainhash.Hash]struct{}, len(child.MsgTx().TxIn))
+	for _, txIn := range child.MsgTx().TxIn {
+		parents[txIn.PreviousOutPoint.Hash] = struct{}{}
+	}
+	for _, tx := range txns[:len(txns)-1] {
+		if _, ok := parents[*tx.Hash()]; !ok {
+			str := fmt.Sprintf("package is not a child with its "+
+				"parents since transaction %v is not spent by "+
+				"the last transaction %v", tx.Hash(),
+				child.Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+	}
+
+	return nil
+}
+
+// ProcessPackage attempts to accept a child-with-parents package of
+// transactions into the pool.  See checkPackageTopology for the requirements
+// the package must meet.
+//
+// Each transaction is first evaluated on its own.  The transactions which don't
+// pay a sufficient fee on their own, along with any transactions in the package
+// which spend them, are then evaluated together by the fee rate of their
+// combined fees and size.  This allows a child paying a high fee to bump a
+// parent which does not meet the minimum fee rate, which is commonly the case
+// for transactions which are signed long before they are broadcast.
+//
+// Either all transactions of the package are accepted or none of them are.
+// Transactions which are already in the pool are left alone.  Packages whose
+// transactions would replace transactions in the pool are not supported.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessPackage(txns []*bteutil.Tx) (*PackageAcceptResult, error) {
+	log.Tracef("Processing package of %d transactions", len(txns))
+
+	if err := checkPackageTopology(txns); err != nil {
+		return nil, err
+	}
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	// Evaluate each of the transactions which are not in the pool on their
+	// own first, treating the ones before them which pass as if they were
+	// in the pool.  Transactions which don't pay sufficient fees, or spend
+	// the outputs of such transactions, are deferred to be evaluated as a
+	// package.
+	pkgResult := &PackageAcceptResult{
+		TxResults: make([]PackageTxResult, len(txns)),
+	}
+	results := make([]*MempoolAcceptResult, len(txns))
+	pkgTxns := make(map[chainhash.Hash]*bteutil.Tx, len(txns))
+	var deferred []int
+	for i, tx := range txns {
+		if txD, exists := mp.pool[*tx.Hash()]; exists {
+			pkgResult.TxResults[i] = PackageTxResult{
+				TxDesc:        txD,
+				FeePerKB:      txD.FeePerKB,
+				AlreadyInPool: true,
+			}
+			continue
+		}
+
+		result, err := mp.checkMempoolAcceptance(tx, true, false, false,
+			true, pkgTxns)
+		if err != nil {
+			code, _ := extractRejectCode(err)
+			if code != wire.RejectInsufficientFee {
+				return nil, err
+			}
+			deferred = append(deferred, i)
+			continue
+		}
+		if len(result.MissingParents) > 0 {
+			deferred = append(deferred, i)
+			continue
+		}
+
+		results[i] = result
+		pkgTxns[*tx.Hash()] = tx
+	}
+
+	// Evaluate the deferred transactions together without checking their
+	// individual fees and ensure their combined fee rate meets both the
+	// minimum relay fee and the minimum fee required by the pool.
+	var pkgFee bteutil.Amount
+	var pkgSize int64
+	for _, i := range deferred {
+		tx := txns[i]
+		result, err := mp.checkMempoolAcceptance(tx, true, false, false,
+			false, pkgTxns)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.MissingParents) > 0 {
+			str := fmt.Sprintf("orphan transaction %v references "+
+				"outputs of unknown or fully-spent "+
+				"transaction %v", tx.Hash(),
+				result.MissingParents[0])
+			return nil, txRuleError(wire.RejectDuplicate, str)
+		}
+
+		results[i] = result
+		pkgTxns[*tx.Hash()] = tx
+		pkgFee += result.TxFee + bteutil.Amount(mp.feeDeltas[*tx.Hash()])
+		pkgSize += result.TxSize
+	}
+	if len(deferred) > 0 {
+		minFee := calcMinRequiredTxRelayFee(pkgSize,
+			mp.cfg.Policy.MinRelayTxFee)
+		if poolMinFee := calcMinRequiredTxRelayFee(pkgSize,
+			mp.minFeeRate()); poolMinFee > minFee {
+
+			minFee = poolMinFee
+		}
+		if int64(pkgFee) < minFee {
+			str := fmt.Sprintf("package has %d fees which is under "+
+				"the required amount of %d", pkgFee, minFee)
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
+		}
+	}
+
+	// Replacements are evaluated by the fees of the replacing transaction
+	// alone and may evict other transactions of the package, so they are
+	// not supported for packages.
+	for i, result := range results {
+		if result != nil && len(result.conflicts) > 0 {
+			str := fmt.Sprintf("transaction %v in the package "+
+				"replaces transactions in the pool which is "+
+				"not supported for packages", txns[i].Hash())
+			return nil, txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	// Now that the package has been deemed valid, add its transactions to
+	// the pool in order so parents always come first.
+	var pkgFeePerKB int64
+	if pkgSize > 0 {
+		pkgFeePerKB = int64(pkgFee) * 1000 / pkgSize
+	}
+	for _, i := range deferred {
+		pkgResult.TxResults[i].PackageFeeRate = true
+	}
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		tx := txns[i]
+		txD := mp.addAcceptedTransaction(tx, result)
+
+		txResult := &pkgResult.TxResults[i]
+		txResult.TxDesc = txD
+		txResult.FeePerKB = txD.FeePerKB
+		if txResult.PackageFeeRate {
+			txResult.FeePerKB = pkgFeePerKB
+		}
+	}
+
+	// Remove expired transactions and then make room for the package if
+	// the pool has grown beyond its maximum size.  When any transaction of
+	// the package was evicted to do so, the remaining ones are removed as
+	// well so the package is never accepted partially.  They are removed
+	// in reverse order so children are removed before their parents.
+	mp.maybeExpireTransactions()
+	mp.trimToSize()
+	for i, result := range results {
+		if result == nil || mp.isTransactionInPool(txns[i].Hash()) {
+			continue
+		}
+		for j := len(results) - 1; j >= 0; j-- {
+			if results[j] == nil {
+				continue
+			}
+			mp.removeTransaction(txns[j], true,
+				RemovalReasonSizeLimit)
+		}
+		str := fmt.Sprintf("transaction %v in the package was not "+
+			"accepted since the mempool is full", txns[i].Hash())
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	// Remove the transactions of the package from the orphan pool now that
+	// the package has been fully accepted.  This is not done when adding
+	// them since they must remain orphans when the package is removed
+	// again above.
+	for i, result := range results {
+		if result != nil {
+			mp.removeOrphan(txns[i], false)
+		}
+	}
+
+	// Accept any orphan transactions that depend on the transactions of
+	// the package now that they are in the pool.
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		txD := pkgResult.TxResults[i].TxDesc
+		pkgResult.AcceptedTxs = append(pkgResult.AcceptedTxs, txD)
+		pkgResult.AcceptedTxs = append(pkgResult.AcceptedTxs,
+			mp.processOrphans(txD.Tx)...)
+	}
+
+	log.Debugf("Accepted package of %d transactions (pool size: %v)",
+		len(txns), len(mp.pool))
+
+	return pkgResult, nil
+}
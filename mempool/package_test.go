@@ -0,0 +1,184 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// TestProcessPackage ensures a child paying a sufficient fee gets its parent
+// which does not pay a sufficient fee on its own accepted into the pool as a
+// package and that invalid packages are rejected without modifying the pool.
+func TestProcessPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	// Require a fee rate of 10 satoshi per byte as if the pool had been
+	// full recently.  The coinbase is added first since doing so connects
+	// a block which would decay the rate.
+	coinbase := ctx.addCoinbaseTx(2)
+	txPool.rollingMinFeeRate = 10000
+	txPool.rollingFeeHeight = harness.chain.BestHeight()
+
+	// createTx creates a transaction spending the passed output with the
+	// passed fee without adding it to the pool.
+	createTx := func(input spendableOutput, fee bteutil.Amount) *bteutil.Tx {
+		t.Helper()
+		tx, err := harness.CreateSignedTx([]spendableOutput{input}, 1,
+			fee, false)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		return tx
+	}
+
+	// assertRejectCode ensures the passed error is a rule error with the
+	// passed reject code.
+	assertRejectCode := func(err error, wantCode wire.RejectCode) {
+		t.Helper()
+		if _, ok := err.(RuleError); !ok {
+			t.Fatalf("unexpected error type %T: %v", err, err)
+		}
+		if code, _ := extractRejectCode(err); code != wantCode {
+			t.Fatalf("unexpected reject code -- got %v, want %v",
+				code, wantCode)
+		}
+	}
+
+	// The parent pays no fee, so it must be rejected on its own.
+	parent := createTx(txOutToSpendableOut(coinbase, 0), 0)
+	_, err = txPool.ProcessTransaction(parent, false, false, 0)
+	assertRejectCode(err, wire.RejectInsufficientFee)
+
+	// A package whose child doesn't pay enough for both transactions must
+	// be rejected as a whole.
+	lowFeeChild := createTx(txOutToSpendableOut(parent, 0), 1000)
+	_, err = txPool.ProcessPackage([]*bteutil.Tx{parent, lowFeeChild})
+	assertRejectCode(err, wire.RejectInsufficientFee)
+	if txPool.Count() != 0 {
+		t.Fatalf("rejected package modified the pool")
+	}
+
+	// Packages where a transaction is not a parent of the child must be
+	// rejected.
+	child := createTx(txOutToSpendableOut(parent, 0), 10000)
+	unrelated := createTx(txOutToSpendableOut(coinbase, 1), 10000)
+	_, err = txPool.ProcessPackage([]*bteutil.Tx{unrelated, child})
+	assertRejectCode(err, wire.RejectInvalid)
+	_, err = txPool.ProcessPackage([]*bteutil.Tx{child, parent})
+	assertRejectCode(err, wire.RejectInvalid)
+
+	// The child arriving on its own is an orphan.  Once it is submitted
+	// along with its parent, both must be accepted and the child must no
+	// longer be an orphan.
+	_, err = txPool.ProcessTransaction(child, true, false, 0)
+	if err != nil {
+		t.Fatalf("unable to add orphan: %v", err)
+	}
+	testPoolMembership(ctx, child, true, false)
+	result, err := txPool.ProcessPackage([]*bteutil.Tx{parent, child})
+	if err != nil {
+		t.Fatalf("unable to process package: %v", err)
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	if len(result.AcceptedTxs) != 2 ||
+		result.AcceptedTxs[0].Tx.Hash() != parent.Hash() {
+
+		t.Fatalf("unexpected accepted transactions %v",
+			result.AcceptedTxs)
+	}
+
+	pkgSize := GetTxVirtualSize(parent) + GetTxVirtualSize(child)
+	wantFeePerKB := int64(10000) * 1000 / pkgSize
+	for i, txResult := range result.TxResults {
+		if !txResult.PackageFeeRate || txResult.AlreadyInPool {
+			t.Fatalf("transaction %d was not evaluated as part of "+
+				"the package", i)
+		}
+		if txResult.FeePerKB != wantFeePerKB {
+			t.Fatalf("unexpected fee rate for transaction %d -- "+
+				"got %d, want %d", i, txResult.FeePerKB,
+				wantFeePerKB)
+		}
+	}
+
+	// Submitting the package again must report both transactions as
+	// already being in the pool.
+	result, err = txPool.ProcessPackage([]*bteutil.Tx{parent, child})
+	if err != nil {
+		t.Fatalf("unable to process package again: %v", err)
+	}
+	if len(result.AcceptedTxs) != 0 || !result.TxResults[0].AlreadyInPool ||
+		!result.TxResults[1].AlreadyInPool {
+
+		t.Fatalf("transactions were not reported as already in the pool")
+	}
+}
+
+// TestProcessPackageFullPool ensures a package is rejected as a whole when some
+// of its transactions are evicted to make room for it in the full pool.
+func TestProcessPackageFullPool(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+
+	coinbase := ctx.addCoinbaseTx(2)
+	highFeeTx := ctx.addSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 1)}, 1, 40000,
+		false, false,
+	)
+
+	// The child pays a lower fee rate than any other package, so only the
+	// child would be evicted since the pool only has room for the parent.
+	parent, err := harness.CreateSignedTx(
+		[]spendableOutput{txOutToSpendableOut(coinbase, 0)}, 1, 20000,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTx(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 1, 1000,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	txPool.cfg.Policy.MaxPoolSize = txPool.poolSize +
+		GetTxVirtualSize(parent) + 10
+
+	// The child which arrived on its own before the package must remain an
+	// orphan when the package is rejected.
+	_, err = txPool.ProcessTransaction(child, true, false, 0)
+	if err != nil {
+		t.Fatalf("unable to add orphan: %v", err)
+	}
+	testPoolMembership(ctx, child, true, false)
+
+	_, err = txPool.ProcessPackage([]*bteutil.Tx{parent, child})
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("unexpected error for package in full pool: %v", err)
+	}
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, child, true, false)
+	testPoolMembership(ctx, highFeeTx, false, true)
+	testPackages(ctx)
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/wire"
+)
+
+const (
+	// DumpFileName is the name of the file the contents of the main pool
+	// are saved to in the data directory so they survive restarts.
+	DumpFileName = "mempool.dat"
+
+	// dumpVersion is the current version of the format the contents of the
+	// main pool are saved in.  Version 1 did not include fee deltas.
+	dumpVersion = 2
+)
+
+// Dump writes all transactions in the main pool to the passed writer along with
+// the time they were added so they can be loaded again with Load.  Parents are
+// always written before the transactions which spend them.  The fee deltas set
+// with PrioritiseTransaction are written as well, including those of
+// transactions which are not in the pool.  It returns the number of
+// transactions written.
+//
+// The format is as follows, where all integers are big endian:
+//
+//	<version><num deltas>[<txid><fee delta>]...<num txns>[<time added><serialized tx>]...
+//
+//	Field           Type      Size
+//	version         uint32    4
+//	num deltas      uint64    8
+//	txid            [32]byte  32
+//	fee delta       int64     8
+//	num txns        uint64    8
+//	time added      int64     8
+//	serialized tx   MsgTx     variable
+//
+// The time added is in seconds since the unix epoch and the transactions are
+// serialized along with their witness data.  Version 1 of the format does not
+// include the number of deltas nor the deltas themselves.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Dump(w io.Writer) (int, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	// Order the transactions such that all of their parents in the pool
+	// come first since they would otherwise be orphans when loading them.
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	visited := make(map[chainhash.Hash]struct{}, len(mp.pool))
+	var addWithParents func(txD *TxDesc)
+	addWithParents = func(txD *TxDesc) {
+		if _, ok := visited[*txD.Tx.Hash()]; ok {
+			return
+		}
+		visited[*txD.Tx.Hash()] = struct{}{}
+		for _, txIn := range txD.Tx.MsgTx().TxIn {
+			parent, ok := mp.pool[txIn.PreviousOutPoint.Hash]
+			if ok {
+				addWithParents(parent)
+			}
+		}
+		descs = append(descs, txD)
+	}
+	for _, txD := range mp.pool {
+		addWithParents(txD)
+	}
+
+	var version [4]byte
+	binary.BigEndian.PutUint32(version[:], dumpVersion)
+	if _, err := w.Write(version[:]); err != nil {
+		return 0, err
+	}
+
+	var numDeltas [8]byte
+	binary.BigEndian.PutUint64(numDeltas[:], uint64(len(mp.feeDeltas)))
+	if _, err := w.Write(numDeltas[:]); err != nil {
+		return 0, err
+	}
+	for hash, delta := range mp.feeDeltas {
+		var entry [chainhash.HashSize + 8]byte
+		copy(entry[:chainhash.HashSize], hash[:])
+		binary.BigEndian.PutUint64(entry[chainhash.HashSize:],
+			uint64(delta))
+		if _, err := w.Write(entry[:]); err != nil {
+			return 0, err
+		}
+	}
+
+	var numTxns [8]byte
+	binary.BigEndian.PutUint64(numTxns[:], uint64(len(descs)))
+	if _, err := w.Write(numTxns[:]); err != nil {
+		return 0, err
+	}
+	for i, txD := range descs {
+		var added [8]byte
+		binary.BigEndian.PutUint64(added[:], uint64(txD.Added.Unix()))
+		if _, err := w.Write(added[:]); err != nil {
+			return i, err
+		}
+		if err := txD.Tx.MsgTx().Serialize(w); err != nil {
+			return i, err
+		}
+	}
+
+	return len(descs), nil
+}
+
+// Load reads transactions previously written by Dump from the passed reader and
+// attempts to add each of them to the main pool.  They are processed the same
+// way as any other new transaction except for rate limiting, so transactions
+// which have since been mined, double spent, or otherwise became invalid are
+// simply skipped, and transactions which come before their parents are held as
+// orphans until the parents are loaded.  Transactions which are accepted keep
+// the time they were originally added.
+// The saved fee deltas are added to any deltas already set before the
+// transactions are loaded.
+//
+// Loading stops early without an error when the interrupt channel is closed.
+// It returns the number of transactions which were accepted and the number
+// which were skipped, including those which are still orphans once all of the
+// transactions have been loaded.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(r io.Reader, interrupt <-chan struct{}) (int, int, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return 0, 0, err
+	}
+	version := binary.BigEndian.Uint32(buf[:4])
+	if version != 1 && version != dumpVersion {
+		return 0, 0, fmt.Errorf("unsupported mempool dump version %d",
+			version)
+	}
+
+	// Fee deltas are applied before loading the transactions since they
+	// may be needed for the transactions to be accepted.
+	if version >= 2 {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, 0, err
+		}
+		numDeltas := binary.BigEndian.Uint64(buf[:])
+		for i := uint64(0); i < numDeltas; i++ {
+			var hash chainhash.Hash
+			if _, err := io.ReadFull(r, hash[:]); err != nil {
+				return 0, 0, err
+			}
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return 0, 0, err
+			}
+			delta := int64(binary.BigEndian.Uint64(buf[:]))
+			mp.PrioritiseTransaction(&hash, delta)
+		}
+	}
+
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, 0, err
+	}
+	numTxns := binary.BigEndian.Uint64(buf[:])
+
+	// The times the transactions were added are kept until they are
+	// accepted since orphans are only accepted once their parents are.
+	addedTimes := make(map[chainhash.Hash]time.Time)
+	var numRead, numAccepted int
+	for i := uint64(0); i < numTxns; i++ {
+		select {
+		case <-interrupt:
+			return numAccepted, numRead - numAccepted, nil
+		default:
+		}
+
+		var added [8]byte
+		if _, err := io.ReadFull(r, added[:]); err != nil {
+			return numAccepted, numRead - numAccepted, err
+		}
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return numAccepted, numRead - numAccepted, err
+		}
+		tx := bteutil.NewTx(&msgTx)
+		numRead++
+
+		addedTimes[*tx.Hash()] = time.Unix(int64(
+			binary.BigEndian.Uint64(added[:])), 0)
+		acceptedTxns, err := mp.ProcessTransaction(tx, true, false, 0)
+		if err != nil {
+			log.Debugf("Skipping saved transaction %v: %v",
+				tx.Hash(), err)
+			delete(addedTimes, *tx.Hash())
+			continue
+		}
+		if len(acceptedTxns) == 0 {
+			log.Debugf("Holding saved transaction %v as an orphan "+
+				"until its parents are loaded", tx.Hash())
+			continue
+		}
+
+		// Restore the times the accepted transactions were added.  The
+		// descriptors may have been handed out already, so they are
+		// replaced rather than modified.  Orphans received from peers
+		// in the mean time keep the current time.
+		mp.mtx.Lock()
+		for _, txD := range acceptedTxns {
+			txHash := *txD.Tx.Hash()
+			added, ok := addedTimes[txHash]
+			if !ok {
+				continue
+			}
+			delete(addedTimes, txHash)
+			numAccepted++
+
+			if current, ok := mp.pool[txHash]; ok {
+				modified := *current
+				modified.Added = added
+				mp.pool[txHash] = &modified
+			}
+		}
+		mp.mtx.Unlock()
+	}
+
+	return numAccepted, numRead - numAccepted, nil
+}
+
+// SetLoaded marks the pool as done loading the transactions saved by a
+// previous run, regardless of whether or not loading them succeeded.  The pool
+// should not be saved before then since any transactions which have not been
+// loaded yet would be lost.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) SetLoaded() {
+	atomic.StoreInt32(&mp.loaded, 1)
+}
+
+// IsLoaded returns whether or not the pool is done loading the transactions
+// saved by a previous run.  See SetLoaded for more details.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) IsLoaded() bool {
+	return atomic.LoadInt32(&mp.loaded) == 1
+}
@@ -0,0 +1,646 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/mining"
+)
+
+// The smart fee estimator tracks how many blocks transactions take to confirm
+// depending on the fee rate they pay.  Fee rates are grouped into
+// exponentially spaced buckets and the statistics of each bucket are kept as
+// moving averages which decay with every block over three horizons:
+//
+//   - a short horizon tracking up to 12 blocks in periods of 1 block
+//   - a medium horizon tracking up to 48 blocks in periods of 2 blocks
+//   - a long horizon tracking up to 1008 blocks in periods of 24 blocks
+//
+// An estimate for a confirmation target is the fee rate of the cheapest range
+// of buckets for which a sufficient fraction of the transactions confirmed
+// within the target.  Transactions which are still unconfirmed after the
+// target, or which left the pool without being confirmed, count against the
+// bucket they are in.
+const (
+	// minBucketFeeRate and maxBucketFeeRate are the lowest and highest
+	// fee rates in satoshi/kB the buckets distinguish between.  Fee rates
+	// above the maximum are placed in a final bucket of their own.
+	minBucketFeeRate = 1000
+	maxBucketFeeRate = 1e7
+
+	// feeBucketSpacing is the ratio between the fee rates of adjacent
+	// buckets.
+	feeBucketSpacing = 1.05
+
+	// The number of periods, the number of blocks in each period and the
+	// decay applied with every block for each of the horizons.
+	shortBlockPeriods  = 12
+	shortScale         = 1
+	shortDecay         = .962
+	mediumBlockPeriods = 24
+	mediumScale        = 2
+	mediumDecay        = .9952
+	longBlockPeriods   = 42
+	longScale          = 24
+	longDecay          = .99931
+
+	// The fractions of transactions which must have confirmed within the
+	// target for the range of buckets to pass when estimating for half,
+	// exactly and double the requested target.
+	halfSuccessPct   = .6
+	successPct       = .85
+	doubleSuccessPct = .95
+
+	// sufficientFeeTxs and sufficientTxsShort are the average number of
+	// transactions per block a range of buckets requires to be considered
+	// for the medium and long horizons and the short horizon respectively.
+	sufficientFeeTxs   = .1
+	sufficientTxsShort = .5
+
+	// MaxSmartFeeTarget is the highest confirmation target in blocks smart
+	// fee estimates can be requested for.
+	MaxSmartFeeTarget = longBlockPeriods * longScale
+)
+
+// feeBuckets holds the upper bound of the fee rate in satoshi/kB of each
+// bucket.
+var feeBuckets = func() []float64 {
+	var buckets []float64
+	for rate := float64(minBucketFeeRate); rate <= maxBucketFeeRate; rate *= feeBucketSpacing {
+		buckets = append(buckets, rate)
+	}
+	return append(buckets, math.Inf(1))
+}()
+
+// feeBucketIndex returns the index of the bucket the passed fee rate in
+// satoshi/kB falls into.
+func feeBucketIndex(feeRate float64) int {
+	return sort.SearchFloat64s(feeBuckets, feeRate)
+}
+
+// txConfirmStats houses the decaying statistics about the confirmation times of
+// transactions in each of the fee buckets for a single horizon.
+type txConfirmStats struct {
+	decay float64
+	scale int32
+
+	// txCtAvg is the moving average of the number of transactions
+	// confirmed in each bucket and feeRateAvg is the moving average of
+	// the sum of their fee rates.
+	txCtAvg    []float64
+	feeRateAvg []float64
+
+	// confAvg and failAvg are the moving averages of the number of
+	// transactions in each bucket which confirmed within, or left the
+	// pool unconfirmed after, the number of periods of the outer index
+	// plus one.
+	confAvg [][]float64
+	failAvg [][]float64
+
+	// unconfTxs is a circular buffer indexed by block height holding the
+	// number of transactions which entered the pool at that height and
+	// are still unconfirmed.  Transactions which have been unconfirmed for
+	// longer than the buffer covers are in oldUnconfTxs instead.
+	unconfTxs    [][]int
+	oldUnconfTxs []int
+}
+
+// newTxConfirmStats returns statistics for a horizon with the passed number of
+// periods of scale blocks each which decay by the passed factor every block.
+func newTxConfirmStats(periods int, scale int32, decay float64) *txConfirmStats {
+	numBuckets := len(feeBuckets)
+	stats := &txConfirmStats{
+		decay:        decay,
+		scale:        scale,
+		txCtAvg:      make([]float64, numBuckets),
+		feeRateAvg:   make([]float64, numBuckets),
+		confAvg:      make([][]float64, periods),
+		failAvg:      make([][]float64, periods),
+		unconfTxs:    make([][]int, periods*int(scale)),
+		oldUnconfTxs: make([]int, numBuckets),
+	}
+	for i := 0; i < periods; i++ {
+		stats.confAvg[i] = make([]float64, numBuckets)
+		stats.failAvg[i] = make([]float64, numBuckets)
+	}
+	for i := range stats.unconfTxs {
+		stats.unconfTxs[i] = make([]int, numBuckets)
+	}
+	return stats
+}
+
+// maxConfirms returns the highest number of blocks to confirm the statistics
+// keep track of.
+func (s *txConfirmStats) maxConfirms() int32 {
+	return int32(len(s.confAvg)) * s.scale
+}
+
+// unconfIndex returns the index in the circular buffer of unconfirmed
+// transactions for the passed block height.
+func (s *txConfirmStats) unconfIndex(height int32) int {
+	bins := int32(len(s.unconfTxs))
+	return int(((height % bins) + bins) % bins)
+}
+
+// clearCurrent moves the unconfirmed transactions which are about to fall out
+// of the circular buffer when the block at the passed height is connected to
+// the old unconfirmed transactions.
+func (s *txConfirmStats) clearCurrent(height int32) {
+	current := s.unconfTxs[s.unconfIndex(height)]
+	for bucket := range current {
+		s.oldUnconfTxs[bucket] += current[bucket]
+		current[bucket] = 0
+	}
+}
+
+// record adds a transaction in the passed bucket which confirmed after the
+// passed number of blocks to the statistics.
+func (s *txConfirmStats) record(blocksToConfirm int32, bucket int, feeRate float64) {
+	if blocksToConfirm < 1 {
+		return
+	}
+	periodsToConfirm := (blocksToConfirm + s.scale - 1) / s.scale
+	for i := periodsToConfirm; i <= int32(len(s.confAvg)); i++ {
+		s.confAvg[i-1][bucket]++
+	}
+	s.txCtAvg[bucket]++
+	s.feeRateAvg[bucket] += feeRate
+}
+
+// updateMovingAverages decays all of the moving averages by one block.
+func (s *txConfirmStats) updateMovingAverages() {
+	for bucket := range s.txCtAvg {
+		s.txCtAvg[bucket] *= s.decay
+		s.feeRateAvg[bucket] *= s.decay
+	}
+	for i := range s.confAvg {
+		for bucket := range s.confAvg[i] {
+			s.confAvg[i][bucket] *= s.decay
+			s.failAvg[i][bucket] *= s.decay
+		}
+	}
+}
+
+// newTx adds a transaction in the passed bucket which entered the pool at the
+// passed height to the unconfirmed transactions.
+func (s *txConfirmStats) newTx(height int32, bucket int) {
+	s.unconfTxs[s.unconfIndex(height)][bucket]++
+}
+
+// removeTx removes a transaction in the passed bucket which entered the pool at
+// the passed height from the unconfirmed transactions.  When it was not
+// confirmed in a block, it counts as having failed to confirm for all periods
+// it was in the pool for.
+func (s *txConfirmStats) removeTx(entryHeight, bestHeight int32, bucket int, inBlock bool) {
+	blocksAgo := bestHeight - entryHeight
+	if blocksAgo < 0 {
+		return
+	}
+
+	if blocksAgo >= int32(len(s.unconfTxs)) {
+		if s.oldUnconfTxs[bucket] > 0 {
+			s.oldUnconfTxs[bucket]--
+		}
+	} else {
+		unconf := s.unconfTxs[s.unconfIndex(entryHeight)]
+		if unconf[bucket] > 0 {
+			unconf[bucket]--
+		}
+	}
+
+	if !inBlock && blocksAgo >= s.scale {
+		periodsAgo := blocksAgo / s.scale
+		for i := int32(0); i < periodsAgo && i < int32(len(s.failAvg)); i++ {
+			s.failAvg[i][bucket]++
+		}
+	}
+}
+
+// estimateMedianVal returns the average fee rate in satoshi/kB of the cheapest
+// range of buckets in which at least the passed fraction of transactions was
+// confirmed within the target number of blocks.  Adjacent buckets are combined
+// until the range holds at least the passed number of transactions per block.
+// It returns -1 when there is no such range.
+func (s *txConfirmStats) estimateMedianVal(confTarget int32, sufficientTxVal,
+	successBreakPoint float64, bestHeight int32) float64 {
+
+	periodTarget := (confTarget + s.scale - 1) / s.scale
+	maxBucket := len(feeBuckets) - 1
+
+	// Start with the highest fee rate bucket and combine buckets until
+	// there is enough data to tell whether the range passes.  Ranges which
+	// pass are remembered and the search continues with lower fee rates.
+	var nConf, totalNum, failNum, extraNum float64
+	curNearBucket, curFarBucket := maxBucket, maxBucket
+	bestNearBucket, bestFarBucket := maxBucket, maxBucket
+	foundAnswer, newBucketRange := false, true
+	for bucket := maxBucket; bucket >= 0; bucket-- {
+		if newBucketRange {
+			curNearBucket = bucket
+			newBucketRange = false
+		}
+		curFarBucket = bucket
+		nConf += s.confAvg[periodTarget-1][bucket]
+		totalNum += s.txCtAvg[bucket]
+		failNum += s.failAvg[periodTarget-1][bucket]
+		for confs := confTarget; confs < s.maxConfirms(); confs++ {
+			extraNum += float64(
+				s.unconfTxs[s.unconfIndex(bestHeight-confs)][bucket])
+		}
+		extraNum += float64(s.oldUnconfTxs[bucket])
+
+		if totalNum < sufficientTxVal/(1-s.decay) {
+			continue
+		}
+		curPct := nConf / (totalNum + failNum + extraNum)
+		if curPct < successBreakPoint {
+			continue
+		}
+
+		foundAnswer, newBucketRange = true, true
+		nConf, totalNum, failNum, extraNum = 0, 0, 0, 0
+		bestNearBucket, bestFarBucket = curNearBucket, curFarBucket
+	}
+	if !foundAnswer {
+		return -1
+	}
+
+	// Use the fee rate of the median transaction in the passing range.
+	minBucket, maxBucket := bestFarBucket, bestNearBucket
+	var txSum float64
+	for bucket := minBucket; bucket <= maxBucket; bucket++ {
+		txSum += s.txCtAvg[bucket]
+	}
+	if txSum == 0 {
+		return -1
+	}
+	txSum /= 2
+	for bucket := minBucket; bucket <= maxBucket; bucket++ {
+		if s.txCtAvg[bucket] < txSum {
+			txSum -= s.txCtAvg[bucket]
+			continue
+		}
+		return s.feeRateAvg[bucket] / s.txCtAvg[bucket]
+	}
+	return -1
+}
+
+// serialize writes the moving averages of the statistics to the passed writer.
+// The unconfirmed transactions are not included since they are tracked again
+// as the transactions are added back to the pool.
+func (s *txConfirmStats) serialize(w io.Writer) {
+	binary.Write(w, binary.BigEndian, s.txCtAvg)
+	binary.Write(w, binary.BigEndian, s.feeRateAvg)
+	for i := range s.confAvg {
+		binary.Write(w, binary.BigEndian, s.confAvg[i])
+		binary.Write(w, binary.BigEndian, s.failAvg[i])
+	}
+}
+
+// deserialize reads the moving averages written by serialize from the passed
+// reader into the statistics.
+func (s *txConfirmStats) deserialize(r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, s.txCtAvg); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, s.feeRateAvg); err != nil {
+		return err
+	}
+	for i := range s.confAvg {
+		err := binary.Read(r, binary.BigEndian, s.confAvg[i])
+		if err != nil {
+			return err
+		}
+		err = binary.Read(r, binary.BigEndian, s.failAvg[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackedTx is an unconfirmed transaction tracked by the smart fee statistics.
+type trackedTx struct {
+	height  int32
+	bucket  int
+	feeRate float64
+}
+
+// smartFeeStats houses the statistics used for smart fee estimation over the
+// short, medium and long horizons along with the unconfirmed transactions they
+// track.
+type smartFeeStats struct {
+	short  *txConfirmStats
+	medium *txConfirmStats
+	long   *txConfirmStats
+
+	tracked map[chainhash.Hash]trackedTx
+
+	// firstRecordedHeight is the height of the first block in which a
+	// tracked transaction was confirmed.  The span of blocks since then
+	// limits the targets which can be estimated.
+	firstRecordedHeight int32
+
+	// bestSeenHeight is the height of the highest block which has been
+	// registered.  Blocks at or below it replace blocks which were rolled
+	// back and are not registered again, so the statistics of a height
+	// are never counted twice.
+	bestSeenHeight int32
+}
+
+// newSmartFeeStats returns empty smart fee statistics.
+func newSmartFeeStats() *smartFeeStats {
+	return &smartFeeStats{
+		short: newTxConfirmStats(shortBlockPeriods, shortScale,
+			shortDecay),
+		medium: newTxConfirmStats(mediumBlockPeriods, mediumScale,
+			mediumDecay),
+		long: newTxConfirmStats(longBlockPeriods, longScale,
+			longDecay),
+		tracked:             make(map[chainhash.Hash]trackedTx),
+		firstRecordedHeight: mining.UnminedHeight,
+		bestSeenHeight:      mining.UnminedHeight,
+	}
+}
+
+// all returns the statistics of all horizons.
+func (s *smartFeeStats) all() [3]*txConfirmStats {
+	return [3]*txConfirmStats{s.short, s.medium, s.long}
+}
+
+// observeTransaction starts tracking the passed transaction which entered the
+// pool at the passed height.
+func (s *smartFeeStats) observeTransaction(hash chainhash.Hash, height int32, feeRate float64) {
+	if _, ok := s.tracked[hash]; ok {
+		return
+	}
+	bucket := feeBucketIndex(feeRate)
+	for _, stats := range s.all() {
+		stats.newTx(height, bucket)
+	}
+	s.tracked[hash] = trackedTx{
+		height:  height,
+		bucket:  bucket,
+		feeRate: feeRate,
+	}
+}
+
+// removeTransaction stops tracking the passed transaction.  The best height is
+// the height of the last registered block.
+func (s *smartFeeStats) removeTransaction(hash chainhash.Hash, bestHeight int32, inBlock bool) (trackedTx, bool) {
+	tx, ok := s.tracked[hash]
+	if !ok {
+		return tx, false
+	}
+	delete(s.tracked, hash)
+	for _, stats := range s.all() {
+		stats.removeTx(tx.height, bestHeight, tx.bucket, inBlock)
+	}
+	return tx, true
+}
+
+// registerBlock updates the statistics with the tracked transactions confirmed
+// by the passed block at the passed height.  Blocks at heights which have been
+// registered before, which happens when blocks are rolled back by a reorg, only
+// stop tracking the transactions they confirm without updating the statistics.
+func (s *smartFeeStats) registerBlock(block *bteutil.Block, height int32) {
+	if s.bestSeenHeight != mining.UnminedHeight && height <= s.bestSeenHeight {
+		for _, t := range block.Transactions() {
+			s.removeTransaction(*t.Hash(), height, true)
+		}
+		return
+	}
+	s.bestSeenHeight = height
+
+	for _, stats := range s.all() {
+		stats.clearCurrent(height)
+		stats.updateMovingAverages()
+	}
+
+	var numRecorded int
+	for _, t := range block.Transactions() {
+		tx, ok := s.removeTransaction(*t.Hash(), height, true)
+		if !ok {
+			continue
+		}
+		blocksToConfirm := height - tx.height
+		if blocksToConfirm <= 0 {
+			continue
+		}
+		for _, stats := range s.all() {
+			stats.record(blocksToConfirm, tx.bucket, tx.feeRate)
+		}
+		numRecorded++
+	}
+
+	if s.firstRecordedHeight == mining.UnminedHeight && numRecorded > 0 {
+		s.firstRecordedHeight = height
+	}
+}
+
+// estimateCombinedFee returns the fee rate in satoshi/kB estimated by the
+// horizon suitable for the passed target.  When checking shorter horizons is
+// requested, a lower estimate by a shorter horizon for its highest target is
+// preferred.  It returns -1 when there is no estimate.
+func (s *smartFeeStats) estimateCombinedFee(confTarget int32,
+	successThreshold float64, checkShorterHorizon bool, bestHeight int32) float64 {
+
+	if confTarget < 1 || confTarget > s.long.maxConfirms() {
+		return -1
+	}
+
+	var estimate float64
+	switch {
+	case confTarget <= s.short.maxConfirms():
+		estimate = s.short.estimateMedianVal(confTarget,
+			sufficientTxsShort, successThreshold, bestHeight)
+	case confTarget <= s.medium.maxConfirms():
+		estimate = s.medium.estimateMedianVal(confTarget,
+			sufficientFeeTxs, successThreshold, bestHeight)
+	default:
+		estimate = s.long.estimateMedianVal(confTarget,
+			sufficientFeeTxs, successThreshold, bestHeight)
+	}
+	if !checkShorterHorizon {
+		return estimate
+	}
+
+	if confTarget > s.medium.maxConfirms() {
+		mediumMax := s.medium.estimateMedianVal(s.medium.maxConfirms(),
+			sufficientFeeTxs, successThreshold, bestHeight)
+		if mediumMax > 0 && (estimate == -1 || mediumMax < estimate) {
+			estimate = mediumMax
+		}
+	}
+	if confTarget > s.short.maxConfirms() {
+		shortMax := s.short.estimateMedianVal(s.short.maxConfirms(),
+			sufficientTxsShort, successThreshold, bestHeight)
+		if shortMax > 0 && (estimate == -1 || shortMax < estimate) {
+			estimate = shortMax
+		}
+	}
+	return estimate
+}
+
+// estimateConservativeFee returns the highest fee rate in satoshi/kB estimated
+// for double the target by the medium and long horizons with the highest
+// success threshold.  It returns -1 when there is no estimate.
+func (s *smartFeeStats) estimateConservativeFee(doubleTarget, bestHeight int32) float64 {
+	estimate := float64(-1)
+	if doubleTarget <= s.short.maxConfirms() {
+		estimate = s.medium.estimateMedianVal(doubleTarget,
+			sufficientFeeTxs, doubleSuccessPct, bestHeight)
+	}
+	if doubleTarget <= s.medium.maxConfirms() {
+		longEstimate := s.long.estimateMedianVal(doubleTarget,
+			sufficientFeeTxs, doubleSuccessPct, bestHeight)
+		if longEstimate > estimate {
+			estimate = longEstimate
+		}
+	}
+	return estimate
+}
+
+// estimateSmartFee returns the fee rate in satoshi/kB a transaction needs to
+// pay to be confirmed within the passed target along with the target the
+// estimate is actually for, which is lower than the requested one when there
+// is not enough data for it.  It returns -1 when there is no estimate.
+func (s *smartFeeStats) estimateSmartFee(confTarget int32, conservative bool, bestHeight int32) (float64, int32) {
+	// A transaction can't be expected to be confirmed in the next block
+	// reliably.
+	if confTarget == 1 {
+		confTarget = 2
+	}
+
+	// Targets can only be estimated when the statistics cover at least
+	// twice as many blocks.
+	var maxUsableTarget int32
+	if s.firstRecordedHeight != mining.UnminedHeight {
+		maxUsableTarget = (bestHeight - s.firstRecordedHeight) / 2
+	}
+	if maxUsableTarget > s.long.maxConfirms() {
+		maxUsableTarget = s.long.maxConfirms()
+	}
+	if confTarget > maxUsableTarget {
+		confTarget = maxUsableTarget
+	}
+	if confTarget <= 1 {
+		return -1, confTarget
+	}
+
+	// The estimate is the highest of the estimates for half the target
+	// with a low success threshold, the target itself, and double the
+	// target with a high success threshold.  Conservative estimates only
+	// consider the longer horizons for double the target.
+	estimate := s.estimateCombinedFee(confTarget/2, halfSuccessPct, true,
+		bestHeight)
+	actual := s.estimateCombinedFee(confTarget, successPct, true,
+		bestHeight)
+	if actual > estimate {
+		estimate = actual
+	}
+	doubleTarget := confTarget * 2
+	if doubleTarget <= maxUsableTarget {
+		double := s.estimateCombinedFee(doubleTarget, doubleSuccessPct,
+			!conservative, bestHeight)
+		if double > estimate {
+			estimate = double
+		}
+	}
+	if conservative || estimate == -1 {
+		cons := s.estimateConservativeFee(doubleTarget, bestHeight)
+		if cons > estimate {
+			estimate = cons
+		}
+	}
+
+	return estimate, confTarget
+}
+
+// serialize writes the smart fee statistics to the passed writer.
+func (s *smartFeeStats) serialize(w io.Writer) {
+	binary.Write(w, binary.BigEndian, s.firstRecordedHeight)
+	binary.Write(w, binary.BigEndian, uint32(len(feeBuckets)))
+	for _, stats := range s.all() {
+		stats.serialize(w)
+	}
+}
+
+// deserializeSmartFeeStats reads the smart fee statistics written by serialize
+// from the passed reader.
+func deserializeSmartFeeStats(r io.Reader) (*smartFeeStats, error) {
+	s := newSmartFeeStats()
+	err := binary.Read(r, binary.BigEndian, &s.firstRecordedHeight)
+	if err != nil {
+		return nil, err
+	}
+	var numBuckets uint32
+	if err := binary.Read(r, binary.BigEndian, &numBuckets); err != nil {
+		return nil, err
+	}
+	if numBuckets != uint32(len(feeBuckets)) {
+		return nil, fmt.Errorf("Incorrect number of fee buckets: "+
+			"expected %d found %d", len(feeBuckets), numBuckets)
+	}
+	for _, stats := range s.all() {
+		if err := stats.deserialize(r); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// EstimateSmartFee estimates the fee rate a transaction needs to pay to be
+// confirmed within the passed number of blocks from the statistics of how long
+// transactions paying different fee rates took to be confirmed.  It returns the
+// estimate along with the number of blocks it is actually for, which is lower
+// than requested when there is not enough data for the requested target.
+//
+// Conservative estimates are more likely to be sufficient but potentially
+// higher since they consider a longer history and fee rates which are
+// sufficient to confirm within twice the target with high certainty.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) EstimateSmartFee(confTarget uint32, conservative bool) (BtePerKilobyte, uint32, error) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	if confTarget == 0 || confTarget > MaxSmartFeeTarget {
+		return -1, 0, fmt.Errorf("can only estimate fees for 1 to %d "+
+			"blocks from now", MaxSmartFeeTarget)
+	}
+
+	feeRate, target := ef.smart.estimateSmartFee(int32(confTarget),
+		conservative, ef.lastKnownHeight)
+	if target < 0 {
+		target = 0
+	}
+	if feeRate < 0 {
+		return -1, uint32(target), errors.New("insufficient data or " +
+			"no feerate found")
+	}
+
+	return SatoshiPerByte(feeRate / bytePerKb).ToBtePerKb(),
+		uint32(target), nil
+}
+
+// RemoveTransaction informs the fee estimator that the passed transaction left
+// the pool without being confirmed in a block.  It counts as having failed to
+// confirm within the number of blocks it was in the pool for.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) RemoveTransaction(hash *chainhash.Hash) {
+	ef.mtx.Lock()
+	ef.smart.removeTransaction(*hash, ef.lastKnownHeight, false)
+	ef.mtx.Unlock()
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// TestEstimateSmartFee tests the bucketed smart fee estimation in the
+// FeeEstimator.
+func TestEstimateSmartFee(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blocks     = 30
+		txPerBlock = 5
+		highFee    = 500
+		lowFee     = 20
+	)
+
+	ef := NewFeeEstimator(estimateFeeDepth, 0)
+	eft := estimateFeeTester{ef: ef, t: t}
+	eft.newBlock(nil)
+
+	// There is no estimate until transactions have been confirmed.
+	if _, _, err := ef.EstimateSmartFee(2, false); err == nil {
+		t.Fatal("EstimateSmartFee: expected error without any data")
+	}
+	if _, _, err := ef.EstimateSmartFee(0, false); err == nil {
+		t.Fatal("EstimateSmartFee: expected error for a target of 0")
+	}
+	if _, _, err := ef.EstimateSmartFee(MaxSmartFeeTarget+1, false); err == nil {
+		t.Fatalf("EstimateSmartFee: expected error for a target of %d",
+			MaxSmartFeeTarget+1)
+	}
+
+	// Every block, transactions paying a high fee are confirmed by the next
+	// block while transactions paying a low fee are never confirmed.  The
+	// transactions are small enough for the fee to be the fee rate in
+	// satoshi per 10 bytes.
+	for i := 0; i < blocks; i++ {
+		var mined []*wire.MsgTx
+		for j := 0; j < txPerBlock; j++ {
+			high := eft.testTx(highFee)
+			ef.ObserveTransaction(high)
+			mined = append(mined, high.Tx.MsgTx())
+
+			ef.ObserveTransaction(eft.testTx(lowFee))
+		}
+		eft.newBlock(mined)
+	}
+
+	expected := SatoshiPerByte(highFee / 10).ToBtePerKb()
+	for _, conservative := range []bool{false, true} {
+		feeRate, target, err := ef.EstimateSmartFee(1, conservative)
+		if err != nil {
+			t.Fatalf("EstimateSmartFee (conservative %v): unexpected "+
+				"error: %v", conservative, err)
+		}
+		if math.Abs(float64(feeRate-expected)) > 1e-12 {
+			t.Fatalf("EstimateSmartFee (conservative %v): unexpected "+
+				"fee rate - got %v, want %v", conservative,
+				feeRate, expected)
+		}
+		if target != 2 {
+			t.Fatalf("EstimateSmartFee (conservative %v): unexpected "+
+				"target - got %d, want 2", conservative, target)
+		}
+	}
+
+	// Targets beyond half of the blocks the statistics cover are lowered.
+	_, target, err := ef.EstimateSmartFee(MaxSmartFeeTarget, false)
+	if err != nil {
+		t.Fatalf("EstimateSmartFee: unexpected error: %v", err)
+	}
+	if want := uint32(blocks-1) / 2; target != want {
+		t.Fatalf("EstimateSmartFee: unexpected target - got %d, want %d",
+			target, want)
+	}
+
+	// The statistics must survive saving and restoring the estimator.
+	restored, err := RestoreFeeEstimator(ef.Save())
+	if err != nil {
+		t.Fatalf("RestoreFeeEstimator: unexpected error: %v", err)
+	}
+	feeRate, _, err := restored.EstimateSmartFee(2, false)
+	if err != nil {
+		t.Fatalf("EstimateSmartFee after restore: unexpected error: %v",
+			err)
+	}
+	if math.Abs(float64(feeRate-expected)) > 1e-12 {
+		t.Fatalf("EstimateSmartFee after restore: unexpected fee rate - "+
+			"got %v, want %v", feeRate, expected)
+	}
+
+	// Transactions which leave the pool without being mined count as
+	// having failed to confirm.  Once enough of the high fee transactions
+	// fail, they no longer confirm reliably enough to be estimated.
+	for i := 0; i < blocks; i++ {
+		var removed []*TxDesc
+		for j := 0; j < txPerBlock; j++ {
+			high := eft.testTx(highFee)
+			ef.ObserveTransaction(high)
+			removed = append(removed, high)
+		}
+		for j := 0; j < 4; j++ {
+			eft.newBlock(nil)
+		}
+		for _, txD := range removed {
+			ef.RemoveTransaction(txD.Tx.Hash())
+		}
+	}
+	if _, _, err := ef.EstimateSmartFee(2, false); err == nil {
+		t.Fatal("EstimateSmartFee: expected error after transactions " +
+			"failed to confirm")
+	}
+}
+
+// TestSmartFeeRollback ensures blocks which replace rolled back blocks are not
+// registered with the smart fee statistics again.
+func TestSmartFeeRollback(t *testing.T) {
+	t.Parallel()
+
+	ef := NewFeeEstimator(estimateFeeDepth, 0)
+	eft := estimateFeeTester{ef: ef, t: t}
+	eft.newBlock(nil)
+
+	high := eft.testTx(500)
+	ef.ObserveTransaction(high)
+	mined := []*wire.MsgTx{high.Tx.MsgTx()}
+	eft.newBlock(mined)
+
+	var want bytes.Buffer
+	ef.smart.serialize(&want)
+
+	// Roll the block back and connect it again after its transaction was
+	// added back to the pool.
+	eft.rollback()
+	ef.ObserveTransaction(high)
+	eft.newBlock(mined)
+
+	var got bytes.Buffer
+	ef.smart.serialize(&got)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatal("statistics changed by registering a replaced block")
+	}
+	if _, ok := ef.smart.tracked[*high.Tx.Hash()]; ok {
+		t.Fatal("transaction in the replaced block is still tracked")
+	}
+}
+
+// TestRestoreFeeEstimatorVersion1 ensures fee estimator states saved before
+// smart fee estimation was added are still accepted.
+func TestRestoreFeeEstimatorVersion1(t *testing.T) {
+	t.Parallel()
+
+	ef := NewFeeEstimator(estimateFeeDepth, 0)
+	eft := estimateFeeTester{ef: ef, t: t}
+	eft.newBlock(nil)
+	ef.ObserveTransaction(eft.testTx(bteutil.Amount(500)))
+	eft.newBlock(nil)
+
+	// Strip the smart fee statistics from the state and mark it as the
+	// first version.
+	var smart bytesCounter
+	ef.smart.serialize(&smart)
+	state := ef.Save()
+	state = append(FeeEstimatorState(nil), state[:len(state)-smart.n]...)
+	state[3] = 1
+
+	restored, err := RestoreFeeEstimator(state)
+	if err != nil {
+		t.Fatalf("RestoreFeeEstimator: unexpected error: %v", err)
+	}
+	if restored.LastKnownHeight() != ef.LastKnownHeight() {
+		t.Fatalf("unexpected last known height - got %d, want %d",
+			restored.LastKnownHeight(), ef.LastKnownHeight())
+	}
+	if restored.smart == nil {
+		t.Fatal("smart fee statistics were not initialized")
+	}
+}
+
+// bytesCounter is an io.Writer which counts the number of bytes written to it.
+type bytesCounter struct {
+	n int
+}
+
+func (c *bytesCounter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
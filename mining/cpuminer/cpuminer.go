@@ -269,13 +269,13 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 				// Non-blocking select to fall through
 			}
 
-			// Update the nonce and hash the block header.  Each
-			// hash is actually a double sha256 (two hashes), so
-			// increment the number of hashes completed for each
-			// attempt accordingly.
+			// Update the nonce and compute the yespower
+			// proof-of-work hash of the block header.  The
+			// hash cache is bypassed since each nonce is only
+			// tried once.
 			header.Nonce = i
-			hash := header.BlockHash()
-			hashesCompleted += 2
+			hash := header.UncachedPowHash()
+			hashesCompleted++
 
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
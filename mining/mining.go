@@ -51,6 +51,11 @@ type TxDesc struct {
 
 	// FeePerKB is the fee the transaction pays in Satoshi per 1000 bytes.
 	FeePerKB int64
+
+	// FeeDelta is the amount in Satoshi added to the fee when ordering the
+	// transaction by fee rate.  It allows the transaction to be
+	// prioritised manually and does not change the fee it pays.
+	FeeDelta int64
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
@@ -575,9 +580,17 @@ mempoolLoop:
 		prioItem.priority = CalcPriority(tx.MsgTx(), utxos,
 			nextBlockHeight)
 
-		// Calculate the fee in Satoshi/kB.
+		// Calculate the fee in Satoshi/kB, taking into account any
+		// fee delta the transaction was prioritised by.
 		prioItem.feePerKB = txDesc.FeePerKB
 		prioItem.fee = txDesc.Fee
+		if txDesc.FeeDelta != 0 {
+			txVSize := (blockchain.GetTransactionWeight(tx) +
+				blockchain.WitnessScaleFactor - 1) /
+				blockchain.WitnessScaleFactor
+			prioItem.feePerKB = (txDesc.Fee + txDesc.FeeDelta) *
+				1000 / txVSize
+		}
 
 		// Add the transaction to the priority queue to mark it ready
 		// for inclusion in the block unless it has dependencies.
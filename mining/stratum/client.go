@@ -0,0 +1,531 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+)
+
+const (
+	// maxLineLength is the maximum length of a single message a miner may
+	// send.  Connections sending longer messages are disconnected.
+	maxLineLength = 16384
+
+	// sendQueueSize is the maximum number of messages which may be queued
+	// for a connection.  Connections which don't keep up with reading them
+	// are disconnected.
+	sendQueueSize = 32
+
+	// idleTimeout is the time after which connections which have not sent
+	// anything are disconnected.
+	idleTimeout = time.Minute * 10
+
+	// writeTimeout is the time after which a message which could not be
+	// written to a connection causes it to be disconnected.
+	writeTimeout = time.Second * 30
+
+	// vardiffTargetTime is the time the share difficulty of each
+	// connection is adjusted to take on average between shares.
+	vardiffTargetTime = time.Second * 15
+
+	// vardiffRetargetTime is the time between adjustments of the share
+	// difficulty of a connection.
+	vardiffRetargetTime = time.Second * 90
+
+	// vardiffRetargetShares is the number of shares after which the share
+	// difficulty of a connection is adjusted before the retarget time has
+	// passed.  It allows miners which start out with a far too low
+	// difficulty to be adjusted quickly.
+	vardiffRetargetShares = 2 * int(vardiffRetargetTime/vardiffTargetTime)
+
+	// vardiffVariance is the fraction the share rate of a connection may
+	// be off from the targeted rate without adjusting its difficulty.
+	vardiffVariance = 0.3
+
+	// vardiffMaxFactor is the maximum factor the share difficulty of a
+	// connection is adjusted by at once.
+	vardiffMaxFactor = 4.0
+
+	// minDifficulty is the lowest share difficulty connections may be
+	// adjusted to.
+	minDifficulty = 0.001
+)
+
+// stratumError is an error sent in response to a request.  It is encoded as an
+// array of the error code, the message, and a traceback which is always null.
+type stratumError struct {
+	Code    int
+	Message string
+}
+
+// MarshalJSON encodes the error the way Stratum mining software expects.
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+// Errors defined by the Stratum protocol.
+var (
+	errJobNotFound    = &stratumError{21, "Job not found"}
+	errDuplicateShare = &stratumError{22, "Duplicate share"}
+	errLowDifficulty  = &stratumError{23, "Low difficulty share"}
+	errUnauthorized   = &stratumError{24, "Unauthorized worker"}
+	errNotSubscribed  = &stratumError{25, "Not subscribed"}
+)
+
+// newOtherError returns an error which does not fit any of the specific errors
+// defined by the protocol with the passed message.
+func newOtherError(message string) *stratumError {
+	return &stratumError{20, message}
+}
+
+// request is a request sent by a miner.
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// response is the response to a request.
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+// notification is a message sent to a miner which is not in response to a
+// request.  Its id is always null.
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stringParams returns the first num parameters of a request which must all be
+// strings.
+func stringParams(params []json.RawMessage, num int) ([]string, error) {
+	if len(params) < num {
+		return nil, fmt.Errorf("expected %d parameters, got %d", num,
+			len(params))
+	}
+	strs := make([]string, num)
+	for i := range strs {
+		if err := json.Unmarshal(params[i], &strs[i]); err != nil {
+			return nil, fmt.Errorf("parameter %d is not a string", i+1)
+		}
+	}
+	return strs, nil
+}
+
+// parseUint32Hex parses the passed big endian hex-encoded 32-bit value the way
+// the time and nonce of shares are submitted.
+func parseUint32Hex(s string) (uint32, error) {
+	if len(s) != 8 {
+		return 0, fmt.Errorf("%q is not 8 hex characters", s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// retargetDifficulty returns the share difficulty a connection which submitted
+// the passed number of shares at the passed difficulty over the passed time
+// should be adjusted to.
+func retargetDifficulty(difficulty float64, numShares int, elapsed time.Duration) float64 {
+	expected := elapsed.Seconds() / vardiffTargetTime.Seconds()
+	if expected <= 0 {
+		return difficulty
+	}
+	ratio := float64(numShares) / expected
+	if ratio > 1-vardiffVariance && ratio < 1+vardiffVariance {
+		return difficulty
+	}
+
+	if ratio < 1/vardiffMaxFactor {
+		ratio = 1 / vardiffMaxFactor
+	} else if ratio > vardiffMaxFactor {
+		ratio = vardiffMaxFactor
+	}
+	newDifficulty := difficulty * ratio
+	if newDifficulty < minDifficulty {
+		newDifficulty = minDifficulty
+	}
+	return newDifficulty
+}
+
+// client houses the state of a connection from a miner.
+type client struct {
+	server       *Server
+	conn         net.Conn
+	extraNonce1  []byte
+	sendQueue    chan []byte
+	disconnected int32
+	quit         chan struct{}
+
+	mtx             sync.Mutex
+	subscribed      bool
+	authorized      bool
+	worker          string
+	difficulty      float64
+	sentDifficulty  float64
+	jobDifficulties map[string]float64
+	lastRetarget    time.Time
+	numShares       int
+}
+
+// newClient returns a new client for the passed connection which is assigned
+// the passed extra nonce.
+func newClient(s *Server, conn net.Conn, extraNonce1 []byte) *client {
+	return &client{
+		server:          s,
+		conn:            conn,
+		extraNonce1:     extraNonce1,
+		sendQueue:       make(chan []byte, sendQueueSize),
+		quit:            make(chan struct{}),
+		difficulty:      s.cfg.Difficulty,
+		jobDifficulties: make(map[string]float64),
+	}
+}
+
+// disconnect closes the connection of the client.  It is safe to call it more
+// than once.
+func (c *client) disconnect() {
+	if !atomic.CompareAndSwapInt32(&c.disconnected, 0, 1) {
+		return
+	}
+	close(c.quit)
+	c.conn.Close()
+}
+
+// inHandler reads and handles the requests of the miner until the connection
+// is closed.  It must be run as a goroutine.
+func (c *client) inHandler() {
+	reader := bufio.NewReaderSize(c.conn, maxLineLength)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			break
+		}
+		if isPrefix {
+			log.Debugf("Stratum client %s sent a message longer than "+
+				"%d bytes", c.conn.RemoteAddr(), maxLineLength)
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Debugf("Stratum client %s sent a malformed "+
+				"message: %v", c.conn.RemoteAddr(), err)
+			break
+		}
+		c.handleRequest(&req)
+	}
+
+	c.disconnect()
+	c.server.removeClient(c)
+	c.server.wg.Done()
+	log.Debugf("Stratum client %s disconnected", c.conn.RemoteAddr())
+}
+
+// outHandler writes the queued messages to the connection.  It must be run as
+// a goroutine.
+func (c *client) outHandler() {
+out:
+	for {
+		select {
+		case msg := <-c.sendQueue:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.conn.Write(msg); err != nil {
+				c.disconnect()
+				break out
+			}
+
+		case <-c.quit:
+			break out
+		}
+	}
+
+	c.server.wg.Done()
+}
+
+// queueMessage queues the passed message to be written to the connection.  The
+// client is disconnected when it has too many messages queued already.
+func (c *client) queueMessage(msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("Failed to marshal Stratum message: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	select {
+	case c.sendQueue <- b:
+	case <-c.quit:
+	default:
+		log.Debugf("Stratum client %s is not reading its messages - "+
+			"disconnecting", c.conn.RemoteAddr())
+		c.disconnect()
+	}
+}
+
+// sendJob sends the passed job to the miner along with its current share
+// difficulty if the miner subscribed to them.  When cleanJobs is set, the miner
+// is told to abandon any previous jobs.
+func (c *client) sendJob(j *job, cleanJobs bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.subscribed {
+		return
+	}
+	c.sendJobLocked(j, cleanJobs)
+}
+
+// sendJobLocked sends the passed job to the miner along with its current share
+// difficulty.
+//
+// This function MUST be called with the client lock held (for writes).
+func (c *client) sendJobLocked(j *job, cleanJobs bool) {
+	if c.difficulty != c.sentDifficulty {
+		c.queueMessage(&notification{
+			Method: "mining.set_difficulty",
+			Params: []interface{}{c.difficulty},
+		})
+		c.sentDifficulty = c.difficulty
+	}
+
+	// Remember the difficulty the shares for the job need to meet.  Miners
+	// only switch to a new difficulty with the next job, so shares for a
+	// job which is sent again after the difficulty was raised are still
+	// accepted at the lower difficulty.
+	if cleanJobs {
+		c.jobDifficulties = make(map[string]float64)
+	} else {
+		for id := range c.jobDifficulties {
+			if c.server.lookupJob(id) == nil {
+				delete(c.jobDifficulties, id)
+			}
+		}
+	}
+	difficulty, ok := c.jobDifficulties[j.id]
+	if !ok || c.difficulty < difficulty {
+		c.jobDifficulties[j.id] = c.difficulty
+	}
+
+	c.queueMessage(&notification{
+		Method: "mining.notify",
+		Params: j.notifyParams(cleanJobs),
+	})
+}
+
+// maybeRetarget adjusts the share difficulty of the miner to the rate at which
+// it submits shares when enough time has passed or enough shares have been
+// submitted since the last adjustment.  The current job is sent again when
+// the difficulty changes so the miner switches to it right away.
+func (c *client) maybeRetarget(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.subscribed {
+		return
+	}
+	elapsed := now.Sub(c.lastRetarget)
+	if elapsed < vardiffRetargetTime &&
+		c.numShares < vardiffRetargetShares {
+
+		return
+	}
+
+	difficulty := retargetDifficulty(c.difficulty, c.numShares,
+		elapsed)
+	c.lastRetarget = now
+	c.numShares = 0
+	if difficulty == c.difficulty {
+		return
+	}
+
+	log.Debugf("Adjusting share difficulty of Stratum client %s from %v "+
+		"to %v", c.conn.RemoteAddr(), c.difficulty, difficulty)
+	c.difficulty = difficulty
+	if j := c.server.currentJob(); j != nil {
+		c.sendJobLocked(j, false)
+	}
+}
+
+// reply queues the response to the passed request.
+func (c *client) reply(req *request, result interface{}, err *stratumError) {
+	if err != nil {
+		result = nil
+	}
+	c.queueMessage(&response{ID: req.ID, Result: result, Error: err})
+}
+
+// handleRequest handles the passed request of the miner.
+func (c *client) handleRequest(req *request) {
+	switch req.Method {
+	case "mining.subscribe":
+		c.handleSubscribe(req)
+
+	case "mining.authorize":
+		result, err := c.handleAuthorize(req.Params)
+		c.reply(req, result, err)
+
+	case "mining.extranonce.subscribe":
+		// The extra nonce of a connection never changes, so there is
+		// nothing to subscribe to.
+		c.reply(req, true, nil)
+
+	case "mining.submit":
+		result, err := c.handleSubmit(req.Params)
+		c.reply(req, result, err)
+
+	default:
+		log.Debugf("Stratum client %s sent unknown method %q",
+			c.conn.RemoteAddr(), req.Method)
+		c.reply(req, nil, newOtherError("Unknown method"))
+	}
+}
+
+// handleSubscribe handles the mining.subscribe request.  The response contains
+// the extra nonce assigned to the connection and the size of the extra nonce
+// the miner chooses, and it is followed by the current job.
+func (c *client) handleSubscribe(req *request) {
+	subscriptionID := hex.EncodeToString(c.extraNonce1)
+	c.reply(req, []interface{}{
+		[][]string{
+			{"mining.set_difficulty", subscriptionID},
+			{"mining.notify", subscriptionID},
+		},
+		hex.EncodeToString(c.extraNonce1),
+		extraNonce2Size,
+	}, nil)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.subscribed {
+		return
+	}
+	c.subscribed = true
+	c.lastRetarget = time.Now()
+	if j := c.server.currentJob(); j != nil {
+		c.sendJobLocked(j, true)
+	}
+}
+
+// handleAuthorize handles the mining.authorize request.  The Stratum server
+// does not require credentials, so the worker name is only used for logging.
+func (c *client) handleAuthorize(params []json.RawMessage) (interface{}, *stratumError) {
+	args, err := stringParams(params, 1)
+	if err != nil {
+		return nil, newOtherError(err.Error())
+	}
+
+	c.mtx.Lock()
+	c.authorized = true
+	c.worker = args[0]
+	c.mtx.Unlock()
+
+	log.Infof("Stratum worker %q authorized from %s", args[0],
+		c.conn.RemoteAddr())
+	return true, nil
+}
+
+// handleSubmit handles the mining.submit request.  The share is checked against
+// the share difficulty the job was sent with and submitted as a block when it
+// also meets the target difficulty of the block.
+func (c *client) handleSubmit(params []json.RawMessage) (interface{}, *stratumError) {
+	args, err := stringParams(params, 5)
+	if err != nil {
+		return nil, newOtherError(err.Error())
+	}
+
+	c.mtx.Lock()
+	subscribed, authorized, worker := c.subscribed, c.authorized, c.worker
+	c.mtx.Unlock()
+	if !subscribed {
+		return nil, errNotSubscribed
+	}
+	if !authorized {
+		return nil, errUnauthorized
+	}
+
+	j := c.server.lookupJob(args[1])
+	if j == nil {
+		return nil, errJobNotFound
+	}
+	extraNonce2, err := hex.DecodeString(args[2])
+	if err != nil || len(extraNonce2) != extraNonce2Size {
+		return nil, newOtherError("Invalid extranonce2")
+	}
+	nTime, err := parseUint32Hex(args[3])
+	if err != nil {
+		return nil, newOtherError("Invalid ntime")
+	}
+	nonce, err := parseUint32Hex(args[4])
+	if err != nil {
+		return nil, newOtherError("Invalid nonce")
+	}
+
+	// The time must be valid for the block the share would result in.
+	timestamp := time.Unix(int64(nTime), 0)
+	maxTimestamp := time.Now().Add(time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	if timestamp.Before(j.minTime) || timestamp.After(maxTimestamp) {
+		return nil, newOtherError("Ntime out of range")
+	}
+
+	c.mtx.Lock()
+	difficulty, ok := c.jobDifficulties[j.id]
+	if !ok {
+		difficulty = c.difficulty
+	}
+	c.mtx.Unlock()
+
+	if !j.addShare(c.extraNonce1, extraNonce2, nTime, nonce) {
+		return nil, errDuplicateShare
+	}
+
+	// Shares are hashed without the shared header hash cache since each
+	// one is only hashed once and would evict useful entries.
+	coinbaseTx, header := j.solve(c.extraNonce1, extraNonce2, nTime, nonce)
+	powHash := header.UncachedPowHash()
+	hashNum := blockchain.HashToBig(&powHash)
+	if hashNum.Cmp(j.target) <= 0 {
+		block := bteutil.NewBlock(j.solvedBlock(coinbaseTx, header))
+		log.Infof("Stratum worker %q found block %s at height %d",
+			worker, block.Hash(), j.height)
+		c.server.submitBlock(block, worker)
+	}
+	if hashNum.Cmp(difficultyToTarget(difficulty)) > 0 {
+		return nil, errLowDifficulty
+	}
+
+	c.mtx.Lock()
+	c.numShares++
+	c.mtx.Unlock()
+	c.maybeRetarget(time.Now())
+
+	log.Tracef("Stratum worker %q submitted share for job %s with "+
+		"difficulty %v", worker, j.id, targetToDifficulty(hashNum))
+	return true, nil
+}
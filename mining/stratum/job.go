@@ -0,0 +1,285 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/mining"
+	"github.com/mraksoll4/bted/txscript"
+	"github.com/mraksoll4/bted/wire"
+)
+
+var (
+	// diff1Target is the target which corresponds to a share difficulty of
+	// one.  It is 65536 times the difficulty one target of bitcoin, which
+	// is the same target factor yespower mining software uses to convert
+	// the difficulties sent with mining.set_difficulty to share targets.
+	diff1Target = new(big.Int).Lsh(big.NewInt(0xffff), 224)
+
+	// maxTarget is the highest possible target.  Share targets are limited
+	// to it for very low difficulties.
+	maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256),
+		big.NewInt(1))
+)
+
+// difficultyToTarget converts the passed share difficulty to the target the
+// proof of work hash of a share must not exceed.
+func difficultyToTarget(difficulty float64) *big.Int {
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target),
+		big.NewFloat(difficulty)).Int(nil)
+	if target.Cmp(maxTarget) > 0 {
+		return new(big.Int).Set(maxTarget)
+	}
+	return target
+}
+
+// targetToDifficulty converts the passed target to the equivalent share
+// difficulty.
+func targetToDifficulty(target *big.Int) float64 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1Target),
+		new(big.Float).SetInt(target)).Float64()
+	return difficulty
+}
+
+// merkleBranch returns the hashes which must be combined with the hash of the
+// coinbase transaction, in order, to arrive at the merkle root of a block with
+// the passed hashes of the remaining transactions.
+func merkleBranch(txHashes []chainhash.Hash) []chainhash.Hash {
+	// The first entry of each level stands in for the branch leading to the
+	// coinbase transaction which is not known yet.
+	level := make([]*chainhash.Hash, 0, len(txHashes)+1)
+	level = append(level, nil)
+	for i := range txHashes {
+		level = append(level, &txHashes[i])
+	}
+
+	var branch []chainhash.Hash
+	for len(level) > 1 {
+		branch = append(branch, *level[1])
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := make([]*chainhash.Hash, 0, len(level)/2)
+		next = append(next, nil)
+		for i := 2; i < len(level); i += 2 {
+			next = append(next, blockchain.HashMerkleBranches(level[i],
+				level[i+1]))
+		}
+		level = next
+	}
+	return branch
+}
+
+// merkleRootFromBranch returns the merkle root which results from combining
+// the passed coinbase transaction hash with the hashes of a merkle branch.
+func merkleRootFromBranch(coinbaseHash chainhash.Hash, branch []chainhash.Hash) chainhash.Hash {
+	root := &coinbaseHash
+	for i := range branch {
+		root = blockchain.HashMerkleBranches(root, &branch[i])
+	}
+	return *root
+}
+
+// swap32 returns a copy of the passed bytes with the order of the bytes of
+// each 32-bit word reversed.  Stratum sends the previous block hash this way
+// since mining software reverses each word back while building the header.
+func swap32(b []byte) []byte {
+	swapped := make([]byte, len(b))
+	for i := 0; i+4 <= len(b); i += 4 {
+		swapped[i] = b[i+3]
+		swapped[i+1] = b[i+2]
+		swapped[i+2] = b[i+1]
+		swapped[i+3] = b[i]
+	}
+	return swapped
+}
+
+// job houses a block template split up the way it is sent to miners with the
+// mining.notify notification.  The coinbase transaction is split around the
+// extra nonces so miners can assemble it along with the merkle root on their
+// own.
+type job struct {
+	id      string
+	block   *wire.MsgBlock
+	height  int32
+	target  *big.Int
+	minTime time.Time
+	created time.Time
+
+	// lastTxUpdate is when the transaction source was last updated as of
+	// the time the template was generated.
+	lastTxUpdate time.Time
+
+	// extraNonceOffset is the offset of the extra nonces in the signature
+	// script of the coinbase transaction.
+	extraNonceOffset int
+
+	coinbase1    []byte
+	coinbase2    []byte
+	merkleBranch []chainhash.Hash
+
+	sharesMtx sync.Mutex
+	shares    map[string]struct{}
+}
+
+// newJob returns a new job with the passed id for the passed block template.
+// The coinbase script of the template is replaced with one which includes room
+// for the extra nonces of the connection and the miner.
+func newJob(id string, template *mining.BlockTemplate, minTime, lastTxUpdate time.Time) (*job, error) {
+	msgBlock := template.Block
+	if len(msgBlock.Transactions) == 0 {
+		return nil, fmt.Errorf("block template has no coinbase transaction")
+	}
+
+	// Replace the coinbase script with one which commits to the block
+	// height as required by BIP0034 followed by the extra nonces and the
+	// usual coinbase flags.
+	extraNonce := make([]byte, extraNonce1Size+extraNonce2Size)
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(template.Height)).AddData(extraNonce).
+		AddData([]byte(mining.CoinbaseFlags)).Script()
+	if err != nil {
+		return nil, err
+	}
+	if len(coinbaseScript) > blockchain.MaxCoinbaseScriptLen {
+		return nil, fmt.Errorf("coinbase transaction script length "+
+			"of %d is out of range (min: %d, max: %d)",
+			len(coinbaseScript), blockchain.MinCoinbaseScriptLen,
+			blockchain.MaxCoinbaseScriptLen)
+	}
+	heightScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(template.Height)).Script()
+	if err != nil {
+		return nil, err
+	}
+	coinbaseTx := msgBlock.Transactions[0]
+	coinbaseTx.TxIn[0].SignatureScript = coinbaseScript
+
+	// The extra nonces follow the height and the opcode which pushes them
+	// in the script.  Since there is a single input, their offset in the
+	// serialized transaction follows from the size of the fields before
+	// the script.
+	extraNonceOffset := len(heightScript) + 1
+	var buf bytes.Buffer
+	buf.Grow(coinbaseTx.SerializeSizeStripped())
+	if err := coinbaseTx.SerializeNoWitness(&buf); err != nil {
+		return nil, err
+	}
+	serialized := buf.Bytes()
+	offset := 4 + wire.VarIntSerializeSize(1) + chainhash.HashSize + 4 +
+		wire.VarIntSerializeSize(uint64(len(coinbaseScript))) +
+		extraNonceOffset
+
+	txHashes := make([]chainhash.Hash, 0, len(msgBlock.Transactions)-1)
+	for _, tx := range msgBlock.Transactions[1:] {
+		txHashes = append(txHashes, tx.TxHash())
+	}
+
+	j := &job{
+		id:               id,
+		block:            msgBlock,
+		height:           template.Height,
+		target:           blockchain.CompactToBig(msgBlock.Header.Bits),
+		minTime:          minTime,
+		created:          time.Now(),
+		lastTxUpdate:     lastTxUpdate,
+		extraNonceOffset: extraNonceOffset,
+		coinbase1:        serialized[:offset],
+		coinbase2:        serialized[offset+len(extraNonce):],
+		merkleBranch:     merkleBranch(txHashes),
+		shares:           make(map[string]struct{}),
+	}
+
+	// Update the merkle root of the template to match the new coinbase
+	// script so the template is consistent on its own.
+	msgBlock.Header.MerkleRoot = merkleRootFromBranch(coinbaseTx.TxHash(),
+		j.merkleBranch)
+	return j, nil
+}
+
+// notifyParams returns the parameters of the mining.notify notification for
+// the job.
+func (j *job) notifyParams(cleanJobs bool) []interface{} {
+	header := &j.block.Header
+	branch := make([]string, 0, len(j.merkleBranch))
+	for i := range j.merkleBranch {
+		branch = append(branch, hex.EncodeToString(j.merkleBranch[i][:]))
+	}
+	return []interface{}{
+		j.id,
+		hex.EncodeToString(swap32(header.PrevBlock[:])),
+		hex.EncodeToString(j.coinbase1),
+		hex.EncodeToString(j.coinbase2),
+		branch,
+		fmt.Sprintf("%08x", uint32(header.Version)),
+		fmt.Sprintf("%08x", header.Bits),
+		fmt.Sprintf("%08x", uint32(header.Timestamp.Unix())),
+		cleanJobs,
+	}
+}
+
+// addShare records the share identified by the passed extra nonces, time, and
+// nonce as submitted.  It returns false when the share was already submitted.
+//
+// This function is safe for concurrent access.
+func (j *job) addShare(extraNonce1, extraNonce2 []byte, nTime, nonce uint32) bool {
+	var timeAndNonce [8]byte
+	binary.BigEndian.PutUint32(timeAndNonce[:4], nTime)
+	binary.BigEndian.PutUint32(timeAndNonce[4:], nonce)
+	key := make([]byte, 0, len(extraNonce1)+len(extraNonce2)+8)
+	key = append(key, extraNonce1...)
+	key = append(key, extraNonce2...)
+	key = append(key, timeAndNonce[:]...)
+
+	j.sharesMtx.Lock()
+	defer j.sharesMtx.Unlock()
+	if _, ok := j.shares[string(key)]; ok {
+		return false
+	}
+	j.shares[string(key)] = struct{}{}
+	return true
+}
+
+// solve returns the coinbase transaction and block header which result from
+// the passed extra nonces, time, and nonce submitted by a miner.
+func (j *job) solve(extraNonce1, extraNonce2 []byte, nTime, nonce uint32) (*wire.MsgTx, wire.BlockHeader) {
+	coinbaseTx := j.block.Transactions[0].Copy()
+	script := coinbaseTx.TxIn[0].SignatureScript
+	copy(script[j.extraNonceOffset:], extraNonce1)
+	copy(script[j.extraNonceOffset+len(extraNonce1):], extraNonce2)
+
+	header := j.block.Header
+	header.MerkleRoot = merkleRootFromBranch(coinbaseTx.TxHash(),
+		j.merkleBranch)
+	header.Timestamp = time.Unix(int64(nTime), 0)
+	header.Nonce = nonce
+	return coinbaseTx, header
+}
+
+// solvedBlock returns the full block for the passed coinbase transaction and header
+// which were returned by solve.
+func (j *job) solvedBlock(coinbaseTx *wire.MsgTx, header wire.BlockHeader) *wire.MsgBlock {
+	msgBlock := &wire.MsgBlock{
+		Header:       header,
+		Transactions: make([]*wire.MsgTx, 0, len(j.block.Transactions)),
+	}
+	msgBlock.Transactions = append(msgBlock.Transactions, coinbaseTx)
+	msgBlock.Transactions = append(msgBlock.Transactions,
+		j.block.Transactions[1:]...)
+	return msgBlock
+}
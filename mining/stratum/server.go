@@ -0,0 +1,404 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/mining"
+)
+
+const (
+	// extraNonce1Size is the size in bytes of the extra nonce the server
+	// assigns to each connection.
+	extraNonce1Size = 4
+
+	// extraNonce2Size is the size in bytes of the extra nonce miners are
+	// free to choose.
+	extraNonce2Size = 4
+
+	// maxJobs is the maximum number of jobs for the same previous block
+	// which are kept around to validate the shares submitted for them.
+	maxJobs = 16
+
+	// jobUpdateInterval is the interval at which the server checks whether
+	// a new job needs to be sent to the miners.
+	jobUpdateInterval = time.Second
+
+	// jobRefreshInterval is the minimum time between jobs for the same
+	// previous block which are generated to include new transactions.
+	jobRefreshInterval = time.Minute
+)
+
+// Config is a descriptor containing the Stratum server configuration.
+type Config struct {
+	// ChainParams identifies which chain parameters the Stratum server is
+	// associated with.
+	ChainParams *chaincfg.Params
+
+	// BlockTemplateGenerator identifies the instance to use in order to
+	// generate the block templates the jobs sent to miners are based on.
+	BlockTemplateGenerator *mining.BlkTmplGenerator
+
+	// MiningAddrs is a list of payment addresses to use for the generated
+	// blocks.  Each block template will randomly choose one of them.
+	MiningAddrs []bteutil.Address
+
+	// ProcessBlock defines the function to call with any solved blocks.
+	// It typically must run the provided block through the same set of
+	// rules and handling as any other block coming from the network.
+	ProcessBlock func(*bteutil.Block, blockchain.BehaviorFlags) (bool, error)
+
+	// ConnectedCount defines the function to use to obtain how many other
+	// peers the server is connected to.  No jobs are sent to miners when
+	// not connected to any peers since there would not be anyone to send
+	// any found blocks to.
+	ConnectedCount func() int32
+
+	// IsCurrent defines the function to use to obtain whether or not the
+	// block chain is current.  No jobs are sent to miners while the chain
+	// is not current since any solved blocks would be on a side chain and
+	// end up orphaned anyways.
+	IsCurrent func() bool
+
+	// Listeners defines a slice of listeners for which the Stratum server
+	// will take ownership of and accept connections.
+	Listeners []net.Listener
+
+	// Difficulty is the share difficulty new connections start with
+	// before it is adjusted to the rate at which they submit shares.
+	Difficulty float64
+
+	// MaxClients is the maximum number of miners which may be connected at
+	// the same time.
+	MaxClients int
+}
+
+// Server provides a Stratum version 1 server which allows external mining
+// software to mine blocks based on the templates of a block template
+// generator.  Each connection is assigned its own extra nonce so the work of
+// the connected miners never overlaps, and the share difficulty of each
+// connection is adjusted to the rate at which it submits shares.
+type Server struct {
+	started         int32
+	shutdown        int32
+	cfg             Config
+	g               *mining.BlkTmplGenerator
+	nextExtraNonce1 uint32
+	numClients      int32
+
+	jobMtx    sync.RWMutex
+	jobs      map[string]*job
+	jobOrder  []string
+	curJob    *job
+	nextJobID uint64
+
+	clientsMtx sync.Mutex
+	clients    map[*client]struct{}
+
+	submitBlockLock sync.Mutex
+	updateJob       chan struct{}
+	wg              sync.WaitGroup
+	quit            chan struct{}
+}
+
+// Start begins accepting connections from miners and generating the jobs sent
+// to them.
+func (s *Server) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting Stratum server")
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+
+	s.wg.Add(1)
+	go s.jobHandler()
+}
+
+// Stop gracefully shuts down the Stratum server by closing all listeners and
+// disconnecting all miners.
+func (s *Server) Stop() {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		log.Infof("Stratum server is already in the process of shutting " +
+			"down")
+		return
+	}
+
+	log.Warnf("Stratum server shutting down")
+	for _, listener := range s.cfg.Listeners {
+		if err := listener.Close(); err != nil {
+			log.Errorf("Problem shutting down Stratum: %v", err)
+		}
+	}
+	close(s.quit)
+
+	s.clientsMtx.Lock()
+	for c := range s.clients {
+		c.disconnect()
+	}
+	s.clientsMtx.Unlock()
+
+	s.wg.Wait()
+	log.Infof("Stratum server shutdown complete")
+}
+
+// listenHandler accepts connections from miners on the passed listener until
+// the server is shut down.  It must be run as a goroutine.
+func (s *Server) listenHandler(listener net.Listener) {
+	log.Infof("Stratum server listening on %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.shutdown) != 0 {
+				break
+			}
+			log.Errorf("Can't accept Stratum connection: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if int(atomic.LoadInt32(&s.numClients))+1 > s.cfg.MaxClients {
+			log.Infof("Max Stratum clients exceeded [%d] - "+
+				"disconnecting client %s", s.cfg.MaxClients,
+				conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		var extraNonce1 [extraNonce1Size]byte
+		binary.BigEndian.PutUint32(extraNonce1[:],
+			atomic.AddUint32(&s.nextExtraNonce1, 1))
+		c := newClient(s, conn, extraNonce1[:])
+
+		// The client is not added once the server is shutting down
+		// since it would not be disconnected.
+		s.clientsMtx.Lock()
+		if atomic.LoadInt32(&s.shutdown) != 0 {
+			s.clientsMtx.Unlock()
+			conn.Close()
+			break
+		}
+		s.clients[c] = struct{}{}
+		s.clientsMtx.Unlock()
+		atomic.AddInt32(&s.numClients, 1)
+
+		log.Debugf("New Stratum client %s", conn.RemoteAddr())
+		s.wg.Add(2)
+		go c.inHandler()
+		go c.outHandler()
+	}
+
+	s.wg.Done()
+	log.Tracef("Stratum listener done for %s", listener.Addr())
+}
+
+// removeClient removes the passed client from the clients the server sends
+// jobs to.
+func (s *Server) removeClient(c *client) {
+	s.clientsMtx.Lock()
+	delete(s.clients, c)
+	s.clientsMtx.Unlock()
+	atomic.AddInt32(&s.numClients, -1)
+}
+
+// currentJob returns the job which is currently being mined or nil if there is
+// none.
+//
+// This function is safe for concurrent access.
+func (s *Server) currentJob() *job {
+	s.jobMtx.RLock()
+	defer s.jobMtx.RUnlock()
+	return s.curJob
+}
+
+// lookupJob returns the job with the passed id or nil when it is not known,
+// which typically means it is stale.
+//
+// This function is safe for concurrent access.
+func (s *Server) lookupJob(id string) *job {
+	s.jobMtx.RLock()
+	defer s.jobMtx.RUnlock()
+	return s.jobs[id]
+}
+
+// jobHandler generates new jobs whenever a new block is connected to the main
+// chain or new transactions have been available for a while, and sends them
+// to the miners.  It also adjusts the share difficulty of the miners which
+// have not submitted enough shares to do so on their own.  It must be run as
+// a goroutine.
+func (s *Server) jobHandler() {
+	ticker := time.NewTicker(jobUpdateInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case <-ticker.C:
+			s.maybeUpdateJob()
+
+			s.clientsMtx.Lock()
+			for c := range s.clients {
+				c.maybeRetarget(time.Now())
+			}
+			s.clientsMtx.Unlock()
+
+		case <-s.updateJob:
+			s.maybeUpdateJob()
+
+		case <-s.quit:
+			break out
+		}
+	}
+
+	s.wg.Done()
+	log.Tracef("Stratum job handler done")
+}
+
+// maybeUpdateJob generates a new job and sends it to the miners when the
+// current job builds on a block which is no longer the best block, or when it
+// is older than the job refresh interval and new transactions are available.
+func (s *Server) maybeUpdateJob() {
+	// No point in mining when not connected to any peers or when the
+	// chain is not current since any solved blocks would not be relayed or
+	// would end up orphaned.
+	if s.cfg.ConnectedCount() == 0 || !s.cfg.IsCurrent() {
+		return
+	}
+
+	best := s.g.BestSnapshot()
+	lastTxUpdate := s.g.TxSource().LastUpdated()
+	curJob := s.currentJob()
+	cleanJobs := curJob == nil ||
+		!curJob.block.Header.PrevBlock.IsEqual(&best.Hash)
+	if !cleanJobs && (lastTxUpdate.Equal(curJob.lastTxUpdate) ||
+		time.Since(curJob.created) < jobRefreshInterval) {
+
+		return
+	}
+
+	// Choose a payment address at random and create a new block template
+	// paying to it.
+	rand.Seed(time.Now().UnixNano())
+	payToAddr := s.cfg.MiningAddrs[rand.Intn(len(s.cfg.MiningAddrs))]
+	template, err := s.g.NewBlockTemplate(payToAddr)
+	if err != nil {
+		log.Errorf("Failed to create new block template for Stratum: %v",
+			err)
+		return
+	}
+
+	s.jobMtx.Lock()
+	s.nextJobID++
+	id := fmt.Sprintf("%x", s.nextJobID)
+	j, err := newJob(id, template, mining.MinimumMedianTime(best),
+		lastTxUpdate)
+	if err != nil {
+		s.jobMtx.Unlock()
+		log.Errorf("Failed to create new Stratum job: %v", err)
+		return
+	}
+
+	// Jobs which build on a previous block that is no longer the best are
+	// stale, so they are all forgotten when a new block shows up.
+	// Otherwise only the oldest jobs are.
+	if cleanJobs {
+		s.jobs = make(map[string]*job)
+		s.jobOrder = s.jobOrder[:0]
+	}
+	s.jobs[id] = j
+	s.jobOrder = append(s.jobOrder, id)
+	if len(s.jobOrder) > maxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	s.curJob = j
+	s.jobMtx.Unlock()
+
+	log.Debugf("New Stratum job %s at height %d with %d transactions",
+		id, j.height, len(j.block.Transactions))
+
+	s.clientsMtx.Lock()
+	for c := range s.clients {
+		c.sendJob(j, cleanJobs)
+	}
+	s.clientsMtx.Unlock()
+}
+
+// submitBlock submits the passed block to network after ensuring it passes all
+// of the consensus validation rules.
+func (s *Server) submitBlock(block *bteutil.Block, worker string) bool {
+	s.submitBlockLock.Lock()
+	defer s.submitBlockLock.Unlock()
+
+	// Ensure the block is not stale since a new block could have shown up
+	// while the share was in flight.
+	msgBlock := block.MsgBlock()
+	if !msgBlock.Header.PrevBlock.IsEqual(&s.g.BestSnapshot().Hash) {
+		log.Debugf("Block submitted via Stratum with previous block %s "+
+			"is stale", msgBlock.Header.PrevBlock)
+		return false
+	}
+
+	// Process this block using the same rules as blocks coming from other
+	// nodes.  This will in turn relay it to the network like normal.
+	isOrphan, err := s.cfg.ProcessBlock(block, blockchain.BFNone)
+	if err != nil {
+		// Anything other than a rule violation is an unexpected error,
+		// so log that error as an internal error.
+		if _, ok := err.(blockchain.RuleError); !ok {
+			log.Errorf("Unexpected error while processing "+
+				"block submitted via Stratum: %v", err)
+			return false
+		}
+
+		log.Debugf("Block submitted via Stratum rejected: %v", err)
+		return false
+	}
+	if isOrphan {
+		log.Debugf("Block submitted via Stratum is an orphan")
+		return false
+	}
+
+	// The block was accepted, so send miners a job for the next block
+	// right away.
+	select {
+	case s.updateJob <- struct{}{}:
+	default:
+	}
+
+	coinbaseTx := msgBlock.Transactions[0].TxOut[0]
+	log.Infof("Block submitted via Stratum by %s accepted (hash %s, "+
+		"amount %v)", worker, block.Hash(), bteutil.Amount(coinbaseTx.Value))
+	return true
+}
+
+// New returns a new instance of a Stratum server for the provided
+// configuration.  Use Start to begin accepting connections from miners.
+// See the documentation for Server for more details.
+func New(cfg *Config) *Server {
+	return &Server{
+		cfg:             *cfg,
+		g:               cfg.BlockTemplateGenerator,
+		nextExtraNonce1: rand.Uint32(),
+		jobs:            make(map[string]*job),
+		clients:         make(map[*client]struct{}),
+		updateJob:       make(chan struct{}, 1),
+		quit:            make(chan struct{}),
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/mining"
+	"github.com/mraksoll4/bted/txscript"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// testTemplate returns a block template at the passed height with a coinbase
+// transaction followed by the passed number of transactions.
+func testTemplate(height int32, numTxns int) *mining.BlockTemplate {
+	coinbaseTx := wire.NewMsgTx(wire.TxVersion)
+	coinbaseTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		Sequence: wire.MaxTxInSequenceNum,
+	})
+	coinbaseTx.AddTxOut(wire.NewTxOut(5000000000,
+		[]byte{txscript.OP_TRUE}))
+
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   0x20000000,
+			PrevBlock: chainhash.DoubleHashH([]byte("prev")),
+			Timestamp: time.Unix(1600000000, 0),
+			Bits:      0x1f1fffff,
+		},
+		Transactions: []*wire.MsgTx{coinbaseTx},
+	}
+	for i := 0; i < numTxns; i++ {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{1},
+				uint32(i)),
+		})
+		tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+		msgBlock.Transactions = append(msgBlock.Transactions, tx)
+	}
+
+	return &mining.BlockTemplate{Block: msgBlock, Height: height}
+}
+
+// TestMerkleBranch ensures the merkle root calculated from the merkle branch
+// sent to miners matches the merkle root of the full block.
+func TestMerkleBranch(t *testing.T) {
+	t.Parallel()
+
+	for numTxns := 0; numTxns < 10; numTxns++ {
+		msgBlock := testTemplate(1, numTxns).Block
+		block := bteutil.NewBlock(msgBlock)
+		merkles := blockchain.BuildMerkleTreeStore(block.Transactions(),
+			false)
+		want := *merkles[len(merkles)-1]
+
+		txHashes := make([]chainhash.Hash, 0, numTxns)
+		for _, tx := range msgBlock.Transactions[1:] {
+			txHashes = append(txHashes, tx.TxHash())
+		}
+		branch := merkleBranch(txHashes)
+		got := merkleRootFromBranch(msgBlock.Transactions[0].TxHash(),
+			branch)
+		if got != want {
+			t.Errorf("merkle root with %d transactions: got %v, "+
+				"want %v", numTxns, got, want)
+		}
+	}
+}
+
+// TestJob ensures the coinbase transaction miners assemble from the parts of a
+// job matches the block which results from the shares they submit.
+func TestJob(t *testing.T) {
+	t.Parallel()
+
+	template := testTemplate(100000, 5)
+	j, err := newJob("1", template, time.Unix(1600000000, 0), time.Time{})
+	if err != nil {
+		t.Fatalf("newJob: unexpected error: %v", err)
+	}
+
+	extraNonce1 := []byte{0x01, 0x02, 0x03, 0x04}
+	extraNonce2 := []byte{0x05, 0x06, 0x07, 0x08}
+	var serialized []byte
+	serialized = append(serialized, j.coinbase1...)
+	serialized = append(serialized, extraNonce1...)
+	serialized = append(serialized, extraNonce2...)
+	serialized = append(serialized, j.coinbase2...)
+	var assembled wire.MsgTx
+	err = assembled.DeserializeNoWitness(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("DeserializeNoWitness: unexpected error: %v", err)
+	}
+
+	coinbaseTx, header := j.solve(extraNonce1, extraNonce2, 1600000001, 42)
+	if assembled.TxHash() != coinbaseTx.TxHash() {
+		t.Fatalf("assembled coinbase %v does not match solved coinbase "+
+			"%v", assembled.TxHash(), coinbaseTx.TxHash())
+	}
+	script := coinbaseTx.TxIn[0].SignatureScript
+	if !bytes.Contains(script, append(extraNonce1, extraNonce2...)) {
+		t.Fatalf("coinbase script %x does not contain the extra nonces",
+			script)
+	}
+	if header.Nonce != 42 || header.Timestamp.Unix() != 1600000001 {
+		t.Fatalf("unexpected nonce %d or time %v", header.Nonce,
+			header.Timestamp)
+	}
+
+	block := bteutil.NewBlock(j.solvedBlock(coinbaseTx, header))
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	if header.MerkleRoot != *merkles[len(merkles)-1] {
+		t.Fatalf("header merkle root %v does not match block merkle "+
+			"root %v", header.MerkleRoot, merkles[len(merkles)-1])
+	}
+
+	// The previous block hash is sent with the bytes of each word reversed
+	// and the other header fields as big endian hex.
+	params := j.notifyParams(true)
+	prevHash, _ := hex.DecodeString(params[1].(string))
+	if !bytes.Equal(swap32(prevHash), template.Block.Header.PrevBlock[:]) {
+		t.Fatalf("unexpected previous block hash %s", params[1])
+	}
+	if params[5] != "20000000" || params[6] != "1f1fffff" ||
+		params[7] != "5f5e1000" {
+
+		t.Fatalf("unexpected version, bits, or time %v %v %v",
+			params[5], params[6], params[7])
+	}
+
+	if !j.addShare(extraNonce1, extraNonce2, 1600000001, 42) {
+		t.Fatal("addShare: new share reported as duplicate")
+	}
+	if j.addShare(extraNonce1, extraNonce2, 1600000001, 42) {
+		t.Fatal("addShare: duplicate share not detected")
+	}
+}
+
+// TestDifficultyToTarget ensures share difficulties are converted to and from
+// targets as expected.
+func TestDifficultyToTarget(t *testing.T) {
+	t.Parallel()
+
+	if target := difficultyToTarget(1); target.Cmp(diff1Target) != 0 {
+		t.Fatalf("unexpected target for difficulty 1: %x", target)
+	}
+	if target := difficultyToTarget(1e-30); target.Cmp(maxTarget) != 0 {
+		t.Fatalf("unexpected target for a tiny difficulty: %x", target)
+	}
+	for _, difficulty := range []float64{0.001, 0.5, 1, 16, 12345.678} {
+		got := targetToDifficulty(difficultyToTarget(difficulty))
+		if math.Abs(got-difficulty)/difficulty > 1e-9 {
+			t.Errorf("difficulty %v converted to %v", difficulty,
+				got)
+		}
+	}
+}
+
+// TestRetargetDifficulty ensures share difficulties are adjusted to the rate
+// at which shares are submitted.
+func TestRetargetDifficulty(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		difficulty float64
+		numShares  int
+		elapsed    time.Duration
+		want       float64
+	}{{
+		name:       "on target",
+		difficulty: 8,
+		numShares:  6,
+		elapsed:    vardiffRetargetTime,
+		want:       8,
+	}, {
+		name:       "within variance",
+		difficulty: 8,
+		numShares:  7,
+		elapsed:    vardiffRetargetTime,
+		want:       8,
+	}, {
+		name:       "twice as fast",
+		difficulty: 8,
+		numShares:  12,
+		elapsed:    vardiffRetargetTime,
+		want:       16,
+	}, {
+		name:       "far too fast",
+		difficulty: 8,
+		numShares:  12,
+		elapsed:    time.Second,
+		want:       32,
+	}, {
+		name:       "half as fast",
+		difficulty: 8,
+		numShares:  3,
+		elapsed:    vardiffRetargetTime,
+		want:       4,
+	}, {
+		name:       "no shares",
+		difficulty: 8,
+		numShares:  0,
+		elapsed:    vardiffRetargetTime,
+		want:       2,
+	}, {
+		name:       "minimum difficulty",
+		difficulty: minDifficulty,
+		numShares:  0,
+		elapsed:    vardiffRetargetTime,
+		want:       minDifficulty,
+	}}
+
+	for _, test := range tests {
+		got := retargetDifficulty(test.difficulty, test.numShares,
+			test.elapsed)
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestSubmit ensures shares submitted over a connection are validated.
+func TestSubmit(t *testing.T) {
+	t.Parallel()
+
+	// Use a difficulty which any share meets and a block target which no
+	// share does.
+	s := New(&Config{
+		ChainParams: &chaincfg.RegressionNetParams,
+		ProcessBlock: func(*bteutil.Block, blockchain.BehaviorFlags) (bool, error) {
+			t.Error("unexpected block submission")
+			return false, nil
+		},
+		Difficulty: 1e-30,
+		MaxClients: 1,
+	})
+	template := testTemplate(1, 1)
+	template.Block.Header.Bits = 0x03000001
+	j, err := newJob("1", template, time.Unix(1600000000, 0), time.Time{})
+	if err != nil {
+		t.Fatalf("newJob: unexpected error: %v", err)
+	}
+	s.jobs[j.id] = j
+	s.curJob = j
+
+	serverConn, clientConn := net.Pipe()
+	c := newClient(s, serverConn, []byte{0, 0, 0, 1})
+	s.clients[c] = struct{}{}
+	s.wg.Add(2)
+	go c.inHandler()
+	go c.outHandler()
+	defer s.Stop()
+
+	reader := bufio.NewReader(clientConn)
+	call := func(method string, params ...interface{}) map[string]interface{} {
+		t.Helper()
+		req, _ := json.Marshal(map[string]interface{}{
+			"id":     1,
+			"method": method,
+			"params": params,
+		})
+		if _, err := clientConn.Write(append(req, '\n')); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(line, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			// Skip notifications.
+			if msg["id"] != nil {
+				return msg
+			}
+		}
+	}
+	errorCode := func(msg map[string]interface{}) float64 {
+		errArr, ok := msg["error"].([]interface{})
+		if !ok {
+			return 0
+		}
+		return errArr[0].(float64)
+	}
+
+	submit := []interface{}{"worker", "1", "00000000", "5f5e1001",
+		"0000002a"}
+	if msg := call("mining.submit", submit...); errorCode(msg) != 25 {
+		t.Fatalf("submit before subscribing: unexpected result %v", msg)
+	}
+	call("mining.subscribe")
+	if msg := call("mining.submit", submit...); errorCode(msg) != 24 {
+		t.Fatalf("submit before authorizing: unexpected result %v", msg)
+	}
+	if msg := call("mining.authorize", "worker", "x"); msg["result"] != true {
+		t.Fatalf("authorize: unexpected result %v", msg)
+	}
+	if msg := call("mining.submit", submit...); msg["result"] != true {
+		t.Fatalf("submit: unexpected result %v", msg)
+	}
+	if msg := call("mining.submit", submit...); errorCode(msg) != 22 {
+		t.Fatalf("duplicate submit: unexpected result %v", msg)
+	}
+	stale := []interface{}{"worker", "2", "00000000", "5f5e1001",
+		"0000002a"}
+	if msg := call("mining.submit", stale...); errorCode(msg) != 21 {
+		t.Fatalf("stale submit: unexpected result %v", msg)
+	}
+	early := []interface{}{"worker", "1", "00000000", "5f5e0fff",
+		"0000002a"}
+	if msg := call("mining.submit", early...); errorCode(msg) != 20 {
+		t.Fatalf("early submit: unexpected result %v", msg)
+	}
+}
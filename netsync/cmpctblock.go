@@ -0,0 +1,244 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	peerpkg "github.com/mraksoll4/bted/peer"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// checkReconstructedBlock ensures the transactions of a block reconstructed
+// from a compact block are the ones committed to by its header.  Short
+// transaction ids can collide, so a transaction from the mempool may have been
+// used in place of the one which is actually part of the block.  The full block
+// must be requested instead in that case rather than rejecting the block.
+func checkReconstructedBlock(block *bteutil.Block) error {
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	merkleRoot := merkles[len(merkles)-1]
+	if !block.MsgBlock().Header.MerkleRoot.IsEqual(merkleRoot) {
+		return fmt.Errorf("merkle root mismatch (calculated %v)",
+			merkleRoot)
+	}
+
+	return blockchain.ValidateWitnessCommitment(block)
+}
+
+// requestFullBlock requests the full block with the passed hash from the peer
+// after a compact block it sent could not be used to reconstruct the block.
+func (sm *SyncManager) requestFullBlock(peer *peerpkg.Peer, state *peerSyncState, hash *chainhash.Hash) {
+	limitAdd(sm.requestedBlocks, *hash, maxRequestedBlocks)
+	limitAdd(state.requestedBlocks, *hash, maxRequestedBlocks)
+
+	iv := wire.NewInvVect(wire.InvTypeBlock, hash)
+	if peer.IsWitnessEnabled() {
+		iv.Type = wire.InvTypeWitnessBlock
+	}
+	gdmsg := wire.NewMsgGetDataSizeHint(1)
+	gdmsg.AddInvVect(iv)
+	peer.QueueMessage(gdmsg, nil)
+}
+
+// processReconstructedBlock processes a block which was reconstructed from a
+// compact block sent by the peer the same way as a full block sent by it.  The
+// full block is requested instead when the reconstructed block does not match
+// its header.
+func (sm *SyncManager) processReconstructedBlock(peer *peerpkg.Peer, state *peerSyncState, msgBlock *wire.MsgBlock) {
+	block := bteutil.NewBlock(msgBlock)
+	if err := checkReconstructedBlock(block); err != nil {
+		log.Debugf("Unable to reconstruct block %v from compact block "+
+			"sent by %s: %v -- requesting full block", block.Hash(),
+			peer, err)
+		sm.requestFullBlock(peer, state, block.Hash())
+		return
+	}
+
+	sm.handleBlockMsg(&blockMsg{block: block, peer: peer})
+}
+
+// handleCmpctBlockMsg handles cmpctblock messages from all peers.  The block is
+// reconstructed from the transactions in the mempool, and any transactions
+// which are missing are requested from the peer with a getblocktxn message.
+func (sm *SyncManager) handleCmpctBlockMsg(cmsg *cmpctBlockMsg) {
+	peer := cmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received cmpctblock message from unknown peer %s",
+			peer)
+		return
+	}
+
+	msg := cmsg.cmpctBlock
+	blockHash := msg.Header.BlockHash()
+	peer.AddKnownInventory(wire.NewInvVect(wire.InvTypeBlock, &blockHash))
+
+	// Compact blocks which weren't requested are only accepted from the
+	// peers which were selected to send them in high-bandwidth mode.
+	// Ignore them unless the chain is current since they would most likely
+	// be orphans otherwise.
+	_, requested := state.requestedBlocks[blockHash]
+	if !requested {
+		if !sm.isHighBandwidthPeer(peer) {
+			log.Debugf("Ignoring unsolicited compact block %v from "+
+				"%s", blockHash, peer)
+			return
+		}
+		if sm.headersFirstMode || !sm.current() {
+			return
+		}
+	}
+
+	// Reject the block before doing any further work when its header does
+	// not carry the proof of work it claims.
+	err := blockchain.CheckHeaderProofOfWork(&msg.Header,
+		sm.chainParams.PowLimit)
+	if err != nil {
+		log.Warnf("Received compact block %v with invalid header from "+
+			"peer %s: %v -- disconnecting", blockHash, peer.Addr(),
+			err)
+		peer.Disconnect()
+		return
+	}
+
+	// Nothing more to do when the block is already known.
+	haveBlock, err := sm.chain.HaveBlock(&blockHash)
+	if err != nil {
+		log.Warnf("Unexpected failure when checking for existing "+
+			"block %v: %v", blockHash, err)
+		return
+	}
+	if haveBlock {
+		delete(state.requestedBlocks, blockHash)
+		delete(sm.requestedBlocks, blockHash)
+		return
+	}
+
+	// Blocks which don't extend a known block are requested in full so
+	// they are handled like any other orphan.
+	haveParent, err := sm.chain.HaveBlock(&msg.Header.PrevBlock)
+	if err != nil || !haveParent ||
+		sm.chain.IsKnownOrphan(&msg.Header.PrevBlock) {
+
+		sm.requestFullBlock(peer, state, &blockHash)
+		return
+	}
+
+	msgBlock, missing, err := sm.txMemPool.ReconstructCmpctBlock(msg)
+	if err != nil {
+		log.Debugf("Unable to reconstruct block %v from compact block "+
+			"sent by %s: %v -- requesting full block", blockHash,
+			peer, err)
+		sm.requestFullBlock(peer, state, &blockHash)
+		return
+	}
+	limitAdd(sm.requestedBlocks, blockHash, maxRequestedBlocks)
+	limitAdd(state.requestedBlocks, blockHash, maxRequestedBlocks)
+
+	if len(missing) == 0 {
+		sm.processReconstructedBlock(peer, state, msgBlock)
+		return
+	}
+
+	// Only a single block per peer waits on missing transactions, so fall
+	// back to requesting the full block for any previous one.
+	if pending := state.pendingCmpctBlock; pending != nil &&
+		pending.hash != blockHash {
+
+		sm.requestFullBlock(peer, state, &pending.hash)
+	}
+
+	log.Debugf("Requesting %d of %d transactions of block %v from %s",
+		len(missing), len(msgBlock.Transactions), blockHash, peer)
+	state.pendingCmpctBlock = &partialBlock{
+		hash:    blockHash,
+		block:   msgBlock,
+		missing: missing,
+	}
+	peer.QueueMessage(wire.NewMsgGetBlockTxn(&blockHash, missing), nil)
+}
+
+// handleBlockTxnMsg handles blocktxn messages from all peers.  The transactions
+// fill in those missing from the block which is being reconstructed from a
+// compact block sent by the peer.
+func (sm *SyncManager) handleBlockTxnMsg(bmsg *blockTxnMsg) {
+	peer := bmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received blocktxn message from unknown peer %s", peer)
+		return
+	}
+
+	msg := bmsg.blockTxn
+	pending := state.pendingCmpctBlock
+	if pending == nil || pending.hash != msg.BlockHash {
+		log.Debugf("Ignoring unrequested blocktxn message for block %v "+
+			"from %s", msg.BlockHash, peer)
+		return
+	}
+	state.pendingCmpctBlock = nil
+
+	// The peer is misbehaving if it doesn't send exactly the requested
+	// transactions.
+	if len(msg.Transactions) != len(pending.missing) {
+		log.Warnf("Got %d transactions instead of the %d requested for "+
+			"block %v from %s -- disconnecting",
+			len(msg.Transactions), len(pending.missing),
+			msg.BlockHash, peer.Addr())
+		peer.Disconnect()
+		return
+	}
+	for i, index := range pending.missing {
+		pending.block.Transactions[index] = msg.Transactions[i]
+	}
+
+	sm.processReconstructedBlock(peer, state, pending.block)
+}
+
+// isHighBandwidthPeer returns whether the passed peer was selected to send new
+// blocks as compact blocks right away.
+func (sm *SyncManager) isHighBandwidthPeer(peer *peerpkg.Peer) bool {
+	for _, hbPeer := range sm.highBandwidthPeers {
+		if hbPeer == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// maybeSetHighBandwidthPeer asks the passed peer, which just delivered a new
+// block, to send new blocks as compact blocks right away rather than
+// announcing them first.  Up to maxHighBandwidthPeers peers are selected this
+// way, and the least recently selected one is asked to go back to announcing
+// new blocks when another one is selected.
+func (sm *SyncManager) maybeSetHighBandwidthPeer(peer *peerpkg.Peer) {
+	if !peer.WantsCmpctBlocks() {
+		return
+	}
+
+	// Move the peer to the end when it is already selected.
+	for i, hbPeer := range sm.highBandwidthPeers {
+		if hbPeer == peer {
+			copy(sm.highBandwidthPeers[i:], sm.highBandwidthPeers[i+1:])
+			sm.highBandwidthPeers[len(sm.highBandwidthPeers)-1] = peer
+			return
+		}
+	}
+
+	if len(sm.highBandwidthPeers) >= maxHighBandwidthPeers {
+		evicted := sm.highBandwidthPeers[0]
+		evicted.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+		sm.highBandwidthPeers = sm.highBandwidthPeers[1:]
+	}
+
+	log.Debugf("Requesting high-bandwidth compact blocks from %s", peer)
+	peer.QueueMessage(wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion),
+		nil)
+	sm.highBandwidthPeers = append(sm.highBandwidthPeers, peer)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	_ "github.com/mraksoll4/bted/database/ffldb"
+	peerpkg "github.com/mraksoll4/bted/peer"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// fixedTimeSource implements blockchain.MedianTimeSource with a fixed adjusted
+// time.
+type fixedTimeSource struct {
+	time.Time
+}
+
+// AdjustedTime returns the fixed time.  It is part of the
+// blockchain.MedianTimeSource interface.
+func (f fixedTimeSource) AdjustedTime() time.Time { return f.Time }
+
+// AddTimeSample ignores the passed time sample.  It is part of the
+// blockchain.MedianTimeSource interface.
+func (f fixedTimeSource) AddTimeSample(string, time.Time) {}
+
+// Offset always returns zero.  It is part of the blockchain.MedianTimeSource
+// interface.
+func (f fixedTimeSource) Offset() time.Duration { return 0 }
+
+// newTestSyncManager returns a sync manager backed by a new regression test
+// chain which considers itself current.  The returned function must be called
+// to remove the chain database once the test is done.
+func newTestSyncManager(t *testing.T) (*SyncManager, func()) {
+	t.Helper()
+
+	// The package logger is not set up by the tests.
+	DisableLog()
+
+	dir, err := ioutil.TempDir("", "netsync")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+
+	params := chaincfg.RegressionNetParams
+	db, err := database.Create("ffldb", filepath.Join(dir, "db"), params.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	// The chain is only current when its tip is recent, so the clock is
+	// set shortly after the genesis block.
+	timeSource := fixedTimeSource{
+		params.GenesisBlock.Header.Timestamp.Add(time.Hour),
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  timeSource,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	sm := &SyncManager{
+		chain:           chain,
+		chainParams:     &params,
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
+	}
+	return sm, teardown
+}
+
+// addTestPeer adds a new unconnected peer to the passed sync manager.
+func addTestPeer(sm *SyncManager) *peerpkg.Peer {
+	peer := peerpkg.NewInboundPeer(&peerpkg.Config{})
+	sm.peerStates[peer] = &peerSyncState{
+		requestedTxns:   make(map[chainhash.Hash]struct{}),
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+	}
+	return peer
+}
+
+// testCmpctBlock returns a compact block whose parent is unknown and whose
+// header either satisfies or fails the proof of work check of the regression
+// test network.
+func testCmpctBlock(t *testing.T, params *chaincfg.Params, validPoW bool) *wire.MsgCmpctBlock {
+	t.Helper()
+
+	msg := &wire.MsgCmpctBlock{
+		Header: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: chainhash.Hash{0x01},
+			Timestamp: params.GenesisBlock.Header.Timestamp.Add(
+				time.Minute),
+			Bits: params.PowLimitBits,
+		},
+	}
+	for {
+		err := blockchain.CheckHeaderProofOfWork(&msg.Header,
+			params.PowLimit)
+		if (err == nil) == validPoW {
+			return msg
+		}
+		msg.Header.Nonce++
+	}
+}
+
+// isDisconnected returns whether the passed peer was asked to disconnect.
+func isDisconnected(peer *peerpkg.Peer) bool {
+	done := make(chan struct{})
+	go func() {
+		peer.WaitForDisconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Millisecond * 100):
+		return false
+	}
+}
+
+// TestHandleCmpctBlockMsg ensures unsolicited compact blocks are only accepted
+// from high-bandwidth peers and compact blocks with an invalid header are
+// rejected before they are reconstructed.
+func TestHandleCmpctBlockMsg(t *testing.T) {
+	sm, teardown := newTestSyncManager(t)
+	defer teardown()
+
+	tests := []struct {
+		name          string
+		highBandwidth bool
+		requested     bool
+		validPoW      bool
+		wantRequest   bool
+		wantDisconn   bool
+	}{{
+		name:     "unsolicited from low-bandwidth peer",
+		validPoW: true,
+	}, {
+		name:          "unsolicited from high-bandwidth peer",
+		highBandwidth: true,
+		validPoW:      true,
+		wantRequest:   true,
+	}, {
+		name:        "requested from low-bandwidth peer",
+		requested:   true,
+		validPoW:    true,
+		wantRequest: true,
+	}, {
+		name:          "invalid proof of work",
+		highBandwidth: true,
+		wantDisconn:   true,
+	}}
+
+	for _, test := range tests {
+		peer := addTestPeer(sm)
+		defer peer.Disconnect()
+		if test.highBandwidth {
+			sm.highBandwidthPeers = append(sm.highBandwidthPeers, peer)
+		}
+
+		msg := testCmpctBlock(t, sm.chainParams, test.validPoW)
+		blockHash := msg.Header.BlockHash()
+		state := sm.peerStates[peer]
+		if test.requested {
+			state.requestedBlocks[blockHash] = struct{}{}
+		}
+
+		sm.handleCmpctBlockMsg(&cmpctBlockMsg{cmpctBlock: msg, peer: peer})
+
+		// Compact blocks whose parent is unknown are requested in full
+		// once they are accepted.
+		_, requested := sm.requestedBlocks[blockHash]
+		if requested != test.wantRequest {
+			t.Errorf("%s: unexpected requested state -- got %v, "+
+				"want %v", test.name, requested, test.wantRequest)
+		}
+		if disconn := isDisconnected(peer); disconn != test.wantDisconn {
+			t.Errorf("%s: unexpected disconnect state -- got %v, "+
+				"want %v", test.name, disconn, test.wantDisconn)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// verifyHeadersPoW checks that the passed headers connect to the header with
+// the provided previous hash and to each other and checks their proof of work
+// across a pool of worker goroutines sized to GOMAXPROCS.  It returns the block
+// hash of each header in the same order as the provided headers.
+//
+// Each header requires a memory-hard yespower evaluation, so verifying them in
+// parallel avoids pinning headers-first sync to a single core.  The first
+// header is checked to connect to the previous header before any work is
+// done.  Since the block hash is the proof of work hash, the remaining headers
+// can only be checked to connect once the header before them is hashed, so
+// each worker checks the header after the one it hashed.  An error is
+// returned for the first header, in order, that was found to fail either
+// check.  Workers stop picking up new headers as soon as any failure is
+// detected since the entire batch is rejected in that case.
+func verifyHeadersPoW(headers []*wire.BlockHeader, prevHash *chainhash.Hash, powLimit *big.Int) ([]chainhash.Hash, error) {
+	if len(headers) > 0 && headers[0].PrevBlock != *prevHash {
+		return nil, fmt.Errorf("header 0 in batch does not connect to "+
+			"the previous header %v", prevHash)
+	}
+
+	numWorkers := runtime.GOMAXPROCS(0)
+	if numWorkers > len(headers) {
+		numWorkers = len(headers)
+	}
+
+	// The connection errors are kept apart from the proof of work errors
+	// since the former are set by the worker which hashed the previous
+	// header.
+	hashes := make([]chainhash.Hash, len(headers))
+	errs := make([]error, len(headers))
+	linkErrs := make([]error, len(headers))
+	var nextIdx, failed int32
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				idx := int(atomic.AddInt32(&nextIdx, 1) - 1)
+				if idx >= len(headers) {
+					return
+				}
+
+				header := headers[idx]
+				err := blockchain.CheckHeaderProofOfWork(header, powLimit)
+				if err != nil {
+					errs[idx] = err
+					atomic.StoreInt32(&failed, 1)
+					return
+				}
+
+				// The proof of work hash doubles as the block
+				// identifier and has just been memoized by the
+				// check above, so this does not hash again.
+				hashes[idx] = header.BlockHash()
+
+				// Ensure the next header connects to this one.
+				next := idx + 1
+				if next < len(headers) &&
+					headers[next].PrevBlock != hashes[idx] {
+
+					linkErrs[next] = fmt.Errorf("previous block "+
+						"%v does not match header %d",
+						headers[next].PrevBlock, idx)
+					atomic.StoreInt32(&failed, 1)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for idx := range headers {
+		if err := linkErrs[idx]; err != nil {
+			return nil, fmt.Errorf("header %d in batch does not "+
+				"connect to the previous header: %v", idx, err)
+		}
+		if err := errs[idx]; err != nil {
+			return nil, fmt.Errorf("header %d in batch failed proof of "+
+				"work check: %v", idx, err)
+		}
+	}
+	return hashes, nil
+}
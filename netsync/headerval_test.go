@@ -0,0 +1,110 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// solveTestHeader increments the nonce of the passed header until it either
+// satisfies or fails the proof of work check of the regression test network.
+func solveTestHeader(header *wire.BlockHeader, params *chaincfg.Params, validPoW bool) {
+	for {
+		err := blockchain.CheckHeaderProofOfWork(header, params.PowLimit)
+		if (err == nil) == validPoW {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// testHeaders returns a chain of the passed number of headers with a valid
+// proof of work on top of the genesis block of the regression test network.
+func testHeaders(params *chaincfg.Params, n int) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, n)
+	prevHash := *params.GenesisHash
+	timestamp := params.GenesisBlock.Header.Timestamp
+	for i := range headers {
+		timestamp = timestamp.Add(time.Minute)
+		headers[i] = &wire.BlockHeader{
+			Version:   4,
+			PrevBlock: prevHash,
+			Timestamp: timestamp,
+			Bits:      params.PowLimitBits,
+		}
+		solveTestHeader(headers[i], params, true)
+		prevHash = headers[i].BlockHash()
+	}
+	return headers
+}
+
+// TestVerifyHeadersPoW ensures the hashes of a batch of headers are returned
+// in order and the first header in the batch which fails to connect or fails
+// the proof of work check is reported.
+func TestVerifyHeadersPoW(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	const numHeaders = 8
+
+	// The hashes of a valid batch are in the same order as the headers.
+	headers := testHeaders(params, numHeaders)
+	hashes, err := verifyHeadersPoW(headers, params.GenesisHash,
+		params.PowLimit)
+	if err != nil {
+		t.Fatalf("verifyHeadersPoW: unexpected error: %v", err)
+	}
+	if len(hashes) != numHeaders {
+		t.Fatalf("verifyHeadersPoW: got %d hashes, want %d",
+			len(hashes), numHeaders)
+	}
+	for i, header := range headers {
+		if hashes[i] != header.BlockHash() {
+			t.Fatalf("verifyHeadersPoW: hash %d is %v, want %v", i,
+				hashes[i], header.BlockHash())
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(headers []*wire.BlockHeader)
+		wantErr string
+	}{{
+		name: "first header does not connect",
+		modify: func(headers []*wire.BlockHeader) {
+			headers[0].PrevBlock = chainhash.Hash{0x01}
+		},
+		wantErr: "header 0 in batch does not connect",
+	}, {
+		name: "header in the middle does not connect",
+		modify: func(headers []*wire.BlockHeader) {
+			headers[4].PrevBlock = chainhash.Hash{0x01}
+			solveTestHeader(headers[4], params, true)
+		},
+		wantErr: "header 4 in batch does not connect",
+	}, {
+		name: "header in the middle fails proof of work",
+		modify: func(headers []*wire.BlockHeader) {
+			solveTestHeader(headers[4], params, false)
+		},
+		wantErr: "header 4 in batch failed proof of work",
+	}}
+
+	for _, test := range tests {
+		headers := testHeaders(params, numHeaders)
+		test.modify(headers)
+		_, err := verifyHeadersPoW(headers, params.GenesisHash,
+			params.PowLimit)
+		if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+			t.Errorf("%s: unexpected error -- got %v, want %q",
+				test.name, err, test.wantErr)
+		}
+	}
+}
@@ -32,6 +32,11 @@ const (
 	// hashes to store in memory.
 	maxRejectedTxns = 1000
 
+	// maxLowFeeTxns is the maximum number of transactions which were
+	// rejected for paying insufficient fees to keep in memory so they can
+	// still be accepted along with a child paying for them.
+	maxLowFeeTxns = 100
+
 	// maxRequestedBlocks is the maximum number of requested block
 	// hashes to store in memory.
 	maxRequestedBlocks = wire.MaxInvPerMsg
@@ -47,6 +52,16 @@ const (
 	// stallSampleInterval the interval at which we will check to see if our
 	// sync has stalled.
 	stallSampleInterval = 30 * time.Second
+
+	// maxHighBandwidthPeers is the maximum number of peers which are asked
+	// to send new blocks as compact blocks right away rather than
+	// announcing them first (BIP0152).
+	maxHighBandwidthPeers = 3
+
+	// maxSnapshotBlocksInFlight is the maximum number of blocks leading up
+	// to the block the utxo set was bootstrapped from which are requested
+	// at a time for the background validation of the utxo snapshot.
+	maxSnapshotBlocksInFlight = 16
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -65,6 +80,22 @@ type blockMsg struct {
 	reply chan struct{}
 }
 
+// cmpctBlockMsg packages a bitcoin cmpctblock message and the peer it came
+// from together so the block handler has access to that information.
+type cmpctBlockMsg struct {
+	cmpctBlock *wire.MsgCmpctBlock
+	peer       *peerpkg.Peer
+	reply      chan struct{}
+}
+
+// blockTxnMsg packages a bitcoin blocktxn message and the peer it came from
+// together so the block handler has access to that information.
+type blockTxnMsg struct {
+	blockTxn *wire.MsgBlockTxn
+	peer     *peerpkg.Peer
+	reply    chan struct{}
+}
+
 // invMsg packages a bitcoin inv message and the peer it came from together
 // so the block handler has access to that information.
 type invMsg struct {
@@ -145,6 +176,14 @@ type headerNode struct {
 	hash   *chainhash.Hash
 }
 
+// partialBlock houses a block reconstructed from a compact block which is
+// missing the transactions at the listed indexes.
+type partialBlock struct {
+	hash    chainhash.Hash
+	block   *wire.MsgBlock
+	missing []uint32
+}
+
 // peerSyncState stores additional information that the SyncManager tracks
 // about a peer.
 type peerSyncState struct {
@@ -152,6 +191,11 @@ type peerSyncState struct {
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
+
+	// pendingCmpctBlock is the block reconstructed from a compact block
+	// sent by the peer which is waiting on the missing transactions
+	// requested with a getblocktxn message.
+	pendingCmpctBlock *partialBlock
 }
 
 // limitAdd is a helper function for maps that require a maximum limit by
@@ -192,12 +236,17 @@ type SyncManager struct {
 
 	// These fields should only be accessed from the blockHandler thread
 	rejectedTxns     map[chainhash.Hash]struct{}
+	lowFeeTxns       map[chainhash.Hash]*bteutil.Tx
 	requestedTxns    map[chainhash.Hash]struct{}
 	requestedBlocks  map[chainhash.Hash]struct{}
 	syncPeer         *peerpkg.Peer
 	peerStates       map[*peerpkg.Peer]*peerSyncState
 	lastProgressTime time.Time
 
+	// highBandwidthPeers are the peers which were asked to send new blocks
+	// as compact blocks right away, from least to most recently selected.
+	highBandwidthPeers []*peerpkg.Peer
+
 	// The following fields are used for headers-first mode.
 	headersFirstMode bool
 	headerList       *list.List
@@ -436,6 +485,17 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
 	}
 
+	// Signal support for compact blocks to peers which can send them with
+	// witness data.  They may send blocks we request as compact blocks
+	// from now on, but should only announce new blocks with them once
+	// asked to in maybeSetHighBandwidthPeer.
+	if peer.ProtocolVersion() >= wire.CompactBlocksVersion &&
+		peer.IsWitnessEnabled() {
+
+		peer.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+	}
+
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
 		sm.startSync()
@@ -513,6 +573,14 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 
 	sm.clearRequestedState(state)
 
+	for i, hbPeer := range sm.highBandwidthPeers {
+		if hbPeer == peer {
+			sm.highBandwidthPeers = append(sm.highBandwidthPeers[:i],
+				sm.highBandwidthPeers[i+1:]...)
+			break
+		}
+	}
+
 	if peer == sm.syncPeer {
 		// Update the sync peer. The server has already disconnected the
 		// peer before signaling to the sync manager.
@@ -581,10 +649,23 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	// interoperability.
 	txHash := tmsg.tx.Hash()
 
+	// Remove transaction from request maps. Either the mempool/chain
+	// already knows about it and as such we shouldn't have any more
+	// instances of trying to fetch it, or we failed to insert and thus
+	// we'll retry next time we get an inv.
+	//
+	// Transactions requested from peers which negotiated wtxid relay were
+	// requested by their witness hash.
+	wtxid := tmsg.tx.WitnessHash()
+	delete(state.requestedTxns, *txHash)
+	delete(sm.requestedTxns, *txHash)
+	delete(state.requestedTxns, *wtxid)
+	delete(sm.requestedTxns, *wtxid)
+
 	// Ignore transactions that we have already rejected.  Do not
 	// send a reject message here because if the transaction was already
 	// rejected, the transaction was unsolicited.
-	if _, exists = sm.rejectedTxns[*txHash]; exists {
+	if _, exists = sm.rejectedTxns[*wtxid]; exists {
 		log.Debugf("Ignoring unsolicited previously rejected "+
 			"transaction %v from %s", txHash, peer)
 		return
@@ -595,17 +676,15 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	acceptedTxs, err := sm.txMemPool.ProcessTransaction(tmsg.tx,
 		true, true, mempool.Tag(peer.ID()))
 
-	// Remove transaction from request maps. Either the mempool/chain
-	// already knows about it and as such we shouldn't have any more
-	// instances of trying to fetch it, or we failed to insert and thus
-	// we'll retry next time we get an inv.
-	delete(state.requestedTxns, *txHash)
-	delete(sm.requestedTxns, *txHash)
-
 	if err != nil {
 		// Do not request this transaction again until a new block
-		// has been processed.
-		limitAdd(sm.rejectedTxns, *txHash, maxRejectedTxns)
+		// has been processed.  Rejections are recorded by witness
+		// hash, which is the same as the hash for transactions
+		// without witness data, since a transaction with witness
+		// data may only have been rejected because its witness was
+		// malleated.  Recording its hash would prevent the valid
+		// version from being accepted.
+		limitAdd(sm.rejectedTxns, *wtxid, maxRejectedTxns)
 
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
@@ -623,12 +702,71 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
 		peer.PushRejectMsg(wire.CmdTx, code, reason, txHash, false)
+
+		// Keep transactions which only lack sufficient fees around
+		// so a child paying for them can still get them accepted.
+		if code == wire.RejectInsufficientFee {
+			if len(sm.lowFeeTxns)+1 > maxLowFeeTxns {
+				for hash := range sm.lowFeeTxns {
+					delete(sm.lowFeeTxns, hash)
+					break
+				}
+			}
+			sm.lowFeeTxns[*txHash] = tmsg.tx
+		}
 		return
 	}
 
+	// The transaction is an orphan when nothing was accepted, so attempt
+	// to accept it along with any of its parents which were rejected for
+	// paying insufficient fees.
+	if len(acceptedTxs) == 0 {
+		acceptedTxs = sm.maybeAcceptPackage(tmsg.tx, peer)
+	}
+
 	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 }
 
+// maybeAcceptPackage attempts to accept the passed orphan transaction into the
+// memory pool as a package along with its parents which were rejected for
+// paying insufficient fees on their own.  This allows a child to pay for its
+// parents without requiring any package relay support from peers.  It returns
+// the transactions which were accepted as a result.
+func (sm *SyncManager) maybeAcceptPackage(tx *bteutil.Tx, peer *peerpkg.Peer) []*mempool.TxDesc {
+	var pkg []*bteutil.Tx
+	for _, txIn := range tx.MsgTx().TxIn {
+		parent, ok := sm.lowFeeTxns[txIn.PreviousOutPoint.Hash]
+		if !ok {
+			continue
+		}
+		delete(sm.lowFeeTxns, *parent.Hash())
+		pkg = append(pkg, parent)
+	}
+	if len(pkg) == 0 {
+		return nil
+	}
+	pkg = append(pkg, tx)
+
+	result, err := sm.txMemPool.ProcessPackage(pkg)
+	if err != nil {
+		if _, ok := err.(mempool.RuleError); ok {
+			log.Debugf("Rejected package for transaction %v from "+
+				"%s: %v", tx.Hash(), peer, err)
+		} else {
+			log.Errorf("Failed to process package for transaction "+
+				"%v: %v", tx.Hash(), err)
+		}
+		return nil
+	}
+
+	// The parents may be requested again now that they were accepted.
+	for _, parent := range pkg[:len(pkg)-1] {
+		delete(sm.rejectedTxns, *parent.WitnessHash())
+	}
+
+	return result.AcceptedTxs
+}
+
 // current returns true if we believe we are synced with our peers, false if we
 // still have blocks to check
 func (sm *SyncManager) current() bool {
@@ -705,6 +843,23 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	// The blocks leading up to the block the utxo set was bootstrapped
+	// from are only stored for the background validation of the snapshot
+	// since they are already part of the main chain.
+	if sm.chain.NeedsSnapshotBlock(blockHash) {
+		err := sm.chain.ProcessSnapshotBlock(bmsg.block)
+		if err != nil {
+			log.Infof("Rejected block %v from %s: %v", blockHash,
+				peer, err)
+			code, reason := mempool.ErrToRejectErr(err)
+			peer.PushRejectMsg(wire.CmdBlock, code, reason,
+				blockHash, false)
+			return
+		}
+		sm.fetchSnapshotBlocks()
+		return
+	}
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
 	_, isOrphan, err := sm.chain.ProcessBlock(bmsg.block, behaviorFlags)
@@ -789,8 +944,15 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		heightUpdate = best.Height
 		blkHashUpdate = &best.Hash
 
+		// Prefer the peer for compact block announcements since it
+		// was able to deliver the new tip.
+		if best.Hash == *blockHash && sm.current() {
+			sm.maybeSetHighBandwidthPeer(peer)
+		}
+
 		// Clear the rejected transactions.
 		sm.rejectedTxns = make(map[chainhash.Hash]struct{})
+		sm.lowFeeTxns = make(map[chainhash.Hash]*bteutil.Tx)
 	}
 
 	// Update the block height for this peer. But only send a message to
@@ -911,6 +1073,57 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 	}
 }
 
+// fetchSnapshotBlocks requests the blocks leading up to the block the utxo set
+// was bootstrapped from which are needed by the background validation of the
+// utxo snapshot from a peer serving the full chain.  Nothing is requested until
+// the chain is current since syncing the main chain takes priority.
+func (sm *SyncManager) fetchSnapshotBlocks() {
+	if !sm.current() {
+		return
+	}
+	missing := sm.chain.MissingSnapshotBlocks(maxSnapshotBlocksInFlight)
+	if len(missing) == 0 {
+		return
+	}
+
+	// Prefer the sync peer and fall back to any other sync candidate.
+	peer := sm.syncPeer
+	if peer == nil {
+		for candidate, state := range sm.peerStates {
+			if state.syncCandidate {
+				peer = candidate
+				break
+			}
+		}
+	}
+	if peer == nil {
+		return
+	}
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		return
+	}
+
+	gdmsg := wire.NewMsgGetDataSizeHint(uint(len(missing)))
+	for i := range missing {
+		hash := &missing[i]
+		if _, exists := sm.requestedBlocks[*hash]; exists {
+			continue
+		}
+
+		iv := wire.NewInvVect(wire.InvTypeBlock, hash)
+		if peer.IsWitnessEnabled() {
+			iv.Type = wire.InvTypeWitnessBlock
+		}
+		sm.requestedBlocks[*hash] = struct{}{}
+		state.requestedBlocks[*hash] = struct{}{}
+		gdmsg.AddInvVect(iv)
+	}
+	if len(gdmsg.InvList) > 0 {
+		peer.QueueMessage(gdmsg, nil)
+	}
+}
+
 // handleHeadersMsg handles block header messages from all peers.  Headers are
 // requested when performing a headers-first sync.
 func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
@@ -936,40 +1149,44 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
-	// Process all of the received headers ensuring each one connects to the
-	// previous and that checkpoints match.
+	// Ensure there is a previous header to compare against.
+	prevNodeEl := sm.headerList.Back()
+	if prevNodeEl == nil {
+		log.Warnf("Header list does not contain a previous" +
+			"element as expected -- disconnecting peer")
+		peer.Disconnect()
+		return
+	}
+	prevNode := prevNodeEl.Value.(*headerNode)
+
+	// Verify the entire batch of headers connects to the previous one and
+	// their proof of work in parallel since each one requires an expensive
+	// yespower evaluation.  The resulting hashes are in the same order as
+	// the headers.
+	blockHashes, err := verifyHeadersPoW(msg.Headers, prevNode.hash,
+		sm.chainParams.PowLimit)
+	if err != nil {
+		log.Warnf("Received invalid block header from peer %s: %v "+
+			"-- disconnecting", peer.Addr(), err)
+		peer.Disconnect()
+		return
+	}
+
+	// Add all of the received headers to the list of headers ensuring
+	// that checkpoints match.
 	receivedCheckpoint := false
 	var finalHash *chainhash.Hash
-	for _, blockHeader := range msg.Headers {
-		blockHash := blockHeader.BlockHash()
+	for i := range msg.Headers {
+		blockHash := blockHashes[i]
 		finalHash = &blockHash
 
-		// Ensure there is a previous header to compare against.
-		prevNodeEl := sm.headerList.Back()
-		if prevNodeEl == nil {
-			log.Warnf("Header list does not contain a previous" +
-				"element as expected -- disconnecting peer")
-			peer.Disconnect()
-			return
-		}
-
-		// Ensure the header properly connects to the previous one and
-		// add it to the list of headers.
-		node := headerNode{hash: &blockHash}
-		prevNode := prevNodeEl.Value.(*headerNode)
-		if prevNode.hash.IsEqual(&blockHeader.PrevBlock) {
-			node.height = prevNode.height + 1
-			e := sm.headerList.PushBack(&node)
-			if sm.startHeader == nil {
-				sm.startHeader = e
-			}
-		} else {
-			log.Warnf("Received block header that does not "+
-				"properly connect to the chain from peer %s "+
-				"-- disconnecting", peer.Addr())
-			peer.Disconnect()
-			return
+		// The header is already known to connect to the previous one.
+		node := headerNode{hash: &blockHash, height: prevNode.height + 1}
+		e := sm.headerList.PushBack(&node)
+		if sm.startHeader == nil {
+			sm.startHeader = e
 		}
+		prevNode = &node
 
 		// Verify the header at the next checkpoint height matches.
 		if node.height == sm.nextCheckpoint.Height {
@@ -1011,7 +1228,7 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 	// headers starting from the latest known header and ending with the
 	// next checkpoint.
 	locator := blockchain.BlockLocator([]*chainhash.Hash{finalHash})
-	err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
+	err = peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
 	if err != nil {
 		log.Warnf("Failed to send getheaders message to "+
 			"peer %s: %v", peer.Addr(), err)
@@ -1031,6 +1248,8 @@ func (sm *SyncManager) handleNotFoundMsg(nfmsg *notFoundMsg) {
 		// verify the hash was actually announced by the peer
 		// before deleting from the global requested maps.
 		switch inv.Type {
+		case wire.InvTypeCmpctBlock:
+			fallthrough
 		case wire.InvTypeWitnessBlock:
 			fallthrough
 		case wire.InvTypeBlock:
@@ -1039,6 +1258,8 @@ func (sm *SyncManager) handleNotFoundMsg(nfmsg *notFoundMsg) {
 				delete(sm.requestedBlocks, inv.Hash)
 			}
 
+		case wire.InvTypeWTx:
+			fallthrough
 		case wire.InvTypeWitnessTx:
 			fallthrough
 		case wire.InvTypeTx:
@@ -1064,6 +1285,12 @@ func (sm *SyncManager) haveInventory(invVect *wire.InvVect) (bool, error) {
 		// chain, side chain, or orphan).
 		return sm.chain.HaveBlock(&invVect.Hash)
 
+	case wire.InvTypeWTx:
+		// Transactions announced by their witness hash can only be
+		// looked up in the transaction memory pool since the chain
+		// does not index them by witness hash.
+		return sm.txMemPool.HaveTransactionByWitnessHash(&invVect.Hash), nil
+
 	case wire.InvTypeWitnessTx:
 		fallthrough
 	case wire.InvTypeTx:
@@ -1151,12 +1378,23 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	// Finally, attempt to detect potential stalls due to long side chains
 	// we already have and request more blocks to prevent them.
 	for i, iv := range invVects {
-		// Ignore unsupported inventory types.
+		// Ignore unsupported inventory types.  Transactions are only
+		// accepted by their witness hash from peers which negotiated
+		// wtxid relay and only by their hash from all other peers
+		// (BIP0339).
 		switch iv.Type {
 		case wire.InvTypeBlock:
-		case wire.InvTypeTx:
 		case wire.InvTypeWitnessBlock:
+		case wire.InvTypeTx:
+			fallthrough
 		case wire.InvTypeWitnessTx:
+			if peer.WantsWTxIdRelay() {
+				continue
+			}
+		case wire.InvTypeWTx:
+			if !peer.WantsWTxIdRelay() {
+				continue
+			}
 		default:
 			continue
 		}
@@ -1179,9 +1417,12 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 			continue
 		}
 		if !haveInv {
-			if iv.Type == wire.InvTypeTx {
+			if iv.Type == wire.InvTypeTx || iv.Type == wire.InvTypeWTx {
 				// Skip the transaction if it has already been
-				// rejected.
+				// rejected.  Rejections are recorded by witness
+				// hash, which is what peers that negotiated
+				// wtxid relay announce, and is the same as the
+				// hash for transactions without witness data.
 				if _, exists := sm.rejectedTxns[iv.Hash]; exists {
 					continue
 				}
@@ -1265,6 +1506,26 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 					iv.Type = wire.InvTypeWitnessBlock
 				}
 
+				// Request new blocks as compact blocks once
+				// the chain is current since most of their
+				// transactions are likely already in the
+				// mempool by then.
+				if sm.current() && peer.WantsCmpctBlocks() {
+					iv.Type = wire.InvTypeCmpctBlock
+				}
+
+				gdmsg.AddInvVect(iv)
+				numRequested++
+			}
+
+		case wire.InvTypeWTx:
+			// Request the transaction by its witness hash if there
+			// is not already a pending request.  It is always sent
+			// including all witness data.
+			if _, exists := sm.requestedTxns[iv.Hash]; !exists {
+				limitAdd(sm.requestedTxns, iv.Hash, maxRequestedTxns)
+				limitAdd(state.requestedTxns, iv.Hash, maxRequestedTxns)
+
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1325,6 +1586,14 @@ out:
 				sm.handleBlockMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *cmpctBlockMsg:
+				sm.handleCmpctBlockMsg(msg)
+				msg.reply <- struct{}{}
+
+			case *blockTxnMsg:
+				sm.handleBlockTxnMsg(msg)
+				msg.reply <- struct{}{}
+
 			case *invMsg:
 				sm.handleInvMsg(msg)
 
@@ -1373,6 +1642,7 @@ out:
 
 		case <-stallTicker.C:
 			sm.handleStallSample()
+			sm.fetchSnapshotBlocks()
 
 		case <-sm.quit:
 			break out
@@ -1403,9 +1673,11 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			break
 		}
 
-		// Generate the inventory vector and relay it.
+		// Generate the inventory vector and relay it along with the
+		// block so it can be sent to peers which prefer headers or
+		// compact blocks.
 		iv := wire.NewInvVect(wire.InvTypeBlock, block.Hash())
-		sm.peerNotifier.RelayInventory(iv, block.MsgBlock().Header)
+		sm.peerNotifier.RelayInventory(iv, block)
 
 	// A block has been connected to the main block chain.
 	case blockchain.NTBlockConnected:
@@ -1423,7 +1695,8 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 		// transaction are NOT removed recursively because they are still
 		// valid.
 		for _, tx := range block.Transactions()[1:] {
-			sm.txMemPool.RemoveTransaction(tx, false)
+			sm.txMemPool.RemoveTransactionWithReason(tx, false,
+				mempool.RemovalReasonBlock)
 			sm.txMemPool.RemoveDoubleSpends(tx)
 			sm.txMemPool.RemoveOrphan(tx)
 			sm.peerNotifier.TransactionConfirmed(tx)
@@ -1431,6 +1704,11 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 		}
 
+		// Remove transactions which have been in the pool for too long.
+		// Blocks are connected regularly, so this makes sure they are
+		// removed even when no new transactions arrive.
+		sm.txMemPool.MaybeExpireTransactions()
+
 		// Register block with the fee estimator, if it exists.
 		if sm.feeEstimator != nil {
 			err := sm.feeEstimator.RegisterBlock(block)
@@ -1462,7 +1740,8 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				// Remove the transaction and all transactions
 				// that depend on it if it wasn't accepted into
 				// the transaction pool.
-				sm.txMemPool.RemoveTransaction(tx, true)
+				sm.txMemPool.RemoveTransactionWithReason(tx,
+					true, mempool.RemovalReasonReorg)
 			}
 		}
 
@@ -1508,6 +1787,33 @@ func (sm *SyncManager) QueueBlock(block *bteutil.Block, peer *peerpkg.Peer, done
 	sm.msgChan <- &blockMsg{block: block, peer: peer, reply: done}
 }
 
+// QueueCmpctBlock adds the passed cmpctblock message and peer to the block
+// handling queue. Responds to the done channel argument after the message is
+// processed.
+func (sm *SyncManager) QueueCmpctBlock(cmpctBlock *wire.MsgCmpctBlock, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &cmpctBlockMsg{cmpctBlock: cmpctBlock, peer: peer,
+		reply: done}
+}
+
+// QueueBlockTxn adds the passed blocktxn message and peer to the block
+// handling queue. Responds to the done channel argument after the message is
+// processed.
+func (sm *SyncManager) QueueBlockTxn(blockTxn *wire.MsgBlockTxn, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &blockTxnMsg{blockTxn: blockTxn, peer: peer, reply: done}
+}
+
 // QueueInv adds the passed inv message and peer to the block handling queue.
 func (sm *SyncManager) QueueInv(inv *wire.MsgInv, peer *peerpkg.Peer) {
 	// No channel handling here because peers do not need to block on inv
@@ -1623,6 +1929,7 @@ func New(config *Config) (*SyncManager, error) {
 		txMemPool:       config.TxMemPool,
 		chainParams:     config.ChainParams,
 		rejectedTxns:    make(map[chainhash.Hash]struct{}),
+		lowFeeTxns:      make(map[chainhash.Hash]*bteutil.Tx),
 		requestedTxns:   make(map[chainhash.Hash]struct{}),
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
 		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
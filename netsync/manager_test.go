@@ -0,0 +1,157 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/mempool"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// addTestTxPool adds a transaction memory pool backed by the chain of the
+// passed sync manager.
+func addTestTxPool(sm *SyncManager) {
+	chain := sm.chain
+	sm.txMemPool = mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			MaxOrphanTxs:      5,
+			MaxOrphanTxSize:   1000,
+			MaxSigOpCostPerTx: blockchain.MaxBlockSigOpsCost / 4,
+			MinRelayTxFee:     mempool.DefaultMinRelayTxFee,
+			MaxTxVersion:      2,
+		},
+		ChainParams:   sm.chainParams,
+		FetchUtxoView: chain.FetchUtxoView,
+		BestHeight: func() int32 {
+			return chain.BestSnapshot().Height
+		},
+		MedianTimePast: func() time.Time {
+			return chain.BestSnapshot().MedianTime
+		},
+		CalcSequenceLock: func(tx *bteutil.Tx, view *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return chain.CalcSequenceLock(tx, view, true)
+		},
+		IsDeploymentActive: chain.IsDeploymentActive,
+	})
+	sm.requestedTxns = make(map[chainhash.Hash]struct{})
+	sm.rejectedTxns = make(map[chainhash.Hash]struct{})
+	sm.lowFeeTxns = make(map[chainhash.Hash]*bteutil.Tx)
+}
+
+// testRejectedTx returns a transaction which is always rejected by the memory
+// pool since it is a coinbase.  The passed tag is included in the signature
+// script so transactions with different tags have distinct hashes.  The
+// transaction includes the passed witness unless it is nil.
+func testRejectedTx(tag byte, witness []byte) *bteutil.Tx {
+	msgTx := wire.NewMsgTx(1)
+	msgTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, tag},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	if witness != nil {
+		msgTx.TxIn[0].Witness = wire.TxWitness{witness}
+	}
+	msgTx.AddTxOut(&wire.TxOut{Value: 1000, PkScript: []byte{0x51}})
+	return bteutil.NewTx(msgTx)
+}
+
+// TestHandleTxMsgRejected ensures rejected transactions are recorded by their
+// witness hash so versions with a different witness can still be requested,
+// and that the request maps are cleared for transactions which were already
+// rejected.
+func TestHandleTxMsgRejected(t *testing.T) {
+	sm, teardown := newTestSyncManager(t)
+	defer teardown()
+	addTestTxPool(sm)
+
+	peer := addTestPeer(sm)
+	defer peer.Disconnect()
+	state := sm.peerStates[peer]
+
+	// requested returns whether the passed hash is in either request map.
+	requested := func(hash *chainhash.Hash) bool {
+		_, inGlobal := sm.requestedTxns[*hash]
+		_, inPeer := state.requestedTxns[*hash]
+		return inGlobal || inPeer
+	}
+
+	// request marks the passed hash as requested from the peer.
+	request := func(hash *chainhash.Hash) {
+		sm.requestedTxns[*hash] = struct{}{}
+		state.requestedTxns[*hash] = struct{}{}
+	}
+
+	// handleTx handles the passed transaction from the peer.
+	handleTx := func(tx *bteutil.Tx) {
+		sm.handleTxMsg(&txMsg{tx: tx, peer: peer})
+	}
+
+	// A transaction with witness data is only recorded by its witness
+	// hash since its witness may have been malleated.
+	tx := testRejectedTx(0, []byte{0x01})
+	request(tx.WitnessHash())
+	handleTx(tx)
+	if _, ok := sm.rejectedTxns[*tx.WitnessHash()]; !ok {
+		t.Fatalf("witness hash of rejected transaction not recorded")
+	}
+	if _, ok := sm.rejectedTxns[*tx.Hash()]; ok {
+		t.Fatalf("hash of rejected transaction with witness recorded")
+	}
+	if requested(tx.WitnessHash()) {
+		t.Fatalf("rejected transaction is still requested")
+	}
+
+	// The same transaction with a different witness must still be
+	// processed and recorded in turn.
+	malleated := testRejectedTx(0, []byte{0x02})
+	if *malleated.Hash() != *tx.Hash() {
+		t.Fatalf("malleated transaction has a different hash")
+	}
+	request(malleated.WitnessHash())
+	handleTx(malleated)
+	if _, ok := sm.rejectedTxns[*malleated.WitnessHash()]; !ok {
+		t.Fatalf("witness hash of malleated transaction not recorded")
+	}
+	if requested(malleated.WitnessHash()) {
+		t.Fatalf("malleated transaction is still requested")
+	}
+
+	// Transactions which were already rejected must not remain in the
+	// request maps either.
+	request(tx.Hash())
+	request(tx.WitnessHash())
+	handleTx(tx)
+	if requested(tx.Hash()) || requested(tx.WitnessHash()) {
+		t.Fatalf("previously rejected transaction is still requested")
+	}
+
+	// A transaction without witness data is recorded by its hash, so it
+	// is not requested again when it is announced by it.  However, the
+	// transaction with witness data announced by its hash is requested
+	// since the witness hash is unknown.
+	noWitness := testRejectedTx(1, nil)
+	handleTx(noWitness)
+	if _, ok := sm.rejectedTxns[*noWitness.Hash()]; !ok {
+		t.Fatalf("hash of rejected transaction not recorded")
+	}
+	inv := wire.NewMsgInv()
+	inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, noWitness.Hash()))
+	inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, tx.Hash()))
+	sm.handleInvMsg(&invMsg{inv: inv, peer: peer})
+	if requested(noWitness.Hash()) {
+		t.Fatalf("rejected transaction without witness requested " +
+			"again")
+	}
+	if !requested(tx.Hash()) {
+		t.Fatalf("transaction rejected by witness hash not requested")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/mraksoll4/bted/blockchain"
 	"github.com/mraksoll4/bted/chaincfg"
 	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/v2transport"
 	"github.com/mraksoll4/bted/wire"
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/davecgh/go-spew/spew"
@@ -203,6 +204,26 @@ type MessageListeners struct {
 	// OnSendAddrV2 is invoked when a peer receives a sendaddrv2 message.
 	OnSendAddrV2 func(p *Peer, msg *wire.MsgSendAddrV2)
 
+	// OnWTxIdRelay is invoked when a peer receives a wtxidrelay message
+	// during the handshake while wtxid relay is enabled.
+	OnWTxIdRelay func(p *Peer, msg *wire.MsgWTxIdRelay)
+
+	// OnSendCmpct is invoked when a peer receives a sendcmpct bitcoin
+	// message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin
+	// message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin
+	// message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin
+	// message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
 	// OnRead is invoked when a peer receives a bitcoin message.  It
 	// consists of the number of bytes read, the message, and whether or not
 	// an error in the read occurred.  Typically, callers will opt to use
@@ -289,6 +310,20 @@ type Config struct {
 	// scenarios where the stall behavior isn't important to the system
 	// under test.
 	DisableStallHandler bool
+
+	// WTxIdRelay specifies whether to signal that transactions are
+	// announced and requested by their witness hash (BIP0339).  The caller
+	// must handle inventory vectors of type InvTypeWTx from peers for which
+	// WantsWTxIdRelay returns true when this is set.
+	WTxIdRelay bool
+
+	// V2Transport specifies whether to use the v2 encrypted transport
+	// protocol (BIP0324).  Outbound peers initiate a v2 handshake, which
+	// fails when the remote peer only supports the original v1 transport,
+	// so it should only be set for outbound peers which are known to
+	// advertise SFNodeP2PV2.  Inbound peers accept connections using either
+	// protocol.
+	V2Transport bool
 }
 
 // minUint32 is a helper function to return the minimum of two uint32s.
@@ -441,8 +476,15 @@ type Peer struct {
 	lastSend      int64
 	connected     int32
 	disconnect    int32
+	v2Rejected    int32
+
+	conn       net.Conn
+	connReader io.Reader
 
-	conn net.Conn
+	// v2Transport is the transport used to exchange messages when the v2
+	// transport protocol was negotiated, or nil otherwise.  It is set
+	// during negotiation and never modified afterwards.
+	v2Transport *v2transport.Transport
 
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
@@ -462,6 +504,9 @@ type Peer struct {
 	verAckReceived       bool
 	witnessEnabled       bool
 	sendAddrV2           bool
+	wtxidRelay           bool // transactions are relayed by wtxid
+	sendCmpct            bool // peer supports our compact blocks version
+	cmpctHighBandwidth   bool // peer wants new blocks as compact blocks
 
 	wireEncoding wire.MessageEncoding
 
@@ -827,6 +872,63 @@ func (p *Peer) IsWitnessEnabled() bool {
 	return witnessEnabled
 }
 
+// WantsCmpctBlocks returns if the peer supports the version of compact blocks
+// implemented by the wire package, in which case blocks may be requested from
+// the peer and sent to it as compact blocks (BIP0152).
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	sendCmpct := p.sendCmpct
+	p.flagsMtx.Unlock()
+
+	return sendCmpct
+}
+
+// WantsHighBandwidthCmpctBlocks returns if the peer asked to be sent compact
+// blocks for new blocks right away instead of announcing them with inventory
+// vectors or headers, which is known as high-bandwidth mode.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsHighBandwidthCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	highBandwidth := p.sendCmpct && p.cmpctHighBandwidth
+	p.flagsMtx.Unlock()
+
+	return highBandwidth
+}
+
+// WantsWTxIdRelay returns if transactions are announced to and requested from
+// the peer by their witness hash using inventory vectors of type InvTypeWTx
+// instead of by their hash (BIP0339).  This is the case when both peers sent
+// a wtxidrelay message during the handshake.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsWTxIdRelay() bool {
+	p.flagsMtx.Lock()
+	wtxidRelay := p.wtxidRelay
+	p.flagsMtx.Unlock()
+
+	return wtxidRelay
+}
+
+// V2Transport returns whether the connection to the peer uses the v2 encrypted
+// transport protocol.
+//
+// This function is safe for concurrent access once the peer is connected.
+func (p *Peer) V2Transport() bool {
+	return p.v2Transport != nil
+}
+
+// V2TransportRejected returns whether the outbound peer disconnected instead
+// of responding to the v2 handshake, which indicates that it only supports the
+// original v1 transport protocol and the connection should be retried with it.
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2TransportRejected() bool {
+	return atomic.LoadInt32(&p.v2Rejected) != 0
+}
+
 // WantsAddrV2 returns if the peer supports addrv2 messages instead of the
 // legacy addr messages.
 func (p *Peer) WantsAddrV2() bool {
@@ -1065,10 +1167,41 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 	}
 }
 
+// handleSendCmpctMsg is invoked when a peer receives a sendcmpct bitcoin
+// message.  Peers may send one for each version of compact blocks they
+// support, so only those for the version implemented by the wire package are
+// recorded.  Later messages for that version update whether the peer wants new
+// blocks sent as compact blocks right away.
+func (p *Peer) handleSendCmpctMsg(msg *wire.MsgSendCmpct) {
+	if msg.Version == wire.CmpctBlockVersion {
+		p.flagsMtx.Lock()
+		p.sendCmpct = true
+		p.cmpctHighBandwidth = msg.AnnounceUsingCmpctBlock
+		p.flagsMtx.Unlock()
+	}
+
+	if p.cfg.Listeners.OnSendCmpct != nil {
+		p.cfg.Listeners.OnSendCmpct(p, msg)
+	}
+}
+
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageWithEncodingN(p.conn,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	var n int
+	var msg wire.Message
+	var buf []byte
+	var err error
+	if p.v2Transport != nil {
+		var contents []byte
+		contents, n, err = p.v2Transport.ReadPacket()
+		if err == nil {
+			msg, buf, err = wire.DecodeV2Message(contents,
+				p.ProtocolVersion(), encoding)
+		}
+	} else {
+		n, msg, buf, err = wire.ReadMessageWithEncodingN(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	}
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
@@ -1130,8 +1263,19 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 	}))
 
 	// Write the message to the peer.
-	n, err := wire.WriteMessageWithEncodingN(p.conn, msg,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	var n int
+	var err error
+	if p.v2Transport != nil {
+		var contents []byte
+		contents, err = wire.EncodeV2Message(msg, p.ProtocolVersion(),
+			enc)
+		if err == nil {
+			n, err = p.v2Transport.WritePacket(contents)
+		}
+	} else {
+		n, err = wire.WriteMessageWithEncodingN(p.conn, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -1214,12 +1358,18 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		pendingResponses[wire.CmdInv] = deadline
 
 	case wire.CmdGetData:
-		// Expects a block, merkleblock, tx, or notfound message.
+		// Expects a block, cmpctblock, merkleblock, tx, or notfound
+		// message.
 		pendingResponses[wire.CmdBlock] = deadline
+		pendingResponses[wire.CmdCmpctBlock] = deadline
 		pendingResponses[wire.CmdMerkleBlock] = deadline
 		pendingResponses[wire.CmdTx] = deadline
 		pendingResponses[wire.CmdNotFound] = deadline
 
+	case wire.CmdGetBlockTxn:
+		// Expects a blocktxn message.
+		pendingResponses[wire.CmdBlockTxn] = deadline
+
 	case wire.CmdGetHeaders:
 		// Expects a headers message.  Use a longer deadline since it
 		// can take a while for the remote peer to load all of the
@@ -1277,12 +1427,15 @@ out:
 				switch msgCmd := msg.message.Command(); msgCmd {
 				case wire.CmdBlock:
 					fallthrough
+				case wire.CmdCmpctBlock:
+					fallthrough
 				case wire.CmdMerkleBlock:
 					fallthrough
 				case wire.CmdTx:
 					fallthrough
 				case wire.CmdNotFound:
 					delete(pendingResponses, wire.CmdBlock)
+					delete(pendingResponses, wire.CmdCmpctBlock)
 					delete(pendingResponses, wire.CmdMerkleBlock)
 					delete(pendingResponses, wire.CmdTx)
 					delete(pendingResponses, wire.CmdNotFound)
@@ -1471,6 +1624,11 @@ out:
 			// completed.
 			break out
 
+		case *wire.MsgWTxIdRelay:
+			// Disconnect if peer sends this after the handshake is
+			// completed.
+			break out
+
 		case *wire.MsgGetAddr:
 			if p.cfg.Listeners.OnGetAddr != nil {
 				p.cfg.Listeners.OnGetAddr(p, msg)
@@ -1612,6 +1770,24 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			p.handleSendCmpctMsg(msg)
+
+		case *wire.MsgCmpctBlock:
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
 		default:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
@@ -2083,9 +2259,10 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 
 	theirNA := p.na.ToLegacy()
 
-	// If p.na is a torv3 hidden service address, we'll need to send over
-	// an empty NetAddress for their address.
-	if p.na.IsTorV3() {
+	// If p.na is a torv3 hidden service, i2p, or cjdns address, which
+	// can't be encoded as a legacy address, we'll need to send over an
+	// empty NetAddress for their address.
+	if theirNA == nil {
 		theirNA = wire.NewNetAddressIPPort(
 			net.IP([]byte{0, 0, 0, 0}), p.na.Port, p.na.Services,
 		)
@@ -2160,9 +2337,19 @@ func (p *Peer) writeSendAddrV2Msg(pver uint32) error {
 	return p.writeMessage(sendAddrMsg, wire.LatestEncoding)
 }
 
+// writeWTxIdRelayMsg sends a wtxidrelay message to the peer if wtxid relay is
+// enabled and the negotiated protocol version supports it.
+func (p *Peer) writeWTxIdRelayMsg(pver uint32) error {
+	if !p.cfg.WTxIdRelay || pver < wire.WTxIdRelayVersion {
+		return nil
+	}
+
+	return p.writeMessage(wire.NewMsgWTxIdRelay(), wire.LatestEncoding)
+}
+
 // waitToFinishNegotiation waits until desired negotiation messages are
-// received, recording the remote peer's preference for sendaddrv2 as an
-// example. The list of negotiated features can be expanded in the future. If a
+// received, recording the remote peer's preference for sendaddrv2 and
+// wtxidrelay. The list of negotiated features can be expanded in the future. If a
 // verack is received, negotiation stops and the connection is live.
 func (p *Peer) waitToFinishNegotiation(pver uint32) error {
 	// There are several possible messages that can be received here. We
@@ -2189,6 +2376,22 @@ func (p *Peer) waitToFinishNegotiation(pver uint32) error {
 					p.cfg.Listeners.OnSendAddrV2(p, m)
 				}
 			}
+		case *wire.MsgWTxIdRelay:
+			// The message is ignored unless it was sent to the
+			// peer as well.
+			if p.cfg.WTxIdRelay && pver >= wire.WTxIdRelayVersion {
+				p.flagsMtx.Lock()
+				p.wtxidRelay = true
+				p.flagsMtx.Unlock()
+
+				if p.cfg.Listeners.OnWTxIdRelay != nil {
+					p.cfg.Listeners.OnWTxIdRelay(p, m)
+				}
+			}
+		case *wire.MsgSendCmpct:
+			// Some implementations send sendcmpct before their
+			// verack.
+			p.handleSendCmpctMsg(m)
 		case *wire.MsgVerAck:
 			// Receiving a verack means we are done with the
 			// handshake.
@@ -2208,12 +2411,14 @@ func (p *Peer) waitToFinishNegotiation(pver uint32) error {
 //
 //   1. Remote peer sends their version.
 //   2. We send our version.
-//   3. We send sendaddrv2 if their version is >= 70016.
-//   4. We send our verack.
-//   5. Wait until sendaddrv2 or verack is received. Unknown messages are
-//      skipped as it could be wtxidrelay or a different message in the future
-//      that bted does not implement but bitcoind does.
-//   6. If remote peer sent sendaddrv2 above, wait until receipt of verack.
+//   3. We send wtxidrelay if it is enabled and their version is >= 70016.
+//   4. We send sendaddrv2 if their version is >= 70016.
+//   5. We send our verack.
+//   6. Wait until wtxidrelay, sendaddrv2 or verack is received. Unknown
+//      messages are skipped as it could be a message in the future that bted
+//      does not implement but bitcoind does.
+//   7. If remote peer sent wtxidrelay or sendaddrv2 above, wait until
+//      receipt of verack.
 func (p *Peer) negotiateInboundProtocol() error {
 	if err := p.readRemoteVersionMsg(); err != nil {
 		return err
@@ -2228,6 +2433,10 @@ func (p *Peer) negotiateInboundProtocol() error {
 	protoVersion = p.protocolVersion
 	p.flagsMtx.Unlock()
 
+	if err := p.writeWTxIdRelayMsg(protoVersion); err != nil {
+		return err
+	}
+
 	if err := p.writeSendAddrV2Msg(protoVersion); err != nil {
 		return err
 	}
@@ -2247,11 +2456,12 @@ func (p *Peer) negotiateInboundProtocol() error {
 //
 //   1. We send our version.
 //   2. Remote peer sends their version.
-//   3. We send sendaddrv2 if their version is >= 70016.
-//   4. We send our verack.
-//   5. We wait to receive sendaddrv2 or verack, skipping unknown messages as
-//      in the inbound case.
-//   6. If sendaddrv2 was received, wait for receipt of verack.
+//   3. We send wtxidrelay if it is enabled and their version is >= 70016.
+//   4. We send sendaddrv2 if their version is >= 70016.
+//   5. We send our verack.
+//   6. We wait to receive wtxidrelay, sendaddrv2 or verack, skipping unknown
+//      messages as in the inbound case.
+//   7. If wtxidrelay or sendaddrv2 was received, wait for receipt of verack.
 func (p *Peer) negotiateOutboundProtocol() error {
 	if err := p.writeLocalVersionMsg(); err != nil {
 		return err
@@ -2266,6 +2476,10 @@ func (p *Peer) negotiateOutboundProtocol() error {
 	protoVersion = p.protocolVersion
 	p.flagsMtx.Unlock()
 
+	if err := p.writeWTxIdRelayMsg(protoVersion); err != nil {
+		return err
+	}
+
 	if err := p.writeSendAddrV2Msg(protoVersion); err != nil {
 		return err
 	}
@@ -2279,12 +2493,56 @@ func (p *Peer) negotiateOutboundProtocol() error {
 	return p.waitToFinishNegotiation(protoVersion)
 }
 
+// negotiateTransport performs the v2 transport handshake when it is enabled.
+// Outbound peers initiate it, while inbound peers first determine whether the
+// remote peer uses the original v1 transport protocol instead and replay the
+// bytes read to do so in that case.
+func (p *Peer) negotiateTransport() error {
+	if !p.cfg.V2Transport {
+		return nil
+	}
+
+	btcnet := p.cfg.ChainParams.Net
+	if !p.inbound {
+		transport, err := v2transport.Initiate(p.conn, btcnet)
+		if err != nil {
+			if err == v2transport.ErrHandshakeRejected {
+				atomic.StoreInt32(&p.v2Rejected, 1)
+			}
+			return err
+		}
+		p.v2Transport = transport
+		log.Debugf("Negotiated v2 transport with %s", p)
+		return nil
+	}
+
+	prefix, isV1, err := v2transport.ReadV1Prefix(p.conn, btcnet)
+	if err != nil {
+		return err
+	}
+	if isV1 {
+		p.connReader = io.MultiReader(bytes.NewReader(prefix), p.conn)
+		return nil
+	}
+	transport, err := v2transport.Respond(p.conn, btcnet, prefix)
+	if err != nil {
+		return err
+	}
+	p.v2Transport = transport
+	log.Debugf("Negotiated v2 transport with %s", p)
+	return nil
+}
+
 // start begins processing input and output messages.
 func (p *Peer) start() error {
 	log.Tracef("Starting peer %s", p)
 
 	negotiateErr := make(chan error, 1)
 	go func() {
+		if err := p.negotiateTransport(); err != nil {
+			negotiateErr <- err
+			return
+		}
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -2325,6 +2583,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.connReader = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -2333,18 +2592,13 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 		// Set up a NetAddress for the peer to be used with AddrManager.  We
 		// only do this inbound because outbound set this up at connection time
 		// and no point recomputing.
-		na, err := newNetAddress(p.conn.RemoteAddr(), p.services)
+		na, err := p.inboundNetAddress()
 		if err != nil {
 			log.Errorf("Cannot create remote net address: %v", err)
 			p.Disconnect()
 			return
 		}
-
-		// Convert the NetAddress created above into NetAddressV2.
-		currentNa := wire.NetAddressV2FromBytes(
-			na.Timestamp, na.Services, na.IP, na.Port,
-		)
-		p.na = currentNa
+		p.na = na
 	}
 
 	go func() {
@@ -2355,6 +2609,37 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}()
 }
 
+// inboundNetAddress returns the NetAddressV2 of the remote address of the
+// connection of an inbound peer.  Connections which are not TCP connections,
+// such as the ones accepted over I2P, may have remote addresses which aren't IP
+// addresses, so they are converted with HostToNetAddress when it is set.
+func (p *Peer) inboundNetAddress() (*wire.NetAddressV2, error) {
+	remoteAddr := p.conn.RemoteAddr()
+	if _, ok := remoteAddr.(*net.TCPAddr); !ok && p.cfg.HostToNetAddress != nil {
+		host, portStr, err := net.SplitHostPort(remoteAddr.String())
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			return p.cfg.HostToNetAddress(host, uint16(port), p.services)
+		}
+	}
+
+	na, err := newNetAddress(remoteAddr, p.services)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the NetAddress created above into NetAddressV2.
+	return wire.NetAddressV2FromBytes(
+		na.Timestamp, na.Services, na.IP, na.Port,
+	), nil
+}
+
 // WaitForDisconnect waits until the peer has completely disconnected and all
 // resources are cleaned up.  This will happen if either the local or remote
 // side has been disconnected or the peer is forcibly disconnected via
@@ -355,7 +355,7 @@ func TestPeerConnection(t *testing.T) {
 // TestPeerListeners tests that the peer listeners are called as expected.
 func TestPeerListeners(t *testing.T) {
 	verack := make(chan struct{}, 1)
-	ok := make(chan wire.Message, 22)
+	ok := make(chan wire.Message, 26)
 	peerCfg := &peer.Config{
 		Listeners: peer.MessageListeners{
 			OnGetAddr: func(p *peer.Peer, msg *wire.MsgGetAddr) {
@@ -449,6 +449,18 @@ func TestPeerListeners(t *testing.T) {
 			OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
 				ok <- msg
 			},
+			OnSendCmpct: func(p *peer.Peer, msg *wire.MsgSendCmpct) {
+				ok <- msg
+			},
+			OnCmpctBlock: func(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+				ok <- msg
+			},
+			OnGetBlockTxn: func(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+				ok <- msg
+			},
+			OnBlockTxn: func(p *peer.Peer, msg *wire.MsgBlockTxn) {
+				ok <- msg
+			},
 		},
 		UserAgentName:     "peer",
 		UserAgentVersion:  "1.0",
@@ -599,6 +611,23 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnSendCmpct",
+			wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion),
+		},
+		{
+			"OnCmpctBlock",
+			wire.NewMsgCmpctBlock(wire.NewMsgBlock(wire.NewBlockHeader(1,
+				&chainhash.Hash{}, &chainhash.Hash{}, 1, 1)), 1),
+		},
+		{
+			"OnGetBlockTxn",
+			wire.NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1}),
+		},
+		{
+			"OnBlockTxn",
+			wire.NewMsgBlockTxn(&chainhash.Hash{}, nil),
+		},
 		{
 			"OnSendAddrV2",
 			wire.NewMsgSendAddrV2(),
@@ -619,6 +648,11 @@ func TestPeerListeners(t *testing.T) {
 			return
 		}
 	}
+
+	// The sendcmpct message must have been recorded.
+	if !inPeer.WantsCmpctBlocks() || !inPeer.WantsHighBandwidthCmpctBlocks() {
+		t.Errorf("TestPeerListeners: sendcmpct message not recorded")
+	}
 	inPeer.Disconnect()
 	outPeer.Disconnect()
 }
@@ -1209,3 +1243,180 @@ func TestSendAddrV2Handshake(t *testing.T) {
 		outPeer.WaitForDisconnect()
 	}
 }
+
+// TestV2TransportHandshake tests that peers negotiate the v2 transport when
+// both of them support it and fall back to the v1 transport otherwise.
+func TestV2TransportHandshake(t *testing.T) {
+	verack := make(chan struct{}, 2)
+	listeners := peer.MessageListeners{
+		OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+			verack <- struct{}{}
+		},
+	}
+	newCfg := func(v2Transport bool) *peer.Config {
+		return &peer.Config{
+			Listeners:      listeners,
+			AllowSelfConns: true,
+			ChainParams:    &chaincfg.MainNetParams,
+			V2Transport:    v2Transport,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		inV2       bool // Whether the inbound peer supports v2
+		outV2      bool // Whether the outbound peer initiates v2
+		connected  bool // Whether the peers are expected to connect
+		expectsV2  bool // Whether the v2 transport is expected
+		expectsRej bool // Whether the v2 handshake is expected to fail
+	}{
+		{"v2 handshake", true, true, true, true, false},
+		{"v1 outbound peer", true, false, true, false, false},
+		{"v1 peers", false, false, true, false, false},
+		{"v1 inbound peer", false, true, false, false, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		inPeer := peer.NewInboundPeer(newCfg(test.inV2))
+		outPeer, err := peer.NewOutboundPeer(newCfg(test.outV2),
+			"10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer #%d (%s): unexpected err: %v",
+				i, test.name, err)
+		}
+		if err := setupPeerConnection(inPeer, outPeer); err != nil {
+			t.Fatalf("setupPeerConnection #%d (%s): unexpected "+
+				"err: %v", i, test.name, err)
+		}
+
+		if test.connected {
+			for j := 0; j < 2; j++ {
+				select {
+				case <-verack:
+				case <-time.After(time.Second * 2):
+					t.Fatalf("#%d (%s): verack timeout", i,
+						test.name)
+				}
+			}
+		} else {
+			outPeer.WaitForDisconnect()
+		}
+
+		if outPeer.V2TransportRejected() != test.expectsRej {
+			t.Errorf("#%d (%s): V2TransportRejected got: %v "+
+				"want: %v", i, test.name,
+				outPeer.V2TransportRejected(), test.expectsRej)
+		}
+		if test.connected && (inPeer.V2Transport() != test.expectsV2 ||
+			outPeer.V2Transport() != test.expectsV2) {
+
+			t.Errorf("#%d (%s): V2Transport got: %v and %v want: %v",
+				i, test.name, inPeer.V2Transport(),
+				outPeer.V2Transport(), test.expectsV2)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+		outPeer.WaitForDisconnect()
+	}
+}
+
+// TestWTxIdRelayHandshake tests that wtxid relay is only negotiated when both
+// peers enable it and support the required protocol version.
+func TestWTxIdRelayHandshake(t *testing.T) {
+	verack := make(chan struct{}, 2)
+	wtxidRelay := make(chan struct{}, 2)
+	listeners := peer.MessageListeners{
+		OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+			verack <- struct{}{}
+		},
+		OnWTxIdRelay: func(p *peer.Peer, msg *wire.MsgWTxIdRelay) {
+			wtxidRelay <- struct{}{}
+		},
+	}
+	newCfg := func(enabled bool, pver uint32) *peer.Config {
+		return &peer.Config{
+			Listeners:       listeners,
+			AllowSelfConns:  true,
+			ChainParams:     &chaincfg.MainNetParams,
+			ProtocolVersion: pver,
+			WTxIdRelay:      enabled,
+		}
+	}
+	legacyVersion := wire.WTxIdRelayVersion - 1
+
+	tests := []struct {
+		name      string
+		inCfg     *peer.Config
+		outCfg    *peer.Config
+		expectsWT bool
+	}{
+		{
+			"both peers enable wtxid relay",
+			newCfg(true, 0), newCfg(true, 0), true,
+		},
+		{
+			"inbound peer does not enable wtxid relay",
+			newCfg(false, 0), newCfg(true, 0), false,
+		},
+		{
+			"outbound peer does not enable wtxid relay",
+			newCfg(true, 0), newCfg(false, 0), false,
+		},
+		{
+			"legacy outbound peer",
+			newCfg(true, 0), newCfg(true, legacyVersion), false,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		inPeer := peer.NewInboundPeer(test.inCfg)
+		outPeer, err := peer.NewOutboundPeer(test.outCfg,
+			"10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer #%d (%s): unexpected err: %v",
+				i, test.name, err)
+		}
+		if err := setupPeerConnection(inPeer, outPeer); err != nil {
+			t.Fatalf("setupPeerConnection #%d (%s): unexpected "+
+				"err: %v", i, test.name, err)
+		}
+
+		for j := 0; j < 2; j++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second * 2):
+				t.Fatalf("#%d (%s): verack timeout", i, test.name)
+			}
+		}
+
+		numWTxIdRelay := len(wtxidRelay)
+		for len(wtxidRelay) > 0 {
+			<-wtxidRelay
+		}
+		wantWTxIdRelay := 0
+		if test.expectsWT {
+			wantWTxIdRelay = 2
+		}
+		if numWTxIdRelay != wantWTxIdRelay {
+			t.Errorf("#%d (%s): OnWTxIdRelay invoked %d times, "+
+				"want %d", i, test.name, numWTxIdRelay,
+				wantWTxIdRelay)
+		}
+		if inPeer.WantsWTxIdRelay() != test.expectsWT ||
+			outPeer.WantsWTxIdRelay() != test.expectsWT {
+
+			t.Errorf("#%d (%s): WantsWTxIdRelay got: %v and %v "+
+				"want: %v", i, test.name, inPeer.WantsWTxIdRelay(),
+				outPeer.WantsWTxIdRelay(), test.expectsWT)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+		outPeer.WaitForDisconnect()
+	}
+}
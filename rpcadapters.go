@@ -7,6 +7,7 @@ package main
 import (
 	"sync/atomic"
 
+	"github.com/mraksoll4/bted/addrmgr"
 	"github.com/mraksoll4/bted/blockchain"
 	"github.com/mraksoll4/bted/chaincfg/chainhash"
 	"github.com/mraksoll4/bted/mempool"
@@ -232,6 +233,23 @@ func (cm *rpcConnManager) NodeAddresses() []*wire.NetAddressV2 {
 	return cm.server.addrManager.AddressCache()
 }
 
+// LocalServices returns the services advertised to peers.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) LocalServices() wire.ServiceFlag {
+	return cm.server.services
+}
+
+// LocalAddresses returns the local addresses advertised to peers along with
+// their scores.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) LocalAddresses() []addrmgr.LocalAddress {
+	return cm.server.addrManager.LocalAddresses()
+}
+
 // rpcSyncMgr provides a block manager for use with the RPC server and
 // implements the rpcserverSyncManager interface.
 type rpcSyncMgr struct {
@@ -410,6 +410,39 @@ func (c *Client) GetWorkSubmit(data string) (bool, error) {
 	return c.GetWorkSubmitAsync(data).Receive()
 }
 
+// FuturePrioritiseTransactionResult is a future promise to deliver the result
+// of a PrioritiseTransactionAsync RPC invocation (or an applicable error).
+type FuturePrioritiseTransactionResult chan *Response
+
+// Receive waits for the Response promised by the future and returns an error if
+// any occurred when prioritising the transaction.
+func (r FuturePrioritiseTransactionResult) Receive() error {
+	_, err := ReceiveFuture(r)
+	return err
+}
+
+// PrioritiseTransactionAsync returns an instance of a type that can be used to
+// get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See PrioritiseTransaction for the blocking version and more details.
+func (c *Client) PrioritiseTransactionAsync(txHash *chainhash.Hash, feeDelta bteutil.Amount) FuturePrioritiseTransactionResult {
+	hash := ""
+	if txHash != nil {
+		hash = txHash.String()
+	}
+
+	cmd := btcjson.NewPrioritiseTransactionCmd(hash, 0, int64(feeDelta))
+	return c.SendCmd(cmd)
+}
+
+// PrioritiseTransaction adds the passed fee delta to the fee of the transaction
+// used by the server when selecting transactions for new blocks.  The
+// transaction does not need to be in the server's memory pool yet.
+func (c *Client) PrioritiseTransaction(txHash *chainhash.Hash, feeDelta bteutil.Amount) error {
+	return c.PrioritiseTransactionAsync(txHash, feeDelta).Receive()
+}
+
 // FutureSubmitBlockResult is a future promise to deliver the result of a
 // SubmitBlockAsync RPC invocation (or an applicable error).
 type FutureSubmitBlockResult chan *Response
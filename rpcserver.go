@@ -21,12 +21,15 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mraksoll4/bted/addrmgr"
 	"github.com/mraksoll4/bted/blockchain"
 	"github.com/mraksoll4/bted/blockchain/indexers"
 	"github.com/mraksoll4/bted/btcec/v2/ecdsa"
@@ -75,6 +78,10 @@ const (
 
 	// maxProtocolVersion is the max protocol version the server supports.
 	maxProtocolVersion = 70002
+
+	// maxTestMempoolAcceptTxns is the maximum number of transactions the
+	// testmempoolaccept RPC checks at once.
+	maxTestMempoolAcceptTxns = 25
 )
 
 var (
@@ -135,7 +142,9 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"debuglevel":             handleDebugLevel,
 	"decoderawtransaction":   handleDecodeRawTransaction,
 	"decodescript":           handleDecodeScript,
+	"dumptxoutset":           handleDumpTxOutSet,
 	"estimatefee":            handleEstimateFee,
+	"estimatesmartfee":       handleEstimateSmartFee,
 	"generate":               handleGenerate,
 	"getaddednodeinfo":       handleGetAddedNodeInfo,
 	"getbestblock":           handleGetBestBlock,
@@ -148,6 +157,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getblocktemplate":       handleGetBlockTemplate,
 	"getcfilter":             handleGetCFilter,
 	"getcfilterheader":       handleGetCFilterHeader,
+	"getchaintips":           handleGetChainTips,
 	"getconnectioncount":     handleGetConnectionCount,
 	"getcurrentnet":          handleGetCurrentNet,
 	"getdifficulty":          handleGetDifficulty,
@@ -155,24 +165,36 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"gethashespersec":        handleGetHashesPerSec,
 	"getheaders":             handleGetHeaders,
 	"getinfo":                handleGetInfo,
+	"getmempoolancestors":    handleGetMempoolAncestors,
+	"getmempooldescendants":  handleGetMempoolDescendants,
+	"getmempoolentry":        handleGetMempoolEntry,
 	"getmempoolinfo":         handleGetMempoolInfo,
 	"getmininginfo":          handleGetMiningInfo,
 	"getnettotals":           handleGetNetTotals,
 	"getnetworkhashps":       handleGetNetworkHashPS,
+	"getnetworkinfo":         handleGetNetworkInfo,
 	"getnodeaddresses":       handleGetNodeAddresses,
 	"getpeerinfo":            handleGetPeerInfo,
 	"getrawmempool":          handleGetRawMempool,
 	"getrawtransaction":      handleGetRawTransaction,
 	"gettxout":               handleGetTxOut,
+	"gettxoutsetinfo":        handleGetTxOutSetInfo,
 	"help":                   handleHelp,
+	"invalidateblock":        handleInvalidateBlock,
 	"node":                   handleNode,
 	"ping":                   handlePing,
+	"preciousblock":          handlePreciousBlock,
+	"prioritisetransaction":  handlePrioritiseTransaction,
+	"reconsiderblock":        handleReconsiderBlock,
+	"savemempool":            handleSaveMempool,
 	"searchrawtransactions":  handleSearchRawTransactions,
 	"sendrawtransaction":     handleSendRawTransaction,
 	"setgenerate":            handleSetGenerate,
 	"signmessagewithprivkey": handleSignMessageWithPrivKey,
 	"stop":                   handleStop,
 	"submitblock":            handleSubmitBlock,
+	"submitpackage":          handleSubmitPackage,
+	"testmempoolaccept":      handleTestMempoolAccept,
 	"uptime":                 handleUptime,
 	"validateaddress":        handleValidateAddress,
 	"verifychain":            handleVerifyChain,
@@ -200,7 +222,6 @@ var rpcAskWallet = map[string]struct{}{
 	"getreceivedbyaccount":   {},
 	"getreceivedbyaddress":   {},
 	"gettransaction":         {},
-	"gettxoutsetinfo":        {},
 	"getunconfirmedbalance":  {},
 	"getwalletinfo":          {},
 	"importprivkey":          {},
@@ -231,13 +252,7 @@ var rpcAskWallet = map[string]struct{}{
 // Commands that are currently unimplemented, but should ultimately be.
 var rpcUnimplemented = map[string]struct{}{
 	"estimatepriority": {},
-	"getchaintips":     {},
-	"getmempoolentry":  {},
-	"getnetworkinfo":   {},
 	"getwork":          {},
-	"invalidateblock":  {},
-	"preciousblock":    {},
-	"reconsiderblock":  {},
 }
 
 // Commands that are available to a limited user
@@ -260,6 +275,7 @@ var rpcLimited = map[string]struct{}{
 	"decoderawtransaction":  {},
 	"decodescript":          {},
 	"estimatefee":           {},
+	"estimatesmartfee":      {},
 	"getbestblock":          {},
 	"getbestblockhash":      {},
 	"getblock":              {},
@@ -268,10 +284,14 @@ var rpcLimited = map[string]struct{}{
 	"getblockheader":        {},
 	"getcfilter":            {},
 	"getcfilterheader":      {},
+	"getchaintips":          {},
 	"getcurrentnet":         {},
 	"getdifficulty":         {},
 	"getheaders":            {},
 	"getinfo":               {},
+	"getmempoolancestors":   {},
+	"getmempooldescendants": {},
+	"getmempoolentry":       {},
 	"getnettotals":          {},
 	"getnetworkhashps":      {},
 	"getrawmempool":         {},
@@ -280,6 +300,8 @@ var rpcLimited = map[string]struct{}{
 	"searchrawtransactions": {},
 	"sendrawtransaction":    {},
 	"submitblock":           {},
+	"submitpackage":         {},
+	"testmempoolaccept":     {},
 	"uptime":                {},
 	"validateaddress":       {},
 	"verifymessage":         {},
@@ -860,6 +882,53 @@ func handleDecodeScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// handleDumpTxOutSet handles dumptxoutset commands.
+func handleDumpTxOutSet(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.DumpTxOutSetCmd)
+
+	// Relative paths are relative to the data directory.  Never overwrite
+	// an existing file.
+	path := c.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.DataDir, path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("%s already exists", path),
+		}
+	}
+
+	// Write the snapshot to a temporary file first so a partially written
+	// snapshot is never mistaken for a complete one.
+	tmpPath := path + ".incomplete"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		context := "Failed to create snapshot file"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	info, err := s.cfg.Chain.DumpUtxoSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		context := "Failed to write utxo snapshot"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return &btcjson.DumpTxOutSetResult{
+		CoinsWritten: info.Outputs,
+		BaseHash:     info.Hash.String(),
+		BaseHeight:   info.Height,
+		Path:         path,
+		SnapshotHash: info.SnapshotHash.String(),
+	}, nil
+}
+
 // handleEstimateFee handles estimatefee commands.
 func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.EstimateFeeCmd)
@@ -882,6 +951,62 @@ func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return float64(feeRate), nil
 }
 
+// handleEstimateSmartFee handles estimatesmartfee commands.
+func handleEstimateSmartFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.EstimateSmartFeeCmd)
+
+	if s.cfg.FeeEstimator == nil {
+		return nil, errors.New("Fee estimation disabled")
+	}
+
+	if c.ConfTarget < 1 || c.ConfTarget > mempool.MaxSmartFeeTarget {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid conf_target, must be "+
+				"between 1 and %d", mempool.MaxSmartFeeTarget),
+		}
+	}
+
+	conservative := true
+	if c.EstimateMode != nil {
+		switch *c.EstimateMode {
+		case btcjson.EstimateModeUnset, btcjson.EstimateModeConservative:
+		case btcjson.EstimateModeEconomical:
+			conservative = false
+		default:
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid estimate_mode parameter",
+			}
+		}
+	}
+
+	feeRate, blocks, err := s.cfg.FeeEstimator.EstimateSmartFee(
+		uint32(c.ConfTarget), conservative)
+	if err != nil {
+		return &btcjson.EstimateSmartFeeResult{
+			Errors: []string{"Insufficient data or no feerate found"},
+			Blocks: int64(blocks),
+		}, nil
+	}
+
+	// A transaction paying less than the minimum fee rate required by
+	// the pool or the minimum relay fee would not even be relayed.
+	minFeeRate := s.cfg.TxMemPool.MinFeeRate()
+	if minFeeRate < cfg.minRelayTxFee {
+		minFeeRate = cfg.minRelayTxFee
+	}
+	estimate := float64(feeRate)
+	if minFee := minFeeRate.ToBTE(); estimate < minFee {
+		estimate = minFee
+	}
+
+	return &btcjson.EstimateSmartFeeResult{
+		FeeRate: &estimate,
+		Blocks:  int64(blocks),
+	}, nil
+}
+
 // handleGenerate handles generate commands.
 func handleGenerate(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if there are no addresses to pay the
@@ -1081,6 +1206,16 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		return err
 	})
 	if err != nil {
+		// Distinguish blocks which are known but have been pruned from
+		// blocks which don't exist at all.
+		if s.cfg.Chain.IsPruned() {
+			if known, _ := s.cfg.Chain.HaveBlock(hash); known {
+				return nil, &btcjson.RPCError{
+					Code:    btcjson.ErrRPCMisc,
+					Message: "Block not available (pruned data)",
+				}
+			}
+		}
 		return nil, &btcjson.RPCError{
 			Code:    btcjson.ErrRPCBlockNotFound,
 			Message: "Block not found",
@@ -1166,6 +1301,42 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return blockReply, nil
 }
 
+// deploymentInfo houses the details about a BIP0009 deployment which are
+// reported by the RPC server.
+type deploymentInfo struct {
+	// name is the name of the deployment.
+	name string
+
+	// gbtForce indicates getblocktemplate clients which don't know about
+	// the deployment may safely ignore it.  Otherwise, the name reported to
+	// them is prefixed with "!" and they must explicitly support it once
+	// it is active.
+	gbtForce bool
+}
+
+// deploymentInfos holds the details about each of the defined deployments
+// indexed by deployment ID.
+var deploymentInfos = [chaincfg.DefinedDeployments]deploymentInfo{
+	chaincfg.DeploymentTestDummy:              {"dummy", true},
+	chaincfg.DeploymentTestDummyMinActivation: {"dummy-min-activation", true},
+	chaincfg.DeploymentCSV:                    {"csv", true},
+	chaincfg.DeploymentSegwit:                 {"segwit", false},
+	chaincfg.DeploymentTaproot:                {"taproot", true},
+}
+
+// lookupDeploymentInfo returns the details about the deployment with the passed
+// ID.  An error is returned when the deployment is not known to the RPC server.
+func lookupDeploymentInfo(id int) (deploymentInfo, error) {
+	if id < 0 || id >= len(deploymentInfos) || deploymentInfos[id].name == "" {
+		return deploymentInfo{}, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInternal.Code,
+			Message: fmt.Sprintf("Unknown deployment %v "+
+				"detected", id),
+		}
+	}
+	return deploymentInfos[id], nil
+}
+
 // softForkStatus converts a ThresholdState state into a human readable string
 // corresponding to the particular state.
 func softForkStatus(state blockchain.ThresholdState) (string, error) {
@@ -1200,11 +1371,14 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 		BestBlockHash: chainSnapshot.Hash.String(),
 		Difficulty:    getDifficultyRatio(chainSnapshot.Bits, params),
 		MedianTime:    chainSnapshot.MedianTime.Unix(),
-		Pruned:        false,
+		Pruned:        chain.IsPruned(),
 		SoftForks: &btcjson.SoftForks{
 			Bip9SoftForks: make(map[string]*btcjson.Bip9SoftForkDescription),
 		},
 	}
+	if chainInfo.Pruned {
+		chainInfo.PruneHeight = chain.PruneHeight()
+	}
 
 	// Next, populate the response with information describing the current
 	// status of soft-forks deployed via the super-majority block
@@ -1245,30 +1419,11 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 	for deployment, deploymentDetails := range params.Deployments {
 		// Map the integer deployment ID into a human readable
 		// fork-name.
-		var forkName string
-		switch deployment {
-		case chaincfg.DeploymentTestDummy:
-			forkName = "dummy"
-
-		case chaincfg.DeploymentTestDummyMinActivation:
-			forkName = "dummy-min-activation"
-
-		case chaincfg.DeploymentCSV:
-			forkName = "csv"
-
-		case chaincfg.DeploymentSegwit:
-			forkName = "segwit"
-
-		case chaincfg.DeploymentTaproot:
-			forkName = "taproot"
-
-		default:
-			return nil, &btcjson.RPCError{
-				Code: btcjson.ErrRPCInternal.Code,
-				Message: fmt.Sprintf("Unknown deployment %v "+
-					"detected", deployment),
-			}
+		info, err := lookupDeploymentInfo(deployment)
+		if err != nil {
+			return nil, err
 		}
+		forkName := info.name
 
 		// Query the chain for the current status of the deployment as
 		// identified by its deployment ID.
@@ -1830,7 +1985,7 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 // has passed without finding a solution.
 //
 // See https://en.bitcoin.it/wiki/BIP_0022 for more details.
-func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbaseValue bool, closeChan <-chan struct{}) (interface{}, error) {
+func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbaseValue bool, closeChan <-chan struct{}) (*btcjson.GetBlockTemplateResult, error) {
 	state := s.gbtWorkState
 	state.Lock()
 	// The state unlock is intentionally not deferred here since it needs to
@@ -1980,9 +2135,20 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	// When a long poll ID was provided, this is a long poll request by the
 	// client to be notified when block template referenced by the ID should
 	// be replaced with a new one.
+	var clientRules []string
+	if request != nil {
+		clientRules = request.Rules
+	}
 	if request != nil && request.LongPollID != "" {
-		return handleGetBlockTemplateLongPoll(s, request.LongPollID,
-			useCoinbaseValue, closeChan)
+		result, err := handleGetBlockTemplateLongPoll(s,
+			request.LongPollID, useCoinbaseValue, closeChan)
+		if err != nil {
+			return nil, err
+		}
+		if err := gbtDeploymentRules(s, result, clientRules); err != nil {
+			return nil, err
+		}
+		return result, nil
 	}
 
 	// Protect concurrent access when updating block templates.
@@ -1999,7 +2165,79 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	if err := state.updateBlockTemplate(s, useCoinbaseValue); err != nil {
 		return nil, err
 	}
-	return state.blockTemplateResult(useCoinbaseValue, nil)
+	result, err := state.blockTemplateResult(useCoinbaseValue, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := gbtDeploymentRules(s, result, clientRules); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// gbtDeploymentRules populates the BIP0009 fields of the passed block template
+// result given the rules supported by the client as specified by BIP0009.
+// Active deployments are listed in the rules while started and locked in
+// deployments are listed as available along with their bit.  The bits of
+// locked in deployments are always set in the block version, and the bits of
+// deployments the client does not support are cleared unless they may safely
+// be ignored.  An error is returned if a deployment the client does not
+// support is active and may not be ignored.
+//
+// See https://github.com/bitcoin/bips/blob/master/bip-0009.mediawiki for more
+// details.
+func gbtDeploymentRules(s *rpcServer, result *btcjson.GetBlockTemplateResult, clientRules []string) error {
+	supported := make(map[string]struct{}, len(clientRules))
+	for _, rule := range clientRules {
+		supported[strings.TrimPrefix(rule, "!")] = struct{}{}
+	}
+
+	result.Rules = make([]string, 0, len(deploymentInfos))
+	result.VbAvailable = make(map[string]int)
+	result.VbRequired = 0
+	for id, deployment := range s.cfg.ChainParams.Deployments {
+		info, err := lookupDeploymentInfo(id)
+		if err != nil {
+			return err
+		}
+		name := info.name
+		if !info.gbtForce {
+			name = "!" + name
+		}
+		_, isSupported := supported[info.name]
+
+		state, err := s.cfg.Chain.ThresholdState(uint32(id))
+		if err != nil {
+			context := "Failed to obtain deployment status"
+			return internalRPCError(err.Error(), context)
+		}
+
+		mask := int32(1) << deployment.BitNumber
+		switch state {
+		case blockchain.ThresholdLockedIn:
+			result.Version |= mask
+			fallthrough
+
+		case blockchain.ThresholdStarted:
+			result.VbAvailable[name] = int(deployment.BitNumber)
+			if !isSupported && !info.gbtForce {
+				result.Version &^= mask
+			}
+
+		case blockchain.ThresholdActive:
+			result.Rules = append(result.Rules, name)
+			if !isSupported && !info.gbtForce {
+				return &btcjson.RPCError{
+					Code: btcjson.ErrRPCInvalidParameter,
+					Message: fmt.Sprintf("Support for '%s' "+
+						"rule requires explicit client "+
+						"support", info.name),
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
 // chainErrToGBTErrString converts an error returned from btechain to a string
@@ -2099,7 +2337,7 @@ func chainErrToGBTErrString(err error) string {
 	case blockchain.ErrInvalidAncestorBlock:
 		return "bad-prevblk"
 	case blockchain.ErrPrevBlockNotBest:
-		return "inconclusive-not-best-prvblk"
+		return "inconclusive-not-best-prevblk"
 	}
 
 	return "rejected: " + err.Error()
@@ -2141,11 +2379,32 @@ func handleGetBlockTemplateProposal(s *rpcServer, request *btcjson.TemplateReque
 	}
 	block := bteutil.NewBlock(&msgBlock)
 
-	// Ensure the block is building from the expected previous block.
+	// Report blocks which are already known along with whether or not they
+	// are valid.
+	haveBlock, err := s.cfg.Chain.HaveBlock(block.Hash())
+	if err != nil {
+		context := "Failed to check for existing block"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	if haveBlock {
+		// Invalidated blocks keep the status of their prior validation,
+		// so the failure takes precedence.
+		switch {
+		case s.cfg.Chain.IsKnownInvalid(block.Hash()):
+			return "duplicate-invalid", nil
+		case s.cfg.Chain.MainChainHasBlock(block.Hash()) ||
+			s.cfg.Chain.IsKnownValid(block.Hash()):
+			return "duplicate", nil
+		}
+		return "duplicate-inconclusive", nil
+	}
+
+	// Ensure the block is building from the expected previous block.  The
+	// proposal can only be checked against the current best chain.
 	expectedPrevHash := s.cfg.Chain.BestSnapshot().Hash
 	prevHash := &block.MsgBlock().Header.PrevBlock
 	if !expectedPrevHash.IsEqual(prevHash) {
-		return "bad-prevblk", nil
+		return "inconclusive-not-best-prevblk", nil
 	}
 
 	if err := s.cfg.Chain.CheckConnectBlockTemplate(block); err != nil {
@@ -2252,6 +2511,21 @@ func handleGetCFilterHeader(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return hash.String(), nil
 }
 
+// handleGetChainTips implements the getchaintips command.
+func handleGetChainTips(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	chainTips := s.cfg.Chain.ChainTips()
+	results := make([]btcjson.GetChainTipsResult, 0, len(chainTips))
+	for _, tip := range chainTips {
+		results = append(results, btcjson.GetChainTipsResult{
+			Height:    tip.Height,
+			Hash:      tip.Hash.String(),
+			BranchLen: tip.BranchLen,
+			Status:    tip.Status.String(),
+		})
+	}
+	return results, nil
+}
+
 // handleGetConnectionCount implements the getconnectioncount command.
 func handleGetConnectionCount(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	return s.cfg.ConnMgr.ConnectedCount(), nil
@@ -2338,6 +2612,70 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 	return ret, nil
 }
 
+// mempoolEntriesResult returns the passed mempool entries as the result of the
+// getmempoolancestors and getmempooldescendants commands, which is either the
+// entries themselves when verbose is set or the hashes of the transactions.
+func mempoolEntriesResult(entries map[string]*btcjson.GetMempoolEntryResult, verbose *bool) interface{} {
+	if verbose != nil && *verbose {
+		return entries
+	}
+
+	hashStrings := make([]string, 0, len(entries))
+	for hash := range entries {
+		hashStrings = append(hashStrings, hash)
+	}
+	sort.Strings(hashStrings)
+	return hashStrings
+}
+
+// handleGetMempoolAncestors implements the getmempoolancestors command.
+func handleGetMempoolAncestors(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolAncestorsCmd)
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	ancestors, err := s.cfg.TxMemPool.MempoolAncestors(txHash)
+	if err != nil {
+		return nil, rpcNoTxInfoError(txHash)
+	}
+
+	return mempoolEntriesResult(ancestors, c.Verbose), nil
+}
+
+// handleGetMempoolDescendants implements the getmempooldescendants command.
+func handleGetMempoolDescendants(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolDescendantsCmd)
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	descendants, err := s.cfg.TxMemPool.MempoolDescendants(txHash)
+	if err != nil {
+		return nil, rpcNoTxInfoError(txHash)
+	}
+
+	return mempoolEntriesResult(descendants, c.Verbose), nil
+}
+
+// handleGetMempoolEntry implements the getmempoolentry command.
+func handleGetMempoolEntry(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolEntryCmd)
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	entry, err := s.cfg.TxMemPool.MempoolEntry(txHash)
+	if err != nil {
+		return nil, rpcNoTxInfoError(txHash)
+	}
+
+	return entry, nil
+}
+
 // handleGetMempoolInfo implements the getmempoolinfo command.
 func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	mempoolTxns := s.cfg.TxMemPool.TxDescs()
@@ -2347,9 +2685,19 @@ func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		numBytes += int64(txD.Tx.MsgTx().SerializeSize())
 	}
 
+	// The minimum fee rate required to enter the pool never drops below
+	// the minimum relay fee.
+	mempoolMinFee := s.cfg.TxMemPool.MinFeeRate()
+	if mempoolMinFee < cfg.minRelayTxFee {
+		mempoolMinFee = cfg.minRelayTxFee
+	}
+
 	ret := &btcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+		Size:          int64(len(mempoolTxns)),
+		Bytes:         numBytes,
+		MaxMempool:    cfg.MaxMempoolMB * 1000000,
+		MempoolMinFee: mempoolMinFee.ToBTE(),
+		MinRelayTxFee: cfg.minRelayTxFee.ToBTE(),
 	}
 
 	return ret, nil
@@ -2496,6 +2844,75 @@ func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return hashesPerSec, nil
 }
 
+// handleGetNetworkInfo implements the getnetworkinfo command.
+func handleGetNetworkInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// The user agent is built the same way as the one advertised to peers.
+	msg := wire.MsgVersion{UserAgent: wire.DefaultUserAgent}
+	msg.AddUserAgent(userAgentName, userAgentVersion,
+		cfg.UserAgentComments...)
+
+	var inbound, outbound int32
+	for _, p := range s.cfg.ConnMgr.ConnectedPeers() {
+		if p.ToPeer().Inbound() {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+
+	// Report the reachability of each supported network along with the
+	// proxy used to connect to it.
+	networks := make([]btcjson.NetworksResult, 0, len(supportedNetworks))
+	for _, name := range supportedNetworks {
+		proxy := cfg.Proxy
+		switch name {
+		case addrmgr.NetOnion:
+			if cfg.OnionProxy != "" {
+				proxy = cfg.OnionProxy
+			}
+		case addrmgr.NetI2P:
+			proxy = cfg.I2PSAM
+		}
+		reachable := cfg.reachableNets[name]
+		networks = append(networks, btcjson.NetworksResult{
+			Name:      name,
+			Limited:   !reachable,
+			Reachable: reachable,
+			Proxy:     proxy,
+			ProxyRandomizeCredentials: cfg.TorIsolation &&
+				name != addrmgr.NetI2P && proxy != "",
+		})
+	}
+
+	localAddrs := s.cfg.ConnMgr.LocalAddresses()
+	addrs := make([]btcjson.LocalAddressesResult, 0, len(localAddrs))
+	for _, la := range localAddrs {
+		addrs = append(addrs, btcjson.LocalAddressesResult{
+			Address: la.NetAddress.Addr.String(),
+			Port:    la.NetAddress.Port,
+			Score:   int32(la.Score),
+		})
+	}
+
+	ret := &btcjson.GetNetworkInfoResult{
+		Version:         int32(1000000*appMajor + 10000*appMinor + 100*appPatch),
+		SubVersion:      msg.UserAgent,
+		ProtocolVersion: int32(maxProtocolVersion),
+		LocalServices:   fmt.Sprintf("%016x", uint64(s.cfg.ConnMgr.LocalServices())),
+		LocalRelay:      !cfg.BlocksOnly,
+		TimeOffset:      int64(s.cfg.TimeSource.Offset().Seconds()),
+		Connections:     inbound + outbound,
+		ConnectionsIn:   inbound,
+		ConnectionsOut:  outbound,
+		NetworkActive:   true,
+		Networks:        networks,
+		RelayFee:        cfg.minRelayTxFee.ToBTE(),
+		IncrementalFee:  cfg.minRelayTxFee.ToBTE(),
+		LocalAddresses:  addrs,
+	}
+	return ret, nil
+}
+
 // handleGetNodeAddresses implements the getnodeaddresses command.
 func handleGetNodeAddresses(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetNodeAddressesCmd)
@@ -2611,6 +3028,15 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 	var blkHeight int32
 	tx, err := s.cfg.TxMemPool.FetchTransaction(txHash)
 	if err != nil {
+		if s.cfg.TxIndex == nil && s.cfg.Chain.IsPruned() {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCNoTxInfo,
+				Message: "No such mempool transaction.  Blockchain " +
+					"transactions are not available in pruned " +
+					"mode since the transaction index can't be " +
+					"enabled",
+			}
+		}
 		if s.cfg.TxIndex == nil {
 			return nil, &btcjson.RPCError{
 				Code: btcjson.ErrRPCNoTxInfo,
@@ -2814,6 +3240,26 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return txOutReply, nil
 }
 
+// handleGetTxOutSetInfo handles gettxoutsetinfo commands.
+func handleGetTxOutSetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	stats, err := s.cfg.Chain.UtxoStats()
+	if err != nil {
+		context := "Failed to calculate utxo set statistics"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return &btcjson.GetTxOutSetInfoResult{
+		Height:         int64(stats.Height),
+		BestBlock:      stats.Hash,
+		Transactions:   stats.Transactions,
+		TxOuts:         stats.Outputs,
+		BogoSize:       stats.BogoSize,
+		HashSerialized: stats.SerializedHash,
+		DiskSize:       stats.DiskSize,
+		TotalAmount:    bteutil.Amount(stats.TotalAmount),
+	}, nil
+}
+
 // handleHelp implements the help command.
 func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.HelpCmd)
@@ -2853,6 +3299,28 @@ func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return help, nil
 }
 
+// handleInvalidateBlock implements the invalidateblock command.
+func handleInvalidateBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.InvalidateBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.HeaderByHash(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+
+	if err := s.cfg.Chain.InvalidateBlock(hash); err != nil {
+		context := "Failed to invalidate block"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return nil, nil
+}
+
 // handlePing implements the ping command.
 func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Ask server to ping \o_
@@ -3087,6 +3555,96 @@ func fetchMempoolTxnsForAddress(s *rpcServer, addr bteutil.Address, numToSkip, n
 	return mpTxns[numToSkip:rangeEnd], numToSkip
 }
 
+// handlePreciousBlock implements the preciousblock command.
+func handlePreciousBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PreciousBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.HeaderByHash(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+
+	if err := s.cfg.Chain.PreciousBlock(hash); err != nil {
+		if _, ok := err.(blockchain.MissingBlockDataError); ok {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCBlockNotFound,
+				Message: "Block not available (only the header is known)",
+			}
+		}
+		context := "Failed to mark block as precious"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return nil, nil
+}
+
+// handlePrioritiseTransaction implements the prioritisetransaction command.
+func handlePrioritiseTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PrioritiseTransactionCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+	if c.PriorityDelta != 0 {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: "Priority deltas are not supported, " +
+				"priority_delta must be 0",
+		}
+	}
+
+	s.cfg.TxMemPool.PrioritiseTransaction(txHash, c.FeeDelta)
+	return true, nil
+}
+
+// handleReconsiderBlock implements the reconsiderblock command.
+func handleReconsiderBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ReconsiderBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.HeaderByHash(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+
+	if err := s.cfg.Chain.ReconsiderBlock(hash); err != nil {
+		context := "Failed to reconsider block"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return nil, nil
+}
+
+// handleSaveMempool implements the savemempool command.
+func handleSaveMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// Saving the mempool before the transactions from the last run have
+	// been loaded would lose the ones which are still pending.
+	if !s.cfg.TxMemPool.IsLoaded() {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "The mempool was not loaded yet",
+		}
+	}
+
+	path := filepath.Join(cfg.DataDir, mempool.DumpFileName)
+	if err := saveMempool(s.cfg.TxMemPool, path); err != nil {
+		context := "Unable to dump mempool to disk"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return &btcjson.SaveMempoolResult{Filename: path}, nil
+}
+
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if the address index is not enabled.
@@ -3577,6 +4135,189 @@ func handleSubmitBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return nil, nil
 }
 
+// handleSubmitPackage implements the submitpackage command.
+func handleSubmitPackage(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.SubmitPackageCmd)
+
+	if len(c.RawTxns) == 0 || len(c.RawTxns) > mempool.MaxPackageCount {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Array must contain between 1 and "+
+				"%d transactions", mempool.MaxPackageCount),
+		}
+	}
+
+	txns, err := decodeRawTxns(c.RawTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgResult, err := s.cfg.TxMemPool.ProcessPackage(txns)
+	if err != nil {
+		// When the error is a rule error, it means the package was
+		// simply rejected as opposed to something actually going wrong,
+		// so log it as such.
+		if _, ok := err.(mempool.RuleError); !ok {
+			rpcsLog.Errorf("Failed to process package: %v", err)
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCTxError,
+				Message: "Package rejected: " + err.Error(),
+			}
+		}
+
+		rpcsLog.Debugf("Rejected package: %v", err)
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCTxRejected,
+			Message: "Package rejected: " + err.Error(),
+		}
+	}
+
+	// Generate and relay inventory vectors for all newly accepted
+	// transactions and notify both websocket and getblocktemplate long
+	// poll clients of them.
+	s.cfg.ConnMgr.RelayTransactions(pkgResult.AcceptedTxs)
+	s.NotifyNewTransactions(pkgResult.AcceptedTxs)
+
+	// The transactions which were evaluated together are reported as the
+	// ones the effective fee rate includes.
+	var pkgWtxids []string
+	for i, txResult := range pkgResult.TxResults {
+		if txResult.PackageFeeRate {
+			pkgWtxids = append(pkgWtxids,
+				txns[i].WitnessHash().String())
+		}
+	}
+
+	reply := &btcjson.SubmitPackageResult{
+		PackageMsg: "success",
+		TxResults: make(map[string]btcjson.SubmitPackageTxResult,
+			len(txns)),
+	}
+	for i, txResult := range pkgResult.TxResults {
+		txD := txResult.TxDesc
+		tx := txns[i]
+
+		// Keep track of the newly accepted package transactions so
+		// that they can be rebroadcast if they don't make their way
+		// into a block.
+		if !txResult.AlreadyInPool {
+			iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+			s.cfg.ConnMgr.AddRebroadcastInventory(iv, txD)
+		}
+
+		effectiveIncludes := []string{tx.WitnessHash().String()}
+		if txResult.PackageFeeRate {
+			effectiveIncludes = pkgWtxids
+		}
+		reply.TxResults[tx.WitnessHash().String()] = btcjson.SubmitPackageTxResult{
+			Txid:  tx.Hash().String(),
+			Vsize: mempool.GetTxVirtualSize(tx),
+			Fees: btcjson.SubmitPackageFees{
+				Base:              bteutil.Amount(txD.Fee).ToBTE(),
+				EffectiveFeeRate:  bteutil.Amount(txResult.FeePerKB).ToBTE(),
+				EffectiveIncludes: effectiveIncludes,
+			},
+		}
+	}
+
+	return reply, nil
+}
+
+// decodeRawTxns decodes the passed hex-encoded serialized transactions.
+func decodeRawTxns(rawTxns []string) ([]*bteutil.Tx, error) {
+	txns := make([]*bteutil.Tx, 0, len(rawTxns))
+	for _, hexStr := range rawTxns {
+		if len(hexStr)%2 != 0 {
+			hexStr = "0" + hexStr
+		}
+		serializedTx, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, rpcDecodeHexError(hexStr)
+		}
+		var msgTx wire.MsgTx
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCDeserialization,
+				Message: "TX decode failed: " + err.Error(),
+			}
+		}
+		txns = append(txns, bteutil.NewTx(&msgTx))
+	}
+
+	return txns, nil
+}
+
+// handleTestMempoolAccept implements the testmempoolaccept command.
+func handleTestMempoolAccept(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.TestMempoolAcceptCmd)
+
+	if len(c.RawTxns) == 0 || len(c.RawTxns) > maxTestMempoolAcceptTxns {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Array must contain between 1 and "+
+				"%d transactions", maxTestMempoolAcceptTxns),
+		}
+	}
+
+	// A maximum fee rate of zero means there is no maximum.
+	var maxFeeRate bteutil.Amount
+	if c.MaxFeeRate != nil {
+		var err error
+		maxFeeRate, err = bteutil.NewAmount(*c.MaxFeeRate)
+		if err != nil || maxFeeRate < 0 {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid maxfeerate",
+			}
+		}
+	}
+
+	txns, err := decodeRawTxns(c.RawTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	results, errs := s.cfg.TxMemPool.CheckMempoolAcceptance(txns)
+	reply := make([]*btcjson.TestMempoolAcceptResult, 0, len(txns))
+	for i, tx := range txns {
+		item := &btcjson.TestMempoolAcceptResult{
+			Txid:  tx.Hash().String(),
+			Wtxid: tx.WitnessHash().String(),
+		}
+		reply = append(reply, item)
+
+		// Rule errors mean the transaction would simply be rejected as
+		// opposed to something actually going wrong.
+		if err := errs[i]; err != nil {
+			if _, ok := err.(mempool.RuleError); !ok {
+				context := "Failed to check transaction"
+				return nil, internalRPCError(err.Error(), context)
+			}
+			item.RejectReason = err.Error()
+			continue
+		}
+
+		result := results[i]
+		feeRate := result.TxFee * 1000 / bteutil.Amount(result.TxSize)
+		if maxFeeRate != 0 && feeRate > maxFeeRate {
+			item.RejectReason = fmt.Sprintf("transaction %v has a fee "+
+				"rate of %v which exceeds the maximum of %v",
+				tx.Hash(), feeRate, maxFeeRate)
+			continue
+		}
+
+		item.Allowed = true
+		item.Vsize = result.TxSize
+		item.Fees = &btcjson.TestMempoolAcceptFees{
+			Base:             result.TxFee.ToBTE(),
+			EffectiveFeeRate: feeRate.ToBTE(),
+		}
+	}
+
+	return reply, nil
+}
+
 // handleUptime implements the uptime command.
 func handleUptime(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	return time.Now().Unix() - s.cfg.StartupTime, nil
@@ -4528,6 +5269,13 @@ type rpcserverConnManager interface {
 	// NodeAddresses returns an array consisting node addresses which can
 	// potentially be used to find new nodes in the network.
 	NodeAddresses() []*wire.NetAddressV2
+
+	// LocalServices returns the services advertised to peers.
+	LocalServices() wire.ServiceFlag
+
+	// LocalAddresses returns the local addresses advertised to peers along
+	// with their scores.
+	LocalAddresses() []addrmgr.LocalAddress
 }
 
 // rpcserverSyncManager represents a sync manager for use with the RPC server.
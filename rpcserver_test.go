@@ -0,0 +1,342 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/blockchain"
+	"github.com/mraksoll4/bted/btcjson"
+	"github.com/mraksoll4/bted/bteutil"
+	"github.com/mraksoll4/bted/chaincfg"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+	"github.com/mraksoll4/bted/database"
+	"github.com/mraksoll4/bted/wire"
+)
+
+const (
+	// gbtTestBaseVersion is the version of the test blocks without any
+	// deployment bits set.
+	gbtTestBaseVersion = 0x20000000
+
+	// gbtTestVersion is the version of the test blocks which signal the
+	// dummy and segwit deployments.
+	gbtTestVersion = gbtTestBaseVersion | 1<<28 | 1<<1
+)
+
+// newGBTTestServer returns an RPC server backed by a new regression test chain
+// whose only started deployments are the dummy and segwit deployments, which
+// use the bits 28 and 1.  The confirmation window is shortened to four blocks
+// with an activation threshold of three, so the deployments progress through
+// their states quickly.  The returned function must be called to remove the
+// chain database once the test is done.
+func newGBTTestServer(t *testing.T) (*rpcServer, func()) {
+	t.Helper()
+
+	// The log rotator is not initialized by the tests, so logging must be
+	// disabled.
+	setLogLevels("off")
+
+	dir, err := ioutil.TempDir("", "rpcservergbt")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+
+	params := chaincfg.RegressionNetParams
+	params.MinerConfirmationWindow = 4
+	params.RuleChangeActivationThreshold = 3
+	neverStarts := chaincfg.NewMedianTimeDeploymentStarter(
+		time.Now().Add(time.Hour * 24 * 365 * 100))
+	params.Deployments[chaincfg.DeploymentTestDummyMinActivation].DeploymentStarter = neverStarts
+	params.Deployments[chaincfg.DeploymentCSV].DeploymentStarter = neverStarts
+	params.Deployments[chaincfg.DeploymentTaproot].DeploymentStarter = neverStarts
+
+	db, err := database.Create("ffldb", filepath.Join(dir, "db"), params.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	s := &rpcServer{cfg: rpcserverConfig{
+		Chain:       chain,
+		ChainParams: &params,
+	}}
+	return s, teardown
+}
+
+// newGBTTestBlock returns a solved block with the passed version which only
+// contains a coinbase transaction on top of the block with the passed hash and
+// height.  The passed tag is included in the coinbase so blocks at the same
+// height have distinct hashes.
+func newGBTTestBlock(t *testing.T, s *rpcServer, prevHash *chainhash.Hash, prevHeight int32, version int32, tag byte) *bteutil.Block {
+	t.Helper()
+
+	prevHeader, err := s.cfg.Chain.HeaderByHash(prevHash)
+	if err != nil {
+		t.Fatalf("HeaderByHash: unexpected error: %v", err)
+	}
+
+	height := prevHeight + 1
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{byte(height), tag},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(&wire.TxOut{
+		Value:    blockchain.CalcBlockSubsidy(height, s.cfg.ChainParams),
+		PkScript: []byte{0x51}, // OP_TRUE
+	})
+	merkles := blockchain.BuildMerkleTreeStore(
+		[]*bteutil.Tx{bteutil.NewTx(coinbase)}, false)
+
+	block := wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    version,
+			PrevBlock:  *prevHash,
+			MerkleRoot: *merkles[len(merkles)-1],
+			Timestamp:  prevHeader.Timestamp.Add(time.Minute),
+			Bits:       s.cfg.ChainParams.PowLimitBits,
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+
+	// Solve the block, which is quick with the regression test network
+	// difficulty.
+	target := blockchain.CompactToBig(block.Header.Bits)
+	for {
+		hash := block.Header.UncachedPowHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		block.Header.Nonce++
+	}
+	return bteutil.NewBlock(&block)
+}
+
+// processGBTTestBlock processes the passed block and fails the test when it is
+// not accepted.
+func processGBTTestBlock(t *testing.T, s *rpcServer, block *bteutil.Block) {
+	t.Helper()
+
+	_, isOrphan, err := s.cfg.Chain.ProcessBlock(block, blockchain.BFNone)
+	if err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	if isOrphan {
+		t.Fatalf("ProcessBlock: block %v is an orphan", block.Hash())
+	}
+}
+
+// mineGBTTestBlocks extends the best chain by the passed number of blocks which
+// signal the dummy and segwit deployments.
+func mineGBTTestBlocks(t *testing.T, s *rpcServer, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		best := s.cfg.Chain.BestSnapshot()
+		block := newGBTTestBlock(t, s, &best.Hash, best.Height,
+			gbtTestVersion, 0)
+		processGBTTestBlock(t, s, block)
+	}
+}
+
+// TestGBTDeploymentRules ensures the BIP0009 fields of block templates and the
+// block version reflect the state of the deployments and the rules supported
+// by the client.
+func TestGBTDeploymentRules(t *testing.T) {
+	s, teardown := newGBTTestServer(t)
+	defer teardown()
+
+	type ruleTest struct {
+		name        string
+		version     int32
+		clientRules []string
+		wantVersion int32
+		wantRules   []string
+		wantAvail   map[string]int
+		wantErr     bool
+	}
+	runTests := func(tests []ruleTest) {
+		t.Helper()
+
+		for _, test := range tests {
+			result := &btcjson.GetBlockTemplateResult{
+				Version:    test.version,
+				VbRequired: 1,
+			}
+			err := gbtDeploymentRules(s, result, test.clientRules)
+			if test.wantErr {
+				rpcErr, ok := err.(*btcjson.RPCError)
+				if !ok || rpcErr.Code != btcjson.ErrRPCInvalidParameter {
+					t.Errorf("%s: unexpected error -- got %v, "+
+						"want invalid parameter", test.name,
+						err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+				continue
+			}
+			if result.Version != test.wantVersion {
+				t.Errorf("%s: unexpected version -- got %08x, "+
+					"want %08x", test.name, result.Version,
+					test.wantVersion)
+			}
+			if !reflect.DeepEqual(result.Rules, test.wantRules) {
+				t.Errorf("%s: unexpected rules -- got %v, want %v",
+					test.name, result.Rules, test.wantRules)
+			}
+			if !reflect.DeepEqual(result.VbAvailable, test.wantAvail) {
+				t.Errorf("%s: unexpected vbavailable -- got %v, "+
+					"want %v", test.name, result.VbAvailable,
+					test.wantAvail)
+			}
+			if result.VbRequired != 0 {
+				t.Errorf("%s: unexpected vbrequired %d", test.name,
+					result.VbRequired)
+			}
+		}
+	}
+
+	// Both deployments are started once the first window is complete.  The
+	// segwit bit may not be ignored, so it is cleared unless the client
+	// supports the deployment.
+	mineGBTTestBlocks(t, s, 3)
+	started := map[string]int{"dummy": 28, "!segwit": 1}
+	runTests([]ruleTest{{
+		name:        "started without client support",
+		version:     gbtTestVersion,
+		wantVersion: gbtTestBaseVersion | 1<<28,
+		wantRules:   []string{},
+		wantAvail:   started,
+	}, {
+		name:        "started with client support",
+		version:     gbtTestVersion,
+		clientRules: []string{"segwit"},
+		wantVersion: gbtTestVersion,
+		wantRules:   []string{},
+		wantAvail:   started,
+	}})
+
+	// Locked in deployments have their bits set even when the template
+	// version does not signal them.
+	mineGBTTestBlocks(t, s, 4)
+	runTests([]ruleTest{{
+		name:        "locked in without client support",
+		version:     gbtTestBaseVersion,
+		wantVersion: gbtTestBaseVersion | 1<<28,
+		wantRules:   []string{},
+		wantAvail:   started,
+	}, {
+		name:        "locked in with client support",
+		version:     gbtTestBaseVersion,
+		clientRules: []string{"!segwit"},
+		wantVersion: gbtTestVersion,
+		wantRules:   []string{},
+		wantAvail:   started,
+	}})
+
+	// Active deployments are listed in the rules and segwit requires
+	// explicit client support.
+	mineGBTTestBlocks(t, s, 4)
+	runTests([]ruleTest{{
+		name:    "active without client support",
+		version: gbtTestBaseVersion,
+		wantErr: true,
+	}, {
+		name:        "active with client support",
+		version:     gbtTestBaseVersion,
+		clientRules: []string{"segwit"},
+		wantVersion: gbtTestBaseVersion,
+		wantRules:   []string{"dummy", "!segwit"},
+		wantAvail:   map[string]int{},
+	}})
+}
+
+// TestHandleGetBlockTemplateProposal ensures block proposals are checked
+// against the best chain and known blocks are reported as specified by
+// BIP0023.
+func TestHandleGetBlockTemplateProposal(t *testing.T) {
+	s, teardown := newGBTTestServer(t)
+	defer teardown()
+
+	mineGBTTestBlocks(t, s, 3)
+	best := s.cfg.Chain.BestSnapshot()
+	tip, err := s.cfg.Chain.BlockByHash(&best.Hash)
+	if err != nil {
+		t.Fatalf("BlockByHash: unexpected error: %v", err)
+	}
+	prevHash := tip.MsgBlock().Header.PrevBlock
+
+	propose := func(name string, block *bteutil.Block, want interface{}) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if err := block.MsgBlock().Serialize(&buf); err != nil {
+			t.Fatalf("%s: unable to serialize block: %v", name, err)
+		}
+		request := &btcjson.TemplateRequest{
+			Mode: "proposal",
+			Data: hex.EncodeToString(buf.Bytes()),
+		}
+		result, err := handleGetBlockTemplateProposal(s, request)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if result != want {
+			t.Fatalf("%s: unexpected result -- got %v, want %v",
+				name, result, want)
+		}
+	}
+
+	// The current tip is a valid duplicate.
+	propose("tip", tip, "duplicate")
+
+	// A new block on the tip is accepted.
+	valid := newGBTTestBlock(t, s, &best.Hash, best.Height, gbtTestVersion, 0)
+	propose("valid", valid, nil)
+
+	// A new block which does not build on the tip can't be checked.
+	side := newGBTTestBlock(t, s, &prevHash, best.Height-1, gbtTestVersion, 1)
+	propose("not best prevblk", side, "inconclusive-not-best-prevblk")
+
+	// A side chain block which was stored without being connected is
+	// neither known to be valid nor invalid.
+	processGBTTestBlock(t, s, side)
+	propose("side chain", side, "duplicate-inconclusive")
+
+	// Invalidating the tip reorganizes to the side chain block, and the
+	// former tip is reported as invalid even though it was connected
+	// before.
+	if err := s.cfg.Chain.InvalidateBlock(tip.Hash()); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	propose("invalidated", tip, "duplicate-invalid")
+	propose("new tip", side, "duplicate")
+}
@@ -115,6 +115,18 @@ var helpDescsEnUS = map[string]string{
 	"decodescript--synopsis": "Returns a JSON object with information about the provided hex-encoded script.",
 	"decodescript-hexscript": "Hex-encoded script",
 
+	// DumpTxOutSetCmd help.
+	"dumptxoutset--synopsis": "Writes a snapshot of the unspent transaction output set along with the block headers leading up to it to a file.\n" +
+		"The snapshot can be used to bootstrap new nodes with the --utxosnapshot option when its hash is known to the network parameters.",
+	"dumptxoutset-path": "The path of the snapshot file, relative to the data directory unless absolute -- the file must not exist",
+
+	// DumpTxOutSetResult help.
+	"dumptxoutsetresult-coins_written": "The number of unspent transaction outputs written",
+	"dumptxoutsetresult-base_hash":     "The hash of the block the snapshot was taken at",
+	"dumptxoutsetresult-base_height":   "The height of the block the snapshot was taken at",
+	"dumptxoutsetresult-path":          "The path of the written snapshot file",
+	"dumptxoutsetresult-snapshot_hash": "The double sha256 of the snapshot file",
+
 	// EstimateFeeCmd help.
 	"estimatefee--synopsis": "Estimate the fee per kilobyte in satoshis " +
 		"required for a transaction to be mined before a certain number of " +
@@ -124,6 +136,25 @@ var helpDescsEnUS = map[string]string{
 	"estimatefee--result0": "Estimated fee per kilobyte in satoshis for a block to " +
 		"be mined in the next NumBlocks blocks.",
 
+	// EstimateSmartFeeCmd help.
+	"estimatesmartfee--synopsis": "Estimate the fee rate in BTE/kB required " +
+		"for a transaction to be confirmed within a certain number of " +
+		"blocks from how long transactions paying different fee rates " +
+		"took to confirm.",
+	"estimatesmartfee-conftarget": "The number of blocks the transaction " +
+		"should be confirmed within (1 to 1008)",
+	"estimatesmartfee-estimatemode": "The fee estimation mode, either " +
+		"ECONOMICAL or CONSERVATIVE.  Conservative estimates consider " +
+		"a longer history and are more likely to be sufficient",
+
+	// EstimateSmartFeeResult help.
+	"estimatesmartfeeresult-feerate": "The estimated fee rate in BTE/kB, " +
+		"omitted when no estimate is available",
+	"estimatesmartfeeresult-errors": "Errors encountered during processing",
+	"estimatesmartfeeresult-blocks": "The number of blocks the estimate is " +
+		"for, which may be lower than the requested target when there " +
+		"is not enough data",
+
 	// GenerateCmd help
 	"generate--synopsis": "Generates a set number of blocks (simnet or regtest only) and returns a JSON\n" +
 		" array of their hashes.",
@@ -297,7 +328,7 @@ var helpDescsEnUS = map[string]string{
 	"templaterequest-target":       "The desired target for the block template (this parameter is ignored)",
 	"templaterequest-data":         "Hex-encoded block data (only for mode=proposal)",
 	"templaterequest-workid":       "The server provided workid if provided in block template (not applicable)",
-	"templaterequest-rules":        "Specific block rules that are to be enforced e.g. '[\"segwit\"]",
+	"templaterequest-rules":        "The BIP0009 deployment rules supported by the client e.g. '[\"segwit\"]'; required once a deployment which is prefixed with '!' in the result rules is active",
 
 	// GetBlockTemplateResultTx help.
 	"getblocktemplateresulttx-data":    "Hex-encoded transaction data (byte-for-byte)",
@@ -337,6 +368,12 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplateresult-reject-reason":              "Reason the proposal was invalid as-is (only applies to proposal responses)",
 	"getblocktemplateresult-default_witness_commitment": "The witness commitment itself. Will be populated if the block has witness data",
 	"getblocktemplateresult-weightlimit":                "The current limit on the max allowed weight of a block",
+	"getblocktemplateresult-rules":                      "The active BIP0009 deployments; clients must understand the ones prefixed with '!' to use the template",
+	"getblocktemplateresult-vbavailable":                "The pending BIP0009 deployments the block version may signal for",
+	"getblocktemplateresult-vbavailable--key":           "rulename",
+	"getblocktemplateresult-vbavailable--value":         "n",
+	"getblocktemplateresult-vbavailable--desc":          "The name of each pending deployment as the key and its bit as the value",
+	"getblocktemplateresult-vbrequired":                 "Bit mask of deployments which must be signaled in the block version (always 0)",
 
 	// GetBlockTemplateCmd help.
 	"getblocktemplate--synopsis": "Returns a JSON object with information necessary to construct a block to mine or accepts a proposal to validate.\n" +
@@ -359,6 +396,15 @@ var helpDescsEnUS = map[string]string{
 	"getcfilterheader-hash":       "The hash of the block",
 	"getcfilterheader--result0":   "The block's gcs filter header",
 
+	// GetChainTipsCmd help.
+	"getchaintips--synopsis": "Returns information about all known tips in the block tree, including the main chain as well as orphaned branches.",
+
+	// GetChainTipsResult help.
+	"getchaintipsresult-height":    "The height of the chain tip",
+	"getchaintipsresult-hash":      "The block hash of the chain tip",
+	"getchaintipsresult-branchlen": "The length of the branch connecting the tip to the main chain (zero for the main chain)",
+	"getchaintipsresult-status":    "The status of the chain (active, valid-fork, valid-headers, headers-only or invalid)",
+
 	// GetConnectionCountCmd help.
 	"getconnectioncount--synopsis": "Returns the number of active connections to other peers.",
 	"getconnectioncount--result0":  "The number of connections",
@@ -418,12 +464,61 @@ var helpDescsEnUS = map[string]string{
 	// GetInfoCmd help.
 	"getinfo--synopsis": "Returns a JSON object containing various state info.",
 
+	// GetMempoolAncestorsCmd help.
+	"getmempoolancestors--synopsis":   "Returns all of the in-mempool ancestors of a transaction in the memory pool.",
+	"getmempoolancestors-txid":        "The hash of the transaction",
+	"getmempoolancestors-verbose":     "Returns JSON objects keyed by transaction hash when true or an array of transaction hashes when false",
+	"getmempoolancestors--condition0": "verbose=false",
+	"getmempoolancestors--condition1": "verbose=true",
+	"getmempoolancestors--result0":    "Array of transaction hashes",
+
+	// GetMempoolDescendantsCmd help.
+	"getmempooldescendants--synopsis":   "Returns all of the in-mempool descendants of a transaction in the memory pool.",
+	"getmempooldescendants-txid":        "The hash of the transaction",
+	"getmempooldescendants-verbose":     "Returns JSON objects keyed by transaction hash when true or an array of transaction hashes when false",
+	"getmempooldescendants--condition0": "verbose=false",
+	"getmempooldescendants--condition1": "verbose=true",
+	"getmempooldescendants--result0":    "Array of transaction hashes",
+
+	// GetMempoolEntryCmd help.
+	"getmempoolentry--synopsis": "Returns information about a transaction in the memory pool.",
+	"getmempoolentry-txid":      "The hash of the transaction",
+
+	// GetMempoolEntryResult help.
+	"getmempoolentryresult-vsize":              "The virtual size of the transaction",
+	"getmempoolentryresult-size":               "Transaction size in bytes",
+	"getmempoolentryresult-weight":             "The transaction's weight (between vsize*4-3 and vsize*4)",
+	"getmempoolentryresult-fee":                "Transaction fee in BTE",
+	"getmempoolentryresult-modifiedfee":        "Transaction fee in BTE with fee deltas used for mining priority",
+	"getmempoolentryresult-time":               "Local time transaction entered pool in seconds since 1 Jan 1970 GMT",
+	"getmempoolentryresult-height":             "Block height when transaction entered the pool",
+	"getmempoolentryresult-descendantcount":    "Number of in-mempool descendant transactions (including this one)",
+	"getmempoolentryresult-descendantsize":     "Virtual size of in-mempool descendants (including this one)",
+	"getmempoolentryresult-descendantfees":     "Modified fees of in-mempool descendants (including this one) in satoshis",
+	"getmempoolentryresult-ancestorcount":      "Number of in-mempool ancestor transactions (including this one)",
+	"getmempoolentryresult-ancestorsize":       "Virtual size of in-mempool ancestors (including this one)",
+	"getmempoolentryresult-ancestorfees":       "Modified fees of in-mempool ancestors (including this one) in satoshis",
+	"getmempoolentryresult-wtxid":              "The hash of the transaction including its witness data",
+	"getmempoolentryresult-fees":               "The fees of the transaction and its in-mempool relatives",
+	"getmempoolentryresult-depends":            "Unconfirmed transactions used as inputs for this transaction",
+	"getmempoolentryresult-spentby":            "Unconfirmed transactions spending outputs from this transaction",
+	"getmempoolentryresult-bip125-replaceable": "Whether or not the transaction could be replaced due to BIP125 (replace-by-fee)",
+
+	// MempoolFees help.
+	"mempoolfees-base":       "Transaction fee in BTE",
+	"mempoolfees-modified":   "Transaction fee in BTE with fee deltas used for mining priority",
+	"mempoolfees-ancestor":   "Modified fees of in-mempool ancestors (including this one) in BTE",
+	"mempoolfees-descendant": "Modified fees of in-mempool descendants (including this one) in BTE",
+
 	// GetMempoolInfoCmd help.
 	"getmempoolinfo--synopsis": "Returns memory pool information",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":         "Size in bytes of the mempool",
+	"getmempoolinforesult-size":          "Number of transactions in the mempool",
+	"getmempoolinforesult-maxmempool":    "Maximum total virtual size in bytes of the mempool",
+	"getmempoolinforesult-mempoolminfee": "Minimum fee rate in BTE/kB for transactions to be accepted, which is raised above the minimum relay fee while the mempool is full",
+	"getmempoolinforesult-minrelaytxfee": "Minimum fee rate in BTE/kB for transactions to be relayed",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":             "Height of the latest best block",
@@ -448,6 +543,38 @@ var helpDescsEnUS = map[string]string{
 	"getnetworkhashps-height":    "Perform estimate ending with this height or -1 for current best chain block height",
 	"getnetworkhashps--result0":  "Estimated hashes per second",
 
+	// GetNetworkInfoCmd help.
+	"getnetworkinfo--synopsis": "Returns a JSON object containing various state info regarding P2P networking.",
+
+	// NetworksResult help.
+	"networksresult-name":                        "The name of the network (ipv4, ipv6, onion, i2p, or cjdns)",
+	"networksresult-limited":                     "Whether connections to the network are disabled",
+	"networksresult-reachable":                   "Whether the network is reachable",
+	"networksresult-proxy":                       "The proxy used to connect to the network, or the SAM bridge for i2p",
+	"networksresult-proxy_randomize_credentials": "Whether randomized credentials are used for the proxy (Tor stream isolation)",
+
+	// LocalAddressesResult help.
+	"localaddressesresult-address": "The network address advertised to peers",
+	"localaddressesresult-port":    "The port advertised to peers",
+	"localaddressesresult-score":   "The priority of the address",
+
+	// GetNetworkInfoResult help.
+	"getnetworkinforesult-version":         "The version of the node as a numeric",
+	"getnetworkinforesult-subversion":      "The user agent advertised to peers",
+	"getnetworkinforesult-protocolversion": "The latest supported protocol version",
+	"getnetworkinforesult-localservices":   "The services advertised to peers as a hex string",
+	"getnetworkinforesult-localrelay":      "Whether transactions are relayed to peers",
+	"getnetworkinforesult-timeoffset":      "The time offset",
+	"getnetworkinforesult-connections":     "The number of connected peers",
+	"getnetworkinforesult-connections_in":  "The number of inbound peers",
+	"getnetworkinforesult-connections_out": "The number of outbound peers",
+	"getnetworkinforesult-networkactive":   "Whether networking is enabled",
+	"getnetworkinforesult-networks":        "The reachability of each supported network",
+	"getnetworkinforesult-relayfee":        "The minimum relay fee for non-free transactions in BTE/KB",
+	"getnetworkinforesult-incrementalfee":  "The minimum fee rate increase for replacement transactions in BTE/KB",
+	"getnetworkinforesult-localaddresses":  "The local addresses advertised to peers",
+	"getnetworkinforesult-warnings":        "Any network warnings",
+
 	// GetNetTotalsCmd help.
 	"getnettotals--synopsis": "Returns a JSON object containing network traffic statistics.",
 
@@ -496,6 +623,7 @@ var helpDescsEnUS = map[string]string{
 	// GetRawMempoolVerboseResult help.
 	"getrawmempoolverboseresult-size":             "Transaction size in bytes",
 	"getrawmempoolverboseresult-fee":              "Transaction fee in bitcoins",
+	"getrawmempoolverboseresult-modifiedfee":      "Transaction fee in bitcoins with fee deltas used for mining priority",
 	"getrawmempoolverboseresult-time":             "Local time transaction entered pool in seconds since 1 Jan 1970 GMT",
 	"getrawmempoolverboseresult-height":           "Block height when transaction entered the pool",
 	"getrawmempoolverboseresult-startingpriority": "Priority when transaction entered the pool",
@@ -533,6 +661,20 @@ var helpDescsEnUS = map[string]string{
 	"gettxout-vout":           "The index of the output",
 	"gettxout-includemempool": "Include the mempool when true",
 
+	// GetTxOutSetInfoCmd help.
+	"gettxoutsetinfo--synopsis": "Returns statistics about the unspent transaction output set.\n" +
+		"Any changes to the set held in memory are written to the database first and the entire set is scanned, so this call may take some time.",
+
+	// GetTxOutSetInfoResult help.
+	"gettxoutsetinforesult-height":            "The height of the block the statistics are for",
+	"gettxoutsetinforesult-bestblock":         "The hash of the block the statistics are for",
+	"gettxoutsetinforesult-transactions":      "The number of transactions with unspent outputs",
+	"gettxoutsetinforesult-txouts":            "The number of unspent transaction outputs",
+	"gettxoutsetinforesult-bogosize":          "A database-independent metric for the size of the unspent outputs",
+	"gettxoutsetinforesult-hash_serialized_2": "The double sha256 of the serialized unspent outputs ordered by outpoint, which is identical across nodes with the same set",
+	"gettxoutsetinforesult-disk_size":         "The number of bytes the unspent outputs use in the database",
+	"gettxoutsetinforesult-total_amount":      "The total amount of all unspent outputs in BTE",
+
 	// HelpCmd help.
 	"help--synopsis":   "Returns a list of all commands or help for a specified command.",
 	"help-command":     "The command to retrieve help for",
@@ -541,10 +683,39 @@ var helpDescsEnUS = map[string]string{
 	"help--result0":    "List of commands",
 	"help--result1":    "Help for specified command",
 
+	// InvalidateBlockCmd help.
+	"invalidateblock--synopsis": "Permanently marks a block as invalid, as if it violated a consensus rule.\n" +
+		"All of its descendants are marked invalid as well and the chain is reorganized to the valid chain with the most work.",
+	"invalidateblock-blockhash": "The hash of the block to mark as invalid",
+
 	// PingCmd help.
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// PreciousBlockCmd help.
+	"preciousblock--synopsis": "Treats a block as if it were received before others with the same work.\n" +
+		"A later preciousblock call can override the effect of an earlier one.",
+	"preciousblock-blockhash": "The hash of the block to mark as precious",
+
+	// PrioritiseTransactionCmd help.
+	"prioritisetransaction--synopsis": "Modifies the fee of a transaction used to select transactions for new blocks and to check the minimum fees required by the memory pool.\n" +
+		"The fee the transaction actually pays is not changed.  Deltas accumulate and are kept until the transaction is mined, even if it is not in the memory pool yet.",
+	"prioritisetransaction-txid":          "The hash of the transaction",
+	"prioritisetransaction-prioritydelta": "Unused, must be 0",
+	"prioritisetransaction-feedelta":      "The amount in satoshis to add to the fee of the transaction, or subtract when negative",
+	"prioritisetransaction--result0":      "Always true",
+
+	// ReconsiderBlockCmd help.
+	"reconsiderblock--synopsis": "Removes invalidity status of a block, its ancestors and its descendants, reconsidering them for activation.\n" +
+		"This can be used to undo the effects of invalidateblock.",
+	"reconsiderblock-blockhash": "The hash of the block to reconsider",
+
+	// SaveMempoolCmd help.
+	"savemempool--synopsis": "Writes the transactions in the memory pool to the mempool.dat file in the data directory so they are loaded again on the next start.",
+
+	// SaveMempoolResult help.
+	"savemempoolresult-filename": "The path of the written file",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -595,6 +766,46 @@ var helpDescsEnUS = map[string]string{
 	"submitblock--condition1": "Block rejected",
 	"submitblock--result1":    "The reason the block was rejected",
 
+	// SubmitPackageCmd help.
+	"submitpackage--synopsis": "Submits a package of a child and its parents to the memory pool and relays them to the network.\n" +
+		"Parents which do not pay a sufficient fee on their own are accepted if the package as a whole pays a sufficient fee rate.",
+	"submitpackage-rawtxns": "Serialized, hex-encoded transactions ordered such that parents come before the child, which must be last",
+
+	// SubmitPackageResult help.
+	"submitpackageresult-package_msg":       "The result of the package validation, which is \"success\" when the package was accepted",
+	"submitpackageresult-tx-results":        "The results of the transactions keyed by their witness hash",
+	"submitpackageresult-tx-results--key":   "wtxid",
+	"submitpackageresult-tx-results--value": "object",
+	"submitpackageresult-tx-results--desc":  "The result of the transaction with the witness hash",
+
+	// SubmitPackageTxResult help.
+	"submitpackagetxresult-txid":  "The hash of the transaction",
+	"submitpackagetxresult-vsize": "The virtual size of the transaction",
+	"submitpackagetxresult-fees":  "The fees of the transaction",
+
+	// SubmitPackageFees help.
+	"submitpackagefees-base":               "The fees of the transaction in BTE",
+	"submitpackagefees-effective-feerate":  "The fee rate in BTE/kvB the transaction was accepted with, which is the fee rate of the package when it was evaluated as part of one",
+	"submitpackagefees-effective-includes": "The witness hashes of the transactions whose fees and virtual sizes are included in the effective fee rate",
+
+	// TestMempoolAcceptCmd help.
+	"testmempoolaccept--synopsis": "Returns whether or not raw transactions would be accepted into the memory pool without adding them to it or relaying them.\n" +
+		"The transactions are checked in order and may spend outputs of the transactions before them.",
+	"testmempoolaccept-rawtxns":    "Serialized, hex-encoded transactions",
+	"testmempoolaccept-maxfeerate": "Reject transactions whose fee rate in BTE/kB is higher than this value (0 for no maximum)",
+
+	// TestMempoolAcceptResult help.
+	"testmempoolacceptresult-txid":          "The hash of the transaction",
+	"testmempoolacceptresult-wtxid":         "The witness hash of the transaction",
+	"testmempoolacceptresult-allowed":       "Whether or not the transaction would be accepted into the memory pool",
+	"testmempoolacceptresult-vsize":         "The virtual size of the transaction (only when allowed)",
+	"testmempoolacceptresult-fees":          "The fees of the transaction (only when allowed)",
+	"testmempoolacceptresult-reject-reason": "The reason the transaction would be rejected (only when not allowed)",
+
+	// TestMempoolAcceptFees help.
+	"testmempoolacceptfees-base":              "The fee paid by the transaction in BTE",
+	"testmempoolacceptfees-effective-feerate": "The fee rate of the transaction in BTE/kB",
+
 	// ValidateAddressResult help.
 	"validateaddresschainresult-isvalid":         "Whether or not the address is valid",
 	"validateaddresschainresult-address":         "The bitcoin address (only when isvalid is true)",
@@ -717,7 +928,9 @@ var rpcResultTypes = map[string][]interface{}{
 	"debuglevel":             {(*string)(nil), (*string)(nil)},
 	"decoderawtransaction":   {(*btcjson.TxRawDecodeResult)(nil)},
 	"decodescript":           {(*btcjson.DecodeScriptResult)(nil)},
+	"dumptxoutset":           {(*btcjson.DumpTxOutSetResult)(nil)},
 	"estimatefee":            {(*float64)(nil)},
+	"estimatesmartfee":       {(*btcjson.EstimateSmartFeeResult)(nil)},
 	"generate":               {(*[]string)(nil)},
 	"getaddednodeinfo":       {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
 	"getbestblock":           {(*btcjson.GetBestBlockResult)(nil)},
@@ -730,6 +943,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"getblockchaininfo":      {(*btcjson.GetBlockChainInfoResult)(nil)},
 	"getcfilter":             {(*string)(nil)},
 	"getcfilterheader":       {(*string)(nil)},
+	"getchaintips":           {(*[]btcjson.GetChainTipsResult)(nil)},
 	"getconnectioncount":     {(*int32)(nil)},
 	"getcurrentnet":          {(*uint32)(nil)},
 	"getdifficulty":          {(*float64)(nil)},
@@ -737,24 +951,36 @@ var rpcResultTypes = map[string][]interface{}{
 	"gethashespersec":        {(*float64)(nil)},
 	"getheaders":             {(*[]string)(nil)},
 	"getinfo":                {(*btcjson.InfoChainResult)(nil)},
+	"getmempoolancestors":    {(*[]string)(nil), (*btcjson.GetMempoolEntryResult)(nil)},
+	"getmempooldescendants":  {(*[]string)(nil), (*btcjson.GetMempoolEntryResult)(nil)},
+	"getmempoolentry":        {(*btcjson.GetMempoolEntryResult)(nil)},
 	"getmempoolinfo":         {(*btcjson.GetMempoolInfoResult)(nil)},
 	"getmininginfo":          {(*btcjson.GetMiningInfoResult)(nil)},
 	"getnettotals":           {(*btcjson.GetNetTotalsResult)(nil)},
 	"getnetworkhashps":       {(*float64)(nil)},
+	"getnetworkinfo":         {(*btcjson.GetNetworkInfoResult)(nil)},
 	"getnodeaddresses":       {(*[]btcjson.GetNodeAddressesResult)(nil)},
 	"getpeerinfo":            {(*[]btcjson.GetPeerInfoResult)(nil)},
 	"getrawmempool":          {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
 	"getrawtransaction":      {(*string)(nil), (*btcjson.TxRawResult)(nil)},
 	"gettxout":               {(*btcjson.GetTxOutResult)(nil)},
+	"gettxoutsetinfo":        {(*btcjson.GetTxOutSetInfoResult)(nil)},
 	"node":                   nil,
 	"help":                   {(*string)(nil), (*string)(nil)},
+	"invalidateblock":        nil,
 	"ping":                   nil,
+	"preciousblock":          nil,
+	"prioritisetransaction":  {(*bool)(nil)},
+	"reconsiderblock":        nil,
+	"savemempool":            {(*btcjson.SaveMempoolResult)(nil)},
 	"searchrawtransactions":  {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":     {(*string)(nil)},
 	"setgenerate":            nil,
 	"signmessagewithprivkey": {(*string)(nil)},
 	"stop":                   {(*string)(nil)},
 	"submitblock":            {nil, (*string)(nil)},
+	"submitpackage":          {(*btcjson.SubmitPackageResult)(nil)},
+	"testmempoolaccept":      {(*[]btcjson.TestMempoolAcceptResult)(nil)},
 	"uptime":                 {(*int64)(nil)},
 	"validateaddress":        {(*btcjson.ValidateAddressChainResult)(nil)},
 	"verifychain":            {(*bool)(nil)},
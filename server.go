@@ -6,6 +6,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
@@ -14,6 +15,8 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -34,6 +37,7 @@ import (
 	"github.com/mraksoll4/bted/mempool"
 	"github.com/mraksoll4/bted/mining"
 	"github.com/mraksoll4/bted/mining/cpuminer"
+	"github.com/mraksoll4/bted/mining/stratum"
 	"github.com/mraksoll4/bted/netsync"
 	"github.com/mraksoll4/bted/peer"
 	"github.com/mraksoll4/bted/txscript"
@@ -45,7 +49,12 @@ const (
 	// defaultServices describes the default services that are supported by
 	// the server.
 	defaultServices = wire.SFNodeNetwork | wire.SFNodeBloom |
-		wire.SFNodeWitness | wire.SFNodeCF
+		wire.SFNodeWitness | wire.SFNodeCF | wire.SFNodeP2PV2
+
+	// maxV1RetryAddrs is the maximum number of addresses of outbound peers
+	// which rejected the v2 transport that are remembered until they are
+	// reconnected to with the v1 transport.
+	maxV1RetryAddrs = 1000
 
 	// defaultRequiredServices describes the default services that are
 	// required to be supported by outbound peers.
@@ -58,6 +67,25 @@ const (
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// i2pSessionRetryInterval is the amount of time to wait in between
+	// attempts to create the session with the I2P router in order to
+	// advertise its address.
+	i2pSessionRetryInterval = time.Minute
+
+	// torControlRetryInterval is the amount of time to wait in between
+	// attempts to create the onion service through the Tor control port.
+	torControlRetryInterval = time.Minute
+
+	// maxCmpctBlockDepth is the number of blocks from the tip of the best
+	// chain within which blocks requested as compact blocks are sent as
+	// such.  Older blocks are sent in full instead.
+	maxCmpctBlockDepth = 5
+
+	// maxCmpctBlockTxnDepth is the number of blocks from the tip of the best
+	// chain within which transactions requested with getblocktxn messages
+	// are sent.  The full block is sent instead for older blocks.
+	maxCmpctBlockTxnDepth = 10
 )
 
 var (
@@ -95,6 +123,53 @@ func (oa *onionAddr) Network() string {
 // Ensure onionAddr implements the net.Addr interface.
 var _ net.Addr = (*onionAddr)(nil)
 
+// onionListener wraps the local listener the connections to the onion service
+// created through the Tor control port are forwarded to, so the connections it
+// accepts can be told apart from the ones accepted by the other listeners.
+type onionListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connection to the onion service.
+//
+// This is part of the net.Listener interface.
+func (l onionListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return onionConn{conn}, nil
+}
+
+// onionConn is a connection accepted by an onionListener.  All of them appear
+// to come from the local host Tor runs on, so their remote addresses don't
+// identify the peer.
+type onionConn struct {
+	net.Conn
+}
+
+// i2pAddr implements the net.Addr interface and represents an I2P address.
+type i2pAddr struct {
+	addr string
+}
+
+// String returns the I2P address.
+//
+// This is part of the net.Addr interface.
+func (ia *i2pAddr) String() string {
+	return ia.addr
+}
+
+// Network returns "i2p".
+//
+// This is part of the net.Addr interface.
+func (ia *i2pAddr) Network() string {
+	return "i2p"
+}
+
+// Ensure i2pAddr implements the net.Addr interface.
+var _ net.Addr = (*i2pAddr)(nil)
+
 // simpleAddr implements the net.Addr interface with two struct fields
 type simpleAddr struct {
 	net, addr string
@@ -216,6 +291,7 @@ type server struct {
 	chain                *blockchain.BlockChain
 	txMemPool            *mempool.TxPool
 	cpuMiner             *cpuminer.CPUMiner
+	stratumServer        *stratum.Server
 	modifyRebroadcastInv chan interface{}
 	newPeers             chan *serverPeer
 	donePeers            chan *serverPeer
@@ -230,6 +306,7 @@ type server struct {
 	db                   database.DB
 	timeSource           blockchain.MedianTimeSource
 	services             wire.ServiceFlag
+	torController        *connmgr.TorController
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -248,6 +325,14 @@ type server struct {
 	cfCheckptCaches    map[wire.FilterType][]cfHeaderKV
 	cfCheckptCachesMtx sync.RWMutex
 
+	// removedTxns houses the hashes of transactions which were removed
+	// from the mempool without being mined until the rebroadcast handler
+	// stops rebroadcasting them in a batch.  removedTxnsSignal is notified
+	// whenever hashes are added.
+	removedTxns       []chainhash.Hash
+	removedTxnsMtx    sync.Mutex
+	removedTxnsSignal chan struct{}
+
 	// agentBlacklist is a list of blacklisted substrings by which to filter
 	// user agents.
 	agentBlacklist []string
@@ -255,6 +340,12 @@ type server struct {
 	// agentWhitelist is a list of whitelisted user agent substrings, no
 	// whitelisting will be applied if the list is empty or nil.
 	agentWhitelist []string
+
+	// v1RetryAddrs houses the addresses of outbound peers which rejected
+	// the v2 transport handshake, so the next connection to them uses the
+	// v1 transport.  Addresses are removed once they are reconnected to,
+	// so later connections try the v2 transport again.
+	v1RetryAddrs lru.Cache
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
@@ -273,6 +364,7 @@ type serverPeer struct {
 	disableRelayTx bool
 	sentAddrs      bool
 	isWhitelisted  bool
+	onionInbound   bool
 	filter         *bloom.Filter
 	addressesMtx   sync.RWMutex
 	knownAddresses lru.Cache
@@ -341,6 +433,17 @@ func (sp *serverPeer) relayTxDisabled() bool {
 	return isDisabled
 }
 
+// txInvVect returns the inventory vector the given transaction is announced to
+// the peer with.  It identifies the transaction by its witness hash when the
+// peer negotiated wtxid relay (BIP0339) and by its hash otherwise.
+// It is safe for concurrent access.
+func (sp *serverPeer) txInvVect(tx *bteutil.Tx) *wire.InvVect {
+	if sp.WantsWTxIdRelay() {
+		return wire.NewInvVect(wire.InvTypeWTx, tx.WitnessHash())
+	}
+	return wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+}
+
 // pushAddrMsg sends a legacy addr message to the connected peer using the
 // provided addresses.
 func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddressV2) {
@@ -379,13 +482,15 @@ func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddressV2) {
 			continue
 		}
 
-		// Must skip the V3 addresses for legacy ADDR messages.
-		if addr.IsTorV3() {
+		// Must skip the V3, I2P and CJDNS addresses for legacy ADDR
+		// messages.
+		legacyAddr := addr.ToLegacy()
+		if legacyAddr == nil {
 			continue
 		}
 
-		// Convert the NetAddressV2 to a legacy address.
-		addrs = append(addrs, addr.ToLegacy())
+		// Add the legacy encoding of the NetAddressV2.
+		addrs = append(addrs, legacyAddr)
 	}
 
 	known, err := sp.PushAddrMsg(addrs)
@@ -569,8 +674,7 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 		// or only the transactions that match the filter when there is
 		// one.
 		if !sp.filter.IsLoaded() || sp.filter.MatchTxAndUpdate(txDesc.Tx) {
-			iv := wire.NewInvVect(wire.InvTypeTx, txDesc.Tx.Hash())
-			invMsg.AddInvVect(iv)
+			invMsg.AddInvVect(sp.txInvVect(txDesc.Tx))
 			if len(invMsg.InvList)+1 > wire.MaxInvPerMsg {
 				break
 			}
@@ -598,8 +702,7 @@ func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
 	// Convert the raw MsgTx to a bteutil.Tx which provides some convenience
 	// methods and things such as hash caching.
 	tx := bteutil.NewTx(msg)
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
-	sp.AddKnownInventory(iv)
+	sp.AddKnownInventory(sp.txInvVect(tx))
 
 	// Queue the transaction up to be handled by the sync manager and
 	// intentionally block further receives until the transaction is fully
@@ -636,6 +739,66 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	<-sp.blockProcessed
 }
 
+// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin message.
+// It blocks until the block it represents has been fully processed or the
+// transactions missing from it have been requested.
+func (sp *serverPeer) OnCmpctBlock(_ *peer.Peer, msg *wire.MsgCmpctBlock) {
+	sp.server.syncManager.QueueCmpctBlock(msg, sp.Peer, sp.blockProcessed)
+	<-sp.blockProcessed
+}
+
+// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin message.  It
+// blocks until the block the transactions complete has been fully processed.
+func (sp *serverPeer) OnBlockTxn(_ *peer.Peer, msg *wire.MsgBlockTxn) {
+	sp.server.syncManager.QueueBlockTxn(msg, sp.Peer, sp.blockProcessed)
+	<-sp.blockProcessed
+}
+
+// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin message
+// and is used to deliver the transactions of a recent block which the peer was
+// unable to find when reconstructing it from a compact block.
+func (sp *serverPeer) OnGetBlockTxn(_ *peer.Peer, msg *wire.MsgGetBlockTxn) {
+	chain := sp.server.chain
+	height, err := chain.BlockHeightByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to find block %v requested in getblocktxn "+
+			"from %s", msg.BlockHash, sp)
+		return
+	}
+
+	// Peers only request transactions of blocks which were recently sent
+	// as compact blocks, so send the full block for older ones.
+	best := chain.BestSnapshot()
+	if best.Height-height >= maxCmpctBlockTxnDepth {
+		sp.server.pushBlockMsg(sp, &msg.BlockHash, nil, nil,
+			wire.WitnessEncoding)
+		return
+	}
+
+	block, err := chain.BlockByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested in "+
+			"getblocktxn from %s: %v", msg.BlockHash, sp, err)
+		return
+	}
+
+	txns := block.MsgBlock().Transactions
+	reply := wire.NewMsgBlockTxn(&msg.BlockHash,
+		make([]*wire.MsgTx, 0, len(msg.Indexes)))
+	for _, index := range msg.Indexes {
+		if int(index) >= len(txns) {
+			peerLog.Debugf("Peer %s requested transaction %d of "+
+				"block %v which only has %d transactions -- "+
+				"disconnecting", sp, index, msg.BlockHash,
+				len(txns))
+			sp.Disconnect()
+			return
+		}
+		reply.Transactions = append(reply.Transactions, txns[index])
+	}
+	sp.QueueMessageWithEncoding(reply, nil, wire.WitnessEncoding)
+}
+
 // OnInv is invoked when a peer receives an inv bitcoin message and is
 // used to examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
@@ -650,7 +813,7 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 
 	newInv := wire.NewMsgInvSizeHint(uint(len(msg.InvList)))
 	for _, invVect := range msg.InvList {
-		if invVect.Type == wire.InvTypeTx {
+		if invVect.Type == wire.InvTypeTx || invVect.Type == wire.InvTypeWTx {
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
 				"blocksonly enabled", invVect.Hash, sp)
 			if sp.ProtocolVersion() >= wire.BIP0037Version {
@@ -719,10 +882,14 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *wire.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeTx:
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeWTx:
+			err = sp.server.pushWTxMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeWitnessBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeCmpctBlock:
+			err = sp.server.pushCmpctBlockMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeFilteredWitnessBlock:
 			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeFilteredBlock:
@@ -1420,10 +1587,14 @@ func (sp *serverPeer) OnNotFound(p *peer.Peer, msg *wire.MsgNotFound) {
 			numBlocks++
 		case wire.InvTypeWitnessBlock:
 			numBlocks++
+		case wire.InvTypeCmpctBlock:
+			numBlocks++
 		case wire.InvTypeTx:
 			numTxns++
 		case wire.InvTypeWitnessTx:
 			numTxns++
+		case wire.InvTypeWTx:
+			numTxns++
 		default:
 			peerLog.Debugf("Invalid inv type '%d' in notfound message from %s",
 				inv.Type, sp)
@@ -1489,6 +1660,33 @@ func (s *server) RemoveRebroadcastInventory(iv *wire.InvVect) {
 	s.modifyRebroadcastInv <- broadcastInventoryDel(iv)
 }
 
+// txRemoved is invoked by the mempool when a transaction is removed from it.
+// Transactions which were removed without being mined are no longer
+// rebroadcast since peers requesting them would not be able to get them.
+//
+// The mempool lock is held while this is called, so the hash is queued for the
+// rebroadcast handler to remove along with any other removed transactions
+// instead of waiting for the handler.
+func (s *server) txRemoved(tx *bteutil.Tx, reason mempool.RemovalReason) {
+	srvrLog.Tracef("Removed transaction %v from the mempool (reason %v)",
+		tx.Hash(), reason)
+
+	// The rebroadcast handler only runs along with the RPC server and
+	// mined transactions are handled by TransactionConfirmed.
+	if cfg.DisableRPC || reason == mempool.RemovalReasonBlock {
+		return
+	}
+
+	s.removedTxnsMtx.Lock()
+	s.removedTxns = append(s.removedTxns, *tx.Hash())
+	s.removedTxnsMtx.Unlock()
+
+	select {
+	case s.removedTxnsSignal <- struct{}{}:
+	default:
+	}
+}
+
 // relayTransactions generates and relays inventory vectors for all of the
 // passed transactions to all connected peers.
 func (s *server) relayTransactions(txns []*mempool.TxDesc) {
@@ -1555,6 +1753,28 @@ func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<-
 	return nil
 }
 
+// pushWTxMsg sends a tx message for the transaction with the provided witness
+// hash to the connected peer, which requested it by its witness hash after
+// negotiating wtxid relay (BIP0339).  The transaction is always sent with its
+// witness data.  An error is returned if the transaction is not known.
+func (s *server) pushWTxMsg(sp *serverPeer, wtxid *chainhash.Hash, doneChan chan<- struct{},
+	waitChan <-chan struct{}) error {
+
+	tx, err := s.txMemPool.FetchTransactionByWitnessHash(wtxid)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch tx with witness hash %v from "+
+			"transaction pool: %v", wtxid, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	return s.pushTxMsg(sp, tx.Hash(), doneChan, waitChan,
+		wire.WitnessEncoding)
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
@@ -1621,6 +1841,49 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 	return nil
 }
 
+// pushCmpctBlockMsg sends a cmpctblock message for the provided block hash to
+// the connected peer.  The full block is sent instead when the peer doesn't
+// support compact blocks or the block is not recent since the peer is unlikely
+// to have its transactions in that case.  An error is returned if the block
+// hash is not known.
+func (s *server) pushCmpctBlockMsg(sp *serverPeer, hash *chainhash.Hash,
+	doneChan chan<- struct{}, waitChan <-chan struct{}) error {
+
+	height, err := sp.server.chain.BlockHeightByHash(hash)
+	best := sp.server.chain.BestSnapshot()
+	if err != nil || !sp.WantsCmpctBlocks() ||
+		best.Height-height >= maxCmpctBlockDepth {
+
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan,
+			wire.WitnessEncoding)
+	}
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan,
+			wire.WitnessEncoding)
+	}
+
+	blk, err := sp.server.chain.BlockByHash(hash)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested block hash %v: %v",
+			hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+	msg := wire.NewMsgCmpctBlock(blk.MsgBlock(), nonce)
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	sp.QueueMessageWithEncoding(msg, doneChan, wire.WitnessEncoding)
+	return nil
+}
+
 // pushMerkleBlockMsg sends a merkleblock message for the provided block hash to
 // the connected peer.  Since a merkle block requires the peer to have a filter
 // loaded, this call will simply be ignored if there is no filter loaded.  An
@@ -1730,14 +1993,15 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		return false
 	}
 
-	// Disconnect banned peers.
+	// Disconnect banned peers.  Inbound onion peers are never banned
+	// since they share the address of the local host.
 	host, _, err := net.SplitHostPort(sp.Addr())
 	if err != nil {
 		srvrLog.Debugf("can't split hostport %v", err)
 		sp.Disconnect()
 		return false
 	}
-	if banEnd, ok := state.banned[host]; ok {
+	if banEnd, ok := state.banned[host]; ok && !sp.onionInbound {
 		if time.Now().Before(banEnd) {
 			srvrLog.Debugf("Peer %s is banned for another %v - disconnecting",
 				host, time.Until(banEnd))
@@ -1832,9 +2096,23 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	// Regardless of whether the peer was found in our list, we'll inform
 	// our connection manager about the disconnection. This can happen if we
 	// process a peer's `done` message before its `add`.
+	//
+	// Outbound peers which rejected the v2 transport handshake are
+	// reconnected to right away using the v1 transport instead.
 	if !sp.Inbound() {
+		v2Rejected := sp.V2TransportRejected()
+		if v2Rejected {
+			srvrLog.Debugf("Peer %s rejected the v2 transport, "+
+				"reconnecting with the v1 transport", sp)
+			s.v1RetryAddrs.Add(sp.connReq.Addr.String())
+		}
 		if sp.persistent {
 			s.connManager.Disconnect(sp.connReq.ID())
+		} else if v2Rejected {
+			s.connManager.Remove(sp.connReq.ID())
+			go s.connManager.Connect(&connmgr.ConnReq{
+				Addr: sp.connReq.Addr,
+			})
 		} else {
 			s.connManager.Remove(sp.connReq.ID())
 			go s.connManager.NewConnReq()
@@ -1854,6 +2132,14 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 // handleBanPeerMsg deals with banning peers.  It is invoked from the
 // peerHandler goroutine.
 func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
+	// Inbound onion peers share the address of the local host Tor runs
+	// on, so banning it would ban all of them.  They are only
+	// disconnected instead.
+	if sp.onionInbound {
+		srvrLog.Infof("Not banning inbound onion peer %s", sp)
+		return
+	}
+
 	host, _, err := net.SplitHostPort(sp.Addr())
 	if err != nil {
 		srvrLog.Debugf("can't split ban peer %s %v", sp.Addr(), err)
@@ -1868,22 +2154,49 @@ func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
 // known to have it.  It is invoked from the peerHandler goroutine.
 func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
+	// The compact block sent to peers which requested high-bandwidth mode
+	// is only created when there is such a peer and is shared by all of
+	// them.
+	var cmpctBlock *wire.MsgCmpctBlock
+
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
 		}
 
-		// If the inventory is a block and the peer prefers headers,
-		// generate and send a headers message instead of an inventory
-		// message.
-		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsHeaders() {
-			blockHeader, ok := msg.data.(wire.BlockHeader)
+		// If the inventory is a block and the peer prefers compact
+		// blocks in high-bandwidth mode, send the compact block right
+		// away.  Otherwise, if the peer prefers headers, generate and
+		// send a headers message instead of an inventory message.
+		if msg.invVect.Type == wire.InvTypeBlock &&
+			(sp.WantsHighBandwidthCmpctBlocks() || sp.WantsHeaders()) {
+
+			block, ok := msg.data.(*bteutil.Block)
 			if !ok {
-				peerLog.Warnf("Underlying data for headers" +
-					" is not a block header")
+				peerLog.Warnf("Underlying data for block inv "+
+					"relay is not a *bteutil.Block: %T", msg.data)
+				return
+			}
+
+			if sp.WantsHighBandwidthCmpctBlocks() {
+				if cmpctBlock == nil {
+					nonce, err := wire.RandomUint64()
+					if err != nil {
+						peerLog.Errorf("Failed to generate "+
+							"compact block nonce: %v", err)
+						return
+					}
+					cmpctBlock = wire.NewMsgCmpctBlock(
+						block.MsgBlock(), nonce)
+				}
+				sp.AddKnownInventory(msg.invVect)
+				sp.QueueMessageWithEncoding(cmpctBlock, nil,
+					wire.WitnessEncoding)
 				return
 			}
+
 			msgHeaders := wire.NewMsgHeaders()
+			blockHeader := block.MsgBlock().Header
 			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
 				peerLog.Errorf("Failed to add block"+
 					" header: %v", err)
@@ -1893,6 +2206,7 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 			return
 		}
 
+		invVect := msg.invVect
 		if msg.invVect.Type == wire.InvTypeTx {
 			// Don't relay the transaction to the peer when it has
 			// transaction relaying disabled.
@@ -1922,12 +2236,14 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					return
 				}
 			}
+
+			invVect = sp.txInvVect(txD.Tx)
 		}
 
 		// Queue the inventory to be relayed with the next batch.
 		// It will be ignored if the peer is already known to
 		// have the inventory.
-		sp.QueueInventory(msg.invVect)
+		sp.QueueInventory(invVect)
 	})
 }
 
@@ -2136,6 +2452,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnGetCFHeaders: sp.OnGetCFHeaders,
 			OnGetCFCheckpt: sp.OnGetCFCheckpt,
 			OnFeeFilter:    sp.OnFeeFilter,
+			OnCmpctBlock:   sp.OnCmpctBlock,
+			OnGetBlockTxn:  sp.OnGetBlockTxn,
+			OnBlockTxn:     sp.OnBlockTxn,
 			OnFilterAdd:    sp.OnFilterAdd,
 			OnFilterClear:  sp.OnFilterClear,
 			OnFilterLoad:   sp.OnFilterLoad,
@@ -2164,6 +2483,8 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		ProtocolVersion:     peer.MaxProtocolVersion,
 		TrickleInterval:     cfg.TrickleInterval,
 		DisableStallHandler: cfg.DisableStallHandler,
+		WTxIdRelay:          true,
+		V2Transport:         !cfg.NoV2Transport,
 	}
 }
 
@@ -2173,7 +2494,13 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 // for disconnection.
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+
+	// Connections to the onion service all come from the local host, so
+	// whitelisting them by IP would apply to every onion peer.
+	_, sp.onionInbound = conn.(onionConn)
+	if !sp.onionInbound {
+		sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	}
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -2186,7 +2513,11 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	peerCfg := newPeerConfig(sp)
+	if peerCfg.V2Transport {
+		peerCfg.V2Transport = s.useV2Transport(c.Addr)
+	}
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		if c.Permanent {
@@ -2204,6 +2535,24 @@ func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	go s.peerDoneHandler(sp)
 }
 
+// useV2Transport returns whether the v2 transport handshake should be initiated
+// with the passed outbound address.  It is only initiated with peers which are
+// known to advertise the v2 transport, and not right after the peer rejected
+// it.
+func (s *server) useV2Transport(addr net.Addr) bool {
+	addrString := addr.String()
+	if s.v1RetryAddrs.Contains(addrString) {
+		s.v1RetryAddrs.Delete(addrString)
+		return false
+	}
+
+	na, err := s.addrManager.DeserializeNetAddress(addrString, 0)
+	if err != nil {
+		return false
+	}
+	return s.addrManager.Services(na)&wire.SFNodeP2PV2 != 0
+}
+
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
 // done along with other performing other desirable cleanup.
 func (s *server) peerDoneHandler(sp *serverPeer) {
@@ -2305,6 +2654,13 @@ out:
 	s.connManager.Stop()
 	s.syncManager.Stop()
 	s.addrManager.Stop()
+	s.chain.Stop()
+
+	// Write the changes to the utxo set held in memory to the database now
+	// that no more blocks are being processed.
+	if err := s.chain.FlushUtxoCache(); err != nil {
+		srvrLog.Errorf("Unable to flush the utxo cache: %v", err)
+	}
 
 	// Drain channels before exiting so nothing is left waiting around
 	// to send.
@@ -2421,6 +2777,22 @@ out:
 				delete(pendingInvs, *msg)
 			}
 
+		// Transactions which were removed from the mempool without
+		// being mined can no longer be rebroadcast.
+		case <-s.removedTxnsSignal:
+			s.removedTxnsMtx.Lock()
+			removedTxns := s.removedTxns
+			s.removedTxns = nil
+			s.removedTxnsMtx.Unlock()
+
+			for i := range removedTxns {
+				iv := wire.InvVect{
+					Type: wire.InvTypeTx,
+					Hash: removedTxns[i],
+				}
+				delete(pendingInvs, iv)
+			}
+
 		case <-timer.C:
 			// Any inventory we have has not made it into a block
 			// yet. We periodically resubmit them until they have.
@@ -2471,11 +2843,36 @@ func (s *server) Start() {
 	s.wg.Add(1)
 	go s.peerHandler()
 
+	// Validate the chain leading up to the utxo snapshot the chain was
+	// bootstrapped from in the background if needed.
+	s.chain.Start()
+
 	if s.nat != nil {
 		s.wg.Add(1)
 		go s.upnpUpdateThread()
 	}
 
+	// Advertise the I2P address once the session with the I2P router is
+	// created when incoming I2P connections are accepted.
+	if cfg.i2pSession != nil && !cfg.NoI2PListen {
+		go s.i2pAddressHandler()
+	}
+
+	// Create the onion service through the Tor control port and advertise
+	// its address.
+	if s.torController != nil {
+		go s.onionServiceHandler()
+	}
+
+	// Load the transactions saved to the mempool file during the last
+	// shutdown in the background since validating them can take a while.
+	s.wg.Add(1)
+	go func() {
+		loadMempool(s.txMemPool, filepath.Join(cfg.DataDir,
+			mempool.DumpFileName), s.quit)
+		s.wg.Done()
+	}()
+
 	if !cfg.DisableRPC {
 		s.wg.Add(1)
 
@@ -2490,6 +2887,11 @@ func (s *server) Start() {
 	if cfg.Generate {
 		s.cpuMiner.Start()
 	}
+
+	// Start the Stratum server if it is enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Start()
+	}
 }
 
 // Stop gracefully shuts down the server by stopping and disconnecting all
@@ -2506,6 +2908,11 @@ func (s *server) Stop() error {
 	// Stop the CPU miner if needed
 	s.cpuMiner.Stop()
 
+	// Stop the Stratum server if it is enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Stop()
+	}
+
 	// Shutdown the RPC server if it's not disabled.
 	if !cfg.DisableRPC {
 		s.rpcServer.Stop()
@@ -2519,6 +2926,28 @@ func (s *server) Stop() error {
 		return nil
 	})
 
+	// Save the mempool so it can be loaded again on the next start.  This
+	// is skipped when it was not loaded yet since the transactions which
+	// are still in the file would otherwise be lost.
+	if s.txMemPool.IsLoaded() {
+		err := saveMempool(s.txMemPool, filepath.Join(cfg.DataDir,
+			mempool.DumpFileName))
+		if err != nil {
+			srvrLog.Errorf("Unable to save mempool: %v", err)
+		}
+	}
+
+	// End the I2P session, which closes all of the I2P connections.
+	if cfg.i2pSession != nil {
+		cfg.i2pSession.Close()
+	}
+
+	// Close the connection to the Tor control port, which removes the
+	// onion service.
+	if s.torController != nil {
+		s.torController.Close()
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
@@ -2667,6 +3096,77 @@ out:
 	s.wg.Done()
 }
 
+// i2pAddressHandler creates the session with the I2P router and adds its I2P
+// address to the address manager to be advertised to peers.  Creating the
+// session is retried until it succeeds since the I2P router may not be running
+// yet.  It must be run as a goroutine.
+func (s *server) i2pAddressHandler() {
+	for {
+		host, err := cfg.i2pSession.LocalAddr()
+		if err == nil {
+			na, err := s.addrManager.HostToNetAddress(host, 0, s.services)
+			if err == nil {
+				err = s.addrManager.AddLocalAddress(na, addrmgr.BoundPrio)
+			}
+			if err != nil {
+				srvrLog.Warnf("Unable to advertise I2P address %s: %v",
+					host, err)
+				return
+			}
+			srvrLog.Infof("Advertising I2P address %s", host)
+			return
+		}
+
+		srvrLog.Warnf("Unable to create I2P session: %v", err)
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(i2pSessionRetryInterval):
+		}
+	}
+}
+
+// onionServiceHandler creates the onion service through the Tor control port
+// and adds its onion address to the address manager to be advertised to peers.
+// Since Tor removes the service when the connection to the control port ends,
+// the service is created again until the server is shutting down.  It must be
+// run as a goroutine.
+func (s *server) onionServiceHandler() {
+	for {
+		host, done, err := s.torController.CreateOnionService()
+		if err == nil {
+			na, err := s.addrManager.HostToNetAddress(host,
+				s.torController.VirtualPort(), s.services)
+			if err == nil {
+				err = s.addrManager.AddLocalAddress(na,
+					addrmgr.ManualPrio)
+			}
+			if err != nil {
+				srvrLog.Warnf("Unable to advertise onion address "+
+					"%s: %v", host, err)
+			} else {
+				srvrLog.Infof("Advertising onion address %s", host)
+			}
+
+			select {
+			case <-s.quit:
+				return
+			case <-done:
+			}
+			srvrLog.Warnf("Connection to the Tor control port lost, " +
+				"onion service removed")
+		} else {
+			srvrLog.Warnf("Unable to create onion service: %v", err)
+		}
+
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(torControlRetryInterval):
+		}
+	}
+}
+
 // setupRPCListeners returns a slice of listeners that are configured for use
 // with the RPC server depending on the configuration settings for listen
 // addresses and TLS.
@@ -2716,6 +3216,27 @@ func setupRPCListeners() ([]net.Listener, error) {
 	return listeners, nil
 }
 
+// setupStratumListeners returns a slice of listeners that are configured for
+// use with the Stratum server depending on the configuration settings.
+func setupStratumListeners() ([]net.Listener, error) {
+	netAddrs, err := parseListeners(cfg.StratumListeners)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, len(netAddrs))
+	for _, addr := range netAddrs {
+		listener, err := net.Listen(addr.Network(), addr.String())
+		if err != nil {
+			strmLog.Warnf("Can't listen on %s: %v", addr, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
 // newServer returns a new bted server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
 // connections from peers.
@@ -2730,8 +3251,17 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	if cfg.NoCFilters {
 		services &^= wire.SFNodeCF
 	}
+	if cfg.NoV2Transport {
+		services &^= wire.SFNodeP2PV2
+	}
+	if cfg.PruneMiB != 0 {
+		// Pruned nodes are only able to serve the most recent blocks.
+		services &^= wire.SFNodeNetwork
+		services |= wire.SFNodeNetworkLimited
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, btedLookup)
+	amgr.SetCJDNSReachable(cfg.CJDNSReachable)
 
 	var listeners []net.Listener
 	var nat NAT
@@ -2746,6 +3276,36 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		}
 	}
 
+	// Accept incoming I2P connections through the I2P router independent
+	// of the other listeners.
+	if cfg.i2pSession != nil && !cfg.NoI2PListen {
+		listeners = append(listeners, cfg.i2pSession.Listener())
+	}
+
+	// Accept the incoming connections of the onion service created through
+	// the Tor control port on a dedicated local listener Tor forwards them
+	// to, independent of the other listeners.
+	var torController *connmgr.TorController
+	if cfg.TorControl != "" {
+		defaultPort, err := strconv.ParseUint(activeNetParams.DefaultPort, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, onionListener{listener})
+
+		torController = connmgr.NewTorController(&connmgr.TorControlConfig{
+			ControlAddr: cfg.TorControl,
+			Password:    cfg.TorPassword,
+			KeyFile:     filepath.Join(cfg.DataDir, defaultOnionKeyFilename),
+			VirtualPort: uint16(defaultPort),
+			TargetAddr:  listener.Addr().String(),
+		})
+	}
+
 	if len(agentBlacklist) > 0 {
 		srvrLog.Infof("User-agent blacklist %s", agentBlacklist)
 	}
@@ -2764,6 +3324,7 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
 		quit:                 make(chan struct{}),
 		modifyRebroadcastInv: make(chan interface{}),
+		removedTxnsSignal:    make(chan struct{}, 1),
 		peerHeightsUpdate:    make(chan updatePeerHeightsMsg),
 		nat:                  nat,
 		db:                   db,
@@ -2774,6 +3335,8 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		cfCheckptCaches:      make(map[wire.FilterType][]cfHeaderKV),
 		agentBlacklist:       agentBlacklist,
 		agentWhitelist:       agentWhitelist,
+		v1RetryAddrs:         lru.NewCache(maxV1RetryAddrs),
+		torController:        torController,
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2823,19 +3386,35 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	s.chain, err = blockchain.New(&blockchain.Config{
-		DB:           s.db,
-		Interrupt:    interrupt,
-		ChainParams:  s.chainParams,
-		Checkpoints:  checkpoints,
-		TimeSource:   s.timeSource,
-		SigCache:     s.sigCache,
-		IndexManager: indexManager,
-		HashCache:    s.hashCache,
+		DB:               s.db,
+		Interrupt:        interrupt,
+		ChainParams:      s.chainParams,
+		Checkpoints:      checkpoints,
+		TimeSource:       s.timeSource,
+		SigCache:         s.sigCache,
+		IndexManager:     indexManager,
+		HashCache:        s.hashCache,
+		UtxoCacheMaxSize: uint64(cfg.UtxoCacheMaxSizeMiB) * 1024 * 1024,
+		Prune:            cfg.PruneMiB * 1024 * 1024,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Bootstrap the chain from a utxo snapshot when requested.  Since the
+	// blocks leading up to the snapshot are never downloaded, only the
+	// most recent blocks can be served to peers in that case.
+	if cfg.UtxoSnapshot != "" {
+		if err := loadUtxoSnapshot(s.chain, cfg.UtxoSnapshot); err != nil {
+			return nil, err
+		}
+	}
+	s.chain.Subscribe(s.handleBlockchainNotification)
+	if s.chain.PruneHeight() > 0 {
+		s.services &^= wire.SFNodeNetwork
+		s.services |= wire.SFNodeNetworkLimited
+	}
+
 	// Search for a FeeEstimator state in the database. If none can be found
 	// or if it cannot be loaded, create a new one.
 	db.Update(func(tx database.Tx) error {
@@ -2877,6 +3456,8 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 			MinRelayTxFee:        cfg.minRelayTxFee,
 			MaxTxVersion:         2,
 			RejectReplacement:    cfg.RejectReplacement,
+			MaxPoolSize:          cfg.MaxMempoolMB * 1000000,
+			TxExpiry:             cfg.MempoolExpiry,
 		},
 		ChainParams:    chainParams,
 		FetchUtxoView:  s.chain.FetchUtxoView,
@@ -2890,6 +3471,7 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		HashCache:          s.hashCache,
 		AddrIndex:          s.addrIndex,
 		FeeEstimator:       s.feeEstimator,
+		OnTxRemoved:        s.txRemoved,
 	}
 	s.txMemPool = mempool.New(&txC)
 
@@ -2931,6 +3513,30 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		IsCurrent:              s.syncManager.IsCurrent,
 	})
 
+	// Create the Stratum server for external mining software when any
+	// Stratum listen addresses are configured.
+	if len(cfg.StratumListeners) > 0 {
+		stratumListeners, err := setupStratumListeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(stratumListeners) == 0 {
+			return nil, errors.New("STRM: No valid listen address")
+		}
+
+		s.stratumServer = stratum.New(&stratum.Config{
+			ChainParams:            chainParams,
+			BlockTemplateGenerator: blockTemplateGenerator,
+			MiningAddrs:            cfg.miningAddrs,
+			ProcessBlock:           s.syncManager.ProcessBlock,
+			ConnectedCount:         s.ConnectedCount,
+			IsCurrent:              s.syncManager.IsCurrent,
+			Listeners:              stratumListeners,
+			Difficulty:             cfg.StratumDifficulty,
+			MaxClients:             cfg.StratumMaxClients,
+		})
+	}
+
 	// Only setup a function to return new addresses to connect to when
 	// not running in connect-only mode.  The simulation network is always
 	// in connect-only mode since it is only intended to connect to
@@ -2946,6 +3552,13 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 					break
 				}
 
+				// Skip addresses on networks which can't be
+				// reached with the current configuration, such as
+				// I2P addresses when no I2P router was specified.
+				if !btedReachable(addr.NetAddress()) {
+					continue
+				}
+
 				// Address will not be invalid, local or unroutable
 				// because addrmanager rejects those on addition.
 				// Just check that we don't already have an address
@@ -2964,8 +3577,10 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 				}
 
 				// allow nondefault ports after 50 failed tries.
-				if tries < 50 && fmt.Sprintf("%d", addr.NetAddress().Port) !=
-					activeNetParams.DefaultPort {
+				// I2P addresses don't have ports.
+				if tries < 50 && !addr.NetAddress().IsI2P() &&
+					fmt.Sprintf("%d", addr.NetAddress().Port) !=
+						activeNetParams.DefaultPort {
 					continue
 				}
 
@@ -3169,6 +3784,16 @@ func addrStringToNetAddr(addr string) (net.Addr, error) {
 		return &onionAddr{addr: addr}, nil
 	}
 
+	// I2P addresses can only be reached through the I2P router, so just
+	// return an I2P address instead.
+	if strings.HasSuffix(host, ".b32.i2p") {
+		if cfg.i2pSession == nil {
+			return nil, errors.New("i2p has not been enabled")
+		}
+
+		return &i2pAddr{addr: addr}, nil
+	}
+
 	// Attempt to look up an IP address associated with the parsed host.
 	ips, err := btedLookup(host)
 	if err != nil {
@@ -3280,6 +3905,105 @@ func isWhitelisted(addr net.Addr) bool {
 	return false
 }
 
+// handleBlockchainNotification handles notifications from blockchain.  It shuts
+// down the process when the chain leading up to the utxo snapshot the chain was
+// bootstrapped from turns out to be invalid since the utxo set can't be trusted
+// anymore in that case.
+func (s *server) handleBlockchainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.NTUtxoSnapshotInvalid {
+		return
+	}
+
+	srvrLog.Criticalf("Shutting down: %v", notification.Data)
+	go func() {
+		shutdownRequestChannel <- struct{}{}
+	}()
+}
+
+// loadUtxoSnapshot bootstraps the passed chain from the utxo snapshot in the
+// file at the passed path.  The snapshot is ignored when the chain has already
+// been initialized, which allows the option to remain set across restarts.
+func loadUtxoSnapshot(chain *blockchain.BlockChain, path string) error {
+	if chain.BestSnapshot().Height != 0 {
+		srvrLog.Infof("Ignoring utxo snapshot %s since the chain has "+
+			"already been initialized", path)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	srvrLog.Infof("Loading utxo snapshot %s", path)
+	info, err := chain.LoadUtxoSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("unable to load utxo snapshot %s: %v", path,
+			err)
+	}
+	srvrLog.Infof("Bootstrapped the chain from utxo snapshot %s at "+
+		"height %d with %d outputs", path, info.Height, info.Outputs)
+	return nil
+}
+
+// saveMempool writes all transactions in the passed mempool to the file at the
+// passed path.  They are written to a temporary file first which then replaces
+// the file at the path, so a failure never leaves a partially written file
+// behind.
+func saveMempool(txMemPool *mempool.TxPool, path string) error {
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	numTxns, err := txMemPool.Dump(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	srvrLog.Infof("Saved %d mempool transactions to %s", numTxns, path)
+	return nil
+}
+
+// loadMempool adds the transactions saved to the file at the passed path by
+// saveMempool to the passed mempool and then marks it as loaded.  Loading stops
+// early when the quit channel is closed.  A missing file is not an error since
+// there is nothing to load on the first start.
+func loadMempool(txMemPool *mempool.TxPool, path string, quit <-chan struct{}) {
+	defer txMemPool.SetLoaded()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			srvrLog.Errorf("Unable to load mempool: %v", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	numAccepted, numSkipped, err := txMemPool.Load(bufio.NewReader(f), quit)
+	if err != nil {
+		srvrLog.Errorf("Unable to load mempool from %s: %v", path, err)
+	}
+	srvrLog.Infof("Loaded %d mempool transactions from %s (%d skipped)",
+		numAccepted, path, numSkipped)
+}
+
 // checkpointSorter implements sort.Interface to allow a slice of checkpoints to
 // be sorted.
 type checkpointSorter []chaincfg.Checkpoint
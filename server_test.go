@@ -0,0 +1,52 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mraksoll4/bted/peer"
+)
+
+// TestOnionInbound ensures connections accepted from the onion service are
+// marked as such and the peers using them are not banned by their address.
+func TestOnionInbound(t *testing.T) {
+	// The log rotator is not initialized by the tests, so logging must be
+	// disabled.
+	setLogLevels("off")
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	l := onionListener{listener}
+	defer l.Close()
+
+	client, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(onionConn); !ok {
+		t.Fatalf("Accept: unexpected connection type %T", conn)
+	}
+
+	s := &server{}
+	sp := newServerPeer(s, false)
+	sp.onionInbound = true
+	sp.Peer = peer.NewInboundPeer(&peer.Config{})
+	state := &peerState{banned: make(map[string]time.Time)}
+	s.handleBanPeerMsg(state, sp)
+	if len(state.banned) != 0 {
+		t.Fatalf("inbound onion peer was banned: %v", state.banned)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// rekeyInterval is the number of messages encrypted by the forward secure
+// ciphers before their key is replaced with one derived from it.
+const rekeyInterval = 224
+
+// fsChaCha20 is the forward secure ChaCha20 stream cipher used to encrypt the
+// length of packets.  The keystream is shared by all chunks encrypted with the
+// same key and the key is replaced by the next 32 bytes of it every
+// rekeyInterval chunks.
+type fsChaCha20 struct {
+	stream       *chacha20.Cipher
+	chunkCounter uint32
+	rekeyCounter uint64
+}
+
+// newFSChaCha20 returns a new forward secure ChaCha20 stream cipher with the
+// passed initial key.
+func newFSChaCha20(key []byte) *fsChaCha20 {
+	c := &fsChaCha20{}
+	c.setKey(key)
+	return c
+}
+
+// setKey starts the keystream for the passed key and the current rekey
+// counter.
+func (c *fsChaCha20) setKey(key []byte) {
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+
+	// The key and nonce are always of the correct size, so this can't
+	// fail.
+	c.stream, _ = chacha20.NewUnauthenticatedCipher(key, nonce[:])
+}
+
+// crypt encrypts or decrypts the passed chunk in place.
+func (c *fsChaCha20) crypt(chunk []byte) {
+	c.stream.XORKeyStream(chunk, chunk)
+
+	c.chunkCounter++
+	if c.chunkCounter == rekeyInterval {
+		var key [chacha20.KeySize]byte
+		c.stream.XORKeyStream(key[:], key[:])
+		c.chunkCounter = 0
+		c.rekeyCounter++
+		c.setKey(key[:])
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure ChaCha20-Poly1305 AEAD used to
+// encrypt the contents of packets.  The key is replaced with one derived from
+// it every rekeyInterval packets.
+type fsChaCha20Poly1305 struct {
+	aead          cipher.AEAD
+	packetCounter uint32
+	rekeyCounter  uint64
+}
+
+// newFSChaCha20Poly1305 returns a new forward secure ChaCha20-Poly1305 AEAD
+// with the passed initial key.
+func newFSChaCha20Poly1305(key []byte) *fsChaCha20Poly1305 {
+	// The key is always of the correct size, so this can't fail.
+	aead, _ := chacha20poly1305.New(key)
+	return &fsChaCha20Poly1305{aead: aead}
+}
+
+// nonce returns the nonce for the next packet.
+func (c *fsChaCha20Poly1305) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], c.packetCounter)
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+	return nonce[:]
+}
+
+// nextPacket advances the cipher to the next packet, replacing the key when
+// the rekey interval is reached.
+func (c *fsChaCha20Poly1305) nextPacket() {
+	c.packetCounter++
+	if c.packetCounter != rekeyInterval {
+		return
+	}
+
+	// The new key is the first 32 bytes of the encryption of 32 zero bytes
+	// with the special nonce which is not used for any packet.
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], 0xffffffff)
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+	var zeroes [chacha20poly1305.KeySize]byte
+	key := c.aead.Seal(nil, nonce[:], zeroes[:], nil)
+
+	c.aead, _ = chacha20poly1305.New(key[:chacha20poly1305.KeySize])
+	c.packetCounter = 0
+	c.rekeyCounter++
+}
+
+// seal encrypts and authenticates plaintext and authenticates aad, appends the
+// result to dst, and returns the updated slice.
+func (c *fsChaCha20Poly1305) seal(dst, aad, plaintext []byte) []byte {
+	dst = c.aead.Seal(dst, c.nonce(), plaintext, aad)
+	c.nextPacket()
+	return dst
+}
+
+// open decrypts and authenticates ciphertext and authenticates aad.  It
+// returns the plaintext or an error when authentication fails.
+func (c *fsChaCha20Poly1305) open(aad, ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(ciphertext[:0], c.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	c.nextPacket()
+	return plaintext, nil
+}
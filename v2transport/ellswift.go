@@ -0,0 +1,299 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/mraksoll4/bted/btcec/v2"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+)
+
+// EllswiftPubKeySize is the size of an ElligatorSwift encoded public key.
+const EllswiftPubKeySize = 64
+
+// ecdhTag is the tag of the hash used to derive the shared secret from the
+// x-only ECDH result and the public keys of both peers.
+var ecdhTag = []byte("bip324_ellswift_xonly_ecdh")
+
+var (
+	// fieldOne, fieldTwo, fieldFour and fieldSeven are the field elements
+	// with the respective values.
+	fieldOne   = new(btcec.FieldVal).SetInt(1)
+	fieldTwo   = new(btcec.FieldVal).SetInt(2)
+	fieldFour  = new(btcec.FieldVal).SetInt(4)
+	fieldSeven = new(btcec.FieldVal).SetInt(7)
+
+	// fieldHalf is the multiplicative inverse of two.
+	fieldHalf = feInv(fieldTwo)
+
+	// sqrtMinus3 is the square root of -3 used by the XSwiftEC function.
+	// It is computed the same way as by the reference implementation of
+	// BIP0324 since both of the roots are valid, but they result in
+	// different encodings.
+	sqrtMinus3 = func() *btcec.FieldVal {
+		root, _ := feSqrt(feNeg(new(btcec.FieldVal).SetInt(3)))
+		return root
+	}()
+)
+
+// The following functions implement the field arithmetic needed by the
+// ElligatorSwift encoding on top of btcec.FieldVal.  They all take normalized
+// field elements and return new normalized field elements without modifying
+// their arguments.
+
+// feAdd returns a + b.
+func feAdd(a, b *btcec.FieldVal) *btcec.FieldVal {
+	return new(btcec.FieldVal).Add2(a, b).Normalize()
+}
+
+// feNeg returns -a.
+func feNeg(a *btcec.FieldVal) *btcec.FieldVal {
+	return new(btcec.FieldVal).NegateVal(a, 1).Normalize()
+}
+
+// feSub returns a - b.
+func feSub(a, b *btcec.FieldVal) *btcec.FieldVal {
+	return feAdd(a, feNeg(b))
+}
+
+// feMul returns a * b.
+func feMul(a, b *btcec.FieldVal) *btcec.FieldVal {
+	return new(btcec.FieldVal).Mul2(a, b).Normalize()
+}
+
+// feSquare returns a^2.
+func feSquare(a *btcec.FieldVal) *btcec.FieldVal {
+	return new(btcec.FieldVal).SquareVal(a).Normalize()
+}
+
+// feInv returns the multiplicative inverse of a, or zero when a is zero.
+func feInv(a *btcec.FieldVal) *btcec.FieldVal {
+	return new(btcec.FieldVal).Set(a).Inverse().Normalize()
+}
+
+// feSqrt returns a square root of a along with whether it exists.
+func feSqrt(a *btcec.FieldVal) (*btcec.FieldVal, bool) {
+	var root btcec.FieldVal
+	ok := root.SquareRootVal(a)
+	return root.Normalize(), ok
+}
+
+// curveY2 returns x^3 + 7, which is the square of the y coordinate of the
+// points on the secp256k1 curve with the x coordinate x.
+func curveY2(x *btcec.FieldVal) *btcec.FieldVal {
+	return feAdd(feMul(feSquare(x), x), fieldSeven)
+}
+
+// isValidX returns whether there is a point on the secp256k1 curve with the x
+// coordinate x.
+func isValidX(x *btcec.FieldVal) bool {
+	_, ok := feSqrt(curveY2(x))
+	return ok
+}
+
+// xSwiftEC returns the x coordinate of the point on the secp256k1 curve that
+// is encoded by the field elements u and t as defined by BIP0324.
+func xSwiftEC(uIn, tIn *btcec.FieldVal) *btcec.FieldVal {
+	u, t := uIn, tIn
+	if u.IsZero() {
+		u = fieldOne
+	}
+	if t.IsZero() {
+		t = fieldOne
+	}
+
+	// Use 2t instead of t when u^3 + t^2 + 7 = 0 since the formulas below
+	// would divide by zero otherwise.
+	u3Plus7 := curveY2(u)
+	t2 := feSquare(t)
+	if feAdd(u3Plus7, t2).IsZero() {
+		t = feAdd(t, t)
+		t2 = feSquare(t)
+	}
+
+	// X = (u^3 + 7 - t^2) / (2t)
+	// Y = (X + t) / (sqrt(-3) * u)
+	x := feMul(feSub(u3Plus7, t2), feInv(feAdd(t, t)))
+	y := feMul(feAdd(x, t), feInv(feMul(sqrtMinus3, u)))
+
+	// Return the first of the following candidates which is on the curve,
+	// which is guaranteed for at least one of them.
+	//
+	// x3 = u + 4Y^2
+	// x2 = (-X/Y - u) / 2
+	// x1 = (X/Y - u) / 2
+	x3 := feAdd(u, feMul(fieldFour, feSquare(y)))
+	if isValidX(x3) {
+		return x3
+	}
+	xOverY := feMul(x, feInv(y))
+	x2 := feMul(feSub(feNeg(xOverY), u), fieldHalf)
+	if isValidX(x2) {
+		return x2
+	}
+	return feMul(feSub(xOverY, u), fieldHalf)
+}
+
+// xSwiftECInv returns a field element t such that xSwiftEC(u, t) is x, which
+// must be a valid x coordinate, along with whether such a t was found.  The
+// passed c, which is in the range [0, 7], selects which of the up to eight
+// possible preimages is returned.
+func xSwiftECInv(x, u *btcec.FieldVal, c int) (*btcec.FieldVal, bool) {
+	var v, s *btcec.FieldVal
+	if c&2 == 0 {
+		// The preimages of x1 and x2 are only valid when x3 can't be
+		// returned for them instead.
+		if isValidX(feSub(feNeg(x), u)) {
+			return nil, false
+		}
+
+		// s = -(u^3 + 7) / (u^2 + uv + v^2)
+		v = x
+		denom := feAdd(feAdd(feSquare(u), feMul(u, v)), feSquare(v))
+		s = feMul(feNeg(curveY2(u)), feInv(denom))
+	} else {
+		s = feSub(x, u)
+		if s.IsZero() {
+			return nil, false
+		}
+
+		// r = sqrt(-s * (4 * (u^3 + 7) + 3 * s * u^2))
+		three := new(btcec.FieldVal).SetInt(3)
+		inner := feAdd(feMul(fieldFour, curveY2(u)),
+			feMul(feMul(three, s), feSquare(u)))
+		r, ok := feSqrt(feMul(feNeg(s), inner))
+		if !ok {
+			return nil, false
+		}
+		if c&1 == 1 && r.IsZero() {
+			return nil, false
+		}
+
+		// v = (-u + r / s) / 2
+		v = feMul(feAdd(feNeg(u), feMul(r, feInv(s))), fieldHalf)
+	}
+
+	w, ok := feSqrt(s)
+	if !ok {
+		return nil, false
+	}
+
+	// The result is w * (u * (1 +- sqrt(-3)) / 2 + v) with the signs
+	// selected by the first and third bits of c.
+	var factor *btcec.FieldVal
+	if c&1 == 0 {
+		factor = feSub(fieldOne, sqrtMinus3)
+	} else {
+		factor = feAdd(fieldOne, sqrtMinus3)
+	}
+	t := feMul(w, feAdd(feMul(feMul(u, factor), fieldHalf), v))
+	if c&5 == 0 || c&5 == 5 {
+		t = feNeg(t)
+	}
+	return t, true
+}
+
+// decodeEllswift returns the x coordinate of the point encoded by the passed
+// ElligatorSwift encoding.  The field elements it consists of are reduced
+// modulo the field prime, so every 64 byte string is a valid encoding.
+func decodeEllswift(encoded *[EllswiftPubKeySize]byte) *btcec.FieldVal {
+	var u, t btcec.FieldVal
+	u.SetByteSlice(encoded[:32])
+	u.Normalize()
+	t.SetByteSlice(encoded[32:])
+	t.Normalize()
+	return xSwiftEC(&u, &t)
+}
+
+// encodeEllswift returns a random ElligatorSwift encoding of the point with
+// the passed x coordinate using the randomness read from r.
+func encodeEllswift(x *btcec.FieldVal, r io.Reader) ([EllswiftPubKeySize]byte, error) {
+	var encoded [EllswiftPubKeySize]byte
+	var rnd [33]byte
+	for {
+		if _, err := io.ReadFull(r, rnd[:]); err != nil {
+			return encoded, err
+		}
+
+		var u btcec.FieldVal
+		u.SetByteSlice(rnd[:32])
+		if u.Normalize().IsZero() {
+			continue
+		}
+		t, ok := xSwiftECInv(x, &u, int(rnd[32]&7))
+		if !ok {
+			continue
+		}
+
+		u.PutBytesUnchecked(encoded[:32])
+		t.PutBytesUnchecked(encoded[32:])
+		return encoded, nil
+	}
+}
+
+// newEllswiftKey returns a new private key along with a random ElligatorSwift
+// encoding of its public key.
+func newEllswiftKey() (*btcec.PrivateKey, [EllswiftPubKeySize]byte, error) {
+	privKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, [EllswiftPubKeySize]byte{}, err
+	}
+
+	var pubKey btcec.JacobianPoint
+	privKey.PubKey().AsJacobian(&pubKey)
+	encoded, err := encodeEllswift(&pubKey.X, rand.Reader)
+	if err != nil {
+		return nil, [EllswiftPubKeySize]byte{}, err
+	}
+	return privKey, encoded, nil
+}
+
+// ellswiftECDHXOnly returns the x coordinate of the product of the passed
+// private key and the point encoded by the passed ElligatorSwift encoding.
+func ellswiftECDHXOnly(theirs *[EllswiftPubKeySize]byte, privKey *btcec.PrivateKey) ([32]byte, error) {
+	var shared [32]byte
+
+	// Either point with the decoded x coordinate can be used since the x
+	// coordinate of the product is the same for both.
+	x := decodeEllswift(theirs)
+	y, ok := feSqrt(curveY2(x))
+	if !ok {
+		return shared, errors.New("ellswift encoding does not " +
+			"decode to a point on the curve")
+	}
+	point := btcec.MakeJacobianPoint(x, y, fieldOne)
+
+	var result btcec.JacobianPoint
+	btcec.ScalarMultNonConst(&privKey.Key, &point, &result)
+	result.ToAffine()
+	if result.X.IsZero() && result.Y.IsZero() {
+		return shared, errors.New("ecdh result is the point at infinity")
+	}
+	result.X.PutBytesUnchecked(shared[:])
+	return shared, nil
+}
+
+// v2ECDH returns the shared secret of the v2 transport protocol derived from
+// the x-only ECDH result of the passed private key and the public key of the
+// remote peer along with the public keys of both peers.
+func v2ECDH(privKey *btcec.PrivateKey, theirs, ours *[EllswiftPubKeySize]byte,
+	initiating bool) (*chainhash.Hash, error) {
+
+	xShared, err := ellswiftECDHXOnly(theirs, privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The public key of the initiator comes first.
+	initiator, responder := ours, theirs
+	if !initiating {
+		initiator, responder = theirs, ours
+	}
+	return chainhash.TaggedHash(ecdhTag, initiator[:], responder[:],
+		xShared[:]), nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/mraksoll4/bted/btcec/v2"
+)
+
+// hexToFieldVal converts the passed hex string into a normalized field
+// element and will panic if there is an error.  This is only provided for the
+// hard-coded constants so errors in the source code can be detected.  It will
+// only (and must only) be called with hard-coded values.
+func hexToFieldVal(s string) *btcec.FieldVal {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex in source file: " + s)
+	}
+	var f btcec.FieldVal
+	f.SetByteSlice(b)
+	return f.Normalize()
+}
+
+// TestDecodeEllswift ensures ElligatorSwift encodings decode to the expected x
+// coordinates using test vectors from BIP0324.
+func TestDecodeEllswift(t *testing.T) {
+	tests := []struct {
+		encoded string // ElligatorSwift encoding
+		x       string // expected x coordinate
+	}{
+		{
+			encoded: "00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+			x:       "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+		{
+			encoded: "000000000000000000000000000000000000000000000000000000000000000001d3475bf7655b0fb2d852921035b2ef607f49069b97454e6795251062741771",
+			x:       "b5da00b73cd6560520e7c364086e7cd23a34bf60d0e707be9fc34d4cd5fdfa2c",
+		},
+		{
+			encoded: "0000000000000000000000000000000000000000000000000000000000000000bde70df51939b94c9c24979fa7dd04ebd9b3572da7802290438af2a681895441",
+			x:       "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa9fffffd6b",
+		},
+		{
+			encoded: "0000000000000000000000000000000000000000000000000000000000000000fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f",
+			x:       "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c",
+		},
+		{
+			encoded: "0000000000000000000000000000000000000000000000000000000000000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff2664bbd5",
+			x:       "50873db31badcc71890e4f67753a65757f97aaa7dd5f1e82b753ace32219064b",
+		},
+	}
+
+	for i, test := range tests {
+		var encoded [EllswiftPubKeySize]byte
+		b, _ := hex.DecodeString(test.encoded)
+		copy(encoded[:], b)
+
+		x := decodeEllswift(&encoded)
+		if !x.Equals(hexToFieldVal(test.x)) {
+			t.Errorf("decodeEllswift #%d: got %v, want %s", i, x,
+				test.x)
+		}
+	}
+}
+
+// TestXSwiftECInv ensures the preimages of x coordinates for all of the cases
+// are the expected ones using test vectors from BIP0324.
+func TestXSwiftECInv(t *testing.T) {
+	tests := []struct {
+		u     string    // field element u
+		x     string    // x coordinate
+		cases [8]string // expected preimage for each case, if any
+	}{
+		{
+			u: "05ff6bdad900fc3261bc7fe34e2fb0f569f06e091ae437d3a52e9da0cbfb9590",
+			x: "80cdf63774ec7022c89a5a8558e373a279170285e0ab27412dbce510bdfe23fc",
+			cases: [8]string{
+				"",
+				"",
+				"45654798ece071ba79286d04f7f3eb1c3f1d17dd883610f2ad2efd82a287466b",
+				"0aeaa886f6b76c7158452418cbf5033adc5747e9e9b5d3b2303db96936528557",
+				"",
+				"",
+				"ba9ab867131f8e4586d792fb080c14e3c0e2e82277c9ef0d52d1027c5d78b5c4",
+				"f51557790948938ea7badbe7340afcc523a8b816164a2c4dcfc24695c9ad76d8",
+			},
+		},
+		{
+			u: "1737a85f4c8d146cec96e3ffdca76d9903dcf3bd53061868d478c78c63c2aa9e",
+			x: "39e48dd150d2f429be088dfd5b61882e7e8407483702ae9a5ab35927b15f85ea",
+			cases: [8]string{
+				"1be8cc0b04be0c681d0c6a68f733f82c6c896e0c8a262fcd392918e303a7abf4",
+				"605b5814bf9b8cb066667c9e5480d22dc5b6c92f14b4af3ee0a9eb83b03685e3",
+				"",
+				"",
+				"e41733f4fb41f397e2f3959708cc07d3937691f375d9d032c6d6e71bfc58503b",
+				"9fa4a7eb4064734f99998361ab7f2dd23a4936d0eb4b50c11f56147b4fc9764c",
+				"",
+				"",
+			},
+		},
+		{
+			u: "1aaa1ccebf9c724191033df366b36f691c4d902c228033ff4516d122b2564f68",
+			x: "c75541259d3ba98f207eaa30c69634d187d0b6da594e719e420f4898638fc5b0",
+			cases: [8]string{
+				"",
+				"",
+				"",
+				"",
+				"",
+				"",
+				"",
+				"",
+			},
+		},
+		{
+			u: "f58cd4d9830bad322699035e8246007d4be27e19b6f53621317b4f309b3daa9d",
+			x: "78ec2b3dc0948de560148bbc7c6dc9633ad5df70a5a5750cbed721804f082a3b",
+			cases: [8]string{
+				"6c4c580b76c7594043569f9dae16dc2801c16a1fbe12860881b75f8ef929bce5",
+				"94231355e7385c5f25ca436aa64191471aea4393d6e86ab7a35fe2afacaefd0d",
+				"dff2a1951ada6db574df834048149da3397a75b829abf58c7e69db1b41ac0989",
+				"a52b66d3c907035548028bf804711bf422aba95f1a666fc86f4648e05f29caae",
+				"93b3a7f48938a6bfbca9606251e923d7fe3e95e041ed79f77e48a07006d63f4a",
+				"6bdcecaa18c7a3a0da35bc9559be6eb8e515bc6c291795485ca01d4f5350ff22",
+				"200d5e6ae525924a8b207cbfb7eb625cc6858a47d6540a73819624e3be53f2a6",
+				"5ad4992c36f8fcaab7fd7407fb8ee40bdd5456a0e599903790b9b71ea0d63181",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		u := hexToFieldVal(test.u)
+		x := hexToFieldVal(test.x)
+		for c, want := range test.cases {
+			got, ok := xSwiftECInv(x, u, c)
+			if !ok {
+				if want != "" {
+					t.Errorf("xSwiftECInv #%d case %d: no "+
+						"preimage, want %s", i, c, want)
+				}
+				continue
+			}
+			if want == "" || !got.Equals(hexToFieldVal(want)) {
+				t.Errorf("xSwiftECInv #%d case %d: got %v, "+
+					"want %q", i, c, got, want)
+				continue
+			}
+
+			// The preimage must encode the x coordinate.
+			if !xSwiftEC(u, got).Equals(x) {
+				t.Errorf("xSwiftEC #%d case %d: preimage does "+
+					"not encode x", i, c)
+			}
+		}
+	}
+}
+
+// TestEllswiftECDH ensures both peers derive the same shared secret from the
+// ElligatorSwift encodings of their public keys.
+func TestEllswiftECDH(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		privKey1, encoded1, err := newEllswiftKey()
+		if err != nil {
+			t.Fatalf("newEllswiftKey: unexpected error: %v", err)
+		}
+		privKey2, encoded2, err := newEllswiftKey()
+		if err != nil {
+			t.Fatalf("newEllswiftKey: unexpected error: %v", err)
+		}
+
+		// The encodings must decode to the x coordinates of the public
+		// keys.
+		var pubKey btcec.JacobianPoint
+		privKey1.PubKey().AsJacobian(&pubKey)
+		if !decodeEllswift(&encoded1).Equals(&pubKey.X) {
+			t.Fatalf("decodeEllswift: public key mismatch")
+		}
+
+		secret1, err := v2ECDH(privKey1, &encoded2, &encoded1, true)
+		if err != nil {
+			t.Fatalf("v2ECDH: unexpected error: %v", err)
+		}
+		secret2, err := v2ECDH(privKey2, &encoded1, &encoded2, false)
+		if err != nil {
+			t.Fatalf("v2ECDH: unexpected error: %v", err)
+		}
+		if *secret1 != *secret2 {
+			t.Fatalf("v2ECDH: shared secret mismatch %v != %v",
+				secret1, secret2)
+		}
+	}
+}
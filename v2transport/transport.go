@@ -0,0 +1,442 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package v2transport implements the v2 encrypted peer-to-peer transport
+// protocol (BIP0324).
+package v2transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mraksoll4/bted/btcec/v2"
+	"github.com/mraksoll4/bted/wire"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// GarbageTerminatorSize is the size of the terminators which end the
+	// garbage sent by each peer during the handshake.
+	GarbageTerminatorSize = 16
+
+	// MaxGarbageLen is the maximum number of garbage bytes a peer may send
+	// after its public key during the handshake.
+	MaxGarbageLen = 4095
+
+	// MaxContentsLen is the maximum size of the contents of a packet which
+	// can be expressed by its length field.
+	MaxContentsLen = 1<<(8*lengthFieldSize) - 1
+
+	// lengthFieldSize is the size of the encrypted length of the contents
+	// which starts each packet.
+	lengthFieldSize = 3
+
+	// headerSize is the size of the header which precedes the contents of
+	// packets.  It only holds the ignore bit.
+	headerSize = 1
+
+	// ignoreBit is the bit of the header which is set for decoy packets
+	// that must be ignored by the receiver.
+	ignoreBit = 1 << 7
+
+	// maxRecvContentsLen is the maximum size of the contents of packets
+	// that are received.  It is the size of the largest message type and
+	// message which is allowed by the wire package.
+	maxRecvContentsLen = 1 + wire.CommandSize + wire.MaxBlockPayload
+
+	// tagSize is the size of the authentication tag which is appended to
+	// the encrypted header and contents of packets.
+	tagSize = 16
+
+	// v1PrefixSize is the size of the prefix which is sent first by peers
+	// which use the original v1 transport protocol.
+	v1PrefixSize = 16
+)
+
+var (
+	// ErrHandshakeRejected is returned when the remote peer closes the
+	// connection instead of responding to a v2 handshake initiated by the
+	// local peer, which typically means it only supports the original v1
+	// transport protocol.
+	ErrHandshakeRejected = errors.New("remote peer did not respond to " +
+		"the v2 handshake")
+
+	// ErrGarbageTooLong is returned when the remote peer does not send its
+	// garbage terminator within the maximum allowed amount of garbage.
+	ErrGarbageTooLong = errors.New("garbage terminator not received")
+
+	// ErrPacketAuth is returned when a packet received from the remote peer
+	// fails authentication.
+	ErrPacketAuth = errors.New("packet authentication failed")
+)
+
+// transportVersion is the contents of the version packet which is sent to the
+// remote peer at the end of the handshake.  It is empty for the current
+// version of the protocol and received contents are ignored since they are
+// reserved for future extensions.
+var transportVersion = []byte{}
+
+// Transport provides the v2 encrypted transport protocol (BIP0324) for a
+// connection once the handshake has completed.  It encrypts the messages sent
+// to the remote peer into packets and decrypts the packets it receives.
+//
+// A Transport is not safe for concurrent reads or concurrent writes, however a
+// single reader and a single writer may use it concurrently.
+type Transport struct {
+	r *bufio.Reader
+	w io.Writer
+
+	sendL *fsChaCha20
+	sendP *fsChaCha20Poly1305
+	recvL *fsChaCha20
+	recvP *fsChaCha20Poly1305
+
+	sessionID [32]byte
+}
+
+// keys houses the keys derived from the shared secret of both peers.
+type keys struct {
+	initiatorL, initiatorP                   []byte
+	responderL, responderP                   []byte
+	initiatorTerminator, responderTerminator []byte
+	sessionID                                []byte
+}
+
+// deriveKeys derives the keys of both peers from the passed shared secret.
+// The network magic is part of the derivation so connections between peers on
+// different networks fail.
+func deriveKeys(sharedSecret []byte, net wire.BitcoinNet) *keys {
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(net))
+	salt := append([]byte("bitcoin_v2_shared_secret"), magic[:]...)
+	prk := hkdf.Extract(sha256.New, sharedSecret, salt)
+
+	expand := func(info string) []byte {
+		key := make([]byte, 32)
+		io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), key)
+		return key
+	}
+	terminators := expand("garbage_terminators")
+	return &keys{
+		initiatorL:          expand("initiator_L"),
+		initiatorP:          expand("initiator_P"),
+		responderL:          expand("responder_L"),
+		responderP:          expand("responder_P"),
+		initiatorTerminator: terminators[:GarbageTerminatorSize],
+		responderTerminator: terminators[GarbageTerminatorSize:],
+		sessionID:           expand("session_id"),
+	}
+}
+
+// newTransport returns a transport for the connection read from r and written
+// to w using the keys derived from the passed shared secret.  It also returns
+// the garbage terminators which are sent and expected to be received.
+func newTransport(r *bufio.Reader, w io.Writer, sharedSecret []byte,
+	net wire.BitcoinNet, initiating bool) (*Transport, []byte, []byte) {
+
+	k := deriveKeys(sharedSecret, net)
+	t := &Transport{r: r, w: w}
+	copy(t.sessionID[:], k.sessionID)
+	if initiating {
+		t.sendL = newFSChaCha20(k.initiatorL)
+		t.sendP = newFSChaCha20Poly1305(k.initiatorP)
+		t.recvL = newFSChaCha20(k.responderL)
+		t.recvP = newFSChaCha20Poly1305(k.responderP)
+		return t, k.initiatorTerminator, k.responderTerminator
+	}
+	t.sendL = newFSChaCha20(k.responderL)
+	t.sendP = newFSChaCha20Poly1305(k.responderP)
+	t.recvL = newFSChaCha20(k.initiatorL)
+	t.recvP = newFSChaCha20Poly1305(k.initiatorP)
+	return t, k.responderTerminator, k.initiatorTerminator
+}
+
+// SessionID returns the session id of the connection, which is the same for
+// both peers.  Comparing it out of band allows the peers to detect a man in
+// the middle.
+func (t *Transport) SessionID() [32]byte {
+	return t.sessionID
+}
+
+// encryptPacket returns the packet which carries the passed contents.  The
+// passed aad is authenticated by the packet as well, and the packet is marked
+// as a decoy which the remote peer ignores when ignore is set.
+func (t *Transport) encryptPacket(contents, aad []byte, ignore bool) ([]byte, error) {
+	if len(contents) > MaxContentsLen {
+		return nil, fmt.Errorf("packet contents of %d bytes exceed the "+
+			"maximum of %d bytes", len(contents), MaxContentsLen)
+	}
+
+	plaintext := make([]byte, headerSize+len(contents))
+	if ignore {
+		plaintext[0] = ignoreBit
+	}
+	copy(plaintext[headerSize:], contents)
+
+	packetLen := lengthFieldSize + len(plaintext) + tagSize
+	packet := make([]byte, lengthFieldSize, packetLen)
+	packet[0] = byte(len(contents))
+	packet[1] = byte(len(contents) >> 8)
+	packet[2] = byte(len(contents) >> 16)
+	t.sendL.crypt(packet)
+	return t.sendP.seal(packet, aad, plaintext), nil
+}
+
+// WritePacket sends a packet carrying the passed contents to the remote peer.
+// It returns the number of bytes written.
+func (t *Transport) WritePacket(contents []byte) (int, error) {
+	packet, err := t.encryptPacket(contents, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	return t.w.Write(packet)
+}
+
+// readPacket reads the next packet which is not a decoy from the remote peer
+// and returns its contents along with the number of bytes read.  The passed
+// aad must be authenticated by the first packet that is read.
+func (t *Transport) readPacket(aad []byte) ([]byte, int, error) {
+	totalBytes := 0
+	for {
+		var lengthField [lengthFieldSize]byte
+		n, err := io.ReadFull(t.r, lengthField[:])
+		totalBytes += n
+		if err != nil {
+			return nil, totalBytes, err
+		}
+		t.recvL.crypt(lengthField[:])
+		contentsLen := int(lengthField[0]) | int(lengthField[1])<<8 |
+			int(lengthField[2])<<16
+		if contentsLen > maxRecvContentsLen {
+			return nil, totalBytes, fmt.Errorf("packet contents of %d "+
+				"bytes exceed the maximum of %d bytes",
+				contentsLen, maxRecvContentsLen)
+		}
+
+		ciphertext := make([]byte, headerSize+contentsLen+
+			tagSize)
+		n, err = io.ReadFull(t.r, ciphertext)
+		totalBytes += n
+		if err != nil {
+			return nil, totalBytes, err
+		}
+		plaintext, err := t.recvP.open(aad, ciphertext)
+		if err != nil {
+			return nil, totalBytes, ErrPacketAuth
+		}
+		aad = nil
+
+		// Skip decoy packets.
+		if plaintext[0]&ignoreBit != 0 {
+			continue
+		}
+		return plaintext[headerSize:], totalBytes, nil
+	}
+}
+
+// ReadPacket reads the next packet from the remote peer and returns its
+// contents along with the number of bytes read.  Decoy packets are skipped.
+func (t *Transport) ReadPacket() ([]byte, int, error) {
+	return t.readPacket(nil)
+}
+
+// v1Prefix returns the first bytes sent by peers on the passed network which
+// use the original v1 transport protocol, which is the start of the header of
+// their version message.
+func v1Prefix(net wire.BitcoinNet) []byte {
+	prefix := make([]byte, v1PrefixSize)
+	binary.LittleEndian.PutUint32(prefix, uint32(net))
+	copy(prefix[4:], wire.CmdVersion)
+	return prefix
+}
+
+// ReadV1Prefix reads the first bytes sent by a remote peer which initiated a
+// connection to determine whether it uses the original v1 transport protocol
+// rather than the v2 protocol.  Both protocols always start with more bytes
+// than are read, so this does not block on peers using either of them.
+//
+// It returns the bytes read along with whether they are the start of the
+// version message of a v1 peer.  These bytes must be replayed before reading
+// messages from v1 peers and passed to Respond otherwise.
+func ReadV1Prefix(r io.Reader, net wire.BitcoinNet) ([]byte, bool, error) {
+	prefix := make([]byte, v1PrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, false, err
+	}
+	return prefix, bytes.Equal(prefix, v1Prefix(net)), nil
+}
+
+// Initiate performs the v2 handshake with the remote peer of a connection
+// which was initiated by the local peer and returns the resulting transport.
+//
+// ErrHandshakeRejected is returned when the remote peer fails to send its
+// public key, in which case the caller should reconnect using the original v1
+// transport protocol.
+func Initiate(rw io.ReadWriter, net wire.BitcoinNet) (*Transport, error) {
+	return handshake(rw, net, true, nil)
+}
+
+// Respond performs the v2 handshake with the remote peer of a connection which
+// was initiated by it and returns the resulting transport.  The passed bytes
+// are the ones which were already received from the remote peer by
+// ReadV1Prefix.
+func Respond(rw io.ReadWriter, net wire.BitcoinNet, received []byte) (*Transport, error) {
+	if len(received) > EllswiftPubKeySize {
+		return nil, fmt.Errorf("%d bytes received exceed the size of "+
+			"a public key", len(received))
+	}
+	return handshake(rw, net, false, received)
+}
+
+// handshake performs the v2 handshake with the remote peer.
+//
+// The data sent by the local peer is written while the data of the remote peer
+// is read since the remote peer might not read anything before it sent its own
+// data as well.
+func handshake(rw io.ReadWriter, net wire.BitcoinNet, initiating bool,
+	received []byte) (*Transport, error) {
+
+	privKey, ours, err := newHandshakeKey(net, initiating)
+	if err != nil {
+		return nil, err
+	}
+	garbage, err := newGarbage()
+	if err != nil {
+		return nil, err
+	}
+
+	// The data is written by a single goroutine, in the order it is queued,
+	// so the handshake can continue reading while the writes are pending.
+	sendQueue := make(chan []byte, 2)
+	sendErr := make(chan error, 1)
+	go func() {
+		var err error
+		for b := range sendQueue {
+			if err == nil {
+				_, err = rw.Write(b)
+			}
+		}
+		sendErr <- err
+	}()
+	sendQueue <- append(ours[:], garbage...)
+
+	t, err := completeHandshake(rw, net, initiating, received, privKey,
+		&ours, garbage, sendQueue)
+	close(sendQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version packet must be written before the transport is used.
+	if err := <-sendErr; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// completeHandshake reads the public key of the remote peer, derives the keys
+// of the transport, and queues the garbage terminator and version packet to be
+// sent.  It then reads the garbage and version packet of the remote peer.
+func completeHandshake(rw io.ReadWriter, net wire.BitcoinNet, initiating bool,
+	received []byte, privKey *btcec.PrivateKey, ours *[EllswiftPubKeySize]byte,
+	garbage []byte, sendQueue chan<- []byte) (*Transport, error) {
+
+	// Read the public key of the remote peer.
+	r := bufio.NewReader(rw)
+	var theirs [EllswiftPubKeySize]byte
+	copy(theirs[:], received)
+	_, err := io.ReadFull(r, theirs[len(received):])
+	if err != nil {
+		if initiating {
+			return nil, ErrHandshakeRejected
+		}
+		return nil, err
+	}
+
+	sharedSecret, err := v2ECDH(privKey, &theirs, ours, initiating)
+	if err != nil {
+		return nil, err
+	}
+	t, sendTerminator, recvTerminator := newTransport(r, rw,
+		sharedSecret[:], net, initiating)
+
+	// Send the garbage terminator followed by the version packet, which
+	// authenticates the garbage that was sent.
+	versionPacket, err := t.encryptPacket(transportVersion, garbage, false)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(sendTerminator)+len(versionPacket))
+	msg = append(msg, sendTerminator...)
+	sendQueue <- append(msg, versionPacket...)
+
+	// Skip the garbage of the remote peer until its terminator is found.
+	recvGarbage := make([]byte, GarbageTerminatorSize,
+		MaxGarbageLen+GarbageTerminatorSize)
+	if _, err := io.ReadFull(r, recvGarbage); err != nil {
+		return nil, err
+	}
+	for !bytes.HasSuffix(recvGarbage, recvTerminator) {
+		if len(recvGarbage) == MaxGarbageLen+GarbageTerminatorSize {
+			return nil, ErrGarbageTooLong
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		recvGarbage = append(recvGarbage, b)
+	}
+	recvGarbage = recvGarbage[:len(recvGarbage)-GarbageTerminatorSize]
+
+	// The version packet of the remote peer, or the first decoy packet
+	// preceding it, authenticates its garbage.  Its contents are ignored.
+	if _, _, err := t.readPacket(recvGarbage); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newHandshakeKey returns a new private key along with the encoding of its
+// public key to send to the remote peer.  The encoding sent by the initiating
+// peer must not start with the network magic since the remote peer would
+// mistake it for the start of a v1 message otherwise.
+func newHandshakeKey(net wire.BitcoinNet, initiating bool) (*btcec.PrivateKey,
+	[EllswiftPubKeySize]byte, error) {
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(net))
+	for {
+		privKey, encoded, err := newEllswiftKey()
+		if err != nil {
+			return nil, encoded, err
+		}
+		if initiating && bytes.Equal(encoded[:4], magic[:]) {
+			continue
+		}
+		return privKey, encoded, nil
+	}
+}
+
+// newGarbage returns a random amount of random garbage to send after the
+// public key during the handshake.
+func newGarbage() ([]byte, error) {
+	var lenBytes [2]byte
+	if _, err := rand.Read(lenBytes[:]); err != nil {
+		return nil, err
+	}
+	garbageLen := int(binary.LittleEndian.Uint16(lenBytes[:])) %
+		(MaxGarbageLen + 1)
+
+	garbage := make([]byte, garbageLen)
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mraksoll4/bted/btcec/v2"
+	"github.com/mraksoll4/bted/wire"
+)
+
+// vectorNet is the network magic the BIP0324 test vectors were generated with,
+// which is the one of the Bitcoin main network.
+const vectorNet = wire.BitcoinNet(0xd9b4bef9)
+
+// TestPacketEncoding tests the key derivation and packet encryption against
+// the test vectors of BIP0324.
+func TestPacketEncoding(t *testing.T) {
+	tests := []struct {
+		inIdx      int    // Number of packets encrypted before
+		priv       string // Private key of the local peer
+		ours       string // Encoded public key of the local peer
+		theirs     string // Encoded public key of the remote peer
+		initiating bool   // Whether the local peer is the initiator
+		contents   string // Contents of the packet
+		multiply   int    // Number of repetitions of the contents
+		aad        string // Data authenticated by the packet
+		ignore     bool   // Whether the packet is a decoy
+		shared     string // Expected shared secret
+		sessionID  string // Expected session id
+		ciphertext string // Expected packet
+		ctSuffix   string // Expected end of the packet when long
+	}{
+		{
+			inIdx:      1,
+			priv:       "61062ea5071d800bbfd59e2e8b53d47d194b095ae5a4df04936b49772ef0d4d7",
+			ours:       "ec0adff257bbfe500c188c80b4fdd640f6b45a482bbc15fc7cef5931deff0aa186f6eb9bba7b85dc4dcc28b28722de1e3d9108b985e2967045668f66098e475b",
+			theirs:     "a4a94dfce69b4a2a0a099313d10f9f7e7d649d60501c9e1d274c300e0d89aafaffffffffffffffffffffffffffffffffffffffffffffffffffffffff8faf88d5",
+			initiating: true,
+			contents:   "8e",
+			multiply:   1,
+			aad:        "",
+			ignore:     false,
+			shared:     "c6992a117f5edbea70c3f511d32d26b9798be4b81a62eaee1a5acaa8459a3592",
+			sessionID:  "ce72dffb015da62b0d0f5474cab8bc72605225b0cee3f62312ec680ec5f41ba5",
+			ciphertext: "7530d2a18720162ac09c25329a60d75adf36eda3c3",
+			ctSuffix:   "",
+		},
+		{
+			inIdx:      999,
+			priv:       "1f9c581b35231838f0f17cf0c979835baccb7f3abbbb96ffcc318ab71e6e126f",
+			ours:       "a1855e10e94e00baa23041d916e259f7044e491da6171269694763f018c7e63693d29575dcb464ac816baa1be353ba12e3876cba7628bd0bd8e755e721eb0140",
+			theirs:     "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f0000000000000000000000000000000000000000000000000000000000000000",
+			initiating: false,
+			contents:   "3eb1d4e98035cfd8eeb29bac969ed3824a",
+			multiply:   1,
+			aad:        "",
+			ignore:     false,
+			shared:     "a0138f564f74d0ad70bc337dacc9d0bf1d2349364caf1188a1e6e8ddb3b7b184",
+			sessionID:  "9267c54560607de73f18c563b76a2442718879c52dd39852885d4a3c9912c9ea",
+			ciphertext: "1da1bcf589f9b61872f45b7fa5371dd3f8bdf5d515b0c5f9fe9f0044afb8dc0aa1cd39a8c4",
+			ctSuffix:   "",
+		},
+		{
+			inIdx:      223,
+			priv:       "6c77432d1fda31e9f942f8af44607e10f3ad38a65f8a4bddae823e5eff90dc38",
+			ours:       "d2685070c1e6376e633e825296634fd461fa9e5bdf2109bcebd735e5a91f3e587c5cb782abb797fbf6bb5074fd1542a474f2a45b673763ec2db7fb99b737bbb9",
+			theirs:     "56bd0c06f10352c3a1a9f4b4c92f6fa2b26df124b57878353c1fc691c51abea77c8817daeeb9fa546b77c8daf79d89b22b0e1b87574ece42371f00237aa9d83a",
+			initiating: false,
+			contents:   "7e0e78eb6990b059e6cf0ded66ea93ef82e72aa2f18ac24f2fc6ebab561ae557420729da103f64cecfa20527e15f9fb669a49bbbf274ef0389b3e43c8c44e5f60bf2ac38e2b55e7ec4273dba15ba41d21f8f5b3ee1688b3c29951218caf847a97fb50d75a86515d445699497d968164bf740012679b8962de573be941c62b7ef",
+			multiply:   1,
+			aad:        "",
+			ignore:     true,
+			shared:     "1918b741ef5f9d1d7670b050c152b4a4ead2c31be9aecb0681c0cd4324150853",
+			sessionID:  "7ec02fea8c1484e3d0875f978c5f36d63545e2e4acf56311394422f4b66af612",
+			ciphertext: "",
+			ctSuffix:   "729847a3e9eba7a5bff454b5de3b393431ee360736b6c030d7a5bd01d1203d2e98f528543fd2bf886ccaa1ada5e215a730a36b3f4abfc4e252c89eb01d9512f94916dae8a76bf16e4da28986ffe159090fe5267ee3394300b7ccf4dfad389a26321b3a3423e4594a82ccfbad16d6561ecb8772b0cb040280ff999a29e3d9d4fd",
+		},
+		{
+			inIdx:      673,
+			priv:       "0af952659ed76f80f585966b95ab6e6fd68654672827878684c8b547b1b94f5a",
+			ours:       "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffc81017fd92fd31637c26c906b42092e11cc0d3afae8d9019d2578af22735ce7bc469c72d",
+			theirs:     "9652d78baefc028cd37a6a92625b8b8f85fde1e4c944ad3f20e198bef8c02f19fffffffffffffffffffffffffffffffffffffffffffffffffffffffff2e91870",
+			initiating: false,
+			contents:   "5c6272ee55da855bbbf7b1246d9885aa7aa601a715ab86fa46c50da533badf82b97597c968293ae04e",
+			multiply:   97561,
+			aad:        "",
+			ignore:     false,
+			shared:     "3568f2aea2e14ef4ee4a3c2a8b8d31bc5e3187ba86db10739b4ff8ec92ff6655",
+			sessionID:  "7332e92a3f9d2792c4d444fac5ed888c39a073043a65eefb626318fd649328f8",
+			ciphertext: "",
+			ctSuffix:   "657a4a19711ce593c3844cb391b224f60124aba7e04266233bc50cafb971e26c7716b76e98376448f7d214dd11e629ef9a974d60e3770a695810a61c4ba66d78b936ee7892b98f0b48ddae9fcd8b599dca1c9b43e9b95e0226cf8d4459b8a7c2c4e6db80f1d58c7b20dd7208fa5c1057fb78734223ee801dbd851db601fee61e",
+		},
+	}
+
+	hexToBytes := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatalf("invalid hex in source file: %s", s)
+		}
+		return b
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		privKey, _ := btcec.PrivKeyFromBytes(hexToBytes(test.priv))
+		var ours, theirs [EllswiftPubKeySize]byte
+		copy(ours[:], hexToBytes(test.ours))
+		copy(theirs[:], hexToBytes(test.theirs))
+
+		shared, err := v2ECDH(privKey, &theirs, &ours, test.initiating)
+		if err != nil {
+			t.Errorf("v2ECDH #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(shared[:], hexToBytes(test.shared)) {
+			t.Errorf("v2ECDH #%d got: %x want: %s", i, shared[:],
+				test.shared)
+			continue
+		}
+
+		tr, _, _ := newTransport(bufio.NewReader(nil), nil, shared[:],
+			vectorNet, test.initiating)
+		sessionID := tr.SessionID()
+		if !bytes.Equal(sessionID[:], hexToBytes(test.sessionID)) {
+			t.Errorf("SessionID #%d got: %x want: %s", i, sessionID,
+				test.sessionID)
+			continue
+		}
+
+		for j := 0; j < test.inIdx; j++ {
+			if _, err := tr.encryptPacket(nil, nil, false); err != nil {
+				t.Fatalf("encryptPacket #%d error %v", i, err)
+			}
+		}
+		contents := bytes.Repeat(hexToBytes(test.contents),
+			test.multiply)
+		packet, err := tr.encryptPacket(contents,
+			hexToBytes(test.aad), test.ignore)
+		if err != nil {
+			t.Errorf("encryptPacket #%d error %v", i, err)
+			continue
+		}
+		if test.ciphertext != "" &&
+			!bytes.Equal(packet, hexToBytes(test.ciphertext)) {
+
+			t.Errorf("encryptPacket #%d got: %x want: %s", i, packet,
+				test.ciphertext)
+			continue
+		}
+		if test.ctSuffix != "" && !strings.HasSuffix(
+			hex.EncodeToString(packet), test.ctSuffix) {
+
+			t.Errorf("encryptPacket #%d unexpected packet suffix", i)
+		}
+	}
+}
+
+// TestHandshake ensures two peers complete the v2 handshake over a connection
+// and can exchange packets in both directions afterwards.
+func TestHandshake(t *testing.T) {
+	inConn, outConn := net.Pipe()
+	defer inConn.Close()
+	defer outConn.Close()
+
+	type result struct {
+		tr  *Transport
+		err error
+	}
+	inbound := make(chan result, 1)
+	go func() {
+		prefix, isV1, err := ReadV1Prefix(inConn, wire.MainNet)
+		if err != nil {
+			inbound <- result{err: err}
+			return
+		}
+		if isV1 {
+			inbound <- result{err: ErrHandshakeRejected}
+			return
+		}
+		tr, err := Respond(inConn, wire.MainNet, prefix)
+		inbound <- result{tr, err}
+	}()
+
+	initiator, err := Initiate(outConn, wire.MainNet)
+	if err != nil {
+		t.Fatalf("Initiate: unexpected error %v", err)
+	}
+	res := <-inbound
+	if res.err != nil {
+		t.Fatalf("Respond: unexpected error %v", res.err)
+	}
+	responder := res.tr
+	if initiator.SessionID() != responder.SessionID() {
+		t.Fatalf("session ids differ: %x and %x",
+			initiator.SessionID(), responder.SessionID())
+	}
+
+	// Exchange enough packets in both directions to ensure the ciphers
+	// stay in sync across rekeying and that decoys are skipped.
+	send := func(from *Transport, contents []byte, ignore bool) {
+		packet, err := from.encryptPacket(contents, nil, ignore)
+		if err == nil {
+			_, err = from.w.Write(packet)
+		}
+		if err != nil {
+			t.Errorf("unable to send packet: %v", err)
+		}
+	}
+	for i := 0; i < rekeyInterval+10; i++ {
+		contents := bytes.Repeat([]byte{byte(i)}, i)
+		go func() {
+			send(initiator, []byte("decoy"), true)
+			send(initiator, contents, false)
+			send(responder, contents, false)
+		}()
+
+		got, _, err := responder.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket #%d: unexpected error %v", i, err)
+		}
+		if !bytes.Equal(got, contents) {
+			t.Fatalf("ReadPacket #%d got: %x want: %x", i, got,
+				contents)
+		}
+		got, _, err = initiator.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket #%d: unexpected error %v", i, err)
+		}
+		if !bytes.Equal(got, contents) {
+			t.Fatalf("ReadPacket #%d got: %x want: %x", i, got,
+				contents)
+		}
+	}
+}
+
+// TestV1Fallback ensures v1 peers are detected by the responder and that the
+// initiator reports a rejected handshake when the remote peer disconnects.
+func TestV1Fallback(t *testing.T) {
+	// The header of a v1 version message is detected.
+	var buf bytes.Buffer
+	msg := wire.NewMsgVerAck()
+	wire.WriteMessage(&buf, msg, wire.ProtocolVersion, wire.MainNet)
+	versionHdr := buf.Bytes()
+	copy(versionHdr[4:], []byte(wire.CmdVersion+"\x00"))
+	prefix, isV1, err := ReadV1Prefix(bytes.NewReader(versionHdr),
+		wire.MainNet)
+	if err != nil {
+		t.Fatalf("ReadV1Prefix: unexpected error %v", err)
+	}
+	if !isV1 || !bytes.Equal(prefix, versionHdr[:v1PrefixSize]) {
+		t.Fatalf("ReadV1Prefix: v1 version header not detected")
+	}
+
+	// A v1 version header of another network is not.
+	_, isV1, err = ReadV1Prefix(bytes.NewReader(versionHdr),
+		wire.TestNet3)
+	if err != nil {
+		t.Fatalf("ReadV1Prefix: unexpected error %v", err)
+	}
+	if isV1 {
+		t.Fatalf("ReadV1Prefix: v1 header of another network detected")
+	}
+
+	// The initiator reports a rejected handshake when the remote peer
+	// closes the connection without responding.
+	inConn, outConn := net.Pipe()
+	defer outConn.Close()
+	go func() {
+		ReadV1Prefix(inConn, wire.MainNet)
+		inConn.Close()
+	}()
+	if _, err := Initiate(outConn, wire.MainNet); err != ErrHandshakeRejected {
+		t.Fatalf("Initiate: unexpected error got: %v want: %v", err,
+			ErrHandshakeRejected)
+	}
+}
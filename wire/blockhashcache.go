@@ -0,0 +1,116 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+)
+
+// blockHashCacheSize is the maximum number of block header hashes kept in the
+// package level block hash cache.  Each entry costs roughly 200 bytes, so the
+// cache tops out at a couple of megabytes while still comfortably covering a
+// full headers message along with the blocks currently being processed.
+const blockHashCacheSize = 10000
+
+// blockHashCache is the package level cache used by BlockHeader.PowHash to
+// avoid repeating the memory-hard yespower computation for headers that have
+// recently been hashed.
+var blockHashCache = newHeaderHashCache(blockHashCacheSize)
+
+// headerHashEntry houses a serialized block header along with its hash so the
+// entry can be removed from the lookup map when it is evicted.
+type headerHashEntry struct {
+	header [blockHeaderLen]byte
+	hash   chainhash.Hash
+}
+
+// headerHashCache is a bounded least recently used cache which maps serialized
+// block headers to their hashes.  Since entries are keyed by the full
+// serialized header, modifying any field of a header naturally results in a
+// different entry, so there is never a need to invalidate cached hashes.
+//
+// The cache is safe for concurrent access.
+type headerHashCache struct {
+	mtx     sync.Mutex
+	entries map[[blockHeaderLen]byte]*list.Element
+	lru     *list.List
+	limit   int
+}
+
+// newHeaderHashCache returns a new header hash cache that holds at most limit
+// entries.
+func newHeaderHashCache(limit int) *headerHashCache {
+	return &headerHashCache{
+		entries: make(map[[blockHeaderLen]byte]*list.Element),
+		lru:     list.New(),
+		limit:   limit,
+	}
+}
+
+// Lookup returns the cached hash for the passed serialized header and whether
+// or not it was found.  A successful lookup marks the entry as the most
+// recently used.
+//
+// This function is safe for concurrent access.
+func (c *headerHashCache) Lookup(header *[blockHeaderLen]byte) (chainhash.Hash, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[*header]
+	if !ok {
+		return chainhash.Hash{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*headerHashEntry).hash, true
+}
+
+// Add adds the hash for the passed serialized header to the cache, evicting
+// the least recently used entry when the cache is full.
+//
+// This function is safe for concurrent access.
+func (c *headerHashCache) Add(header *[blockHeaderLen]byte, hash *chainhash.Hash) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.limit <= 0 {
+		return
+	}
+
+	// Nothing more to do when another caller already added the entry while
+	// the hash was being computed.
+	if elem, ok := c.entries[*header]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	// Reuse the least recently used entry when the cache is full in order
+	// to avoid an extra allocation.
+	if c.lru.Len() >= c.limit {
+		elem := c.lru.Back()
+		entry := elem.Value.(*headerHashEntry)
+		delete(c.entries, entry.header)
+		entry.header = *header
+		entry.hash = *hash
+		c.entries[*header] = elem
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	entry := &headerHashEntry{header: *header, hash: *hash}
+	c.entries[*header] = c.lru.PushFront(entry)
+}
+
+// Len returns the number of entries currently in the cache.
+//
+// This function is safe for concurrent access.
+func (c *headerHashCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lru.Len()
+}
@@ -47,19 +47,61 @@ type BlockHeader struct {
 const blockHeaderLen = 80
 
 // BlockHash computes the block identifier hash for the given block header.
+//
+// The block identifier is defined to be the yespower proof-of-work hash of
+// the header, so this is equivalent to calling PowHash.  Callers which already
+// have the identifier available, such as from a bteutil.Block or the block
+// index, should use it instead of calling this method.
 func (h *BlockHeader) BlockHash() chainhash.Hash {
-	var blockhash chainhash.Hash
-	// Encode the header and double sha256 everything prior to the number of
+	return h.PowHash()
+}
+
+// PowHash computes the yespower proof-of-work hash for the given block header.
+//
+// Yespower is intentionally expensive to compute, so recently computed hashes
+// are memoized in a bounded cache keyed by the serialized header.  Since the
+// cache key covers every header field, modifying a header always results in a
+// fresh computation.
+func (h *BlockHeader) PowHash() chainhash.Hash {
+	serialized := h.powSerialize()
+	if powHash, ok := blockHashCache.Lookup(&serialized); ok {
+		return powHash
+	}
+
+	powHash := yespowerHash(&serialized)
+	blockHashCache.Add(&serialized, &powHash)
+	return powHash
+}
+
+// UncachedPowHash computes the yespower proof-of-work hash for the given block
+// header without consulting or populating the hash cache.
+//
+// It is intended for hashing headers which are unlikely to be hashed again,
+// such as the nonces tried while mining or the shares submitted by miners,
+// which would otherwise evict useful entries from the cache.
+func (h *BlockHeader) UncachedPowHash() chainhash.Hash {
+	serialized := h.powSerialize()
+	return yespowerHash(&serialized)
+}
+
+// powSerialize returns the serialized header which is hashed by yespower.
+func (h *BlockHeader) powSerialize() [blockHeaderLen]byte {
+	// Encode the header and yespower hash everything prior to the number of
 	// transactions.  Ignore the error returns since there is no way the
 	// encode could fail except being out of memory which would cause a
 	// run-time panic.
-	buf := bytes.NewBuffer(make([]byte, 0, MaxBlockHeaderPayload))
+	var serialized [blockHeaderLen]byte
+	buf := bytes.NewBuffer(serialized[:0])
 	_ = writeBlockHeader(buf, 0, h)
-       
-	hashedYespower := yespower.YespowerHash(buf.Bytes())
-	copy(blockhash[:], hashedYespower)
+	copy(serialized[:], buf.Bytes())
+	return serialized
+}
 
-	return blockhash
+// yespowerHash returns the yespower hash of the passed serialized header.
+func yespowerHash(serialized *[blockHeaderLen]byte) chainhash.Hash {
+	var powHash chainhash.Hash
+	copy(powHash[:], yespower.YespowerHash(serialized[:]))
+	return powHash
 }
 
 // BteDecode decodes r using the bitcoin protocol encoding into the receiver.
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
 )
 
 // TestBlockHeader tests the BlockHeader API.
@@ -259,3 +260,75 @@ func TestBlockHeaderSerialize(t *testing.T) {
 		}
 	}
 }
+
+// TestBlockHeaderHashCache ensures the block hash cache returns the same hash
+// as a fresh computation, tracks header modifications, and is bounded.
+func TestBlockHeaderHashCache(t *testing.T) {
+	bh := NewBlockHeader(1, &mainNetGenesisHash, &mainNetGenesisMerkleRoot,
+		0x1d00ffff, 123123)
+
+	// Hashing the same header repeatedly must yield the same result.
+	hash := bh.BlockHash()
+	if cachedHash := bh.BlockHash(); cachedHash != hash {
+		t.Fatalf("BlockHash: cached hash mismatch - got %v, want %v",
+			cachedHash, hash)
+	}
+
+	// The block identifier is defined as the proof-of-work hash.
+	if powHash := bh.PowHash(); powHash != hash {
+		t.Fatalf("PowHash: mismatched hash - got %v, want %v", powHash,
+			hash)
+	}
+
+	// Hashing without the cache must yield the same result without adding
+	// the header to the cache.
+	bh.Nonce++
+	uncachedLen := blockHashCache.Len()
+	uncachedHash := bh.UncachedPowHash()
+	if blockHashCache.Len() != uncachedLen {
+		t.Fatalf("UncachedPowHash: cache grew from %d to %d entries",
+			uncachedLen, blockHashCache.Len())
+	}
+	if powHash := bh.PowHash(); powHash != uncachedHash {
+		t.Fatalf("UncachedPowHash: mismatched hash - got %v, want %v",
+			uncachedHash, powHash)
+	}
+	bh.Nonce--
+
+	// Modifying the header must not return the stale cached hash.
+	bh.Nonce++
+	modifiedHash := bh.BlockHash()
+	if modifiedHash == hash {
+		t.Fatalf("BlockHash: stale hash %v returned for modified header",
+			hash)
+	}
+	bh.Nonce--
+	if gotHash := bh.BlockHash(); gotHash != hash {
+		t.Fatalf("BlockHash: wrong hash after restoring header - got "+
+			"%v, want %v", gotHash, hash)
+	}
+
+	// Ensure the cache evicts the least recently used entries once the
+	// limit is reached.
+	cache := newHeaderHashCache(2)
+	var headers [3][blockHeaderLen]byte
+	for i := range headers {
+		headers[i][0] = byte(i)
+		hash := chainhash.Hash{byte(i)}
+		cache.Add(&headers[i], &hash)
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("Len: unexpected number of entries - got %d, want 2",
+			cache.Len())
+	}
+	if _, ok := cache.Lookup(&headers[0]); ok {
+		t.Fatal("Lookup: least recently used entry was not evicted")
+	}
+	for i := 1; i < len(headers); i++ {
+		gotHash, ok := cache.Lookup(&headers[i])
+		if !ok || gotHash != (chainhash.Hash{byte(i)}) {
+			t.Fatalf("Lookup: unexpected result for entry %d - got "+
+				"%v (found %v)", i, gotHash, ok)
+		}
+	}
+}
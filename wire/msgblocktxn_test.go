@@ -0,0 +1,53 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestBlockTxnWire tests the MsgBlockTxn wire encode and decode.
+func TestBlockTxnWire(t *testing.T) {
+	hash := blockOne.BlockHash()
+	msg := NewMsgBlockTxn(&hash, []*MsgTx{multiTx, multiWitnessTx})
+
+	// Ensure the command is expected value.
+	wantCmd := "blocktxn"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	var buf bytes.Buffer
+	err := msg.BteEncode(&buf, ProtocolVersion, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BteEncode error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes()[:32], hash[:]) || buf.Bytes()[32] != 0x02 {
+		t.Fatalf("BteEncode: unexpected encoding %s",
+			spew.Sdump(buf.Bytes()))
+	}
+
+	var readmsg MsgBlockTxn
+	err = readmsg.BteDecode(&buf, ProtocolVersion, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BteDecode error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BteDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Ensure encode fails with the protocol version before
+	// CompactBlocksVersion.
+	err = msg.BteEncode(&buf, CompactBlocksVersion-1, WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BteEncode: unexpected error %v", err)
+	}
+}
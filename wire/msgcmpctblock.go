@@ -0,0 +1,270 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/aead/siphash"
+	"github.com/mraksoll4/bted/chaincfg/chainhash"
+)
+
+const (
+	// ShortTxIDSize is the number of bytes a short transaction id takes up
+	// in a cmpctblock message.
+	ShortTxIDSize = 6
+
+	// shortTxIDMask is the mask applied to the SipHash-2-4 of a witness
+	// transaction hash to obtain its short transaction id.
+	shortTxIDMask = 1<<(ShortTxIDSize*8) - 1
+)
+
+// PrefilledTx houses a transaction which is sent in full in a cmpctblock
+// message along with its index in the block.
+type PrefilledTx struct {
+	// Index is the absolute index of the transaction in the block.  It is
+	// differentially encoded on the wire.
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitcoin
+// cmpctblock message.  It is used to relay a block header along with short
+// transaction ids in place of most of the transactions of the block, which the
+// receiving peer likely already has in its memory pool (BIP0152).
+//
+// The transactions of the block are the prefilled transactions at their
+// indexes with the transactions identified by the short ids filling the
+// remaining indexes in order.
+//
+// This message was not added until protocol versions starting with
+// CompactBlocksVersion.
+type MsgCmpctBlock struct {
+	Header       BlockHeader
+	Nonce        uint64
+	ShortIDs     []uint64
+	PrefilledTxs []PrefilledTx
+}
+
+// TxCount returns the number of transactions in the block the message
+// represents.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxs)
+}
+
+// ShortIDKey returns the SipHash-2-4 key used to calculate the short
+// transaction ids of the message.  It is the first 16 bytes of the single
+// SHA256 of the block header followed by the little endian nonce.
+func (msg *MsgCmpctBlock) ShortIDKey() [16]byte {
+	var buf bytes.Buffer
+	buf.Grow(MaxBlockHeaderPayload + 8)
+	_ = writeBlockHeader(&buf, 0, &msg.Header)
+	var nonce [8]byte
+	binary.LittleEndian.PutUint64(nonce[:], msg.Nonce)
+	buf.Write(nonce[:])
+
+	hash := sha256.Sum256(buf.Bytes())
+	var key [16]byte
+	copy(key[:], hash[:16])
+	return key
+}
+
+// ShortTxID returns the short transaction id of the transaction with the
+// passed witness hash using the passed key obtained from ShortIDKey.
+func ShortTxID(key *[16]byte, wtxid *chainhash.Hash) uint64 {
+	return siphash.Sum64(wtxid[:], key) & shortTxIDMask
+}
+
+// BteDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BteDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BteDecode", str)
+	}
+
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	// Prevent more short ids than could possibly fit into a block.
+	numShortIDs, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if numShortIDs > maxTxPerBlock {
+		str := fmt.Sprintf("too many short ids to fit into a block "+
+			"[count %d, max %d]", numShortIDs, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BteDecode", str)
+	}
+	msg.ShortIDs = make([]uint64, 0, numShortIDs)
+	var shortID [8]byte
+	for i := uint64(0); i < numShortIDs; i++ {
+		_, err := io.ReadFull(r, shortID[:ShortTxIDSize])
+		if err != nil {
+			return err
+		}
+		msg.ShortIDs = append(msg.ShortIDs,
+			binary.LittleEndian.Uint64(shortID[:]))
+	}
+
+	numPrefilled, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if numPrefilled > maxTxPerBlock-numShortIDs {
+		str := fmt.Sprintf("too many transactions to fit into a block "+
+			"[count %d, max %d]", numShortIDs+numPrefilled,
+			maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BteDecode", str)
+	}
+
+	// The index of each prefilled transaction is encoded as the difference
+	// from the index of the previous one minus one.  Each index must fall
+	// within the block.
+	numTxns := numShortIDs + numPrefilled
+	msg.PrefilledTxs = make([]PrefilledTx, 0, numPrefilled)
+	var nextIndex uint64
+	for i := uint64(0); i < numPrefilled; i++ {
+		diff, err := ReadVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+		if diff >= numTxns || nextIndex+diff >= numTxns {
+			str := fmt.Sprintf("prefilled transaction index is "+
+				"out of range [index %d, transactions %d]",
+				nextIndex+diff, numTxns)
+			return messageError("MsgCmpctBlock.BteDecode", str)
+		}
+		index := nextIndex + diff
+		nextIndex = index + 1
+
+		tx := MsgTx{}
+		err = tx.BteDecode(r, pver, enc)
+		if err != nil {
+			return err
+		}
+		msg.PrefilledTxs = append(msg.PrefilledTxs, PrefilledTx{
+			Index: uint32(index),
+			Tx:    &tx,
+		})
+	}
+
+	return nil
+}
+
+// BteEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BteEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BteEncode", str)
+	}
+
+	numTxns := msg.TxCount()
+	if numTxns > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions to fit into a block "+
+			"[count %d, max %d]", numTxns, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BteEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	var shortID [8]byte
+	for _, id := range msg.ShortIDs {
+		binary.LittleEndian.PutUint64(shortID[:], id)
+		_, err := w.Write(shortID[:ShortTxIDSize])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.PrefilledTxs)))
+	if err != nil {
+		return err
+	}
+	var nextIndex uint32
+	for i := range msg.PrefilledTxs {
+		prefilled := &msg.PrefilledTxs[i]
+		if prefilled.Index < nextIndex ||
+			int(prefilled.Index) >= numTxns {
+
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"out of order or range", prefilled.Index)
+			return messageError("MsgCmpctBlock.BteEncode", str)
+		}
+		err = WriteVarInt(w, pver, uint64(prefilled.Index-nextIndex))
+		if err != nil {
+			return err
+		}
+		nextIndex = prefilled.Index + 1
+
+		err = prefilled.Tx.BteEncode(w, pver, enc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block is never larger than the block it represents.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new bitcoin cmpctblock message that conforms to
+// the Message interface for the passed block.  The coinbase transaction is
+// prefilled since the receiving peer can't possibly have it while the rest of
+// the transactions are replaced by their short transaction ids calculated with
+// the passed nonce.  See MsgCmpctBlock for details.
+func NewMsgCmpctBlock(block *MsgBlock, nonce uint64) *MsgCmpctBlock {
+	msg := &MsgCmpctBlock{
+		Header: block.Header,
+		Nonce:  nonce,
+	}
+	if len(block.Transactions) == 0 {
+		return msg
+	}
+
+	msg.PrefilledTxs = []PrefilledTx{{Index: 0, Tx: block.Transactions[0]}}
+	msg.ShortIDs = make([]uint64, 0, len(block.Transactions)-1)
+	key := msg.ShortIDKey()
+	for _, tx := range block.Transactions[1:] {
+		wtxid := tx.WitnessHash()
+		msg.ShortIDs = append(msg.ShortIDs, ShortTxID(&key, &wtxid))
+	}
+	return msg
+}
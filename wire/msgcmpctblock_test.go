@@ -0,0 +1,184 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestCmpctBlock tests the MsgCmpctBlock API.
+func TestCmpctBlock(t *testing.T) {
+	pver := ProtocolVersion
+
+	block := blockOne
+	block.Transactions = []*MsgTx{blockOne.Transactions[0], multiTx,
+		multiWitnessTx}
+	msg := NewMsgCmpctBlock(&block, 0x0102030405060708)
+
+	// Ensure the command is expected value.
+	wantCmd := "cmpctblock"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCmpctBlock: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(MaxBlockPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure only the coinbase is prefilled and the short ids of the rest
+	// of the transactions are calculated from their witness hashes.
+	if msg.TxCount() != len(block.Transactions) {
+		t.Fatalf("TxCount: got %d, want %d", msg.TxCount(),
+			len(block.Transactions))
+	}
+	if len(msg.PrefilledTxs) != 1 || msg.PrefilledTxs[0].Index != 0 ||
+		msg.PrefilledTxs[0].Tx != block.Transactions[0] {
+
+		t.Fatalf("NewMsgCmpctBlock: unexpected prefilled "+
+			"transactions %v", spew.Sdump(msg.PrefilledTxs))
+	}
+	key := msg.ShortIDKey()
+	for i, tx := range block.Transactions[1:] {
+		wtxid := tx.WitnessHash()
+		want := ShortTxID(&key, &wtxid)
+		if msg.ShortIDs[i] != want {
+			t.Errorf("short id #%d: got %x, want %x", i,
+				msg.ShortIDs[i], want)
+		}
+		if want>>48 != 0 {
+			t.Errorf("short id #%d: %x is larger than 6 bytes", i,
+				want)
+		}
+	}
+
+	// Ensure a different nonce results in different short ids.
+	other := NewMsgCmpctBlock(&block, 0)
+	if reflect.DeepEqual(other.ShortIDs, msg.ShortIDs) {
+		t.Errorf("short ids did not change with the nonce")
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BteEncode(&buf, pver, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("encode of MsgCmpctBlock failed %v err <%v>", msg, err)
+	}
+	var readmsg MsgCmpctBlock
+	err = readmsg.BteDecode(&buf, pver, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("decode of MsgCmpctBlock failed [%v] err <%v>", buf,
+			err)
+	}
+	if !reflect.DeepEqual(msg, &readmsg) {
+		t.Errorf("decode of MsgCmpctBlock: got %v, want %v",
+			spew.Sdump(&readmsg), spew.Sdump(msg))
+	}
+
+	// Ensure encode fails with the protocol version before
+	// CompactBlocksVersion.
+	err = msg.BteEncode(&buf, CompactBlocksVersion-1, WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("encode of MsgCmpctBlock succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestCmpctBlockWire tests the MsgCmpctBlock wire encode and decode, notably
+// the differential encoding of the prefilled transaction indexes.
+func TestCmpctBlockWire(t *testing.T) {
+	tx := blockOne.Transactions[0]
+	msg := MsgCmpctBlock{
+		Header:   blockOne.Header,
+		Nonce:    1,
+		ShortIDs: []uint64{0x060504030201},
+		PrefilledTxs: []PrefilledTx{
+			{Index: 0, Tx: tx},
+			{Index: 2, Tx: tx},
+		},
+	}
+
+	var txBuf bytes.Buffer
+	if err := tx.Serialize(&txBuf); err != nil {
+		t.Fatalf("Serialize: unexpected error: %v", err)
+	}
+	var want []byte
+	want = append(want, blockOneBytes[:MaxBlockHeaderPayload]...)
+	want = append(want, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	want = append(want, 0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06)
+	want = append(want, 0x02, 0x00)
+	want = append(want, txBuf.Bytes()...)
+	want = append(want, 0x01)
+	want = append(want, txBuf.Bytes()...)
+
+	var buf bytes.Buffer
+	err := msg.BteEncode(&buf, ProtocolVersion, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BteEncode error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("BteEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want))
+	}
+
+	var readmsg MsgCmpctBlock
+	err = readmsg.BteDecode(bytes.NewReader(want), ProtocolVersion,
+		WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BteDecode error %v", err)
+	}
+	if !reflect.DeepEqual(readmsg, msg) {
+		t.Fatalf("BteDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+}
+
+// TestCmpctBlockWireErrors performs negative tests against wire encode and
+// decode of MsgCmpctBlock to confirm invalid indexes are rejected.
+func TestCmpctBlockWireErrors(t *testing.T) {
+	tx := blockOne.Transactions[0]
+
+	// Prefilled transactions which are out of order or beyond the end of
+	// the block can't be encoded.
+	tests := []MsgCmpctBlock{{
+		Header: blockOne.Header,
+		PrefilledTxs: []PrefilledTx{
+			{Index: 1, Tx: tx},
+			{Index: 0, Tx: tx},
+		},
+	}, {
+		Header:       blockOne.Header,
+		PrefilledTxs: []PrefilledTx{{Index: 1, Tx: tx}},
+	}}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := test.BteEncode(&buf, ProtocolVersion, WitnessEncoding)
+		if _, ok := err.(*MessageError); !ok {
+			t.Errorf("BteEncode #%d: unexpected error %v", i, err)
+		}
+	}
+
+	// A prefilled transaction index beyond the end of the block can't be
+	// decoded.
+	var encoded []byte
+	encoded = append(encoded, blockOneBytes[:MaxBlockHeaderPayload]...)
+	encoded = append(encoded, make([]byte, 8)...)
+	encoded = append(encoded, 0x00, 0x01, 0x01)
+	var msg MsgCmpctBlock
+	err := msg.BteDecode(bytes.NewReader(encoded), ProtocolVersion,
+		WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BteDecode: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetBlockTxnWire tests the MsgGetBlockTxn wire encode and decode, notably
+// the differential encoding of the transaction indexes.
+func TestGetBlockTxnWire(t *testing.T) {
+	hash := blockOne.BlockHash()
+	msg := NewMsgGetBlockTxn(&hash, []uint32{1, 2, 5, 300})
+
+	// Ensure the command is expected value.
+	wantCmd := "getblocktxn"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	var want []byte
+	want = append(want, hash[:]...)
+	want = append(want, 0x04, 0x01, 0x00, 0x02, 0xfd, 0x26, 0x01)
+
+	var buf bytes.Buffer
+	err := msg.BteEncode(&buf, ProtocolVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("BteEncode error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("BteEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want))
+	}
+
+	var readmsg MsgGetBlockTxn
+	err = readmsg.BteDecode(bytes.NewReader(want), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("BteDecode error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BteDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Ensure indexes which are out of order can't be encoded.
+	msg.Indexes = []uint32{2, 1}
+	err = msg.BteEncode(&buf, ProtocolVersion, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BteEncode: unexpected error %v", err)
+	}
+
+	// Ensure encode and decode fail with the protocol version before
+	// CompactBlocksVersion.
+	pver := CompactBlocksVersion - 1
+	err = msg.BteEncode(&buf, pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BteEncode: unexpected error %v", err)
+	}
+	err = readmsg.BteDecode(bytes.NewReader(want), pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BteDecode: unexpected error %v", err)
+	}
+}
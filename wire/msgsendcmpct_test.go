@@ -0,0 +1,125 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestSendCmpct tests the MsgSendCmpct API against the latest protocol
+// version and the protocol version before CompactBlocksVersion.
+func TestSendCmpct(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgSendCmpct(true, CmpctBlockVersion)
+	if !msg.AnnounceUsingCmpctBlock || msg.Version != CmpctBlockVersion {
+		t.Errorf("NewMsgSendCmpct: wrong fields - got %v", spew.Sdump(msg))
+	}
+
+	// Ensure the command is expected value.
+	wantCmd := "sendcmpct"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendCmpct: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(9)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BteEncode(&buf, pver, BaseEncoding)
+	if err != nil {
+		t.Errorf("encode of MsgSendCmpct failed %v err <%v>", msg, err)
+	}
+	var readmsg MsgSendCmpct
+	err = readmsg.BteDecode(&buf, pver, BaseEncoding)
+	if err != nil {
+		t.Errorf("decode of MsgSendCmpct failed [%v] err <%v>", buf, err)
+	}
+	if !reflect.DeepEqual(msg, &readmsg) {
+		t.Errorf("decode of MsgSendCmpct: got %v, want %v",
+			spew.Sdump(&readmsg), spew.Sdump(msg))
+	}
+
+	// Ensure encode and decode fail with the protocol version before
+	// CompactBlocksVersion.
+	pver = CompactBlocksVersion - 1
+	buf.Reset()
+	err = msg.BteEncode(&buf, pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("encode of MsgSendCmpct succeeded when it should "+
+			"have failed for protocol version %d", pver)
+	}
+	err = readmsg.BteDecode(bytes.NewReader(make([]byte, 9)), pver,
+		BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("decode of MsgSendCmpct succeeded when it should "+
+			"have failed for protocol version %d", pver)
+	}
+}
+
+// TestSendCmpctWire tests the MsgSendCmpct wire encode and decode.
+func TestSendCmpctWire(t *testing.T) {
+	tests := []struct {
+		in  MsgSendCmpct // Message to encode
+		buf []byte       // Wire encoding
+	}{
+		{
+			MsgSendCmpct{AnnounceUsingCmpctBlock: false, Version: 2},
+			[]byte{
+				0x00,
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+		},
+		{
+			MsgSendCmpct{AnnounceUsingCmpctBlock: true, Version: 1},
+			[]byte{
+				0x01,
+				0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BteEncode(&buf, ProtocolVersion, BaseEncoding)
+		if err != nil {
+			t.Errorf("BteEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BteEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgSendCmpct
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BteDecode(rbuf, ProtocolVersion, BaseEncoding)
+		if err != nil {
+			t.Errorf("BteDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.in) {
+			t.Errorf("BteDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.in))
+			continue
+		}
+	}
+}
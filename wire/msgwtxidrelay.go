@@ -0,0 +1,47 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"io"
+)
+
+// MsgWTxIdRelay defines a bitcoin wtxidrelay message which is used for a peer
+// to signal that it announces and requests transactions by their witness hash
+// using inventory vectors of type InvTypeWTx (BIP0339).  It must be sent
+// during the version-verack handshake.  It implements the Message interface.
+//
+// This message has no payload.
+type MsgWTxIdRelay struct{}
+
+// BteDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgWTxIdRelay) BteDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return nil
+}
+
+// BteEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgWTxIdRelay) BteEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgWTxIdRelay) Command() string {
+	return CmdWTxIdRelay
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgWTxIdRelay) MaxPayloadLength(pver uint32) uint32 {
+	return 0
+}
+
+// NewMsgWTxIdRelay returns a new bitcoin wtxidrelay message that conforms to
+// the Message interface.
+func NewMsgWTxIdRelay() *MsgWTxIdRelay {
+	return &MsgWTxIdRelay{}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestWTxIdRelay tests the MsgWTxIdRelay API.
+func TestWTxIdRelay(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "wtxidrelay"
+	msg := NewMsgWTxIdRelay()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgWTxIdRelay: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestWTxIdRelayWire tests the MsgWTxIdRelay wire encode and decode for
+// various protocol versions.
+func TestWTxIdRelayWire(t *testing.T) {
+	msgWTxIdRelay := NewMsgWTxIdRelay()
+	msgWTxIdRelayEncoded := []byte{}
+
+	tests := []struct {
+		in   *MsgWTxIdRelay  // Message to encode
+		out  *MsgWTxIdRelay  // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for wire encoding
+		enc  MessageEncoding // Message encoding format
+	}{
+		// Latest protocol version.
+		{
+			msgWTxIdRelay,
+			msgWTxIdRelay,
+			msgWTxIdRelayEncoded,
+			ProtocolVersion,
+			BaseEncoding,
+		},
+
+		// Protocol version WTxIdRelayVersion.
+		{
+			msgWTxIdRelay,
+			msgWTxIdRelay,
+			msgWTxIdRelayEncoded,
+			WTxIdRelayVersion,
+			BaseEncoding,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BteEncode(&buf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BteEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BteEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgWTxIdRelay
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BteDecode(rbuf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BteDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BteDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
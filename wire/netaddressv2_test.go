@@ -3,6 +3,8 @@ package wire
 import (
 	"bytes"
 	"io"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -236,7 +238,7 @@ func TestReadNetAddressV2(t *testing.T) {
 				0x22,
 			},
 			string(i2p),
-			ErrSkippedNetworkID,
+			nil,
 		},
 
 		// Invalid cjdns size.
@@ -246,7 +248,7 @@ func TestReadNetAddressV2(t *testing.T) {
 			ErrInvalidAddressSize,
 		},
 
-		// Valid cjdns encoding.
+		// Cjdns address outside of fc00::/8 is skipped.
 		{
 			[]byte{
 				0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x10, 0x20,
@@ -254,9 +256,21 @@ func TestReadNetAddressV2(t *testing.T) {
 				0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22,
 				0x22,
 			},
-			string(cjdns),
+			"",
 			ErrSkippedNetworkID,
 		},
+
+		// Valid cjdns encoding.
+		{
+			[]byte{
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x10, 0xfc,
+				0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20,
+				0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22,
+				0x22,
+			},
+			string(cjdns),
+			nil,
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -283,3 +297,66 @@ func TestReadNetAddressV2(t *testing.T) {
 		}
 	}
 }
+
+// TestNetAddressV2I2PAndCJDNS tests that i2p and cjdns addresses are created,
+// encoded, and decoded as expected.
+func TestNetAddressV2I2PAndCJDNS(t *testing.T) {
+	i2pHash := []byte{
+		0xca, 0xd2, 0xd3, 0xc8, 0xdc, 0x9c, 0xc4, 0xd3,
+		0x70, 0x33, 0x30, 0xc5, 0x23, 0xaf, 0x02, 0xed,
+		0xc4, 0x9d, 0xf8, 0xc6, 0xb0, 0x4e, 0x74, 0x6d,
+		0x3b, 0x51, 0x57, 0xa7, 0x15, 0xfe, 0x98, 0x35,
+	}
+
+	tests := []struct {
+		na              *NetAddressV2
+		expectedString  string
+		expectedNetwork string
+	}{
+		{
+			NetAddressV2FromI2P(time.Unix(0x495fab29, 0), 0, i2pHash),
+			"zljnhsg4ttcng4btgdcshlyc5xcj36ggwbhhi3j3kfl2ofp6ta2q.b32.i2p",
+			string(i2p),
+		},
+		{
+			NetAddressV2FromCJDNS(time.Unix(0x495fab29, 0), 0,
+				net.ParseIP("fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa"),
+				8333),
+			"fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa",
+			string(cjdns),
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if test.na.ToLegacy() != nil {
+			t.Errorf("Test #%d has legacy encoding", i)
+		}
+
+		if test.na.Addr.String() != test.expectedString {
+			t.Errorf("Test #%d did not match expected string - got "+
+				"%s, want %s", i, test.na.Addr.String(),
+				test.expectedString)
+		}
+
+		if test.na.Addr.Network() != test.expectedNetwork {
+			t.Errorf("Test #%d did not match expected network", i)
+		}
+
+		var b bytes.Buffer
+		if err := writeNetAddressV2(&b, 0, test.na); err != nil {
+			t.Errorf("Test #%d failed writing address %v", i, err)
+			continue
+		}
+
+		var na NetAddressV2
+		if err := readNetAddressV2(&b, 0, &na); err != nil {
+			t.Errorf("Test #%d failed reading address %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&na, test.na) {
+			t.Errorf("Test #%d address mismatch - got %v, want %v",
+				i, na, test.na)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// v2Commands maps the short message type ids used by the v2 transport
+// protocol (BIP0324) to the commands of the messages they identify.  Id 0 is
+// reserved to indicate the command follows in its original 12 byte form.
+var v2Commands = [...]string{
+	1:  CmdAddr,
+	2:  CmdBlock,
+	3:  CmdBlockTxn,
+	4:  CmdCmpctBlock,
+	5:  CmdFeeFilter,
+	6:  CmdFilterAdd,
+	7:  CmdFilterClear,
+	8:  CmdFilterLoad,
+	9:  CmdGetBlocks,
+	10: CmdGetBlockTxn,
+	11: CmdGetData,
+	12: CmdGetHeaders,
+	13: CmdHeaders,
+	14: CmdInv,
+	15: CmdMemPool,
+	16: CmdMerkleBlock,
+	17: CmdNotFound,
+	18: CmdPing,
+	19: CmdPong,
+	20: CmdSendCmpct,
+	21: CmdTx,
+	22: CmdGetCFilters,
+	23: CmdCFilter,
+	24: CmdGetCFHeaders,
+	25: CmdCFHeaders,
+	26: CmdGetCFCheckpt,
+	27: CmdCFCheckpt,
+	28: CmdAddrV2,
+}
+
+// v2MessageIDs maps commands to their short message type ids used by the v2
+// transport protocol.
+var v2MessageIDs = func() map[string]byte {
+	ids := make(map[string]byte, len(v2Commands))
+	for id, cmd := range v2Commands {
+		if cmd != "" {
+			ids[cmd] = byte(id)
+		}
+	}
+	return ids
+}()
+
+// EncodeV2Message encodes msg as the contents of a packet of the v2 transport
+// protocol (BIP0324).  The contents consist of the message type, which is
+// either the short id of the command or a zero byte followed by the command in
+// its original 12 byte form, followed by the message payload.
+func EncodeV2Message(msg Message, pver uint32, enc MessageEncoding) ([]byte, error) {
+	var bw bytes.Buffer
+	cmd := msg.Command()
+	if id, ok := v2MessageIDs[cmd]; ok {
+		bw.WriteByte(id)
+	} else {
+		// Enforce max command size.
+		if len(cmd) > CommandSize {
+			str := fmt.Sprintf("command [%s] is too long [max %v]",
+				cmd, CommandSize)
+			return nil, messageError("EncodeV2Message", str)
+		}
+		var command [1 + CommandSize]byte
+		copy(command[1:], cmd)
+		bw.Write(command[:])
+	}
+	typeLen := bw.Len()
+
+	// Encode the message payload.
+	if err := msg.BteEncode(&bw, pver, enc); err != nil {
+		return nil, err
+	}
+	lenp := bw.Len() - typeLen
+
+	// Enforce maximum overall message payload.
+	if lenp > MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload is %d bytes",
+			lenp, MaxMessagePayload)
+		return nil, messageError("EncodeV2Message", str)
+	}
+
+	// Enforce maximum message payload based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(lenp) > mpl {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload size for "+
+			"messages of type [%s] is %d.", lenp, cmd, mpl)
+		return nil, messageError("EncodeV2Message", str)
+	}
+
+	return bw.Bytes(), nil
+}
+
+// DecodeV2Message decodes the message contained in the contents of a packet
+// of the v2 transport protocol (BIP0324) as encoded by EncodeV2Message.  It
+// returns the parsed Message along with the raw bytes of its payload.
+//
+// ErrUnknownMessage is returned for message types which are not known, the
+// same as when reading messages with the original transport protocol.
+func DecodeV2Message(contents []byte, pver uint32, enc MessageEncoding) (Message, []byte, error) {
+	if len(contents) == 0 {
+		str := "message type is missing"
+		return nil, nil, messageError("DecodeV2Message", str)
+	}
+
+	// Determine the command from the message type.
+	var command string
+	payload := contents[1:]
+	if id := contents[0]; id != 0 {
+		if int(id) >= len(v2Commands) || v2Commands[id] == "" {
+			return nil, nil, ErrUnknownMessage
+		}
+		command = v2Commands[id]
+	} else {
+		if len(payload) < CommandSize {
+			str := fmt.Sprintf("message type is truncated - %d "+
+				"bytes instead of %d", len(payload), CommandSize)
+			return nil, nil, messageError("DecodeV2Message", str)
+		}
+
+		// The command must be zero padded without any data after the
+		// padding.
+		cmdBytes := payload[:CommandSize]
+		payload = payload[CommandSize:]
+		cmdLen := bytes.IndexByte(cmdBytes, 0)
+		if cmdLen == -1 {
+			cmdLen = CommandSize
+		}
+		if cmdLen == 0 || !utf8.Valid(cmdBytes[:cmdLen]) ||
+			bytes.IndexFunc(cmdBytes[cmdLen:], func(r rune) bool {
+				return r != 0
+			}) != -1 {
+
+			str := fmt.Sprintf("invalid command %v", cmdBytes)
+			return nil, nil, messageError("DecodeV2Message", str)
+		}
+		command = string(cmdBytes[:cmdLen])
+	}
+
+	// Enforce maximum message payload.
+	if len(payload) > MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - %d bytes, "+
+			"but max message payload is %d bytes.", len(payload),
+			MaxMessagePayload)
+		return nil, nil, messageError("DecodeV2Message", str)
+	}
+
+	// Create struct of appropriate message type based on the command.
+	msg, err := makeEmptyMessage(command)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Check for maximum length based on the message type.
+	mpl := msg.MaxPayloadLength(pver)
+	if uint32(len(payload)) > mpl {
+		str := fmt.Sprintf("payload exceeds max length - %v bytes, "+
+			"but max payload size for messages of type [%v] is %v.",
+			len(payload), command, mpl)
+		return nil, nil, messageError("DecodeV2Message", str)
+	}
+
+	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
+	// MsgVersion BteDecode function requires it.
+	pr := bytes.NewBuffer(payload)
+	if err := msg.BteDecode(pr, pver, enc); err != nil {
+		return nil, nil, err
+	}
+
+	return msg, payload, nil
+}
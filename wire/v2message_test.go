@@ -0,0 +1,121 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestV2Message tests the EncodeV2Message and DecodeV2Message API.
+func TestV2Message(t *testing.T) {
+	pver := ProtocolVersion
+
+	// MsgVersion.
+	addrYou := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 8333}
+	you := NewNetAddress(addrYou, SFNodeNetwork)
+	you.Timestamp = time.Time{} // Version message has zero value timestamp.
+	addrMe := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8333}
+	me := NewNetAddress(addrMe, SFNodeNetwork)
+	me.Timestamp = time.Time{} // Version message has zero value timestamp.
+	msgVersion := NewMsgVersion(me, you, 123123, 0)
+
+	tests := []struct {
+		in       Message // Value to encode
+		typeSize int     // Expected size of the message type
+	}{
+		// Messages with a short message type id.
+		{NewMsgPing(123123), 1},
+		{NewMsgAddrV2(), 1},
+		{NewMsgSendCmpct(true, CmpctBlockVersion), 1},
+		{&blockOne, 1},
+
+		// Messages without a short message type id.
+		{msgVersion, 1 + CommandSize},
+		{NewMsgVerAck(), 1 + CommandSize},
+		{NewMsgSendAddrV2(), 1 + CommandSize},
+		{NewMsgWTxIdRelay(), 1 + CommandSize},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		contents, err := EncodeV2Message(test.in, pver, BaseEncoding)
+		if err != nil {
+			t.Errorf("EncodeV2Message #%d error %v", i, err)
+			continue
+		}
+
+		var payload bytes.Buffer
+		test.in.BteEncode(&payload, pver, BaseEncoding)
+		if !bytes.Equal(contents[test.typeSize:], payload.Bytes()) {
+			t.Errorf("EncodeV2Message #%d\n got: %s want: %s", i,
+				spew.Sdump(contents), spew.Sdump(payload.Bytes()))
+			continue
+		}
+
+		msg, buf, err := DecodeV2Message(contents, pver, BaseEncoding)
+		if err != nil {
+			t.Errorf("DecodeV2Message #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.in) {
+			t.Errorf("DecodeV2Message #%d\n got: %v want: %v", i,
+				spew.Sdump(msg), spew.Sdump(test.in))
+			continue
+		}
+		if !bytes.Equal(buf, payload.Bytes()) {
+			t.Errorf("DecodeV2Message #%d unexpected payload %x", i, buf)
+		}
+	}
+}
+
+// TestV2MessageErrors performs negative tests against decoding the contents of
+// v2 transport packets to confirm error paths work correctly.
+func TestV2MessageErrors(t *testing.T) {
+	pver := ProtocolVersion
+
+	tests := []struct {
+		contents []byte // Contents to decode
+		unknown  bool   // Whether ErrUnknownMessage is expected
+	}{
+		// No message type.
+		{[]byte{}, false},
+		// Short message type ids which are not assigned.
+		{[]byte{29}, true},
+		{[]byte{0xff}, true},
+		// Truncated command.
+		{[]byte{0, 'v', 'e', 'r', 'a', 'c', 'k'}, false},
+		// Empty command.
+		{make([]byte, 1+CommandSize), false},
+		// Data after the padding of the command.
+		{[]byte{0, 'v', 'e', 'r', 'a', 'c', 'k', 0, 0, 0, 0, 0, 1}, false},
+		// Unknown command.
+		{[]byte{0, 'b', 'o', 'g', 'u', 's', 0, 0, 0, 0, 0, 0, 0}, true},
+		// Payload of a message without one.
+		{[]byte{0, 'v', 'e', 'r', 'a', 'c', 'k', 0, 0, 0, 0, 0, 0, 1}, false},
+		// Truncated payload.
+		{[]byte{18, 0x01, 0x02}, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, _, err := DecodeV2Message(test.contents, pver, BaseEncoding)
+		if test.unknown {
+			if err != ErrUnknownMessage {
+				t.Errorf("DecodeV2Message #%d wrong error got: %v, "+
+					"want: %v", i, err, ErrUnknownMessage)
+			}
+			continue
+		}
+		if err == nil || err == ErrUnknownMessage {
+			t.Errorf("DecodeV2Message #%d unexpected error %v", i, err)
+		}
+	}
+}